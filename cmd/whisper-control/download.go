@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// downloads holds the models being fetched, so two requests never write
+// the same .tmp file.
+var downloads = struct {
+	sync.Mutex
+	active map[string]bool
+}{active: map[string]bool{}}
+
+// claimDownload marks name as downloading. Returns false if it already is.
+func claimDownload(name string) (release func(), ok bool) {
+	downloads.Lock()
+	defer downloads.Unlock()
+	if downloads.active[name] {
+		return nil, false
+	}
+	downloads.active[name] = true
+	return func() {
+		downloads.Lock()
+		defer downloads.Unlock()
+		delete(downloads.active, name)
+	}, true
+}
+
+func downloading(name string) bool {
+	downloads.Lock()
+	defer downloads.Unlock()
+	return downloads.active[name]
+}
+
+// progressWriter wraps a file and streams NDJSON progress to the HTTP response.
+type progressWriter struct {
+	out        *os.File
+	w          http.ResponseWriter
+	flushFn    func()
+	total      int64
+	downloaded int64
+	lastReport time.Time
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.out.Write(p)
+	if err != nil {
+		return n, err
+	}
+	pw.downloaded += int64(n)
+	if time.Since(pw.lastReport) <= 500*time.Millisecond {
+		return n, nil
+	}
+	json.NewEncoder(pw.w).Encode(map[string]int64{"bytes": pw.downloaded, "total": pw.total})
+	pw.flushFn()
+	pw.lastReport = time.Now()
+	return n, nil
+}
+
+func noopFlush() {}
+
+func handleDownloadModel(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if json.NewDecoder(r.Body).Decode(&req) != nil || req.Name == "" {
+		http.Error(w, "missing name", http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(knownModels, req.Name) {
+		http.Error(w, "unknown model", http.StatusBadRequest)
+		return
+	}
+	dest := filepath.Join(modelsDir, req.Name)
+	if _, err := os.Stat(dest); err == nil {
+		writeJSON(w, map[string]string{"status": "already_downloaded"})
+		return
+	}
+	release, ok := claimDownload(req.Name)
+	if !ok {
+		http.Error(w, "download already in progress", http.StatusConflict)
+		return
+	}
+	defer release()
+
+	os.MkdirAll(modelsDir, 0755)
+
+	flushFn := noopFlush
+	flusher, ok := w.(http.Flusher)
+	if ok {
+		flushFn = flusher.Flush
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	err := downloadModel(req.Name, dest, w, flushFn)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "done"})
+	flushFn()
+}
+
+// downloadModel fetches name into dest.tmp and moves it to dest once it
+// checks out. An interrupted download leaves dest.tmp behind, and the next
+// attempt asks the server for just the remaining bytes.
+func downloadModel(name, dest string, w http.ResponseWriter, flushFn func()) error {
+	url := modelBaseURL + name
+	tmp := dest + ".tmp"
+	var offset int64
+	if info, err := os.Stat(tmp); err == nil {
+		offset = info.Size()
+	}
+	slog.Info("downloading whisper model", "name", name, "url", url, "resume_from", offset)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("download request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("download request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// the partial file already holds every byte
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+		return finishDownload(name, tmp, dest, offset, w, flushFn)
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	total := resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			os.Remove(tmp)
+			return fmt.Errorf("server resumed at the wrong offset (%s); retry to start over", resp.Header.Get("Content-Range"))
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+		if total >= 0 {
+			total += offset
+		}
+	} else if resp.StatusCode == http.StatusOK {
+		offset = 0 // the server ignored the range; start over
+	} else {
+		return fmt.Errorf("download returned %s", resp.Status)
+	}
+
+	out, err := os.OpenFile(tmp, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+
+	pw := &progressWriter{out: out, w: w, flushFn: flushFn, total: total, downloaded: offset, lastReport: time.Now()}
+	_, copyErr := io.Copy(pw, resp.Body)
+	out.Close()
+
+	if copyErr != nil {
+		return fmt.Errorf("interrupted at %d bytes, retry to resume: %w", pw.downloaded, copyErr)
+	}
+	return finishDownload(name, tmp, dest, pw.downloaded, w, flushFn)
+}
+
+// finishDownload checks tmp against the manifest checksum and moves it to
+// dest. A mismatch deletes tmp, since resuming a corrupt file can't fix it.
+func finishDownload(name, tmp, dest string, size int64, w http.ResponseWriter, flushFn func()) error {
+	want, err := manifestSum(name)
+	if err != nil {
+		return err
+	}
+	if want == "" {
+		slog.Warn("no manifest checksum, model not verified", "name", name)
+	} else {
+		json.NewEncoder(w).Encode(map[string]string{"status": "verifying"})
+		flushFn()
+		got, err := fileSHA256(tmp)
+		if err != nil {
+			return fmt.Errorf("checksum: %w", err)
+		}
+		if !strings.EqualFold(got, want) {
+			os.Remove(tmp)
+			return fmt.Errorf("sha256 mismatch: got %s, manifest has %s", got, want)
+		}
+	}
+	if err = os.Rename(tmp, dest); err != nil {
+		return fmt.Errorf("install model: %w", err)
+	}
+	slog.Info("model downloaded", "name", name, "bytes", size, "verified", want != "")
+	return nil
+}
+
+// manifestSum returns the expected SHA256 of name from the manifest, a
+// JSON object of model file name to hex digest. A missing manifest or
+// entry returns "".
+func manifestSum(name string) (string, error) {
+	path := modelsManifest
+	if path == "" {
+		path = filepath.Join(modelsDir, "manifest.json")
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read manifest: %w", err)
+	}
+	var sums map[string]string
+	if err = json.Unmarshal(data, &sums); err != nil {
+		return "", fmt.Errorf("parse manifest %s: %w", path, err)
+	}
+	return sums[name], nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// handleDeleteModel removes a downloaded model, and any partial download
+// of it, to reclaim disk. The model whisper-server runs can't be deleted.
+func handleDeleteModel(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !slices.Contains(knownModels, name) {
+		http.Error(w, "unknown model", http.StatusBadRequest)
+		return
+	}
+	if name == filepath.Base(activeWhisperModel()) {
+		http.Error(w, "model is in use by whisper-server", http.StatusConflict)
+		return
+	}
+	if downloading(name) {
+		http.Error(w, "download in progress", http.StatusConflict)
+		return
+	}
+	dest := filepath.Join(modelsDir, name)
+	removed := false
+	for _, path := range []string{dest, dest + ".tmp"} {
+		err := os.Remove(path)
+		if err == nil {
+			removed = true
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if !removed {
+		http.Error(w, "model not downloaded", http.StatusNotFound)
+		return
+	}
+	slog.Info("model deleted", "name", name)
+	writeJSON(w, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// errNoInstancePorts is returned for a service without instance_ports.
+	errNoInstancePorts = errors.New("no instance_ports configured")
+
+	// errNoFreePort is returned when every instance port is taken.
+	errNoFreePort = errors.New("every instance port is in use")
+)
+
+// instance is a side-by-side process of a service, on one of its
+// InstancePorts with a model of its own.
+type instance struct {
+	Port      int
+	Model     string // {model} path ("" = unknown, for one found at startup)
+	Device    string
+	StartedAt time.Time // zero for one found at startup
+}
+
+// recoverInstances adds instances still running from before a controller
+// restart to the process table, reading their model from the command line.
+func (s *service) recoverInstances() {
+	for _, port := range s.cfg.InstancePorts {
+		if s.runningOn(port) {
+			s.instances[port] = &instance{Port: port, Model: s.runningModel(port)}
+		}
+	}
+}
+
+// runningModel finds the {model} argument of the process on port.
+func (s *service) runningModel(port int) string {
+	k := slices.Index(s.cfg.Args, "{model}")
+	if k < 0 {
+		return ""
+	}
+	out, err := exec.Command("pgrep", "-a", "-f", s.instancePattern(port)).Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	fields := strings.Fields(line) // pid, binary, args...
+	if len(fields) < k+3 {
+		return ""
+	}
+	return fields[k+2]
+}
+
+// startInstance launches model (a file name in ModelsDir) on device ("" = the
+// configured one) on a free instance port and waits for its health URL.
+func (s *service) startInstance(model, device string) (instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.cfg.InstancePorts) == 0 {
+		return instance{}, errNoInstancePorts
+	}
+	if filepath.Base(model) != model || model == "" {
+		return instance{}, fmt.Errorf("invalid model name %q", model)
+	}
+	if device == "" {
+		device = s.cfg.Device
+	} else if _, err := deviceIndex(device); err != nil {
+		return instance{}, err
+	}
+	port := 0
+	for _, p := range s.cfg.InstancePorts {
+		if _, taken := s.instances[p]; !taken && !s.runningOn(p) {
+			port = p
+			break
+		}
+	}
+	if port == 0 {
+		return instance{}, errNoFreePort
+	}
+
+	inst := &instance{Port: port, Model: filepath.Join(s.cfg.ModelsDir, model), Device: device, StartedAt: time.Now().UTC()}
+	if err := s.launch(port, inst.Model, device, os.O_TRUNC); err != nil {
+		return instance{}, err
+	}
+	if !s.waitHealthy(port) {
+		exec.Command("pkill", "-f", s.instancePattern(port)).Run()
+		return instance{}, fmt.Errorf("%s on port %d did not become healthy", s.name, port)
+	}
+	s.instances[port] = inst
+	return *inst, nil
+}
+
+// stopInstance stops the side-by-side instance on port.
+func (s *service) stopInstance(port int) error {
+	if !slices.Contains(s.cfg.InstancePorts, port) {
+		return fmt.Errorf("port %d is not an instance port of %s", port, s.name)
+	}
+	s.stopPort(port)
+	s.mu.Lock()
+	delete(s.instances, port)
+	s.mu.Unlock()
+	return nil
+}
+
+// instanceTable snapshots the process table, by port.
+func (s *service) instanceTable() []instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]instance, 0, len(s.instances))
+	for _, inst := range s.instances {
+		out = append(out, *inst)
+	}
+	slices.SortFunc(out, func(a, b instance) int { return a.Port - b.Port })
+	return out
+}
+
+func (s *service) instanceCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.instances)
+}
+
+// instanceStatus is one row of the /instances process table.
+type instanceStatus struct {
+	Port      int        `json:"port"`
+	Model     string     `json:"model,omitempty"`
+	Device    string     `json:"device,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	Running   bool       `json:"running"`
+	Healthy   bool       `json:"healthy"`
+	HealthURL string     `json:"health_url,omitempty"`
+}
+
+func (s *service) status(inst instance) instanceStatus {
+	st := instanceStatus{Port: inst.Port, Device: inst.Device, Running: s.runningOn(inst.Port), HealthURL: s.healthURL(inst.Port)}
+	if inst.Model != "" {
+		st.Model = filepath.Base(inst.Model)
+	}
+	if !inst.StartedAt.IsZero() {
+		st.StartedAt = &inst.StartedAt
+	}
+	st.Healthy = st.Running && (st.HealthURL == "" || healthOK(&http.Client{Timeout: 2 * time.Second}, st.HealthURL))
+	return st
+}
+
+func handleListInstances(w http.ResponseWriter, r *http.Request, svc *service) {
+	table := svc.instanceTable()
+	out := make([]instanceStatus, 0, len(table))
+	for _, inst := range table {
+		out = append(out, svc.status(inst))
+	}
+	writeJSON(w, map[string]any{"instances": out, "ports": svc.cfg.InstancePorts})
+}
+
+// handleStartInstance starts ?model= beside the service's running ones, on
+// ?device= if given. Services without instance_ports get 501, and 409 when
+// every port is taken.
+func handleStartInstance(w http.ResponseWriter, r *http.Request, svc *service) {
+	q := r.URL.Query()
+	inst, err := svc.startInstance(q.Get("model"), q.Get("device"))
+	if errors.Is(err, errNoInstancePorts) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if errors.Is(err, errNoFreePort) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Error("start instance", "name", svc.name, "model", q.Get("model"), "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("instance ready", "name", svc.name, "model", q.Get("model"), "port", inst.Port)
+	resp := currentGPU("started")
+	resp["instance"] = svc.status(inst)
+	writeJSON(w, resp)
+}
+
+func handleStopInstance(w http.ResponseWriter, r *http.Request, svc *service) {
+	port, err := strconv.Atoi(r.PathValue("port"))
+	if err != nil {
+		http.Error(w, "invalid port", http.StatusBadRequest)
+		return
+	}
+	if err = svc.stopInstance(port); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	slog.Info("instance stopped", "name", svc.name, "port", port)
+	writeJSON(w, currentGPU("stopped"))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// cpuPeriod is the cgroup cpu.max period, in microseconds.
+const cpuPeriod = 100000
+
+var cpuListPattern = regexp.MustCompile(`^\d+(-\d+)?(,\d+(-\d+)?)*$`)
+
+// resourceLimits caps what a spawned process may take from the host, so a
+// transcription burst can't starve the gateway and Ollama beside it. Nice and
+// CPUs go through nice(1) and taskset(1); MemoryMB and CPUQuota put each
+// process in a cgroup of its own under CONTROL_CGROUP, which must be a
+// writable cgroup v2 directory.
+type resourceLimits struct {
+	Threads  int     `json:"threads"`   // replaces {threads} in Args and sets OMP_NUM_THREADS
+	CPUs     string  `json:"cpus"`      // CPU affinity as a taskset list, e.g. "0-3,8"
+	Nice     int     `json:"nice"`      // scheduling priority; 1-19 yields to other processes
+	MemoryMB int     `json:"memory_mb"` // memory.max; the kernel OOM-kills the process past it
+	CPUQuota float64 `json:"cpu_quota"` // cpu.max in cores, e.g. 2.5
+}
+
+func (l resourceLimits) validate() error {
+	if l.Nice < -20 || l.Nice > 19 {
+		return fmt.Errorf("nice %d outside -20..19", l.Nice)
+	}
+	if l.Threads < 0 || l.MemoryMB < 0 || l.CPUQuota < 0 {
+		return errors.New("threads, memory_mb and cpu_quota must not be negative")
+	}
+	if l.CPUs != "" && !cpuListPattern.MatchString(l.CPUs) {
+		return fmt.Errorf("cpus %q is not a CPU list such as 0-3,8", l.CPUs)
+	}
+	return nil
+}
+
+func (l resourceLimits) needsCgroup() bool {
+	return l.MemoryMB > 0 || l.CPUQuota > 0
+}
+
+// wrap prefixes the command line with taskset and nice as needed. Both exec
+// the binary in place, so pgrep still finds it by Match.
+func (l resourceLimits) wrap(bin string, args []string) (string, []string) {
+	if l.CPUs != "" {
+		args = append([]string{"-c", l.CPUs, bin}, args...)
+		bin = "taskset"
+	}
+	if l.Nice != 0 {
+		args = append([]string{"-n", strconv.Itoa(l.Nice), bin}, args...)
+		bin = "nice"
+	}
+	return bin, args
+}
+
+// cgroup prepares the cgroup for the process on port with the configured
+// memory and CPU caps, and opens it for SysProcAttr.CgroupFD so the process
+// starts inside it. The caller closes it once the process has started.
+func (s *service) cgroup(port int) (*os.File, error) {
+	l := s.cfg.Limits
+	if err := os.MkdirAll(cgroupRoot, 0o755); err != nil {
+		return nil, fmt.Errorf("cgroup: %w", err)
+	}
+	// a cgroup only gets the controllers its parent delegates
+	if err := os.WriteFile(filepath.Join(cgroupRoot, "cgroup.subtree_control"), []byte("+memory +cpu"), 0o644); err != nil {
+		return nil, fmt.Errorf("cgroup %s: enable memory and cpu controllers: %w", cgroupRoot, err)
+	}
+	dir := filepath.Join(cgroupRoot, fmt.Sprintf("%s-%d", s.name, port))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cgroup: %w", err)
+	}
+
+	// rewrite both, so a cap removed from the config is lifted on restart
+	memMax, cpuMax := "max", fmt.Sprintf("max %d", cpuPeriod)
+	if l.MemoryMB > 0 {
+		memMax = strconv.FormatInt(int64(l.MemoryMB)<<20, 10)
+	}
+	if l.CPUQuota > 0 {
+		cpuMax = fmt.Sprintf("%d %d", int(l.CPUQuota*cpuPeriod), cpuPeriod)
+	}
+	for file, val := range map[string]string{"memory.max": memMax, "cpu.max": cpuMax} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(val), 0o644); err != nil {
+			return nil, fmt.Errorf("cgroup %s: %w", file, err)
+		}
+	}
+	return os.Open(dir)
+}
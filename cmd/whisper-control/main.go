@@ -2,8 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
@@ -20,11 +20,18 @@ var (
 	whisperBin     = envOr("WHISPER_BIN", filepath.Join(os.Getenv("HOME"), ".local/bin/whisper-server"))
 	whisperModel   = envOr("WHISPER_MODEL", filepath.Join(os.Getenv("HOME"), ".local/share/whisper/ggml-medium.bin"))
 	whisperPort    = envOr("WHISPER_PORT", "8178")
+	whisperAltPort = envInt("WHISPER_ALT_PORT", 0) // second port for model swaps (0 = disabled)
 	whisperThreads = envOr("WHISPER_THREADS", "4")
 	gpuDevice      = envOr("GPU_DEVICE", "card0")
 	modelsDir      = envOr("WHISPER_MODELS_DIR", filepath.Join(os.Getenv("HOME"), ".local/share/whisper"))
+	modelsManifest = envOr("WHISPER_MODELS_MANIFEST", "") // {model: sha256} ("" = manifest.json in the models dir)
+	// cgroupRoot holds a cgroup per process for memory and CPU limits
+	cgroupRoot = envOr("CONTROL_CGROUP", "/sys/fs/cgroup/whisper-control")
 )
 
+// services is every binary the controller manages, from CONTROL_CONFIG.
+var services *registry
+
 const modelBaseURL = "https://huggingface.co/ggerganov/whisper.cpp/resolve/main/"
 
 var knownModels = []string{
@@ -34,6 +41,7 @@ var knownModels = []string{
 	"ggml-base.en.bin",
 	"ggml-small.bin",
 	"ggml-small.en.bin",
+	"ggml-small.en-tdrz.bin", // tinydiarize: speaker-turn detection
 	"ggml-medium.bin",
 	"ggml-medium.en.bin",
 	"ggml-large-v2.bin",
@@ -44,66 +52,103 @@ var knownModels = []string{
 func main() {
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, nil)))
 
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("load config", "error", err)
+		os.Exit(1)
+	}
+	services = newRegistry(cfg)
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /start", handleStart)
-	mux.HandleFunc("POST /stop", handleStop)
-	mux.HandleFunc("GET /status", handleStatus)
+	services.register(mux)
 	mux.HandleFunc("GET /health", handleHealth)
 	mux.HandleFunc("GET /gpu", handleGPU)
 	mux.HandleFunc("GET /models", handleListModels)
 	mux.HandleFunc("POST /models/download", handleDownloadModel)
+	mux.HandleFunc("DELETE /models/{name}", handleDeleteModel)
 
-	slog.Info("whisper-control listening", "port", port)
+	slog.Info("whisper-control listening", "port", port, "services", services.names())
 	if err := http.ListenAndServe(":"+port, mux); err != nil {
 		slog.Error("server failed", "error", err)
 		os.Exit(1)
 	}
 }
 
-func handleStart(w http.ResponseWriter, r *http.Request) {
-	modelPath := whisperModel
-	if name := r.URL.Query().Get("model"); name != "" {
-		modelPath = filepath.Join(modelsDir, name)
-	}
-	if isRunning() {
+func handleStart(w http.ResponseWriter, r *http.Request, svc *service) {
+	if svc.running() {
 		writeJSON(w, currentGPU("already_running"))
 		return
 	}
-	whisperModel = modelPath
-	cmd := fmt.Sprintf(
-		"nohup %s -m %s --host 0.0.0.0 --port %s -t %s > /tmp/whisper-server.log 2>&1 &",
-		whisperBin, modelPath, whisperPort, whisperThreads,
-	)
-	out, err := exec.Command("bash", "-c", cmd).CombinedOutput()
-	if err != nil {
-		slog.Error("start whisper-server", "error", err, "output", string(out))
+	q := r.URL.Query()
+	if err := svc.start(q.Get("model"), q.Get("device")); err != nil {
+		slog.Error("start service", "name", svc.name, "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	slog.Info("waiting for whisper-server health", "port", whisperPort)
-	waitForHealth(fmt.Sprintf("http://localhost:%s", whisperPort), 30*time.Second)
-	slog.Info("whisper-server ready", "port", whisperPort)
+	slog.Info("service ready", "name", svc.name, "port", svc.activePort())
 	writeJSON(w, currentGPU("started"))
 }
 
-func handleStop(w http.ResponseWriter, r *http.Request) {
-	exec.Command("pkill", "-f", whisperBin).Run()
-	waitForExit(5 * time.Second)
-	slog.Info("whisper-server stopped")
+// handleStop stops every instance of svc, or with ?port= only that one.
+func handleStop(w http.ResponseWriter, r *http.Request, svc *service) {
+	if p := r.URL.Query().Get("port"); p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			http.Error(w, "invalid port", http.StatusBadRequest)
+			return
+		}
+		svc.stopPort(port)
+		slog.Info("service instance stopped", "name", svc.name, "port", port)
+		writeJSON(w, currentGPU("stopped"))
+		return
+	}
+	svc.stop()
+	slog.Info("service stopped", "name", svc.name)
 	writeJSON(w, currentGPU("stopped"))
 }
 
+// handleSwap starts ?model= beside the running instance of svc, for a
+// model change without downtime. The caller moves traffic to the returned
+// port and then stops previous_port. Services without alt_port get 501 and
+// stopped ones 409; both need a plain stop and start instead.
+func handleSwap(w http.ResponseWriter, r *http.Request, svc *service) {
+	if !svc.running() {
+		http.Error(w, "service not running; use start", http.StatusConflict)
+		return
+	}
+	model := r.URL.Query().Get("model")
+	prev, err := svc.swap(model)
+	if errors.Is(err, errNoAltPort) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		slog.Error("swap service", "name", svc.name, "model", model, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	next := svc.activePort()
+	slog.Info("service swapped", "name", svc.name, "model", model, "port", next, "previous_port", prev)
+	resp := currentGPU("swapped")
+	resp["port"] = next
+	resp["previous_port"] = prev
+	resp["health_url"] = svc.healthURL(next)
+	writeJSON(w, resp)
+}
+
 // waitForHealth polls a URL until it returns 200 or timeout expires.
-func waitForHealth(url string, timeout time.Duration) {
+// Reports whether it became healthy.
+func waitForHealth(url string, timeout time.Duration) bool {
 	client := &http.Client{Timeout: 2 * time.Second}
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
 		if healthOK(client, url) {
-			return
+			return true
 		}
 		time.Sleep(500 * time.Millisecond)
 	}
 	slog.Warn("health check timed out", "url", url)
+	return false
 }
 
 func healthOK(client *http.Client, url string) bool {
@@ -115,11 +160,11 @@ func healthOK(client *http.Client, url string) bool {
 	return resp.StatusCode == http.StatusOK
 }
 
-// waitForExit polls until process is no longer running or timeout expires.
-func waitForExit(timeout time.Duration) {
+// waitForExit polls until running reports false or timeout expires.
+func waitForExit(running func() bool, timeout time.Duration) {
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
-		if !isRunning() {
+		if !running() {
 			return
 		}
 		time.Sleep(200 * time.Millisecond)
@@ -135,18 +180,35 @@ func currentGPU(status string) map[string]any {
 	}
 }
 
-func handleStatus(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, map[string]bool{"running": isRunning()})
+func handleStatus(w http.ResponseWriter, r *http.Request, svc *service) {
+	st := map[string]any{"running": svc.running()}
+	if m := svc.activeModel(); m != "" {
+		st["model"] = filepath.Base(m)
+	}
+	writeJSON(w, st)
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]string{"status": "ok"})
 }
 
+// gpuInfo is the /gpu payload. The top-level fields describe GPU_DEVICE, so
+// single-GPU clients keep working; Devices lists every card.
 type gpuInfo struct {
 	VRAMTotalMB int          `json:"vram_total_mb"`
 	VRAMUsedMB  int          `json:"vram_used_mb"`
 	Processes   []gpuProcess `json:"processes"`
+	Devices     []gpuCard    `json:"devices"`
+}
+
+// gpuCard is one GPU as reported by rocm-smi. Index is the HIP device index
+// a service start's ?device= pins the process to.
+type gpuCard struct {
+	ID          string       `json:"id"` // rocm-smi name, e.g. "card0"
+	Index       int          `json:"index"`
+	VRAMTotalMB int          `json:"vram_total_mb"`
+	VRAMUsedMB  int          `json:"vram_used_mb"`
+	Processes   []gpuProcess `json:"processes"`
 }
 
 type gpuProcess struct {
@@ -161,67 +223,120 @@ func handleGPU(w http.ResponseWriter, r *http.Request) {
 }
 
 func getGPUInfo() gpuInfo {
-	info := gpuInfo{Processes: []gpuProcess{}}
+	info := gpuInfo{Processes: []gpuProcess{}, Devices: []gpuCard{}}
 	out, err := exec.Command("rocm-smi", "--showmeminfo", "vram", "--json").Output()
 	if err != nil {
 		slog.Error("rocm-smi failed", "error", err)
 		return info
 	}
-	info.VRAMTotalMB, info.VRAMUsedMB = parseVRAM(out)
-	info.Processes = scanGPUProcesses()
+	info.Devices = parseVRAM(out)
+	procs := scanGPUProcesses()
+
+	for i := range info.Devices {
+		card := &info.Devices[i]
+		card.Processes = procs[card.Index]
+		if card.Processes == nil {
+			card.Processes = []gpuProcess{}
+		}
+		addSystemProcess(card)
+		if card.ID == gpuDevice {
+			info.VRAMTotalMB, info.VRAMUsedMB, info.Processes = card.VRAMTotalMB, card.VRAMUsedMB, card.Processes
+		}
+	}
+
+	slog.Info("gpu response", "devices", len(info.Devices), "vram_total_mb", info.VRAMTotalMB, "vram_used_mb", info.VRAMUsedMB, "processes", len(info.Processes))
+	return info
+}
 
-	// Add "system" entry for unaccounted VRAM (driver, display server, framebuffers)
+// addSystemProcess adds a "system" entry for unaccounted VRAM (driver,
+// display server, framebuffers).
+func addSystemProcess(card *gpuCard) {
 	accounted := 0
-	for _, p := range info.Processes {
+	for _, p := range card.Processes {
 		accounted += p.VRAMMB
 	}
-	if gap := info.VRAMUsedMB - accounted; gap > 0 {
-		info.Processes = append(info.Processes, gpuProcess{PID: 0, Name: "system", VRAMMB: gap})
+	if gap := card.VRAMUsedMB - accounted; gap > 0 {
+		card.Processes = append(card.Processes, gpuProcess{PID: 0, Name: "system", VRAMMB: gap})
 	}
-
-	slog.Info("gpu response", "vram_total_mb", info.VRAMTotalMB, "vram_used_mb", info.VRAMUsedMB, "processes", len(info.Processes))
-	return info
 }
 
-func parseVRAM(raw []byte) (totalMB, usedMB int) {
+// parseVRAM reads every card from rocm-smi's JSON, ordered by index.
+func parseVRAM(raw []byte) []gpuCard {
 	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
 	jsonLine := lines[len(lines)-1]
 	var data map[string]map[string]string
 	if json.Unmarshal([]byte(jsonLine), &data) != nil {
-		return 0, 0
+		return []gpuCard{}
+	}
+	cards := make([]gpuCard, 0, len(data))
+	for id, card := range data {
+		idx, err := deviceIndex(id)
+		if err != nil {
+			continue // e.g. "system" entries
+		}
+		total, _ := strconv.ParseInt(card["VRAM Total Memory (B)"], 10, 64)
+		used, _ := strconv.ParseInt(card["VRAM Total Used Memory (B)"], 10, 64)
+		cards = append(cards, gpuCard{ID: id, Index: idx, VRAMTotalMB: int(total / (1024 * 1024)), VRAMUsedMB: int(used / (1024 * 1024))})
 	}
-	card, ok := data[gpuDevice]
-	if !ok {
-		return 0, 0
+	slices.SortFunc(cards, func(a, b gpuCard) int { return a.Index - b.Index })
+	return cards
+}
+
+// deviceIndex parses a device as given to ?device= or by rocm-smi: "card1" or "1".
+func deviceIndex(device string) (int, error) {
+	idx, err := strconv.Atoi(strings.TrimPrefix(device, "card"))
+	if err != nil || idx < 0 {
+		return 0, fmt.Errorf("invalid device %q", device)
 	}
-	total, _ := strconv.ParseInt(card["VRAM Total Memory (B)"], 10, 64)
-	used, _ := strconv.ParseInt(card["VRAM Total Used Memory (B)"], 10, 64)
-	return int(total / (1024 * 1024)), int(used / (1024 * 1024))
+	return idx, nil
 }
 
-func scanGPUProcesses() []gpuProcess {
+// scanGPUProcesses returns per-process VRAM keyed by device index. KFD
+// reports one vram_<gpu_id> file per GPU a process has memory on.
+func scanGPUProcesses() map[int][]gpuProcess {
 	kfdProc := "/sys/class/kfd/kfd/proc"
 	entries, err := os.ReadDir(kfdProc)
 	if err != nil {
-		return []gpuProcess{}
+		return nil
 	}
-	procs := []gpuProcess{}
+	devices := kfdDeviceIndexes()
+	procs := map[int][]gpuProcess{}
 	for _, entry := range entries {
-		p := parseGPUProc(kfdProc, entry.Name())
-		if p != nil {
-			procs = append(procs, *p)
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		name := processName(pid)
+		for idx, vram := range pidVRAM(filepath.Join(kfdProc, entry.Name()), devices) {
+			procs[idx] = append(procs[idx], gpuProcess{PID: pid, Name: name, VRAMMB: vram / (1024 * 1024)})
 		}
 	}
 	return procs
 }
 
-func parseGPUProc(kfdProc, name string) *gpuProcess {
-	pid, err := strconv.Atoi(name)
+// kfdDeviceIndexes maps KFD gpu_ids to device indexes. GPU topology nodes
+// (CPU nodes have gpu_id 0) are numbered in the same order as rocm-smi cards.
+func kfdDeviceIndexes() map[string]int {
+	nodes, err := filepath.Glob("/sys/class/kfd/kfd/topology/nodes/*/gpu_id")
 	if err != nil {
 		return nil
 	}
-	vram := pidVRAM(filepath.Join(kfdProc, name))
-	return &gpuProcess{PID: pid, Name: processName(pid), VRAMMB: vram / (1024 * 1024)}
+	slices.SortFunc(nodes, func(a, b string) int { return topologyNode(a) - topologyNode(b) })
+	devices := map[string]int{}
+	for _, path := range nodes {
+		data, err := os.ReadFile(path)
+		id := strings.TrimSpace(string(data))
+		if err != nil || id == "0" {
+			continue
+		}
+		devices[id] = len(devices)
+	}
+	return devices
+}
+
+func topologyNode(gpuIDPath string) int {
+	n, _ := strconv.Atoi(filepath.Base(filepath.Dir(gpuIDPath)))
+	return n
 }
 
 func processName(pid int) string {
@@ -233,16 +348,19 @@ func processName(pid int) string {
 	return filepath.Base(exe)
 }
 
-func pidVRAM(dir string) int {
+// pidVRAM sums a process's VRAM bytes per device index. Files for unknown
+// gpu_ids count toward device 0.
+func pidVRAM(dir string, devices map[string]int) map[int]int {
 	entries, err := filepath.Glob(filepath.Join(dir, "vram_*"))
 	if err != nil {
-		return 0
+		return nil
 	}
-	total := 0
+	totals := map[int]int{}
 	for _, f := range entries {
-		total += readVRAMFile(f)
+		idx := devices[strings.TrimPrefix(filepath.Base(f), "vram_")]
+		totals[idx] += readVRAMFile(f)
 	}
-	return total
+	return totals
 }
 
 func readVRAMFile(path string) int {
@@ -257,10 +375,6 @@ func readVRAMFile(path string) int {
 	return int(v)
 }
 
-func isRunning() bool {
-	return exec.Command("pgrep", "-f", whisperBin).Run() == nil
-}
-
 func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(v)
@@ -271,121 +385,43 @@ func handleListModels(w http.ResponseWriter, r *http.Request) {
 		Name       string `json:"name"`
 		Downloaded bool   `json:"downloaded"`
 		SizeMB     int    `json:"size_mb"`
+		PartialMB  int    `json:"partial_mb,omitempty"` // an interrupted download a retry resumes
 	}
 	models := make([]modelInfo, 0, len(knownModels))
 	for _, name := range knownModels {
 		downloaded, sizeMB := modelStatus(name)
-		models = append(models, modelInfo{Name: name, Downloaded: downloaded, SizeMB: sizeMB})
+		models = append(models, modelInfo{Name: name, Downloaded: downloaded, SizeMB: sizeMB, PartialMB: partialMB(name)})
 	}
 	writeJSON(w, map[string]any{
 		"models": models,
-		"active": filepath.Base(whisperModel),
+		"active": filepath.Base(activeWhisperModel()),
 		"dir":    modelsDir,
 	})
 }
 
-// progressWriter wraps a file and streams NDJSON progress to the HTTP response.
-type progressWriter struct {
-	out        *os.File
-	w          http.ResponseWriter
-	flushFn    func()
-	total      int64
-	downloaded int64
-	lastReport time.Time
-}
-
-func (pw *progressWriter) Write(p []byte) (int, error) {
-	n, err := pw.out.Write(p)
-	if err != nil {
-		return n, err
-	}
-	pw.downloaded += int64(n)
-	if time.Since(pw.lastReport) <= 500*time.Millisecond {
-		return n, nil
-	}
-	json.NewEncoder(pw.w).Encode(map[string]int64{"bytes": pw.downloaded, "total": pw.total})
-	pw.flushFn()
-	pw.lastReport = time.Now()
-	return n, nil
-}
-
-func noopFlush() {}
-
-func handleDownloadModel(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name string `json:"name"`
-	}
-	if json.NewDecoder(r.Body).Decode(&req) != nil || req.Name == "" {
-		http.Error(w, "missing name", http.StatusBadRequest)
-		return
-	}
-	if !slices.Contains(knownModels, req.Name) {
-		http.Error(w, "unknown model", http.StatusBadRequest)
-		return
-	}
-	dest := filepath.Join(modelsDir, req.Name)
-	if _, err := os.Stat(dest); err == nil {
-		writeJSON(w, map[string]string{"status": "already_downloaded"})
-		return
-	}
-
-	os.MkdirAll(modelsDir, 0755)
-
-	flushFn := noopFlush
-	flusher, ok := w.(http.Flusher)
-	if ok {
-		flushFn = flusher.Flush
-	}
-	w.Header().Set("Content-Type", "application/x-ndjson")
-	w.WriteHeader(http.StatusOK)
-
-	err := downloadModel(req.Name, dest, w, flushFn)
+func modelStatus(name string) (bool, int) {
+	info, err := os.Stat(filepath.Join(modelsDir, name))
 	if err != nil {
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		return
+		return false, 0
 	}
-	json.NewEncoder(w).Encode(map[string]string{"status": "done"})
-	flushFn()
+	return true, int(info.Size() / (1024 * 1024))
 }
 
-func downloadModel(name, dest string, w http.ResponseWriter, flushFn func()) error {
-	url := modelBaseURL + name
-	slog.Info("downloading whisper model", "name", name, "url", url)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("download request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download returned %s", resp.Status)
-	}
-
-	out, err := os.Create(dest + ".tmp")
+// partialMB is the size of name's interrupted download, if any.
+func partialMB(name string) int {
+	info, err := os.Stat(filepath.Join(modelsDir, name+".tmp"))
 	if err != nil {
-		return fmt.Errorf("create file: %w", err)
-	}
-
-	pw := &progressWriter{out: out, w: w, flushFn: flushFn, total: resp.ContentLength, lastReport: time.Now()}
-	_, copyErr := io.Copy(pw, resp.Body)
-	out.Close()
-
-	if copyErr != nil {
-		os.Remove(dest + ".tmp")
-		return copyErr
+		return 0
 	}
-	os.Rename(dest+".tmp", dest)
-	slog.Info("model downloaded", "name", name, "bytes", pw.downloaded)
-	return nil
+	return int(info.Size() / (1024 * 1024))
 }
 
-func modelStatus(name string) (bool, int) {
-	info, err := os.Stat(filepath.Join(modelsDir, name))
-	if err != nil {
-		return false, 0
+// activeWhisperModel is the model path whisper-server runs (or will run) with.
+func activeWhisperModel() string {
+	if svc, ok := services.services["whisper-server"]; ok {
+		return svc.activeModel()
 	}
-	return true, int(info.Size() / (1024 * 1024))
+	return whisperModel
 }
 
 func envOr(key, fallback string) string {
@@ -395,3 +431,11 @@ func envOr(key, fallback string) string {
 	}
 	return v
 }
+
+func envInt(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return n
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultStartTimeout is how long /start waits for a service's health URL.
+	defaultStartTimeout = 30 * time.Second
+
+	// logTailBytes caps how much of a service log /logs returns.
+	logTailBytes = 64 * 1024
+)
+
+// serviceConfig describes one host binary the controller can run.
+// In Args, {port}, {model} and {threads} are replaced with the service's
+// port, active model path, and Limits.Threads (default every CPU).
+type serviceConfig struct {
+	Bin           string   `json:"bin"`
+	Args          []string `json:"args"`
+	Port          int      `json:"port"`
+	HealthURL     string   `json:"health_url"`      // may use {port}; default http://localhost:{port}
+	Model         string   `json:"model"`           // initial {model} path
+	ModelsDir     string   `json:"models_dir"`      // where ?model=name is looked up
+	StartTimeoutS int      `json:"start_timeout_s"` // default 30
+	// Device pins the process to one GPU ("card1" or "1") via
+	// HIP_VISIBLE_DEVICES; "" leaves every GPU visible.
+	Device string `json:"device"`
+	// Match is the pgrep -f pattern that finds the running process
+	// (default Bin); set it when Bin is an interpreter such as python3.
+	Match string `json:"match"`
+	// AltPort enables /swap: the new model starts on whichever of Port and
+	// AltPort is idle, so the old instance serves until it is stopped.
+	AltPort int `json:"alt_port"`
+	// InstancePorts enables /instances: extra processes on their own models
+	// running beside the main one, e.g. a tiny model for live calls next to
+	// large-v3 for batch jobs. One instance per port.
+	InstancePorts []int `json:"instance_ports"`
+	// Limits applies to every process of the service, each on its own.
+	Limits resourceLimits `json:"limits"`
+}
+
+// controlConfig is the controller's config file (CONTROL_CONFIG).
+type controlConfig struct {
+	// Default is the service behind the legacy /start, /stop, and /status routes.
+	Default  string                   `json:"default"`
+	Services map[string]serviceConfig `json:"services"`
+}
+
+// loadConfig reads CONTROL_CONFIG, or falls back to a single whisper-server
+// built from the WHISPER_* environment variables.
+func loadConfig() (controlConfig, error) {
+	path := os.Getenv("CONTROL_CONFIG")
+	if path == "" {
+		return defaultConfig(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return controlConfig{}, fmt.Errorf("read %s: %w", path, err)
+	}
+	var cfg controlConfig
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return controlConfig{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(cfg.Services) == 0 {
+		return controlConfig{}, fmt.Errorf("%s: no services configured", path)
+	}
+	if _, ok := cfg.Services[cfg.Default]; !ok {
+		return controlConfig{}, fmt.Errorf("%s: default service %q not configured", path, cfg.Default)
+	}
+	for name, sc := range cfg.Services {
+		if err = sc.Limits.validate(); err != nil {
+			return controlConfig{}, fmt.Errorf("%s: %s limits: %w", path, name, err)
+		}
+	}
+	return cfg, nil
+}
+
+func defaultConfig() controlConfig {
+	port, _ := strconv.Atoi(whisperPort)
+	return controlConfig{
+		Default: "whisper-server",
+		Services: map[string]serviceConfig{
+			"whisper-server": {
+				Bin:       whisperBin,
+				Args:      []string{"-m", "{model}", "--host", "0.0.0.0", "--port", "{port}", "-t", whisperThreads},
+				Port:      port,
+				Model:     whisperModel,
+				ModelsDir: modelsDir,
+				AltPort:   whisperAltPort,
+				Limits: resourceLimits{
+					CPUs:     os.Getenv("WHISPER_CPUS"),
+					Nice:     envInt("WHISPER_NICE", 0),
+					MemoryMB: envInt("WHISPER_MEMORY_MB", 0),
+				},
+			},
+		},
+	}
+}
+
+// service is the runtime state of one configured binary. Processes are
+// detached and found by binary path, so they outlive a controller restart.
+type service struct {
+	name string
+	cfg  serviceConfig
+
+	mu        sync.Mutex
+	model     string            // active {model} path
+	device    string            // active Device
+	port      int               // active {port}: Port, or AltPort after a swap
+	instances map[int]*instance // side-by-side instances by port
+}
+
+func newService(name string, cfg serviceConfig) *service {
+	if cfg.Match == "" {
+		cfg.Match = cfg.Bin
+	}
+	if cfg.HealthURL == "" && cfg.Port != 0 {
+		cfg.HealthURL = "http://localhost:{port}"
+	}
+	s := &service{name: name, cfg: cfg, model: cfg.Model, device: cfg.Device, port: cfg.Port, instances: map[int]*instance{}}
+	// a swapped instance outlives a controller restart on the alternate port
+	if cfg.AltPort != 0 && !s.runningOn(cfg.Port) && s.runningOn(cfg.AltPort) {
+		s.port = cfg.AltPort
+	}
+	s.recoverInstances()
+	return s
+}
+
+func (s *service) logPath() string {
+	return filepath.Join(os.TempDir(), s.name+".log")
+}
+
+// portLogPath is the log of the process on port: the service log for the
+// main instance, or a log of its own for a side-by-side one.
+func (s *service) portLogPath(port int) string {
+	if slices.Contains(s.cfg.InstancePorts, port) {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d.log", s.name, port))
+	}
+	return s.logPath()
+}
+
+func (s *service) activeModel() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.model
+}
+
+func (s *service) activeDevice() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.device
+}
+
+func (s *service) activePort() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.port
+}
+
+// healthURL is the health URL of the instance on port ("" = none).
+func (s *service) healthURL(port int) string {
+	return strings.ReplaceAll(s.cfg.HealthURL, "{port}", strconv.Itoa(port))
+}
+
+// running reports whether the main instance is up, on either swap port.
+// Side-by-side instances don't count.
+func (s *service) running() bool {
+	if len(s.cfg.InstancePorts) > 0 {
+		return s.runningOn(s.cfg.Port) || (s.cfg.AltPort != 0 && s.runningOn(s.cfg.AltPort))
+	}
+	return exec.Command("pgrep", "-f", s.cfg.Match).Run() == nil
+}
+
+// instancePattern matches the process listening on port. Only services
+// with AltPort or InstancePorts run more than one instance, so others match
+// on Match alone.
+func (s *service) instancePattern(port int) string {
+	if s.cfg.AltPort == 0 && len(s.cfg.InstancePorts) == 0 {
+		return s.cfg.Match
+	}
+	return regexp.QuoteMeta(s.cfg.Match) + ".*[^0-9]" + strconv.Itoa(port) + "([^0-9]|$)"
+}
+
+func (s *service) runningOn(port int) bool {
+	return exec.Command("pgrep", "-f", s.instancePattern(port)).Run() == nil
+}
+
+// start launches the binary with model (a file name in ModelsDir, or "" for
+// the current one) on device ("" for the configured one) and waits for its
+// health URL.
+func (s *service) start(model, device string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if model != "" {
+		if filepath.Base(model) != model {
+			return fmt.Errorf("invalid model name %q", model)
+		}
+		s.model = filepath.Join(s.cfg.ModelsDir, model)
+	}
+	if device != "" {
+		if _, err := deviceIndex(device); err != nil {
+			return err
+		}
+		s.device = device
+	}
+
+	if err := s.launch(s.port, s.model, s.device, os.O_TRUNC); err != nil {
+		return err
+	}
+	s.waitHealthy(s.port)
+	return nil
+}
+
+// launch runs the binary on port with model and device. flag is
+// os.O_TRUNC for a fresh log or os.O_APPEND to keep a running instance's
+// output.
+func (s *service) launch(port int, model, device string, flag int) error {
+	logFile, err := os.OpenFile(s.portLogPath(port), os.O_CREATE|os.O_WRONLY|flag, 0o644)
+	if err != nil {
+		return fmt.Errorf("log file: %w", err)
+	}
+	defer logFile.Close()
+
+	bin, args := s.cfg.Limits.wrap(s.cfg.Bin, s.args(port, model))
+	cmd := exec.Command(bin, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	var env []string
+	if device != "" {
+		idx, _ := deviceIndex(device)
+		env = append(env, "HIP_VISIBLE_DEVICES="+strconv.Itoa(idx))
+	}
+	if s.cfg.Limits.Threads > 0 {
+		env = append(env, "OMP_NUM_THREADS="+strconv.Itoa(s.cfg.Limits.Threads))
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true} // detach like nohup
+	if s.cfg.Limits.needsCgroup() {
+		cg, err := s.cgroup(port)
+		if err != nil {
+			return fmt.Errorf("limit %s: %w", s.name, err)
+		}
+		defer cg.Close()
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cg.Fd())
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", s.name, err)
+	}
+	go cmd.Wait() // reap the child if it exits while we're running
+	return nil
+}
+
+// waitHealthy waits for the instance on port. Reports false on timeout;
+// services without a health URL are healthy once launched.
+func (s *service) waitHealthy(port int) bool {
+	url := s.healthURL(port)
+	if url == "" {
+		return true
+	}
+	timeout := defaultStartTimeout
+	if s.cfg.StartTimeoutS > 0 {
+		timeout = time.Duration(s.cfg.StartTimeoutS) * time.Second
+	}
+	slog.Info("waiting for service health", "name", s.name, "url", url)
+	return waitForHealth(url, timeout)
+}
+
+func (s *service) args(port int, model string) []string {
+	threads := s.cfg.Limits.Threads
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+	r := strings.NewReplacer("{port}", strconv.Itoa(port), "{model}", model, "{threads}", strconv.Itoa(threads))
+	args := make([]string, len(s.cfg.Args))
+	for i, a := range s.cfg.Args {
+		args[i] = r.Replace(a)
+	}
+	return args
+}
+
+// errNoAltPort is returned by swap for a service that can only run once.
+var errNoAltPort = errors.New("no alt_port configured")
+
+// swap starts model on the idle port while the current instance keeps
+// serving, and makes it the active one. The old instance is left running
+// for the caller to stop once traffic has moved; its port is returned.
+func (s *service) swap(model string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cfg.AltPort == 0 {
+		return 0, errNoAltPort
+	}
+	if filepath.Base(model) != model || model == "" {
+		return 0, fmt.Errorf("invalid model name %q", model)
+	}
+	next := s.cfg.AltPort
+	if s.port == s.cfg.AltPort {
+		next = s.cfg.Port
+	}
+	if s.runningOn(next) {
+		return 0, fmt.Errorf("%s port %d is still in use by a previous instance", s.name, next)
+	}
+
+	prevModel := s.model
+	s.model = filepath.Join(s.cfg.ModelsDir, model)
+	if err := s.launch(next, s.model, s.device, os.O_APPEND); err != nil {
+		s.model = prevModel
+		return 0, err
+	}
+	if !s.waitHealthy(next) {
+		exec.Command("pkill", "-f", s.instancePattern(next)).Run()
+		s.model = prevModel
+		return 0, fmt.Errorf("%s on port %d did not become healthy", s.name, next)
+	}
+	prev := s.port
+	s.port = next
+	return prev, nil
+}
+
+// stop stops every instance, side-by-side ones included.
+func (s *service) stop() {
+	exec.Command("pkill", "-f", s.cfg.Match).Run()
+	waitForExit(func() bool { return exec.Command("pgrep", "-f", s.cfg.Match).Run() == nil }, 5*time.Second)
+	s.mu.Lock()
+	clear(s.instances)
+	s.mu.Unlock()
+}
+
+// stopPort stops only the instance on port, e.g. the old one after a swap.
+func (s *service) stopPort(port int) {
+	exec.Command("pkill", "-f", s.instancePattern(port)).Run()
+	waitForExit(func() bool { return s.runningOn(port) }, 5*time.Second)
+}
+
+// logTail returns up to the last logTailBytes of the log of the process on
+// port (0 = the main instance).
+func (s *service) logTail(port int) ([]byte, error) {
+	data, err := os.ReadFile(s.portLogPath(port))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > logTailBytes {
+		data = data[len(data)-logTailBytes:]
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	return data, nil
+}
+
+// registry holds every configured service by name.
+type registry struct {
+	services map[string]*service
+	fallback *service
+}
+
+func newRegistry(cfg controlConfig) *registry {
+	reg := &registry{services: make(map[string]*service, len(cfg.Services))}
+	for name, sc := range cfg.Services {
+		reg.services[name] = newService(name, sc)
+	}
+	reg.fallback = reg.services[cfg.Default]
+	return reg
+}
+
+// names returns service names in sorted order.
+func (reg *registry) names() []string {
+	names := make([]string, 0, len(reg.services))
+	for n := range reg.services {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// lookup resolves the {name} path segment, writing a 404 when unknown.
+func (reg *registry) lookup(w http.ResponseWriter, r *http.Request) (*service, bool) {
+	svc, ok := reg.services[r.PathValue("name")]
+	if !ok {
+		http.Error(w, "unknown service", http.StatusNotFound)
+	}
+	return svc, ok
+}
+
+func (reg *registry) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /services", reg.handleList)
+	mux.HandleFunc("POST /services/{name}/start", reg.named(handleStart))
+	mux.HandleFunc("POST /services/{name}/stop", reg.named(handleStop))
+	mux.HandleFunc("POST /services/{name}/swap", reg.named(handleSwap))
+	mux.HandleFunc("GET /services/{name}/status", reg.named(handleStatus))
+	mux.HandleFunc("GET /services/{name}/logs", reg.named(handleLogs))
+	mux.HandleFunc("GET /services/{name}/instances", reg.named(handleListInstances))
+	mux.HandleFunc("POST /services/{name}/instances", reg.named(handleStartInstance))
+	mux.HandleFunc("DELETE /services/{name}/instances/{port}", reg.named(handleStopInstance))
+
+	// legacy single-service routes, kept for existing gateway configs
+	mux.HandleFunc("POST /start", reg.fallbackTo(handleStart))
+	mux.HandleFunc("POST /stop", reg.fallbackTo(handleStop))
+	mux.HandleFunc("POST /swap", reg.fallbackTo(handleSwap))
+	mux.HandleFunc("GET /status", reg.fallbackTo(handleStatus))
+}
+
+type serviceHandler func(w http.ResponseWriter, r *http.Request, svc *service)
+
+func (reg *registry) named(h serviceHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if svc, ok := reg.lookup(w, r); ok {
+			h(w, r, svc)
+		}
+	}
+}
+
+func (reg *registry) fallbackTo(h serviceHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, reg.fallback)
+	}
+}
+
+func (reg *registry) handleList(w http.ResponseWriter, r *http.Request) {
+	type serviceStatus struct {
+		Name      string `json:"name"`
+		Running   bool   `json:"running"`
+		Port      int    `json:"port"`
+		HealthURL string `json:"health_url,omitempty"`
+		Model     string `json:"model,omitempty"`
+		Device    string `json:"device,omitempty"`
+		Instances int    `json:"instances,omitempty"` // side-by-side instances running
+	}
+	out := make([]serviceStatus, 0, len(reg.services))
+	for _, name := range reg.names() {
+		svc := reg.services[name]
+		st := serviceStatus{Name: name, Running: svc.running(), Port: svc.activePort(), HealthURL: svc.healthURL(svc.activePort()), Device: svc.activeDevice(), Instances: svc.instanceCount()}
+		if m := svc.activeModel(); m != "" {
+			st.Model = filepath.Base(m)
+		}
+		out = append(out, st)
+	}
+	writeJSON(w, map[string]any{"services": out})
+}
+
+// handleLogs returns the service log, or with ?port= a side-by-side
+// instance's.
+func handleLogs(w http.ResponseWriter, r *http.Request, svc *service) {
+	port, _ := strconv.Atoi(r.URL.Query().Get("port"))
+	data, err := svc.logTail(port)
+	if err != nil {
+		http.Error(w, "no log", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
@@ -0,0 +1,33 @@
+import asyncio
+from contextlib import asynccontextmanager
+
+from fastapi import FastAPI
+from pydantic import BaseModel
+
+from models import Embedder
+
+embedder: Embedder | None = None
+
+
+@asynccontextmanager
+async def lifespan(_app: FastAPI):
+    global embedder
+    embedder = Embedder()
+    yield
+
+
+app = FastAPI(lifespan=lifespan)
+
+
+class EmbedRequest(BaseModel):
+    input: str
+
+
+@app.get("/health")
+async def health():
+    return {"status": "ok", "version": embedder.model}
+
+
+@app.post("/embed")
+async def embed(req: EmbedRequest):
+    return await asyncio.get_event_loop().run_in_executor(None, embedder.embed, req.input)
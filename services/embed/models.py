@@ -0,0 +1,49 @@
+import os
+import time
+
+import numpy as np
+import onnxruntime as ort
+from huggingface_hub import hf_hub_download
+from tokenizers import Tokenizer
+
+DEFAULT_MODEL = "sentence-transformers/all-MiniLM-L6-v2"
+MAX_TOKENS = 256
+
+
+class Embedder:
+    """Sentence embeddings from an ONNX export: mean-pooled token states, L2-normalized."""
+
+    def __init__(self) -> None:
+        self.model = os.environ.get("EMBED_MODEL", DEFAULT_MODEL)
+        threads = int(os.environ.get("EMBED_THREADS", "2"))
+
+        self.tokenizer = Tokenizer.from_file(hf_hub_download(self.model, "tokenizer.json"))
+        self.tokenizer.enable_truncation(MAX_TOKENS)
+
+        opts = ort.SessionOptions()
+        opts.intra_op_num_threads = threads
+        opts.inter_op_num_threads = 1
+        self.session = ort.InferenceSession(
+            hf_hub_download(self.model, "onnx/model.onnx"), opts, providers=["CPUExecutionProvider"],
+        )
+        self.inputs = {i.name for i in self.session.get_inputs()}
+
+    def embed(self, text: str) -> dict:
+        t0 = time.perf_counter()
+        enc = self.tokenizer.encode(text)
+        ids = np.array([enc.ids], dtype=np.int64)
+        mask = np.array([enc.attention_mask], dtype=np.int64)
+        feed = {"input_ids": ids, "attention_mask": mask}
+        if "token_type_ids" in self.inputs:
+            feed["token_type_ids"] = np.zeros_like(ids)
+        states = self.session.run(None, feed)[0][0]
+
+        weights = mask[0][:, None].astype(np.float32)
+        vec = (states * weights).sum(axis=0) / max(weights.sum(), 1.0)
+        vec /= max(float(np.linalg.norm(vec)), 1e-12)
+        latency_ms = (time.perf_counter() - t0) * 1000
+        return {
+            "embedding": vec.astype(np.float32).tolist(),
+            "model": self.model,
+            "latency_ms": round(latency_ms, 2),
+        }
@@ -1,10 +1,35 @@
+// FRAME_SECONDS batches render quanta (128 samples each) into 20 ms frames,
+// so the socket carries 50 messages a second at any context rate rather
+// than one per quantum (375/s at 48 kHz).
+const FRAME_SECONDS = 0.02;
+
 class PCMSender extends AudioWorkletProcessor {
+  constructor() {
+    super();
+    this.frame = new Float32Array(Math.round(sampleRate * FRAME_SECONDS));
+    this.filled = 0;
+  }
+
   process(inputs) {
     const input = inputs[0];
     if (input.length > 0) {
-      this.port.postMessage(new Float32Array(input[0]));
+      this.append(input[0]);
     }
     return true;
   }
+
+  append(samples) {
+    let offset = 0;
+    while (offset < samples.length) {
+      const n = Math.min(samples.length - offset, this.frame.length - this.filled);
+      this.frame.set(samples.subarray(offset, offset + n), this.filled);
+      this.filled += n;
+      offset += n;
+      if (this.filled === this.frame.length) {
+        this.port.postMessage(this.frame.slice());
+        this.filled = 0;
+      }
+    }
+  }
 }
 registerProcessor("pcm-sender", PCMSender);
@@ -12,6 +12,9 @@ export const stopService = (name) =>
 export const fetchASRModels = () =>
   client.get("/asr/models").then((r) => r.data);
 
+export const activateASRModel = (name) =>
+  client.post("/asr/models/activate", { name }).then((r) => r.data);
+
 const processNDJSONLines = (lines, onProgress) => {
   const parsed = lines.filter((l) => l.trim()).map((l) => JSON.parse(l));
   const err = parsed.find((m) => m.error);
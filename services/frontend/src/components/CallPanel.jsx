@@ -12,6 +12,7 @@ import {
   stopService as apiStopService,
   fetchASRModels as apiFetchASRModels,
   downloadASRModel as apiDownloadASRModel,
+  activateASRModel as apiActivateASRModel,
 } from "../api/services";
 import { warmupTTS } from "../api/tts";
 import "../style/call-panel.css";
@@ -77,6 +78,7 @@ export const CallPanel = () => {
   const [loadingLLM, setLoadingLLM] = createSignal(false);
   const [loadingTTS, setLoadingTTS] = createSignal(false);
   const [availableTTS, setAvailableTTS] = createSignal([]);
+  const [availableASR, setAvailableASR] = createSignal([]);
   const [availableLLMEngines, setAvailableLLMEngines] = createSignal(["ollama"]);
   const [transcripts, setTranscripts] = createSignal([]);
   const [llmResponse, setLlmResponse] = createSignal("");
@@ -132,6 +134,7 @@ export const CallPanel = () => {
 
   let playAudioCtx = null;
   let playAt = 0;
+  const playingSources = new Set();
   let scCtx = null;
   let scStream = null;
   let scRaf = null;
@@ -178,6 +181,7 @@ export const CallPanel = () => {
         setOllamaModels(data.llm.models);
         if (data.llm?.engines) setAvailableLLMEngines(data.llm.engines);
         if (data.tts?.engines) setAvailableTTS(data.tts.engines);
+        if (data.asr?.engines) setAvailableASR(data.asr.engines);
         if (data.audio?.bandwidth_modes) setBandwidthModes(data.audio.bandwidth_modes);
 
         // Validate saved model belongs to the current engine's model list
@@ -288,10 +292,19 @@ export const CallPanel = () => {
       const startAt = Math.max(ctx.currentTime, playAt);
       source.start(startAt);
       playAt = startAt + buf.duration;
+      playingSources.add(source);
+      source.onended = () => playingSources.delete(source);
     });
   };
 
-  const { isStreaming, isRecording, startMic, startSnippet, pauseRecording, resumeRecording, processSnippet, startFile, stop, sendChat } = useAudioStream({
+  // barge-in: the gateway dropped the reply, so stop what is queued to play
+  const stopPlayback = () => {
+    playingSources.forEach((source) => source.stop());
+    playingSources.clear();
+    playAt = 0;
+  };
+
+  const { isStreaming, isRecording, startMic, startSnippet, pauseRecording, resumeRecording, processSnippet, startFile, uploadSnippet, stop, sendChat } = useAudioStream({
     ttsEngine,
     asrEngine,
     systemPrompt,
@@ -325,6 +338,16 @@ export const CallPanel = () => {
       setPendingThinking("");
     },
     onThinkingDone: (text) => setPendingThinking(text),
+    onTurnCancelled: () => {
+      stopPlayback();
+      cancelAnimationFrame(tokenRAF);
+      tokenRAF = 0;
+      const partial = llmResponse() + tokenBuf;
+      tokenBuf = "";
+      if (partial) setTranscripts((prev) => [...prev, { role: "agent", text: partial, interrupted: true }]);
+      setLlmResponse("");
+      setPendingThinking("");
+    },
     onAudio: playAudio,
     onMetrics: (m) => {
       setLatestMetrics(m);
@@ -364,8 +387,12 @@ export const CallPanel = () => {
     const svc = ENGINE_TO_SERVICE[asrEngine()];
     if (!svc) return;
     setLoadingASR(true);
-    stopService(svc)
-      .then(() => startService(svc, `model=${model}`))
+    // the gateway swaps whisper-server models without dropping live calls
+    const activate = svc === "whisper-server"
+      ? apiActivateASRModel(model).then(() =>
+          setServiceStatuses((prev) => ({ ...prev, [svc]: "healthy" })))
+      : stopService(svc).then(() => startService(svc, `model=${model}`));
+    activate
       .catch((err) =>
         setError(`ASR model switch failed: ${err instanceof Error ? err.message : err}`),
       )
@@ -486,7 +513,7 @@ export const CallPanel = () => {
 
   const configProps = {
     asrEngine, asrModel, asrModels, llmEngine, llmModel, allLLMModels, ttsEngine,
-    availableTTS, loadingASR, loadingLLM, loadingTTS, isStreaming,
+    availableTTS, availableASR, loadingASR, loadingLLM, loadingTTS, isStreaming,
     systemPrompt, promptPreset, langPref, serviceStatuses, downloadingModel, downloadProgress,
     audioBandwidth, bandwidthModes,
   };
@@ -527,6 +554,7 @@ export const CallPanel = () => {
     stop,
     startMic: () => { if (soundChecking()) stopSoundCheck(); startMic(); },
     startFile,
+    uploadSnippet,
     setMode: handleSetMode,
     startSnippet: () => { if (soundChecking()) stopSoundCheck(); startSnippet(); },
     pauseRecording,
@@ -6,6 +6,7 @@ import { ExplainPanel } from "./ExplainPanel";
 export const CenterPanel = (props) => {
   const { config: c, on } = props;
   let fileInput;
+  let snippetFileInput;
   let transcriptRef;
   const [chatInput, setChatInput] = createSignal("");
 
@@ -20,6 +21,12 @@ export const CenterPanel = (props) => {
     if (file) on.startFile(file);
   };
 
+  const handleSnippetFileSelect = (e) => {
+    const file = e.target.files?.[0];
+    e.target.value = "";
+    if (file) on.uploadSnippet(file);
+  };
+
   const handleChatSubmit = (e) => {
     e.preventDefault();
     const text = chatInput().trim();
@@ -124,6 +131,10 @@ export const CenterPanel = (props) => {
           <button onClick={on.startSnippet} class="btn" disabled={!enginesReady()}>
             Start Session
           </button>
+          <button onClick={() => snippetFileInput.click()} class="btn btn-secondary" disabled={!enginesReady()}>
+            Upload File
+          </button>
+          <input ref={snippetFileInput} type="file" accept="audio/*" onChange={handleSnippetFileSelect} style={{ display: "none" }} />
         </Show>
 
         <Show when={c.mode() === "snippet" && c.isStreaming()}>
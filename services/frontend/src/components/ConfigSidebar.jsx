@@ -33,6 +33,16 @@ export const ConfigSidebar = (props) => {
               <optgroup label="whisper-server (GPU)">
                 <option value="whisper-server">whisper-server (GPU)</option>
               </optgroup>
+              <Show when={c.availableASR().some((e) => e === "openai" || e === "azure")}>
+                <optgroup label="Cloud">
+                  <Show when={c.availableASR().includes("openai")}>
+                    <option value="openai">OpenAI transcription</option>
+                  </Show>
+                  <Show when={c.availableASR().includes("azure")}>
+                    <option value="azure">Azure Speech-to-Text</option>
+                  </Show>
+                </optgroup>
+              </Show>
             </select>
             <Show when={c.loadingASR()}>
               <span class="spinner" />
@@ -170,6 +180,19 @@ export const ConfigSidebar = (props) => {
                 <option value="quality">Piper Quality, balanced (17MB)</option>
                 <option value="high">Piper High, most natural (109MB)</option>
               </optgroup>
+              <Show when={c.availableTTS().some((e) => ["azure", "google", "polly"].includes(e))}>
+                <optgroup label="Managed (cloud)">
+                  <Show when={c.availableTTS().includes("azure")}>
+                    <option value="azure">Azure Speech</option>
+                  </Show>
+                  <Show when={c.availableTTS().includes("google")}>
+                    <option value="google">Google Cloud TTS</option>
+                  </Show>
+                  <Show when={c.availableTTS().includes("polly")}>
+                    <option value="polly">AWS Polly (neural)</option>
+                  </Show>
+                </optgroup>
+              </Show>
             </select>
             <Show when={c.loadingTTS()}>
               <span class="spinner" />
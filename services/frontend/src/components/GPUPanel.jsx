@@ -4,6 +4,13 @@ import "../style/gpu-panel.css";
 
 const [gpu, setGpu] = createSignal(null);
 const [sseStatus, setSseStatus] = createSignal("connecting");
+const [decision, setDecision] = createSignal(null);
+
+const DECISION_TEXT = {
+  evict: (d) => `Evicted ${d.victim} for ${d.target}`,
+  admit: (d) => `Made room for ${d.target}`,
+  refuse: (d) => `No room for ${d.target} (${d.required_mb} MB, ${d.free_mb} MB free)`,
+};
 
 // Connect SSE directly to gateway (Vite proxy buffers SSE)
 const gwOrigin = `http://${window.location.hostname}:8000`;
@@ -15,6 +22,7 @@ export const GPUPanel = (props) => {
     setSseStatus("open");
     setGpu(JSON.parse(e.data));
   };
+  es.addEventListener("schedule", (e) => setDecision(JSON.parse(e.data)));
   es.onerror = () => {
     setSseStatus(es.readyState === EventSource.CLOSED ? "closed" : "connecting");
   };
@@ -69,6 +77,9 @@ export const GPUPanel = (props) => {
             )}
           </For>
         </Show>
+        <Show when={decision()}>
+          <p class="gpu-decision">{DECISION_TEXT[decision().action]?.(decision())}</p>
+        </Show>
       </Show>
     </div>
   );
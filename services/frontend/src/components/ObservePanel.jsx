@@ -143,6 +143,25 @@ export function ObservePanel() {
   );
 }
 
+// orderSpans lists each span after its parent, siblings by seq, with its
+// nesting depth. Spans whose parent wasn't recorded (and those from before
+// parent_id existed) are top level.
+const orderSpans = (spans) => {
+  const ids = new Set((spans || []).map((s) => s.id));
+  const children = {};
+  (spans || []).forEach((s) => (children[ids.has(s.parent_id) ? s.parent_id : ""] ??= []).push(s));
+  const ordered = [];
+  const visit = (parentId, depth) =>
+    (children[parentId] || [])
+      .sort((a, b) => a.seq - b.seq)
+      .forEach((s) => {
+        ordered.push({ ...s, depth });
+        visit(s.id, depth + 1);
+      });
+  visit("", 0);
+  return ordered;
+};
+
 function Waterfall(props) {
   const barStyle = (span) => {
     const runStart = new Date(props.runStart).getTime();
@@ -156,10 +175,10 @@ function Waterfall(props) {
 
   return (
     <div class="waterfall">
-      <For each={props.spans}>
+      <For each={orderSpans(props.spans)}>
         {(span) => (
           <div class="waterfall-row" onClick={() => props.onSelect(span)}>
-            <div class="waterfall-label">{span.name}</div>
+            <div class="waterfall-label" style={{ "padding-left": `${span.depth * 12}px` }}>{span.name}</div>
             <div class="waterfall-track">
               <div
                 class={`waterfall-bar ${SPAN_COLORS[span.name] || ""}`}
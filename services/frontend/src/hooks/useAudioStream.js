@@ -32,7 +32,7 @@ export const useAudioStream = (opts) => {
   window.addEventListener("beforeunload", handleUnload);
   onCleanup(() => window.removeEventListener("beforeunload", handleUnload));
 
-  const connect = (mode) => {
+  const connect = (mode, codec = "pcm") => {
     const protocol = window.location.protocol === "https:" ? "wss:" : "ws:";
     const socket = new WebSocket(`${protocol}//${window.location.host}/ws/call`);
     socket.binaryType = "arraybuffer";
@@ -41,7 +41,7 @@ export const useAudioStream = (opts) => {
       const nativeRate = audioCtx?.sampleRate ?? 48000;
       const sampleRate = activeBwMode?.sample_rate || nativeRate;
       const meta = {
-        codec: "pcm",
+        codec,
         sample_rate: sampleRate,
         audio_bandwidth: opts.audioBandwidth?.() || "wideband",
         tts_engine: opts.ttsEngine(),
@@ -77,6 +77,7 @@ export const useAudioStream = (opts) => {
         llm_token: () => opts.onLLMToken(event.token ?? ""),
         llm_done: () => opts.onLLMDone(event.text ?? ""),
         thinking_done: () => opts.onThinkingDone?.(event.text ?? ""),
+        turn_cancelled: () => opts.onTurnCancelled?.(),
         metrics: () =>
           opts.onMetrics({
             asr_ms: event.asr_ms ?? 0,
@@ -223,6 +224,27 @@ export const useAudioStream = (opts) => {
     setIsStreaming(false);
   };
 
+  // Snippet upload: the file goes to the gateway as-is (WAV, MP3, Ogg, ...)
+  // and is decoded there, so its own sample rate and channels are kept.
+  const uploadSnippet = async (file) => {
+    const socket = connect("snippet", "file");
+    const bytes = new Uint8Array(await file.arrayBuffer());
+
+    await new Promise((resolve) => {
+      if (socket.readyState === WebSocket.OPEN) return resolve();
+      const origOpen = socket.onopen;
+      socket.onopen = (ev) => {
+        origOpen?.(ev);
+        resolve();
+      };
+    });
+
+    setIsStreaming(true);
+    const chunkSize = 64 * 1024;
+    for (let i = 0; i < bytes.length; i += chunkSize) socket.send(bytes.slice(i, i + chunkSize));
+    socket.send(JSON.stringify({ action: "process" }));
+  };
+
   const stop = () => {
     mediaStream?.getTracks().forEach((t) => t.stop());
     worklet?.disconnect();
@@ -238,5 +260,5 @@ export const useAudioStream = (opts) => {
     setIsRecording(false);
   };
 
-  return { isStreaming, isRecording, startMic, startSnippet, pauseRecording, resumeRecording, processSnippet, startFile, stop, sendChat };
+  return { isStreaming, isRecording, startMic, startSnippet, pauseRecording, resumeRecording, processSnippet, startFile, uploadSnippet, stop, sendChat };
 };
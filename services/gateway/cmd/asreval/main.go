@@ -0,0 +1,379 @@
+// Command asreval scores ASR engines against a labelled corpus. It runs every
+// (audio, reference) pair in a directory through each configured engine, and
+// optionally each whisper model and resampler, and prints a comparison
+// matrix of WER, real-time factor, and latency.
+//
+//	asreval -dir corpus/ -models ggml-base.en.bin,ggml-medium.bin -format csv
+//
+// To measure what the resampler costs in WER on 44.1/48 kHz recordings,
+// resample them in 20 ms chunks as a talk-mode call sends them:
+//
+//	asreval -dir corpus/ -resamplers linear,polyphase -chunk-ms 20 -format csv
+//
+// A pair is a .wav file and a .txt file with the same base name.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/env"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/orchestrator"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+)
+
+const (
+	// asrSampleRate is the rate the pipeline feeds ASR engines.
+	asrSampleRate = 16000
+
+	// modelSwitchTimeout bounds restarting whisper-server on a new model.
+	modelSwitchTimeout = 2 * time.Minute
+
+	// transcribeTimeout bounds one file's transcription.
+	transcribeTimeout = 5 * time.Minute
+)
+
+// recording is one corpus pair, decoded once at its own rate.
+type recording struct {
+	Name      string
+	Samples   []float32
+	Rate      int
+	Reference string
+}
+
+// sample is a recording at asrSampleRate, reused for every engine.
+type sample struct {
+	Name      string
+	Samples   []float32
+	Reference string
+}
+
+// fileResult is one engine's transcription of one sample.
+type fileResult struct {
+	File       string  `json:"file"`
+	Hypothesis string  `json:"hypothesis"`
+	WER        float64 `json:"wer"`
+	LatencyMs  float64 `json:"latency_ms"`
+	RTF        float64 `json:"rtf"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// row is one cell of the comparison matrix: an engine and model over the
+// whole corpus. WER is weighted by reference word count.
+type row struct {
+	Engine       string       `json:"engine"`
+	Model        string       `json:"model"`
+	Resampler    string       `json:"resampler"`
+	Files        int          `json:"files"`
+	Errors       int          `json:"errors"`
+	WER          float64      `json:"wer"`
+	RTF          float64      `json:"rtf"`
+	MeanLatency  float64      `json:"mean_latency_ms"`
+	P95Latency   float64      `json:"p95_latency_ms"`
+	AudioSeconds float64      `json:"audio_seconds"`
+	Results      []fileResult `json:"results"`
+}
+
+func main() {
+	dir := flag.String("dir", "", "corpus directory of .wav files with matching .txt references")
+	engines := flag.String("engines", "", "comma-separated name=url ASR endpoints (default whisper-server=$WHISPER_SERVER_URL); openai=<base url> and azure=<region> use the cloud APIs")
+	modelList := flag.String("models", "", "comma-separated whisper model files to compare; whisper-server is restarted on each via $WHISPER_CONTROL_URL")
+	format := flag.String("format", "json", "output format: json or csv")
+	outPath := flag.String("out", "", "output file (default stdout)")
+	prompt := flag.String("prompt", env.Str("WHISPER_PROMPT", ""), "initial prompt sent with each file")
+	language := flag.String("language", "", `spoken language code, "auto" to detect, "" for the server default`)
+	resamplerList := flag.String("resamplers", "linear", "comma-separated resamplers to compare: linear, polyphase")
+	chunkMs := flag.Int("chunk-ms", 0, "resample in chunks of this many ms, as calls stream audio (0 = whole files)")
+	flag.Parse()
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	writer, ok := writers[*format]
+	modes, modesOK := parseResamplers(*resamplerList)
+	if *dir == "" || !ok || !modesOK || *chunkMs < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	recordings, err := loadCorpus(*dir)
+	if err != nil {
+		slog.Error("load corpus", "error", err)
+		os.Exit(1)
+	}
+	corpora := map[audio.ResampleMode][]sample{}
+	for _, mode := range modes {
+		corpora[mode] = resampleCorpus(recordings, mode, *chunkMs)
+	}
+	backends, err := parseEngines(*engines)
+	if err != nil {
+		slog.Error("engines", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("corpus loaded", "files", len(recordings), "engines", len(backends), "resamplers", len(modes))
+
+	opts := pipeline.ASROptions{Prompt: *prompt, Language: *language}
+	var rows []row
+	for _, name := range sortedKeys(backends) {
+		for _, model := range modelsFor(name, *modelList) {
+			if model != "" {
+				if err = switchModel(name, model); err != nil {
+					slog.Error("switch model", "engine", name, "model", model, "error", err)
+					continue
+				}
+			}
+			for _, mode := range modes {
+				rows = append(rows, evaluate(name, model, mode, backends[name], corpora[mode], opts))
+			}
+		}
+	}
+
+	out := io.Writer(os.Stdout)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			slog.Error("create output", "error", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err = writer(out, rows); err != nil {
+		slog.Error("write report", "error", err)
+		os.Exit(1)
+	}
+}
+
+// loadCorpus decodes every .wav in dir that has a .txt reference beside it.
+func loadCorpus(dir string) ([]recording, error) {
+	wavs, err := filepath.Glob(filepath.Join(dir, "*.wav"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(wavs)
+	var corpus []recording
+	for _, path := range wavs {
+		ref, err := os.ReadFile(strings.TrimSuffix(path, ".wav") + ".txt")
+		if err != nil {
+			slog.Warn("skipping file without reference", "file", path)
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		samples, rate, err := audio.DecodeWAV(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		corpus = append(corpus, recording{
+			Name:      filepath.Base(path),
+			Samples:   samples,
+			Rate:      rate,
+			Reference: strings.TrimSpace(string(ref)),
+		})
+	}
+	if len(corpus) == 0 {
+		return nil, fmt.Errorf("no .wav/.txt pairs in %s", dir)
+	}
+	return corpus, nil
+}
+
+// parseResamplers splits the -resamplers list, reporting whether every
+// entry is a resampler.
+func parseResamplers(list string) ([]audio.ResampleMode, bool) {
+	var modes []audio.ResampleMode
+	for _, name := range strings.Split(list, ",") {
+		mode := audio.ResampleMode(strings.TrimSpace(name))
+		if mode == "" || !audio.ValidResampleMode(mode) {
+			return nil, false
+		}
+		modes = append(modes, mode)
+	}
+	return modes, true
+}
+
+// resampleCorpus brings the recordings to asrSampleRate through mode's
+// resampler, fed chunkMs at a time like the gateway's (0 = whole files).
+func resampleCorpus(recordings []recording, mode audio.ResampleMode, chunkMs int) []sample {
+	corpus := make([]sample, len(recordings))
+	for i, rec := range recordings {
+		r := audio.NewResampler(mode, rec.Rate, asrSampleRate)
+		step := len(rec.Samples)
+		if chunkMs > 0 {
+			step = max(1, rec.Rate*chunkMs/1000)
+		}
+		var out []float32
+		for off := 0; off < len(rec.Samples); off += step {
+			out = r.Append(out, rec.Samples[off:min(len(rec.Samples), off+step)])
+		}
+		corpus[i] = sample{Name: rec.Name, Samples: out, Reference: rec.Reference}
+	}
+	return corpus
+}
+
+// parseEngines builds one ASR client per name=url entry. The openai and
+// azure names select the cloud clients, keyed by $OPENAI_API_KEY and
+// $AZURE_SPEECH_KEY, with the value as base URL or region.
+func parseEngines(spec string) (map[string]pipeline.ASRTranscriber, error) {
+	if spec == "" {
+		spec = "whisper-server=" + env.Str("WHISPER_SERVER_URL", "")
+	}
+	backends := map[string]pipeline.ASRTranscriber{}
+	for _, entry := range strings.Split(spec, ",") {
+		name, url, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		if name == "" || url == "" {
+			return nil, fmt.Errorf("engine %q: want name=url", entry)
+		}
+		backends[name] = newEngine(name, url)
+	}
+	return backends, nil
+}
+
+func newEngine(name, target string) pipeline.ASRTranscriber {
+	if name == "openai" {
+		return pipeline.NewOpenAIASRClient(target, env.Str("OPENAI_API_KEY", ""), env.Str("OPENAI_ASR_MODEL", "whisper-1"), 1, "")
+	}
+	if name == "azure" {
+		return pipeline.NewAzureASRClient(env.Str("AZURE_SPEECH_KEY", ""), target, env.Str("AZURE_STT_LOCALE", "en-US"), 1)
+	}
+	return pipeline.NewASRClient(target, 1, "")
+}
+
+// modelsFor returns the models to sweep for an engine. Only whisper-server
+// can be restarted on another model; other engines run once as configured.
+func modelsFor(engine, list string) []string {
+	if engine != "whisper-server" || list == "" {
+		return []string{""}
+	}
+	return strings.Split(list, ",")
+}
+
+// switchModel restarts whisper-server on model through whisper-control and
+// waits for it to come back healthy.
+func switchModel(engine, model string) error {
+	controlURL := env.Str("WHISPER_CONTROL_URL", "")
+	if controlURL == "" {
+		return fmt.Errorf("WHISPER_CONTROL_URL is required to switch models")
+	}
+	mgr := orchestrator.NewHTTPControlManager(orchestrator.NewRegistry(map[string]orchestrator.ServiceMeta{
+		engine: {Category: "asr", HealthURL: env.Str("WHISPER_SERVER_URL", ""), ControlURL: controlURL},
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), modelSwitchTimeout)
+	defer cancel()
+
+	slog.Info("switching model", "engine", engine, "model", strings.TrimSpace(model))
+	if _, err := mgr.Stop(ctx, engine); err != nil {
+		return err
+	}
+	if _, err := mgr.Start(ctx, engine, "model="+strings.TrimSpace(model)); err != nil {
+		return err
+	}
+	_, err := mgr.EnsureRunning(ctx, engine)
+	return err
+}
+
+func evaluate(engine, model string, mode audio.ResampleMode, asr pipeline.ASRTranscriber, corpus []sample, opts pipeline.ASROptions) row {
+	r := row{Engine: engine, Model: strings.TrimSpace(model), Resampler: string(mode), Files: len(corpus)}
+	var werWords, refWords, asrSeconds float64
+	latencies := make([]float64, 0, len(corpus))
+
+	for _, s := range corpus {
+		audioSeconds := float64(len(s.Samples)) / asrSampleRate
+		r.AudioSeconds += audioSeconds
+
+		ctx, cancel := context.WithTimeout(context.Background(), transcribeTimeout)
+		start := time.Now()
+		res, err := asr.Transcribe(ctx, s.Samples, opts)
+		elapsed := time.Since(start)
+		cancel()
+
+		fr := fileResult{File: s.Name, LatencyMs: float64(elapsed.Milliseconds())}
+		if err != nil {
+			fr.Error = err.Error()
+			r.Errors++
+			r.Results = append(r.Results, fr)
+			continue
+		}
+		fr.Hypothesis = res.Text
+		fr.WER = pipeline.ComputeWER(s.Reference, res.Text)
+		fr.RTF = elapsed.Seconds() / audioSeconds
+		r.Results = append(r.Results, fr)
+
+		words := float64(len(strings.Fields(s.Reference)))
+		werWords += fr.WER * words
+		refWords += words
+		asrSeconds += elapsed.Seconds()
+		latencies = append(latencies, fr.LatencyMs)
+	}
+
+	if refWords > 0 {
+		r.WER = werWords / refWords
+	}
+	if r.AudioSeconds > 0 {
+		r.RTF = asrSeconds / r.AudioSeconds
+	}
+	r.MeanLatency, r.P95Latency = latencyStats(latencies)
+	slog.Info("evaluated", "engine", engine, "model", r.Model, "resampler", mode, "wer", r.WER, "rtf", r.RTF, "errors", r.Errors)
+	return r
+}
+
+func latencyStats(ms []float64) (mean, p95 float64) {
+	if len(ms) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range ms {
+		sum += v
+	}
+	slices.Sort(ms)
+	return sum / float64(len(ms)), ms[(len(ms)*95-1)/100]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// writers maps -format values to report writers.
+var writers = map[string]func(io.Writer, []row) error{
+	"json": writeJSON,
+	"csv":  writeCSV,
+}
+
+func writeJSON(w io.Writer, rows []row) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+// writeCSV writes the matrix only; per-file results are in the JSON report.
+func writeCSV(w io.Writer, rows []row) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"engine", "model", "resampler", "files", "errors", "wer", "rtf", "mean_latency_ms", "p95_latency_ms", "audio_seconds"})
+	for _, r := range rows {
+		_ = cw.Write([]string{
+			r.Engine, r.Model, r.Resampler, strconv.Itoa(r.Files), strconv.Itoa(r.Errors),
+			strconv.FormatFloat(r.WER, 'f', 4, 64), strconv.FormatFloat(r.RTF, 'f', 4, 64),
+			strconv.FormatFloat(r.MeanLatency, 'f', 1, 64), strconv.FormatFloat(r.P95Latency, 'f', 1, 64),
+			strconv.FormatFloat(r.AudioSeconds, 'f', 1, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+)
+
+// bench places one call against a running gateway as a smoke test: a typed
+// message (text mode), or a recording sent as a snippet, exercising ASR
+// too. It reports when each event type first arrived and the turn's
+// metrics, and exits 1 if the call errors or doesn't finish in time.
+func bench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	url := fs.String("url", "ws://localhost:8000/ws/call", "gateway call WebSocket")
+	key := fs.String("key", os.Getenv("GATEWAY_API_KEY"), "API key, if the gateway requires one")
+	message := fs.String("message", "Hello, what can you help me with?", "message to send in text mode")
+	file := fs.String("file", "", "audio file (WAV, MP3, Ogg...) to send as a snippet instead of a message")
+	ttsEngine := fs.String("tts", "fast", "TTS engine for snippet calls")
+	timeout := fs.Duration("timeout", 60*time.Second, "how long to wait for the turn")
+	fs.Parse(args)
+
+	meta := map[string]any{"mode": "text"}
+	var upload []byte
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		meta = map[string]any{"mode": "snippet", "codec": "file", "tts_engine": *ttsEngine}
+		upload = data
+	}
+
+	header := http.Header{}
+	if *key != "" {
+		header.Set("Authorization", "Bearer "+*key)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(*url, header)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(*timeout))
+
+	if err = conn.WriteJSON(meta); err != nil {
+		fmt.Fprintf(os.Stderr, "send metadata: %v\n", err)
+		return 1
+	}
+	start := time.Now()
+	if err = sendBenchTurn(conn, upload, *message); err != nil {
+		fmt.Fprintf(os.Stderr, "send: %v\n", err)
+		return 1
+	}
+
+	res, err := readBenchTurn(conn, start)
+	printBench(res)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func sendBenchTurn(conn *websocket.Conn, upload []byte, message string) error {
+	if upload == nil {
+		return conn.WriteJSON(map[string]string{"action": "chat", "message": message})
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, upload); err != nil {
+		return err
+	}
+	return conn.WriteJSON(map[string]string{"action": "process"})
+}
+
+// benchResult is what one bench call observed.
+type benchResult struct {
+	order      []string                 // event types in order of first arrival
+	first      map[string]time.Duration // since the turn was sent
+	audioBytes int
+	metrics    pipeline.Event
+	reply      string
+}
+
+// readBenchTurn reads events until the turn's metrics event, which ends
+// every turn, or an error.
+func readBenchTurn(conn *websocket.Conn, start time.Time) (benchResult, error) {
+	res := benchResult{first: map[string]time.Duration{}}
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			return res, fmt.Errorf("read: %w", err)
+		}
+		if msgType == websocket.BinaryMessage {
+			res.audioBytes += len(data)
+			continue
+		}
+		var ev pipeline.Event
+		if json.Unmarshal(data, &ev) != nil {
+			continue
+		}
+		if _, seen := res.first[ev.Type]; !seen {
+			res.first[ev.Type] = time.Since(start)
+			res.order = append(res.order, ev.Type)
+		}
+		if ev.Type == "llm_done" {
+			res.reply = ev.Text
+		}
+		if ev.Type == "error" || ev.Type == "budget_exceeded" {
+			return res, errors.New("call failed: " + ev.Text)
+		}
+		if ev.Type == "metrics" {
+			res.metrics = ev
+			return res, nil
+		}
+	}
+}
+
+func printBench(res benchResult) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "EVENT\tFIRST_MS")
+	for _, typ := range res.order {
+		fmt.Fprintf(tw, "%s\t%d\n", typ, res.first[typ].Milliseconds())
+	}
+	m := res.metrics
+	fmt.Fprintf(tw, "\nasr_ms\t%.0f\nllm_ms\t%.0f\ntts_ms\t%.0f\ntotal_ms\t%.0f\naudio_bytes\t%d\n", m.ASRMs, m.LLMMs, m.TTSMs, m.TotalMs, res.audioBytes)
+	tw.Flush()
+	if res.reply != "" {
+		fmt.Printf("\nreply: %s\n", res.reply)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/health"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/trace"
+)
+
+// check validates the configuration serve would start with and probes
+// every backend, for a deploy step or an operator to run before a restart.
+// It exits 1 when the configuration is invalid or a critical backend is
+// down, which serve itself only logs.
+func check(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := fs.String("config", "gateway.json", "settings file to validate")
+	fs.Parse(args)
+
+	c, err := loadConfig(*configPath)
+	if err != nil {
+		slog.Error("bad config file", "error", err)
+		return 1
+	}
+	for _, p := range c.problems {
+		slog.Error("invalid config", "problem", p)
+	}
+
+	var postgres health.Probe
+	if c.postgresURL != "" {
+		postgres = func(ctx context.Context) (string, error) { return trace.Probe(ctx, c.postgresURL) }
+	}
+	rep := newHealthChecker(c.healthTargets(postgres)).Report(context.Background())
+	logDependencies(rep)
+	printStartupTable(os.Stdout, c, rep)
+
+	if len(c.problems) > 0 || rep.Status == health.Unavailable {
+		return 1
+	}
+	return 0
+}
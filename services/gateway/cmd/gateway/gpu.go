@@ -10,85 +10,126 @@ import (
 	"sync"
 	"time"
 
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/models"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/orchestrator"
 )
 
-// gpuFetchTimeout is how long we wait for the GPU control sidecar to respond.
-const gpuFetchTimeout = 5 * time.Second
+const (
+	// gpuFetchTimeout is how long we wait for the GPU control sidecar to respond.
+	gpuFetchTimeout = 5 * time.Second
+
+	// gpuDecisionQueue is how many scheduling decisions a slow /api/gpu/stream
+	// client may fall behind by before they're dropped.
+	gpuDecisionQueue = 16
+)
 
 type gpuHub struct {
 	mu         sync.Mutex
-	subs       map[chan []byte]struct{}
+	subs       map[*gpuSub]struct{}
 	ollamaURL  string
 	controlURL string
 }
 
+// gpuSub is one /api/gpu/stream client. state holds only the latest GPU
+// snapshot; decisions queue, so the steps of one eviction all arrive.
+type gpuSub struct {
+	state     chan []byte
+	decisions chan []byte
+}
+
 func newGPUHub(ollamaURL, controlURL string) *gpuHub {
 	return &gpuHub{
-		subs:       map[chan []byte]struct{}{},
+		subs:       map[*gpuSub]struct{}{},
 		ollamaURL:  ollamaURL,
 		controlURL: controlURL,
 	}
 }
 
-func (h *gpuHub) subscribe() chan []byte {
-	ch := make(chan []byte, 1)
+func (h *gpuHub) subscribe() *gpuSub {
+	sub := &gpuSub{state: make(chan []byte, 1), decisions: make(chan []byte, gpuDecisionQueue)}
 	h.mu.Lock()
-	h.subs[ch] = struct{}{}
+	h.subs[sub] = struct{}{}
 	h.mu.Unlock()
-	return ch
+	return sub
 }
 
-func (h *gpuHub) unsubscribe(ch chan []byte) {
+func (h *gpuHub) unsubscribe(sub *gpuSub) {
 	h.mu.Lock()
-	delete(h.subs, ch)
+	delete(h.subs, sub)
 	h.mu.Unlock()
 }
 
+type gpuUsage struct {
+	VRAMTotalMB int `json:"vram_total_mb"`
+	VRAMUsedMB  int `json:"vram_used_mb"`
+}
+
+type gpuProc struct {
+	PID    int    `json:"pid"`
+	Name   string `json:"name"`
+	VRAMMB int    `json:"vram_mb"`
+}
+
+// gpuCard is one device from the control server's multi-GPU breakdown.
+type gpuCard struct {
+	ID          string    `json:"id"`
+	Index       int       `json:"index"`
+	VRAMTotalMB int       `json:"vram_total_mb"`
+	VRAMUsedMB  int       `json:"vram_used_mb"`
+	Processes   []gpuProc `json:"processes"`
+}
+
 // enrich augments raw GPU JSON by filtering out zero-VRAM processes and
 // replacing generic "ollama" process names with the actual loaded model names
-// so the frontend can display which LLM is consuming VRAM.
+// so the frontend can display which LLM is consuming VRAM. The top-level
+// fields describe the primary GPU; devices carries every card.
 func (h *gpuHub) enrich(raw []byte) []byte {
 	if raw == nil {
 		return nil
 	}
-	type gpuProc struct {
-		PID    int    `json:"pid"`
-		Name   string `json:"name"`
-		VRAMMB int    `json:"vram_mb"`
-	}
 	var gpu struct {
 		VRAMTotalMB int       `json:"vram_total_mb"`
 		VRAMUsedMB  int       `json:"vram_used_mb"`
 		Processes   []gpuProc `json:"processes"`
+		Devices     []gpuCard `json:"devices,omitempty"`
 	}
 	if json.Unmarshal(raw, &gpu) != nil {
 		return raw
 	}
 
-	filtered := make([]gpuProc, 0, len(gpu.Processes))
-	for _, p := range gpu.Processes {
+	loaded, _ := models.ListLoadedLLMs(context.Background(), h.ollamaURL)
+	gpu.Processes = nameProcesses(gpu.Processes, loaded)
+	for i := range gpu.Devices {
+		gpu.Devices[i].Processes = nameProcesses(gpu.Devices[i].Processes, loaded)
+	}
+
+	enriched, err := json.Marshal(gpu)
+	if err != nil {
+		return raw
+	}
+	return enriched
+}
+
+// nameProcesses drops zero-VRAM processes and names Ollama runners after the
+// loaded models, in order.
+func nameProcesses(procs []gpuProc, loaded []models.LoadedLLM) []gpuProc {
+	filtered := make([]gpuProc, 0, len(procs))
+	for _, p := range procs {
 		if p.VRAMMB > 0 {
 			filtered = append(filtered, p)
 		}
 	}
-	gpu.Processes = filtered
 
-	loaded, _ := models.ListLoadedLLMs(context.Background(), h.ollamaURL)
 	modelIdx := 0
-	for i := range gpu.Processes {
-		isOllama := strings.Contains(gpu.Processes[i].Name, "ollama")
+	for i := range filtered {
+		isOllama := strings.Contains(filtered[i].Name, "ollama")
 		if isOllama && modelIdx < len(loaded) {
-			gpu.Processes[i].Name = loaded[modelIdx].Name
+			filtered[i].Name = loaded[modelIdx].Name
 			modelIdx++
 		}
 	}
-
-	enriched, err := json.Marshal(gpu)
-	if err != nil {
-		return raw
-	}
-	return enriched
+	return filtered
 }
 
 func (h *gpuHub) fetch() []byte {
@@ -113,6 +154,46 @@ func (h *gpuHub) fetch() []byte {
 	return h.enrich(body)
 }
 
+// recordMetrics copies a GPU snapshot into the VRAM gauges. Control servers
+// that predate the per-device breakdown report under device "default".
+func recordMetrics(data []byte) {
+	var gpu struct {
+		gpuUsage
+		Devices []gpuCard `json:"devices"`
+	}
+	if data == nil || json.Unmarshal(data, &gpu) != nil {
+		return
+	}
+	if len(gpu.Devices) == 0 {
+		metrics.GPUVRAMTotalMB.WithLabelValues("default").Set(float64(gpu.VRAMTotalMB))
+		metrics.GPUVRAMUsedMB.WithLabelValues("default").Set(float64(gpu.VRAMUsedMB))
+		return
+	}
+	for _, d := range gpu.Devices {
+		metrics.GPUVRAMTotalMB.WithLabelValues(d.ID).Set(float64(d.VRAMTotalMB))
+		metrics.GPUVRAMUsedMB.WithLabelValues(d.ID).Set(float64(d.VRAMUsedMB))
+	}
+}
+
+// pollMetrics refreshes the GPU and service-up gauges every interval until
+// ctx is done. A non-positive interval disables polling.
+func (h *gpuHub) pollMetrics(ctx context.Context, svcMgr *orchestrator.HTTPControlManager, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		recordMetrics(h.fetch())
+		svcMgr.ReportMetrics(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // broadcast sends GPU data to all SSE subscribers.
 // The select/default pattern is a non-blocking send: if a subscriber's
 // channel buffer is full (slow consumer), the update is dropped rather
@@ -122,11 +203,29 @@ func (h *gpuHub) broadcast(data []byte) {
 	if data == nil {
 		return
 	}
+	recordMetrics(data)
 	slog.Info("gpu broadcast", "data", string(data))
 	h.mu.Lock()
-	for ch := range h.subs {
+	for sub := range h.subs {
+		select {
+		case sub.state <- data:
+		default:
+		}
+	}
+	h.mu.Unlock()
+}
+
+// broadcastDecision sends a VRAM scheduling decision to all SSE
+// subscribers, as a "schedule" event beside the GPU snapshots.
+func (h *gpuHub) broadcastDecision(d orchestrator.Decision) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	for sub := range h.subs {
 		select {
-		case ch <- data:
+		case sub.decisions <- data:
 		default:
 		}
 	}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/health"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+)
+
+const (
+	// healthProbeTimeout bounds each dependency probe of /api/health/deep.
+	healthProbeTimeout = 3 * time.Second
+
+	// healthCacheTTL is how long a deep health report is reused.
+	healthCacheTTL = 5 * time.Second
+)
+
+// healthTargets are the dependencies /api/health/deep probes. Empty URLs
+// are sidecars that aren't configured and are left out.
+type healthTargets struct {
+	ollamaURL         string
+	whisperServerURL  string
+	whisperControlURL string
+	asrInstances      map[string]string
+	piperModelDir     string
+	audioclassifyURL  string
+	embedURL          string
+	vllmURL           string
+	llamacppURL       string
+	postgres          health.Probe // nil without a trace database
+}
+
+// newHealthChecker builds the deep health checks. Ollama and Piper serve
+// every call by default, so they're critical. whisper-server is critical
+// unless whisper-control starts it on demand, since it's then expected to
+// be stopped while idle.
+func newHealthChecker(t healthTargets) *health.Checker {
+	checks := []health.Check{
+		{Name: "ollama", Critical: true, Probe: health.HTTP(t.ollamaURL+"/api/version", "version")},
+		{Name: "piper", Critical: true, Probe: piperProbe(t.piperModelDir)},
+	}
+	if t.whisperServerURL != "" {
+		checks = append(checks, health.Check{Name: "whisper-server", Critical: t.whisperControlURL == "", Probe: health.HTTP(t.whisperServerURL, "")})
+	}
+	names := make([]string, 0, len(t.asrInstances))
+	for name := range t.asrInstances {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		checks = append(checks, health.Check{Name: "asr:" + name, Probe: health.HTTP(t.asrInstances[name], "")})
+	}
+	optional := []struct{ name, url, path string }{
+		{"whisper-control", t.whisperControlURL, "/services"},
+		{"audioclassify", t.audioclassifyURL, "/health"},
+		{"embed", t.embedURL, "/health"},
+		{"vllm", t.vllmURL, "/version"},
+		{"llamacpp", t.llamacppURL, "/health"},
+	}
+	for _, o := range optional {
+		if o.url != "" {
+			checks = append(checks, health.Check{Name: o.name, Probe: health.HTTP(o.url+o.path, "version")})
+		}
+	}
+	if t.postgres != nil {
+		checks = append(checks, health.Check{Name: "postgres", Probe: t.postgres})
+	}
+	return health.NewChecker(checks, healthProbeTimeout, healthCacheTTL)
+}
+
+// piperProbe checks the piper binary and the default voice's model.
+func piperProbe(modelDir string) health.Probe {
+	return func(ctx context.Context) (string, error) {
+		model := filepath.Join(modelDir, defaultPiperVoice+".onnx")
+		if _, err := os.Stat(model); err != nil {
+			return "", fmt.Errorf("default voice: %w", err)
+		}
+		return pipeline.PiperVersion(ctx)
+	}
+}
+
+// handleDeepHealth reports every dependency's status, latency, and version.
+// It answers 503 when a critical one is down so load balancers can drain
+// the gateway; a degraded gateway still answers 200.
+func (d deps) handleDeepHealth(w http.ResponseWriter, r *http.Request) {
+	// the report is shared with concurrent callers, so one hanging up
+	// mustn't cancel their probes
+	rep := d.health.Report(context.WithoutCancel(r.Context()))
+	w.Header().Set("Content-Type", "application/json")
+	if rep.Status == health.Unavailable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(rep)
+}
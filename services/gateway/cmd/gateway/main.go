@@ -3,10 +3,16 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,17 +20,30 @@ import (
 	"github.com/openai/openai-go/v2/packages/param"
 
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/auth"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/denoise"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/env"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/experiment"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/flow"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/health"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/logctx"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/models"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/orchestrator"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/prompts"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/ratelimit"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/redact"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/trace"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/ws"
 )
 
+// configPollInterval is how often gateway.json is checked for changes.
+const configPollInterval = 2 * time.Second
+
 // tuning holds knobs loaded from gateway.json. These are values that may
 // eventually move to a database; for now a JSON file keeps them out of env vars.
+// The call settings in ws.Tunables are reloaded when the file changes; the
+// rest take effect on restart.
 type tuning struct {
 	LLMSystemPrompt    string  `json:"llm_system_prompt"`
 	LLMMaxTokens       int     `json:"llm_max_tokens"`
@@ -32,10 +51,130 @@ type tuning struct {
 	LLMPoolSize        int     `json:"llm_pool_size"`
 	TTSPoolSize        int     `json:"tts_pool_size"`
 	VADSpeechThreshold float64 `json:"vad_speech_threshold_db"`
+	VADSilenceTimeoutMs int    `json:"vad_silence_timeout_ms"` // 0 = the VAD default
 	OpenAIURL          string  `json:"openai_url"`
 	OpenAIModel        string  `json:"openai_model"`
+	OpenAIASRModel     string  `json:"openai_asr_model"`
+	// ASRInstances registers extra whisper-server instances as ASR engines,
+	// by engine name -> URL; whisper-control's side-by-side instances run on
+	// their own ports with their own models (e.g. a tiny one for live calls).
+	ASRInstances       map[string]string `json:"asr_instances"`
 	AnthropicURL       string  `json:"anthropic_url"`
 	AnthropicModel     string  `json:"anthropic_model"`
+	LLMFallbackChain   []string `json:"llm_fallback_chain"`
+	LLMTTFTBudgetMs    int      `json:"llm_ttft_budget_ms"`
+	// AnthropicPromptCaching marks the system prompt for Anthropic's prompt
+	// cache, so turns after the first read it at the cached input price.
+	AnthropicPromptCaching bool `json:"anthropic_prompt_caching"`
+	// AnthropicThinkingBudget turns on extended thinking with this many
+	// tokens to think in (0 = off; at least 1024 and under llm_max_tokens).
+	AnthropicThinkingBudget int `json:"anthropic_thinking_budget"`
+	// LLMParams sets seed, temperature, and response_format per engine, for
+	// the engines on OpenAI-compatible APIs (openai, ollama, vllm), e.g. a
+	// fixed seed and temperature 0 for reproducible evaluation runs.
+	LLMParams map[string]pipeline.OpenAIParams `json:"llm_params"`
+	// Rate limits (0 disables). REST limits are per client (API key or IP)
+	// across all routes; WS limits apply to frames per client.
+	RESTRateLimitRPS     float64 `json:"rest_rate_limit_rps"`
+	RESTRateLimitBurst   int     `json:"rest_rate_limit_burst"`
+	WSMsgRateLimit       float64 `json:"ws_msg_rate_limit"`
+	WSMsgBurst           int     `json:"ws_msg_burst"`
+	MaxSessionAudioBytes int64   `json:"max_session_audio_bytes"`
+	// TTSLanguageVoices maps language codes to TTS voices used when ASR
+	// detects the caller switching language (e.g. "es": "es_ES-davefx-medium").
+	TTSLanguageVoices map[string]string `json:"tts_language_voices"`
+	// Cost estimates: LLM price per model, TTS price per engine per 1K characters.
+	LLMPricing          map[string]pipeline.LLMPrice `json:"llm_pricing"`
+	TTSPricingPer1KChar map[string]float64           `json:"tts_pricing_per_1k_chars"`
+	// ServiceIdleTimeoutMin stops GPU services (whisper-server, TTS sidecars)
+	// after this many minutes without traffic (0 disables).
+	ServiceIdleTimeoutMin int `json:"service_idle_timeout_min"`
+	// ServiceAutoStartWaitS is how long a call waits for a stopped engine it
+	// requested to be started and healthy (0 = start without waiting).
+	ServiceAutoStartWaitS int `json:"service_autostart_wait_s"`
+	// HistoryRetentionDays purges stored sessions, runs, and turns older
+	// than this many days (0 keeps them until the session cap evicts them).
+	HistoryRetentionDays int `json:"history_retention_days"`
+	// DenoisePerChunk runs noise suppression on every talk-mode chunk before
+	// the VAD, instead of once per speech segment after it.
+	DenoisePerChunk bool `json:"denoise_per_chunk"`
+	// Resampler brings caller audio to 16 kHz: "linear" (cheap, per chunk)
+	// or "polyphase" (continuous, sharper anti-aliasing for 44.1/48 kHz).
+	Resampler audio.ResampleMode `json:"resampler"`
+	// PiperProcesses is how many warm piper processes each voice keeps
+	// (spawned on demand); it also caps concurrent synthesis per voice.
+	PiperProcesses int `json:"piper_processes"`
+	// Slow WebSocket clients: per-frame write deadline, outbound queue depth,
+	// and what to do when the queue fills ("drop" frames or "close" the client).
+	WSWriteTimeoutMs   int    `json:"ws_write_timeout_ms"`
+	WSSendQueue        int    `json:"ws_send_queue"`
+	WSSlowClientPolicy string `json:"ws_slow_client_policy"`
+	// Dead WebSocket clients: how often clients are pinged, and how long
+	// they may leave pings unanswered before they are disconnected.
+	WSPingIntervalMs int `json:"ws_ping_interval_ms"`
+	WSPongTimeoutMs  int `json:"ws_pong_timeout_ms"`
+	// PinnedModels are Ollama models (LLM or embedding) loaded at startup and
+	// after an unload-all with an indefinite keep-alive.
+	PinnedModels []string `json:"pinned_models"`
+	// Moderation screens LLM output before TTS (deny-list regexes and/or an
+	// Ollama safety classifier); empty disables it.
+	Moderation pipeline.ModerationConfig `json:"moderation"`
+	// PIIRedaction masks card numbers, SSNs, phone numbers, and emails in
+	// logs and stored traces and history.
+	PIIRedaction bool `json:"pii_redaction"`
+	// VRAMAdmission refuses (or evicts per priority) service starts and model
+	// preloads that would push the GPU past capacity.
+	VRAMAdmission orchestrator.AdmissionConfig `json:"vram_admission"`
+	// MetricsPollIntervalS is how often GPU VRAM and service health are
+	// sampled into Prometheus gauges (0 disables polling).
+	MetricsPollIntervalS int `json:"metrics_poll_interval_s"`
+	// Filler plays a pre-rendered phrase (or comfort noise) when the LLM's
+	// first sentence takes longer than the threshold.
+	Filler pipeline.FillerConfig `json:"filler"`
+	// EmbeddingEngine embeds questions for the semantic cache: "ollama"
+	// with embedding_model, or "onnx" for the embed sidecar at EMBED_URL.
+	EmbeddingEngine string `json:"embedding_engine"`
+	// EmbeddingModel is the Ollama model that embeds questions for the
+	// semantic cache.
+	EmbeddingModel string `json:"embedding_model"`
+	// SemanticCache answers a question that closely matches an earlier one
+	// with the earlier answer and audio, shared across sessions. Only enable
+	// it for FAQ-style prompts whose answers don't depend on the caller.
+	SemanticCache pipeline.SemanticCacheConfig `json:"semantic_cache"`
+	// Intent classifies each caller turn (billing, cancel, ...) and applies
+	// that intent's prompt override and webhook.
+	Intent pipeline.IntentConfig `json:"intent"`
+	// Handoff lets the LLM (or an escalating intent) hand the call to a
+	// human: a summary goes to the webhook and the call holds.
+	Handoff pipeline.HandoffConfig `json:"handoff"`
+	// CallSummary has the call's LLM summarize each traced call when it
+	// ends (topics, resolution, action items, sentiment), stored on its
+	// trace session.
+	CallSummary bool `json:"call_summary"`
+	// Endpointing shortens the VAD silence timeout when the caller sounds
+	// finished and lengthens it when they stop mid-sentence.
+	Endpointing pipeline.EndpointConfig `json:"endpointing"`
+	// CircuitBreaker fails fast on an ASR, LLM, or TTS engine after
+	// consecutive failures, routing to the stage's fallback engine.
+	CircuitBreaker pipeline.BreakerConfig `json:"circuit_breaker"`
+	// Tenants partitions a shared gateway: per-tenant default prompt,
+	// allowed engines, and concurrent call quota, by tenant name.
+	Tenants map[string]ws.TenantConfig `json:"tenants"`
+	// Experiments split calls between engine combinations for A/B
+	// comparison; DefaultExperiment enrolls calls that don't name one.
+	Experiments       map[string]experiment.Experiment `json:"experiments"`
+	DefaultExperiment string                           `json:"default_experiment"`
+	// SessionLimits cap each call's duration, turns, and buffered snippet
+	// audio; a tenant's session_limits override them.
+	SessionLimits ws.SessionLimits `json:"session_limits"`
+	// FlowsDir holds YAML call scripts sessions can select with "flow".
+	FlowsDir string `json:"flows_dir"`
+	// PromptsDB is the SQLite file of the versioned prompt library sessions
+	// can select from with "prompt" ("" disables it and /api/prompts).
+	PromptsDB string `json:"prompts_db"`
+	// TraceAudioDir archives each traced run's post-VAD speech as WAV for
+	// replay ("" disables). The audio is not redacted.
+	TraceAudioDir string `json:"trace_audio_dir"`
 }
 
 // defaultTuning returns sensible defaults matching gateway.json.
@@ -49,57 +188,189 @@ func defaultTuning() tuning {
 		VADSpeechThreshold: -25,
 		OpenAIURL:          "https://api.openai.com",
 		OpenAIModel:        "gpt-5.4",
+		OpenAIASRModel:     "whisper-1",
 		AnthropicURL:       "https://api.anthropic.com",
 		AnthropicModel:     "claude-sonnet-4-5",
+		LLMFallbackChain:   []string{"ollama", "openai", "anthropic"},
+		RESTRateLimitRPS:   20,
+		RESTRateLimitBurst: 40,
+		WSMsgRateLimit:     1000,
+		WSMsgBurst:         2000,
+		PiperProcesses:     4,
+		WSWriteTimeoutMs:   5000,
+		WSSendQueue:        256,
+		WSSlowClientPolicy: "drop",
+		WSPingIntervalMs:   15000,
+		WSPongTimeoutMs:    90000,
+		MetricsPollIntervalS: 15,
+		FlowsDir:             "flows",
+		PromptsDB:            "prompts.db",
+		EmbeddingEngine:      "ollama",
+		Resampler:            audio.ResampleLinear,
+		EmbeddingModel:       "nomic-embed-text",
+		AnthropicPromptCaching: true,
+		VRAMAdmission: orchestrator.AdmissionConfig{
+			Policy:      orchestrator.AdmissionRefuse,
+			EstimatesMB: map[string]int{"whisper-server": 2000},
+		},
 	}
 }
 
-// loadTuning reads gateway.json if present, otherwise returns defaults.
-func loadTuning(path string) tuning {
-	t := defaultTuning()
+// loadTuning reads gateway.json if present, otherwise returns defaults. It
+// also returns the keys the file sets. A file that doesn't parse is an
+// error rather than a silent fallback to defaults; keys no setting reads
+// are logged.
+func loadTuning(path string) (tuning, map[string]bool, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		slog.Info("no config file, using defaults", "path", path)
-		return t
+		return defaultTuning(), nil, nil
+	}
+	t, err := parseTuning(data)
+	if err != nil {
+		return t, nil, fmt.Errorf("%s: %w", path, err)
 	}
-	if err = json.Unmarshal(data, &t); err != nil {
-		slog.Warn("bad config file, using defaults", "path", path, "error", err)
-		return defaultTuning()
+	keys, unknown := fileKeys(data)
+	if len(unknown) > 0 {
+		slog.Warn("config file has unknown settings, ignoring them", "path", path, "keys", unknown)
 	}
 	slog.Info("loaded config", "path", path)
-	return t
+	return t, keys, nil
+}
+
+func parseTuning(data []byte) (tuning, error) {
+	t := defaultTuning()
+	if err := json.Unmarshal(data, &t); err != nil {
+		return t, err
+	}
+	return t, t.tunables().Validate()
+}
+
+// tunables is the subset of t that can change without a restart.
+func (t tuning) tunables() ws.Tunables {
+	return ws.Tunables{
+		SystemPrompt:         t.LLMSystemPrompt,
+		VADSpeechThresholdDB: t.VADSpeechThreshold,
+		VADSilenceTimeoutMs:  t.VADSilenceTimeoutMs,
+		Endpointing:          t.Endpointing,
+		Tenants:              t.Tenants,
+		Experiments:          t.Experiments,
+		DefaultExperiment:    t.DefaultExperiment,
+		SessionLimits:        t.SessionLimits,
+	}
+}
+
+// watchTuning polls path and hands the reloaded tunables to apply whenever
+// the file changes. A file that fails to parse is logged and skipped, leaving
+// the running settings in place.
+func watchTuning(ctx context.Context, path string, interval time.Duration, apply func(ws.Tunables)) {
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().After(lastMod) {
+			continue
+		}
+		lastMod = info.ModTime()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("config reload", "path", path, "error", err)
+			continue
+		}
+		t, err := parseTuning(data)
+		if err != nil {
+			slog.Warn("config reload: bad config file, keeping current settings", "path", path, "error", err)
+			continue
+		}
+		apply(t.tunables())
+		slog.Info("config reloaded", "path", path)
+	}
+}
+
+// command is one subcommand of the gateway binary.
+type command struct {
+	summary string
+	run     func(args []string) (exitCode int)
+}
+
+var commands = map[string]command{
+	"serve": {"run the gateway (the default without a command)", serve},
+	"check": {"validate the configuration and probe every backend, then exit", check},
+	"bench": {"place one call against a running gateway and report its latencies", bench},
 }
 
 func main() {
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	slog.SetDefault(slog.New(logctx.NewHandler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))))
+
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(args))
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: gateway [command] [flags]\n\ncommands:")
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		fmt.Fprintf(w, "  %-6s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w, "\nRun gateway <command> -h for its flags.")
+}
 
-	t := loadTuning("gateway.json")
+// serve runs the gateway until SIGINT/SIGTERM.
+func serve(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", "gateway.json", "settings file, reloaded when it changes")
+	fs.Parse(args)
 
-	// Deployment env vars — URLs, ports, keys
-	port := env.Str("GATEWAY_PORT", "8000")
-	ollamaURL := env.Str("OLLAMA_URL", "http://localhost:11434")
-	ollamaModel := env.Str("OLLAMA_MODEL", "llama3.2:3b")
-	piperModelDir := env.Str("PIPER_MODEL_DIR", "/models")
-	whisperServerURL := env.Str("WHISPER_SERVER_URL", "")
-	whisperControlURL := env.Str("WHISPER_CONTROL_URL", "")
-	openaiAPIKey := env.Str("OPENAI_API_KEY", "")
-	anthropicAPIKey := env.Str("ANTHROPIC_API_KEY", "")
-	audioclassifyURL := env.Str("AUDIOCLASSIFY_URL", "")
+	c, err := loadConfig(*configPath)
+	if err != nil {
+		slog.Error("bad config file", "error", err)
+		return 1
+	}
+	if len(c.problems) > 0 {
+		for _, p := range c.problems {
+			slog.Error("invalid config", "problem", p)
+		}
+		return 1
+	}
+	t := c.tuning
 
 	// Service orchestrator
 	svcRegistry := orchestrator.NewRegistry(map[string]orchestrator.ServiceMeta{
 		"whisper-server": {
 			Category:   "asr",
-			HealthURL:  whisperServerURL,
-			ControlURL: whisperControlURL,
+			HealthURL:  c.whisperServerURL,
+			ControlURL: c.whisperControlURL,
 		},
 	})
 	svcMgr := orchestrator.NewHTTPControlManager(svcRegistry)
 
-	whisperPrompt := env.Str("WHISPER_PROMPT", "Customer service call transcript:")
-	asrRouter := initASR(whisperServerURL, t.ASRPoolSize, whisperPrompt)
-	llmRouter := initLLM(ollamaURL, ollamaModel, openaiAPIKey, anthropicAPIKey, t)
-	ttsClient := initTTS(piperModelDir)
+	asrRouter, whisperASR := initASR(c.whisperServerURL, c.openaiAPIKey, t, c.whisperPrompt)
+	llmRouter := initLLM(c.ollamaURL, c.ollamaModel, c.openaiAPIKey, c.anthropicAPIKey, c.vllmURL, c.llamacppURL, t)
+	ttsClient := initTTS(c.piperModelDir, t.PiperProcesses, t.TTSPoolSize)
+	breakers := pipeline.NewBreakers(t.CircuitBreaker)
+	asrRouter.SetBreakers(breakers, "asr")
+	llmRouter.SetBreakers(breakers)
+	ttsClient.SetBreakers(breakers, "tts")
 
 	// VAD config
 	vad := audio.DefaultVADConfig()
@@ -108,12 +379,46 @@ func main() {
 	denoiser := denoise.New()
 
 	var classifyClient *pipeline.ClassifyClient
-	if audioclassifyURL != "" {
-		classifyClient = pipeline.NewClassifyClient(audioclassifyURL)
+	if c.audioclassifyURL != "" {
+		classifyClient = pipeline.NewClassifyClient(c.audioclassifyURL)
+	}
+
+	embedder := pipeline.NewOllamaEmbedder(c.ollamaURL, t.EmbeddingModel)
+	if t.EmbeddingEngine == "onnx" {
+		embedder = pipeline.NewONNXEmbedder(c.embedURL)
+	}
+
+	redactor := redact.New(t.PIIRedaction)
+	traceStore := initTraceStore(c.postgresURL)
+	if traceStore != nil {
+		traceStore.SetRedactor(redactor)
+	}
+	if traceStore != nil && t.TraceAudioDir != "" {
+		if err := traceStore.SetAudioDir(t.TraceAudioDir); err != nil {
+			slog.Warn("trace audio archive disabled", "error", err)
+		}
 	}
+	go trace.RunRetention(context.Background(), traceStore, time.Duration(t.HistoryRetentionDays)*24*time.Hour)
+	go trace.RunAnalytics(context.Background(), traceStore)
 
-	postgresURL := env.Str("POSTGRES_URL", "")
-	traceStore := initTraceStore(postgresURL)
+	gpu := newGPUHub(c.ollamaURL, c.whisperControlURL)
+	admission := orchestrator.NewAdmission(t.VRAMAdmission, c.ollamaURL, gpu.fetch)
+	svcMgr.SetAdmission(admission)
+	admission.OnDecision(gpu.broadcastDecision)
+
+	moderator, err := pipeline.NewModerator(t.Moderation, c.ollamaURL)
+	if err != nil {
+		slog.Error("moderation config", "error", err)
+		return 1
+	}
+
+	flows, err := flow.LoadDir(t.FlowsDir)
+	if err != nil {
+		slog.Error("call flows", "error", err)
+		return 1
+	}
+
+	promptStore := initPromptStore(t.PromptsDB)
 
 	handler := ws.NewHandler(ws.HandlerConfig{
 		ASRClient:     asrRouter,
@@ -121,43 +426,118 @@ func main() {
 		TTSClient:     ttsClient,
 		VADConfig:     vad,
 		Denoiser:       denoiser,
+		DenoisePerChunk: t.DenoisePerChunk,
+		Resampler:       t.Resampler,
 		ClassifyClient: classifyClient,
 		TraceStore:     traceStore,
+		MsgLimiter:           ratelimit.New("ws_messages", t.WSMsgRateLimit, t.WSMsgBurst),
+		MaxSessionAudioBytes: t.MaxSessionAudioBytes,
+		TTSVoices:            t.TTSLanguageVoices,
+		Pricing:              &pipeline.Pricing{LLM: t.LLMPricing, TTS: t.TTSPricingPer1KChar},
+		Services:             svcMgr,
+		ServiceStartWait:     time.Duration(t.ServiceAutoStartWaitS) * time.Second,
+		OnServiceStarted:     func(gpuData json.RawMessage) { gpu.broadcast(gpuData) },
+		WriteTimeout:         time.Duration(t.WSWriteTimeoutMs) * time.Millisecond,
+		PingInterval:         time.Duration(t.WSPingIntervalMs) * time.Millisecond,
+		PongTimeout:          time.Duration(t.WSPongTimeoutMs) * time.Millisecond,
+		SendQueueSize:        t.WSSendQueue,
+		SlowClientPolicy:     t.WSSlowClientPolicy,
+		OllamaURL:            c.ollamaURL,
+		Moderator:            moderator,
+		Redactor:             redactor,
+		Filler:               pipeline.NewFillerCache(t.Filler, ttsClient),
+		SemanticCache:        pipeline.NewSemanticCache(t.SemanticCache, embedder),
+		Intents:              pipeline.NewIntentRouter(t.Intent, c.ollamaURL),
+		Handoff:              pipeline.NewHandoff(t.Handoff),
+		CallSummary:          t.CallSummary,
+		Flows:                flows,
+		Prompts:              promptStore,
+		Tunables:             t.tunables(),
+	})
+	go watchTuning(context.Background(), *configPath, configPollInterval, handler.SetTunables)
+
+	idle := orchestrator.NewIdleWatchdog(svcMgr, time.Duration(t.ServiceIdleTimeoutMin)*time.Minute, func(_ string, gpuData json.RawMessage) {
+		gpu.broadcast(gpuData)
 	})
+	touch := func(engine string) {
+		idle.Touch(engine)
+		admission.Touch(engine, "")
+	}
+	asrRouter.OnRoute(touch)
+	ttsClient.OnRoute(touch)
+	// the default model is the active LLM until a call uses another
+	admission.Touch(c.ollamaModel, orchestrator.CategoryLLM)
+	llmRouter.OnRoute(func(engine, model string) {
+		if engine == "ollama" {
+			admission.Touch(model, orchestrator.CategoryLLM)
+		}
+	})
+	go idle.Run(context.Background())
+	go models.PinModels(context.Background(), c.ollamaURL, t.PinnedModels)
+	go gpu.pollMetrics(context.Background(), svcMgr, time.Duration(t.MetricsPollIntervalS)*time.Second)
 
-	gpu := newGPUHub(ollamaURL, whisperControlURL)
+	var postgres health.Probe
+	if traceStore != nil {
+		postgres = traceStore.ServerVersion
+	}
+	checker := newHealthChecker(c.healthTargets(postgres))
 
 	mux := http.NewServeMux()
 	registerRoutes(mux, deps{
-		ollamaURL:         ollamaURL,
-		ollamaModel:       ollamaModel,
-		whisperControlURL: whisperControlURL,
+		ollamaURL:         c.ollamaURL,
+		ollamaModel:       c.ollamaModel,
+		whisperControlURL: c.whisperControlURL,
 		asrRouter:         asrRouter,
+		whisperASR:        whisperASR,
 		llmRouter:         llmRouter,
 		ttsClient:         ttsClient,
 		svcMgr:            svcMgr,
+		idle:              idle,
 		gpu:               gpu,
 		wsHandler:         handler,
+		callConfig:        handler,
+		realtimeHandler:   handler.Realtime(),
+		chatHandler:       handler.Completions(),
+		monitorHandler:    handler.Monitor(),
 		traceStore:        traceStore,
+		promptStore:       promptStore,
+		pinnedModels:      t.PinnedModels,
+		admission:         admission,
+		health:            checker,
 	})
 
-	addr := ":" + port
-	srv := &http.Server{Addr: addr, Handler: mux}
+	apiKeys, err := auth.LoadKeys(env.Str("GATEWAY_API_KEYS", ""), env.Str("GATEWAY_API_KEYS_FILE", ""))
+	if err != nil {
+		slog.Error("load api keys", "error", err)
+		return 1
+	}
+	if !apiKeys.Enabled() {
+		slog.Warn("no api keys configured, authentication disabled")
+	}
+
+	addr := ":" + c.port
+	restLimiter := ratelimit.New("rest", t.RESTRateLimitRPS, t.RESTRateLimitBurst)
+	srv := &http.Server{Addr: addr, Handler: auth.Middleware(apiKeys, ratelimit.Middleware(restLimiter, mux))}
 
-	go awaitShutdown(srv, ollamaURL, svcMgr)
+	go awaitShutdown(srv, c.ollamaURL, svcMgr, ttsClient)
+
+	rep := checker.Report(context.Background())
+	logDependencies(rep)
+	printStartupTable(os.Stderr, c, rep)
 
 	slog.Info("gateway starting", "addr", addr)
 
 	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		slog.Error("server failed", "error", err)
-		os.Exit(1)
+		return 1
 	}
 
 	slog.Info("gateway stopped")
+	return 0
 }
 
 // awaitShutdown blocks until SIGINT/SIGTERM, then gracefully unloads models and stops services.
-func awaitShutdown(srv *http.Server, ollamaURL string, svcMgr *orchestrator.HTTPControlManager) {
+func awaitShutdown(srv *http.Server, ollamaURL string, svcMgr *orchestrator.HTTPControlManager, tts *pipeline.TTSRouter) {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-sigCh
@@ -175,33 +555,64 @@ func awaitShutdown(srv *http.Server, ollamaURL string, svcMgr *orchestrator.HTTP
 	stopRunningServices(ctx, svcMgr, "shutdown")
 
 	srv.Shutdown(ctx)
+
+	slog.Info("stopping piper processes")
+	tts.Close()
 }
 
-func initASR(whisperServerURL string, poolSize int, prompt string) *pipeline.ASRRouter {
+// initASR also returns the whisper-server client (nil if unconfigured) so a
+// model hot-swap can repoint it.
+// initASR registers the local whisper server plus any cloud backend whose
+// credentials are set.
+func initASR(whisperServerURL, openaiAPIKey string, t tuning, prompt string) (*pipeline.ASRRouter, *pipeline.MultipartASRClient) {
 	backends := map[string]pipeline.ASRTranscriber{}
+	var whisper *pipeline.MultipartASRClient
 	if whisperServerURL != "" {
-		backends["whisper-server"] = pipeline.NewASRClient(whisperServerURL, poolSize, prompt)
+		whisper = pipeline.NewASRClient(whisperServerURL, t.ASRPoolSize, prompt)
+		backends["whisper-server"] = whisper
 	}
-	return pipeline.NewASRRouter(backends, "whisper-server")
+	if openaiAPIKey != "" {
+		backends["openai"] = pipeline.NewOpenAIASRClient(t.OpenAIURL, openaiAPIKey, t.OpenAIASRModel, t.ASRPoolSize, prompt)
+	}
+	if key := env.Str("AZURE_SPEECH_KEY", ""); key != "" {
+		backends["azure"] = pipeline.NewAzureASRClient(key, env.Str("AZURE_SPEECH_REGION", "eastus"), env.Str("AZURE_STT_LOCALE", "en-US"), t.ASRPoolSize)
+	}
+	for name, url := range t.ASRInstances {
+		if _, taken := backends[name]; taken {
+			slog.Warn("asr instance shadows a built-in engine, ignoring", "engine", name)
+			continue
+		}
+		backends[name] = pipeline.NewASRClient(url, t.ASRPoolSize, prompt)
+	}
+	return pipeline.NewASRRouter(backends, "whisper-server"), whisper
 }
 
-func initLLM(ollamaURL, ollamaModel, openaiAPIKey, anthropicAPIKey string, t tuning) *pipeline.AgentLLM {
+func initLLM(ollamaURL, ollamaModel, openaiAPIKey, anthropicAPIKey, vllmURL, llamacppURL string, t tuning) *pipeline.AgentLLM {
 	router := pipeline.NewAgentLLM("ollama", t.LLMMaxTokens)
 	router.Register("ollama", agents.NewOpenAIProvider(agents.OpenAIProviderParams{
 		BaseURL:      param.NewOpt(ollamaURL + "/v1/"),
 		APIKey:       param.NewOpt("ollama"),
 		UseResponses: param.NewOpt(false),
 	}), ollamaModel)
+	router.SetParams("ollama", t.LLMParams["ollama"])
 	if openaiAPIKey != "" {
 		router.Register("openai", agents.NewOpenAIProvider(agents.OpenAIProviderParams{
 			BaseURL:      param.NewOpt(t.OpenAIURL + "/v1/"),
 			APIKey:       param.NewOpt(openaiAPIKey),
 			UseResponses: param.NewOpt(true),
 		}), t.OpenAIModel)
+		router.SetParams("openai", t.LLMParams["openai"])
 	}
 	if anthropicAPIKey != "" {
-		router.RegisterRaw("anthropic", pipeline.NewAnthropicClient(t.AnthropicURL, anthropicAPIKey, t.LLMMaxTokens), t.AnthropicModel)
+		router.RegisterRaw("anthropic", pipeline.NewAnthropicClient(t.AnthropicURL, anthropicAPIKey, t.LLMMaxTokens, t.AnthropicPromptCaching, t.AnthropicThinkingBudget), t.AnthropicModel)
+	}
+	if vllmURL != "" {
+		router.RegisterRaw("vllm", pipeline.NewOpenAIChatClient(vllmURL, env.Str("VLLM_API_KEY", ""), t.LLMMaxTokens, t.LLMParams["vllm"]), env.Str("VLLM_MODEL", ""))
 	}
+	if llamacppURL != "" {
+		router.RegisterRaw("llamacpp", pipeline.NewLlamaCppClient(llamacppURL, t.LLMMaxTokens), env.Str("LLAMACPP_MODEL", "llama.cpp"))
+	}
+	router.SetFallbackChain(t.LLMFallbackChain, time.Duration(t.LLMTTFTBudgetMs)*time.Millisecond)
 	return router
 }
 
@@ -218,11 +629,46 @@ func initTraceStore(postgresURL string) *trace.Store {
 	return store
 }
 
-func initTTS(piperModelDir string) *pipeline.TTSRouter {
+func initPromptStore(path string) *prompts.Store {
+	if path == "" {
+		return nil
+	}
+	store, err := prompts.Open(path)
+	if err != nil {
+		slog.Error("prompt library open failed", "error", err)
+		return nil
+	}
+	slog.Info("prompt library enabled", "path", path)
+	return store
+}
+
+// initTTS registers the local piper voices plus any managed backend whose
+// credentials are set.
+// defaultPiperVoice is the voice of the default "fast" TTS engine.
+const defaultPiperVoice = "en_US-lessac-low"
+
+func initTTS(piperModelDir string, poolSize, httpPoolSize int) *pipeline.TTSRouter {
 	backends := map[string]pipeline.TTSSynthesizer{
-		"fast":    pipeline.NewPiperSynthesizer(piperModelDir, "en_US-lessac-low"),
-		"quality": pipeline.NewPiperSynthesizer(piperModelDir, "en_US-lessac-medium"),
-		"high":    pipeline.NewPiperSynthesizer(piperModelDir, "en_US-lessac-high"),
+		"fast":    pipeline.NewPiperSynthesizer(piperModelDir, defaultPiperVoice, poolSize),
+		"quality": pipeline.NewPiperSynthesizer(piperModelDir, "en_US-lessac-medium", poolSize),
+		"high":    pipeline.NewPiperSynthesizer(piperModelDir, "en_US-lessac-high", poolSize),
+	}
+	if key := env.Str("AZURE_SPEECH_KEY", ""); key != "" {
+		backends["azure"] = pipeline.NewAzureSynthesizer(key, env.Str("AZURE_SPEECH_REGION", "eastus"), env.Str("AZURE_SPEECH_VOICE", "en-US-JennyNeural"), httpPoolSize)
+	}
+	if key := env.Str("GOOGLE_TTS_API_KEY", ""); key != "" {
+		backends["google"] = pipeline.NewGoogleSynthesizer(key, env.Str("GOOGLE_TTS_VOICE", "en-US-Neural2-F"), httpPoolSize)
+	}
+	if key := env.Str("AWS_ACCESS_KEY_ID", ""); key != "" {
+		backends["polly"] = pipeline.NewPollySynthesizer(pipeline.PollyConfig{
+			Region:       env.Str("AWS_REGION", "us-east-1"),
+			AccessKey:    key,
+			SecretKey:    env.Str("AWS_SECRET_ACCESS_KEY", ""),
+			SessionToken: env.Str("AWS_SESSION_TOKEN", ""),
+			Voice:        env.Str("POLLY_VOICE", "Joanna"),
+			Engine:       env.Str("POLLY_ENGINE", "neural"),
+			OutputFormat: env.Str("POLLY_OUTPUT_FORMAT", "pcm"),
+		}, httpPoolSize)
 	}
 	return pipeline.NewTTSRouter(backends, "fast")
 }
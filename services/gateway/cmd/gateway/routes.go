@@ -3,17 +3,27 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/auth"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/experiment"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/health"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/models"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/orchestrator"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/prompts"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/trace"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/ws"
 )
 
 const (
@@ -24,6 +34,21 @@ const (
 	// defaultTraceSessionLimit is how many trace sessions are returned
 	// when the caller omits the ?limit= query parameter.
 	defaultTraceSessionLimit = 20
+
+	// maxSynthesizeTextLen caps the text accepted by /api/synthesize so one
+	// request can't tie up a TTS worker for minutes.
+	maxSynthesizeTextLen = 5000
+
+	// asrSwapDrain is how long the old whisper-server keeps running after a
+	// hot-swap, so transcriptions already sent to it can finish.
+	asrSwapDrain = 10 * time.Second
+
+	// asrSwapTimeout bounds starting the new instance, model load included.
+	asrSwapTimeout = 2 * time.Minute
+
+	// defaultAnalyticsDays is how many days /api/analytics/daily returns
+	// when the caller omits ?from=.
+	defaultAnalyticsDays = 30
 )
 
 type deps struct {
@@ -31,33 +56,155 @@ type deps struct {
 	ollamaModel       string
 	whisperControlURL string
 	asrRouter         *pipeline.ASRRouter
+	whisperASR        *pipeline.MultipartASRClient
 	llmRouter         *pipeline.AgentLLM
 	ttsClient         *pipeline.TTSRouter
 	svcMgr            *orchestrator.HTTPControlManager
+	idle              *orchestrator.IdleWatchdog
 	gpu               *gpuHub
 	wsHandler         http.Handler
+	callConfig        *ws.Handler // owner of the runtime-tunable call settings
+	realtimeHandler   http.Handler
+	chatHandler       http.Handler
+	monitorHandler    http.Handler
 	traceStore        *trace.Store
+	promptStore       *prompts.Store
+	pinnedModels      []string
+	admission         *orchestrator.Admission
+	health            *health.Checker
 }
 
 // registerRoutes wires all HTTP endpoints to the shared mux.
 func registerRoutes(mux *http.ServeMux, d deps) {
 	mux.Handle("/ws/call", d.wsHandler)
+	mux.Handle("/v1/realtime", d.realtimeHandler)
+	mux.Handle("/v1/chat/completions", d.chatHandler)
+	mux.Handle("GET /ws/monitor/{session_id}", d.monitorHandler)
 	mux.HandleFunc("/health", handleHealth)
+	mux.HandleFunc("GET /api/health/deep", d.handleDeepHealth)
+	mux.Handle("/metrics", promhttp.Handler())
 	mux.HandleFunc("/api/models", d.handleModels)
 	mux.HandleFunc("POST /api/models/preload", d.handlePreload)
 	mux.HandleFunc("POST /api/models/unload", d.handleUnload)
 	mux.HandleFunc("POST /api/tts/warmup", d.handleTTSWarmup)
 	mux.HandleFunc("/api/tts/health", d.handleTTSHealth)
+	mux.HandleFunc("POST /api/synthesize", d.handleSynthesize)
 	mux.HandleFunc("POST /api/gpu/unload-all", d.handleGPUUnloadAll)
 	mux.HandleFunc("GET /api/gpu", d.handleGPU)
 	mux.HandleFunc("GET /api/gpu/stream", d.handleGPUStream)
+	mux.HandleFunc("GET /api/gpu/schedule", d.handleGPUSchedule)
 	mux.HandleFunc("GET /api/asr/models", d.handleASRModels)
 	mux.HandleFunc("POST /api/asr/models/download", d.handleASRDownload)
+	mux.HandleFunc("DELETE /api/asr/models/{name}", d.handleASRDelete)
+	mux.HandleFunc("POST /api/asr/models/activate", d.handleASRActivate)
 	mux.HandleFunc("GET /api/services", d.handleServices)
 	mux.HandleFunc("POST /api/services/{name}/start", d.handleServiceStart)
 	mux.HandleFunc("POST /api/services/{name}/stop", d.handleServiceStop)
 	mux.HandleFunc("GET /api/services/{name}/status", d.handleServiceStatus)
+	mux.HandleFunc("GET /api/config", d.handleGetConfig)
+	mux.HandleFunc("PUT /api/config", d.handlePutConfig)
 	registerTraceRoutes(mux, d.traceStore)
+	registerPromptRoutes(mux, d.promptStore)
+	mux.HandleFunc("GET /api/experiments/{id}/results", d.handleExperimentResults)
+	mux.HandleFunc("GET /api/analytics/daily", d.handleAnalyticsDaily)
+}
+
+// handleExperimentResults compares an experiment's variants from their
+// traced runs. Keys bound to a tenant see only that tenant's calls.
+func (d deps) handleExperimentResults(w http.ResponseWriter, r *http.Request) {
+	if d.traceStore == nil {
+		http.Error(w, "tracing disabled", http.StatusNotFound)
+		return
+	}
+	id := r.PathValue("id")
+	exp, configured := d.callConfig.Tunables().Experiments[id]
+	stats, err := d.traceStore.ExperimentStats(id, auth.TenantOf(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !configured && len(stats) == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(experiment.Summarize(id, exp, stats))
+}
+
+// handleAnalyticsDaily returns the daily stats of ?from= through ?to=
+// (YYYY-MM-DD, UTC; the last 30 days by default), overall or for ?tenant=.
+// Keys bound to a tenant see only that tenant's.
+func (d deps) handleAnalyticsDaily(w http.ResponseWriter, r *http.Request) {
+	if d.traceStore == nil {
+		http.Error(w, "tracing disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	to, err := queryDay(q.Get("to"), time.Now().UTC())
+	if err != nil {
+		http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := queryDay(q.Get("from"), to.AddDate(0, 0, -defaultAnalyticsDays+1))
+	if err != nil {
+		http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	tenant := auth.TenantOf(r)
+	if tenant == "" {
+		tenant = q.Get("tenant")
+	}
+	days, err := d.traceStore.ListDailyStats(tenant, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"days": days})
+}
+
+// queryDay parses a YYYY-MM-DD query value, or returns fallback if empty.
+func queryDay(v string, fallback time.Time) (time.Time, error) {
+	if v == "" {
+		return fallback, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
+
+// handleGetConfig returns the call settings new calls start with. They're
+// gateway-wide, so keys bound to a tenant can't read them.
+func (d deps) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	if auth.TenantOf(r) != "" {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.callConfig.Tunables())
+}
+
+// handlePutConfig replaces the call settings (the whole object, as returned
+// by GET). Calls in progress keep theirs. The change lasts until the gateway
+// restarts or gateway.json changes.
+func (d deps) handlePutConfig(w http.ResponseWriter, r *http.Request) {
+	if auth.TenantOf(r) != "" {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	var t ws.Tunables
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := t.Validate(); err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.callConfig.SetTunables(t)
+	slog.Info("config updated via api")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -88,6 +235,7 @@ func (d deps) handleModels(w http.ResponseWriter, r *http.Request) {
 		},
 		"tts": map[string]interface{}{
 			"engines": d.ttsClient.Engines(),
+			"ssml":    ssmlEngines(d.ttsClient),
 		},
 		"audio": map[string]interface{}{
 			"bandwidth_modes": []map[string]interface{}{
@@ -110,6 +258,11 @@ func (d deps) handlePreload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	slog.Info("preloading llm model", "model", req.Model)
+	if err := d.admission.AdmitModel(r.Context(), req.Model); err != nil {
+		slog.Error("preload model", "model", req.Model, "error", err)
+		writeStartError(w, err)
+		return
+	}
 	if err := models.PreloadLLM(r.Context(), d.ollamaURL, req.Model); err != nil {
 		slog.Error("preload model", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -173,12 +326,73 @@ func (d deps) handleTTSHealth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "engine": engine})
 }
 
+// handleSynthesize renders text to audio outside of a live call (IVR prompts,
+// pre-rendered announcements). Responds with the engine's raw audio bytes.
+func (d deps) handleSynthesize(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Text   string  `json:"text"`
+		Engine string  `json:"engine"`
+		Voice  string  `json:"voice"`
+		Speed  float64 `json:"speed"`
+		Pitch  float64 `json:"pitch"`
+		SSML   bool    `json:"ssml"` // text is SSML markup
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Text == "" || len(req.Text) > maxSynthesizeTextLen {
+		http.Error(w, fmt.Sprintf("text must be 1-%d bytes", maxSynthesizeTextLen), http.StatusBadRequest)
+		return
+	}
+	if req.Engine != "" && !d.ttsClient.Has(req.Engine) {
+		http.Error(w, "engine not available", http.StatusNotFound)
+		return
+	}
+
+	result, err := d.ttsClient.Synthesize(r.Context(), req.Text, req.Engine, pipeline.TTSOptions{Speed: req.Speed, Pitch: req.Pitch, Voice: req.Voice, SSML: req.SSML})
+	if err != nil {
+		slog.Error("synthesize", "engine", req.Engine, "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", audioContentType(result.Audio))
+	w.Header().Set("X-TTS-Latency-Ms", strconv.FormatFloat(result.LatencyMs, 'f', 0, 64))
+	w.Write(result.Audio)
+}
+
+// ssmlEngines lists the TTS engines that accept SSML input.
+func ssmlEngines(tts *pipeline.TTSRouter) []string {
+	engines := []string{}
+	for _, name := range tts.Engines() {
+		if tts.SupportsSSML(name) {
+			engines = append(engines, name)
+		}
+	}
+	return engines
+}
+
+// audioContentType maps synthesized audio to its MIME type. Backends
+// return WAV or MP3 without announcing which.
+func audioContentType(data []byte) string {
+	types := map[audio.OutputFormat]string{audio.OutputWAV: "audio/wav", audio.OutputMP3: "audio/mpeg"}
+	if t, ok := types[audio.Container(data)]; ok {
+		return t
+	}
+	return "application/octet-stream"
+}
+
 func (d deps) handleGPUUnloadAll(w http.ResponseWriter, r *http.Request) {
 	slog.Info("unload-all requested")
 	if err := models.UnloadAllLLMs(r.Context(), d.ollamaURL); err != nil {
 		slog.Warn("unload-all ollama", "error", err)
 	}
 	stopRunningServices(r.Context(), d.svcMgr, "unload-all")
+	// pinned models come straight back so the next call isn't a cold start
+	go func() {
+		models.PinModels(context.Background(), d.ollamaURL, d.pinnedModels)
+		d.gpu.broadcast(d.gpu.fetch())
+	}()
 	data := d.gpu.fetch()
 	d.gpu.broadcast(data)
 	w.Header().Set("Content-Type", "application/json")
@@ -216,8 +430,8 @@ func (d deps) handleGPUStream(w http.ResponseWriter, r *http.Request) {
 		flusher.Flush()
 	}
 
-	ch := d.gpu.subscribe()
-	defer d.gpu.unsubscribe(ch)
+	sub := d.gpu.subscribe()
+	defer d.gpu.unsubscribe(sub)
 	slog.Info("gpu/stream client connected", "remote", r.RemoteAddr)
 
 	for {
@@ -225,13 +439,26 @@ func (d deps) handleGPUStream(w http.ResponseWriter, r *http.Request) {
 		case <-r.Context().Done():
 			slog.Info("gpu/stream client disconnected", "remote", r.RemoteAddr)
 			return
-		case msg := <-ch:
+		case msg := <-sub.state:
 			fmt.Fprintf(w, "data: %s\n\n", msg)
 			flusher.Flush()
+		case msg := <-sub.decisions:
+			fmt.Fprintf(w, "event: schedule\ndata: %s\n\n", msg)
+			flusher.Flush()
 		}
 	}
 }
 
+// handleGPUSchedule reports what the VRAM scheduler sees: the running
+// services and loaded models in eviction order, and its recent decisions.
+func (d deps) handleGPUSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"residents": d.admission.Residents(r.Context()),
+		"decisions": d.admission.Decisions(),
+	})
+}
+
 func (d deps) handleASRModels(w http.ResponseWriter, r *http.Request) {
 	if d.whisperControlURL == "" {
 		http.Error(w, "whisper-control not configured", http.StatusServiceUnavailable)
@@ -280,6 +507,91 @@ func (d deps) handleASRDownload(w http.ResponseWriter, r *http.Request) {
 	io.Copy(&flushWriter{w: w, flush: flush}, resp.Body)
 }
 
+// handleASRDelete removes a downloaded model (and any partial download)
+// from whisper-control's disk. Its status and message pass through.
+func (d deps) handleASRDelete(w http.ResponseWriter, r *http.Request) {
+	if d.whisperControlURL == "" {
+		http.Error(w, "whisper-control not configured", http.StatusServiceUnavailable)
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), "DELETE", d.whisperControlURL+"/models/"+url.PathEscape(r.PathValue("name")), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	client := &http.Client{Timeout: proxyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// handleASRActivate switches whisper-server to another model without an
+// outage: whisper-control starts the new model on its alternate port, new
+// transcriptions move there, and the old instance stops after a drain.
+// Without an alternate port it falls back to a restart.
+func (d deps) handleASRActivate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if d.whisperASR == nil {
+		http.Error(w, "whisper-server not configured", http.StatusServiceUnavailable)
+		return
+	}
+	const svc = "whisper-server"
+	ctx, cancel := context.WithTimeout(r.Context(), asrSwapTimeout)
+	defer cancel()
+
+	slog.Info("asr model activate requested", "model", req.Name)
+	res, err := d.svcMgr.Swap(ctx, svc, req.Name)
+	if errors.Is(err, orchestrator.ErrSwapUnsupported) {
+		slog.Warn("asr hot-swap unavailable, restarting", "model", req.Name)
+		gpuData, err := d.svcMgr.EnsureModel(ctx, svc, req.Name)
+		if err != nil {
+			writeStartError(w, err)
+			return
+		}
+		d.gpu.broadcast(gpuData)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "restarted", "model": req.Name})
+		return
+	}
+	if err != nil {
+		slog.Error("asr hot-swap", "model", req.Name, "error", err)
+		writeStartError(w, err)
+		return
+	}
+
+	prevURL := d.whisperASR.URL()
+	d.whisperASR.SetURL(res.URL)
+	d.idle.Touch(svc)
+	slog.Info("asr model swapped", "model", req.Name, "url", res.URL, "previous_url", prevURL)
+	d.gpu.broadcast(res.GPU)
+	go func() {
+		time.Sleep(asrSwapDrain)
+		ctx, cancel := context.WithTimeout(context.Background(), proxyTimeout)
+		defer cancel()
+		gpuData, err := d.svcMgr.StopInstance(ctx, svc, res.PreviousPort)
+		if err != nil {
+			slog.Error("stop previous whisper-server", "port", res.PreviousPort, "error", err)
+			return
+		}
+		d.gpu.broadcast(gpuData)
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"status": "swapped", "model": req.Name, "url": res.URL, "previous_port": res.PreviousPort})
+}
+
 func (d deps) handleServices(w http.ResponseWriter, r *http.Request) {
 	services, err := d.svcMgr.StatusAll(r.Context())
 	if err != nil {
@@ -300,16 +612,30 @@ func (d deps) handleServiceStart(w http.ResponseWriter, r *http.Request) {
 	gpuData, err := d.svcMgr.Start(r.Context(), name, params...)
 	if err != nil {
 		slog.Error("service start failed", "name", name, "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStartError(w, err)
 		return
 	}
 	slog.Info("service started", "name", name)
+	d.idle.Touch(name) // grace period before idle shutdown
 	d.gpu.broadcast(gpuData)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]string{"status": "starting"})
 }
 
+// writeStartError reports a failed start or preload: a 409 with the GPU
+// snapshot when it was refused for VRAM, otherwise a 500.
+func writeStartError(w http.ResponseWriter, err error) {
+	var admissionErr *orchestrator.AdmissionError
+	if !errors.As(err, &admissionErr) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]any{"error": err.Error(), "admission": admissionErr})
+}
+
 func (d deps) handleServiceStop(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
 	slog.Info("service stop requested", "name", name)
@@ -393,7 +719,7 @@ func registerTraceRoutes(mux *http.ServeMux, store *trace.Store) {
 		}
 		limit := queryInt(r, "limit", defaultTraceSessionLimit)
 		offset := queryInt(r, "offset", 0)
-		sessions, total, err := store.ListSessions(limit, offset)
+		sessions, total, err := store.ListSessions(auth.TenantOf(r), limit, offset)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -407,6 +733,10 @@ func registerTraceRoutes(mux *http.ServeMux, store *trace.Store) {
 			http.Error(w, "tracing disabled", http.StatusNotFound)
 			return
 		}
+		if !sessionVisible(r, store, r.PathValue("id")) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
 		sess, runs, err := store.GetSession(r.PathValue("id"))
 		if err != nil {
 			http.Error(w, "not found", http.StatusNotFound)
@@ -416,11 +746,113 @@ func registerTraceRoutes(mux *http.ServeMux, store *trace.Store) {
 		json.NewEncoder(w).Encode(map[string]interface{}{"session": sess, "runs": runs})
 	})
 
+	// The end-of-call summary (call_summary); 404 until the call has ended
+	// and been summarized.
+	mux.HandleFunc("GET /api/traces/sessions/{id}/summary", func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			http.Error(w, "tracing disabled", http.StatusNotFound)
+			return
+		}
+		if !sessionVisible(r, store, r.PathValue("id")) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		sum, err := store.GetSummary(r.PathValue("id"))
+		if err != nil || sum == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sum)
+	})
+
+	// Deletes everything stored for a session (metadata, runs, spans, and
+	// conversation turns) on a caller's erasure request.
+	mux.HandleFunc("DELETE /api/history/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			http.Error(w, "tracing disabled", http.StatusNotFound)
+			return
+		}
+		if !sessionVisible(r, store, r.PathValue("id")) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		id := r.PathValue("id")
+		found, err := store.DeleteSession(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		slog.Info("session history deleted", "session_id", id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Reconstructed conversation for reviewers: ?format=json (default), txt, or srt.
+	mux.HandleFunc("GET /api/traces/sessions/{id}/transcript", func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			http.Error(w, "tracing disabled", http.StatusNotFound)
+			return
+		}
+		if !sessionVisible(r, store, r.PathValue("id")) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		f, ok := trace.TranscriptFormats[format]
+		if !ok {
+			http.Error(w, "format must be json, txt, or srt", http.StatusBadRequest)
+			return
+		}
+		sess, entries, err := store.GetTranscript(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", f.ContentType)
+		if format != "json" {
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, sess.ID, format))
+		}
+		if err = f.Write(w, sess, entries); err != nil {
+			slog.Warn("write transcript", "session_id", sess.ID, "error", err)
+		}
+	})
+
+	// Per-turn ASR/LLM/TTS/end-to-end latency, for charting a call's quality
+	// over time rather than only its last metrics event.
+	mux.HandleFunc("GET /api/sessions/{id}/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			http.Error(w, "tracing disabled", http.StatusNotFound)
+			return
+		}
+		if !sessionVisible(r, store, r.PathValue("id")) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		sess, turns, err := store.SessionMetrics(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"session": sess, "turns": turns})
+	})
+
 	mux.HandleFunc("GET /api/traces/sessions/{id}/runs/{runId}", func(w http.ResponseWriter, r *http.Request) {
 		if store == nil {
 			http.Error(w, "tracing disabled", http.StatusNotFound)
 			return
 		}
+		if !sessionVisible(r, store, r.PathValue("id")) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
 		run, spans, err := store.GetRun(r.PathValue("id"), r.PathValue("runId"))
 		if err != nil {
 			http.Error(w, "not found", http.StatusNotFound)
@@ -429,6 +861,158 @@ func registerTraceRoutes(mux *http.ServeMux, store *trace.Store) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{"run": run, "spans": spans})
 	})
+
+	// Serves the run's archived speech segment (trace_audio_dir) so it can
+	// be replayed through another ASR engine.
+	mux.HandleFunc("GET /api/traces/sessions/{id}/runs/{runId}/audio", func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			http.Error(w, "tracing disabled", http.StatusNotFound)
+			return
+		}
+		if !sessionVisible(r, store, r.PathValue("id")) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		data, err := store.RunAudio(r.PathValue("id"), r.PathValue("runId"))
+		if errors.Is(err, trace.ErrNoAudio) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Write(data)
+	})
+}
+
+// promptBody is the request body for creating or revising a prompt.
+type promptBody struct {
+	Name string `json:"name"` // POST only; PUT takes the name from the path
+	Text string `json:"text"`
+	Note string `json:"note"`
+}
+
+// registerPromptRoutes serves the prompt library. Writes never change an
+// existing version: PUT adds the next one, so traces stay reproducible.
+func registerPromptRoutes(mux *http.ServeMux, store *prompts.Store) {
+	withStore := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if store == nil {
+				http.Error(w, "prompt library disabled", http.StatusNotFound)
+				return
+			}
+			h(w, r)
+		}
+	}
+	writeJSON := func(w http.ResponseWriter, status int, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	writeErr := func(w http.ResponseWriter, err error) {
+		status := http.StatusInternalServerError
+		if errors.Is(err, prompts.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		if errors.Is(err, prompts.ErrExists) {
+			status = http.StatusConflict
+		}
+		if errors.Is(err, prompts.ErrInvalidName) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+	}
+	readBody := func(w http.ResponseWriter, r *http.Request) (promptBody, bool) {
+		var body promptBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Text == "" {
+			http.Error(w, "body must be JSON with non-empty text", http.StatusBadRequest)
+			return body, false
+		}
+		return body, true
+	}
+
+	mux.HandleFunc("GET /api/prompts", withStore(func(w http.ResponseWriter, r *http.Request) {
+		list, err := store.List()
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"prompts": list})
+	}))
+
+	mux.HandleFunc("POST /api/prompts", withStore(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		p, err := store.Create(body.Name, body.Text, body.Note)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		slog.Info("prompt created", "name", p.Name)
+		writeJSON(w, http.StatusCreated, p)
+	}))
+
+	// ?version=N returns that version instead of the latest.
+	mux.HandleFunc("GET /api/prompts/{name}", withStore(func(w http.ResponseWriter, r *http.Request) {
+		p, err := store.Get(r.PathValue("name"), queryInt(r, "version", 0))
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	}))
+
+	mux.HandleFunc("GET /api/prompts/{name}/versions", withStore(func(w http.ResponseWriter, r *http.Request) {
+		versions, err := store.Versions(r.PathValue("name"))
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"versions": versions})
+	}))
+
+	mux.HandleFunc("PUT /api/prompts/{name}", withStore(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		p, err := store.Update(r.PathValue("name"), body.Text, body.Note)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		slog.Info("prompt revised", "name", p.Name, "version", p.Version)
+		writeJSON(w, http.StatusOK, p)
+	}))
+
+	mux.HandleFunc("DELETE /api/prompts/{name}", withStore(func(w http.ResponseWriter, r *http.Request) {
+		found, err := store.Delete(r.PathValue("name"))
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		if !found {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		slog.Info("prompt deleted", "name", r.PathValue("name"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+// sessionVisible reports whether the request's API key may see session id.
+// Keys bound to a tenant see only that tenant's sessions; others see all.
+func sessionVisible(r *http.Request, store *trace.Store, id string) bool {
+	tenant := auth.TenantOf(r)
+	if tenant == "" {
+		return true
+	}
+	owner, err := store.SessionTenant(id)
+	return err == nil && owner == tenant
 }
 
 func queryInt(r *http.Request, key string, fallback int) int {
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/env"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/health"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/orchestrator"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/ws"
+)
+
+// openAIEngines are the LLM engines on OpenAI-compatible APIs, which
+// llm_params applies to.
+var openAIEngines = []string{"ollama", "openai", "vllm"}
+
+// vramCategories are the stages vram_admission.stage_priority may order.
+var vramCategories = []string{"tts", "asr", orchestrator.CategoryLLM}
+
+// tableValueMax truncates long values (prompts, pricing maps) in the
+// startup table.
+const tableValueMax = 60
+
+// setting is one row of the startup configuration table.
+type setting struct {
+	name, value, source string
+}
+
+// startupConfig is gateway.json plus the deployment env vars (URLs, ports,
+// keys), as resolved at startup. Each env var is recorded for the startup
+// table, and whatever is wrong with the configuration is collected, so the
+// gateway can refuse to start with every problem listed at once instead of
+// failing on the first call.
+type startupConfig struct {
+	tuning   tuning
+	fileKeys map[string]bool // keys gateway.json sets
+	env      []setting
+	problems []string
+
+	port, postgresURL                                     string
+	ollamaURL, ollamaModel, piperModelDir, whisperPrompt  string
+	whisperServerURL, whisperControlURL, audioclassifyURL string
+	embedURL                                              string
+	vllmURL, llamacppURL, openaiAPIKey, anthropicAPIKey   string
+}
+
+// loadConfig reads gateway.json at path and the env, and validates them.
+// Only an unreadable gateway.json is an error; other problems are left in
+// problems for the caller to report.
+func loadConfig(path string) (*startupConfig, error) {
+	t, keys, err := loadTuning(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &startupConfig{tuning: t, fileKeys: keys}
+	c.port = c.str("GATEWAY_PORT", "8000")
+	c.ollamaURL = c.url("OLLAMA_URL", "http://localhost:11434")
+	c.ollamaModel = c.str("OLLAMA_MODEL", "llama3.2:3b")
+	c.piperModelDir = c.str("PIPER_MODEL_DIR", "/models")
+	c.whisperServerURL = c.url("WHISPER_SERVER_URL", "")
+	c.whisperControlURL = c.url("WHISPER_CONTROL_URL", "")
+	c.whisperPrompt = c.str("WHISPER_PROMPT", "Customer service call transcript:")
+	c.openaiAPIKey = c.secret("OPENAI_API_KEY")
+	c.anthropicAPIKey = c.secret("ANTHROPIC_API_KEY")
+	c.audioclassifyURL = c.url("AUDIOCLASSIFY_URL", "")
+	c.embedURL = c.url("EMBED_URL", "")
+	c.vllmURL = c.url("VLLM_URL", "")
+	c.llamacppURL = c.url("LLAMACPP_URL", "")
+	c.postgresURL = c.dsn("POSTGRES_URL")
+	c.validate()
+	return c, nil
+}
+
+// healthTargets are the dependencies to probe, with postgres checked
+// through probe (nil without a trace database).
+func (c *startupConfig) healthTargets(postgres health.Probe) healthTargets {
+	return healthTargets{
+		ollamaURL:         c.ollamaURL,
+		whisperServerURL:  c.whisperServerURL,
+		whisperControlURL: c.whisperControlURL,
+		asrInstances:      c.tuning.ASRInstances,
+		piperModelDir:     c.piperModelDir,
+		audioclassifyURL:  c.audioclassifyURL,
+		embedURL:          c.embedURL,
+		vllmURL:           c.vllmURL,
+		llamacppURL:       c.llamacppURL,
+		postgres:          postgres,
+	}
+}
+
+// str is env.Str, recorded.
+func (c *startupConfig) str(key, fallback string) string {
+	v := env.Str(key, fallback)
+	c.record(key, v)
+	return v
+}
+
+// url is str for a backend URL, which must be absolute http(s) if set.
+func (c *startupConfig) url(key, fallback string) string {
+	v := c.str(key, fallback)
+	if err := checkURL(v); v != "" && err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("%s: %v", key, err))
+	}
+	return v
+}
+
+// secret is str for a credential, shown in the table only as set or not.
+func (c *startupConfig) secret(key string) string {
+	v := env.Str(key, "")
+	shown := ""
+	if v != "" {
+		shown = "(set)"
+	}
+	c.record(key, shown)
+	return v
+}
+
+// dsn is str for a database URL; its password is masked in the table.
+func (c *startupConfig) dsn(key string) string {
+	v := env.Str(key, "")
+	shown := v
+	if u, err := url.Parse(v); err == nil && u.User != nil {
+		shown = u.Redacted()
+	}
+	c.record(key, shown)
+	return v
+}
+
+func (c *startupConfig) record(key, shown string) {
+	source := "default"
+	if os.Getenv(key) != "" {
+		source = "env"
+	}
+	c.env = append(c.env, setting{key, shown, source})
+}
+
+func checkURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s)://host URL", v)
+	}
+	return nil
+}
+
+// validate checks settings that are each valid alone but don't work
+// together, or would silently turn a feature off.
+func (c *startupConfig) validate() {
+	t := c.tuning
+	add := func(format string, args ...any) {
+		c.problems = append(c.problems, fmt.Sprintf(format, args...))
+	}
+	urls := map[string]string{
+		"openai_url":      t.OpenAIURL,
+		"anthropic_url":   t.AnthropicURL,
+		"handoff.webhook": t.Handoff.Webhook,
+	}
+	for name, u := range t.ASRInstances {
+		urls["asr_instances."+name] = u
+	}
+	for name, route := range t.Intent.Intents {
+		urls["intent.intents."+name+".webhook"] = route.Webhook
+	}
+	for name, u := range urls {
+		if err := checkURL(u); u != "" && err != nil {
+			add("%s: %v", name, err)
+		}
+	}
+
+	pools := map[string]int{"asr_pool_size": t.ASRPoolSize, "llm_pool_size": t.LLMPoolSize, "tts_pool_size": t.TTSPoolSize, "piper_processes": t.PiperProcesses}
+	for name, n := range pools {
+		if n <= 0 {
+			add("%s must be positive, got %d", name, n)
+		}
+	}
+	if b := t.AnthropicThinkingBudget; b != 0 && (b < 1024 || b >= t.LLMMaxTokens) {
+		add("anthropic_thinking_budget must be at least 1024 and under llm_max_tokens (%d), got %d", t.LLMMaxTokens, b)
+	}
+	for engine, p := range t.LLMParams {
+		if !slices.Contains(openAIEngines, engine) {
+			add("llm_params.%s: only %s take request parameters", engine, strings.Join(openAIEngines, ", "))
+		}
+		if err := p.Validate(); err != nil {
+			add("llm_params.%s: %v", engine, err)
+		}
+	}
+	if !audio.ValidResampleMode(t.Resampler) {
+		add("resampler must be %q or %q, got %q", audio.ResampleLinear, audio.ResamplePolyphase, t.Resampler)
+	}
+	if t.EmbeddingEngine != "ollama" && t.EmbeddingEngine != "onnx" {
+		add("embedding_engine must be \"ollama\" or \"onnx\", got %q", t.EmbeddingEngine)
+	}
+	if t.SemanticCache.Threshold > 0 && t.EmbeddingEngine == "ollama" && t.EmbeddingModel == "" {
+		add("semantic_cache is enabled but embedding_model is empty")
+	}
+	if t.SemanticCache.Threshold > 0 && t.EmbeddingEngine == "onnx" && c.embedURL == "" {
+		add("semantic_cache embeds with the onnx engine but EMBED_URL is unset")
+	}
+	if t.SemanticCache.Threshold > 1 {
+		add("semantic_cache.threshold is a cosine similarity and must be at most 1, got %g", t.SemanticCache.Threshold)
+	}
+	if t.Intent.Model != "" && len(t.Intent.Intents) == 0 {
+		add("intent.model is set but intent.intents is empty, so intent routing is off")
+	}
+	if t.Handoff.Enabled && t.Handoff.When == "" && len(t.Handoff.Intents) == 0 {
+		add("handoff is enabled but neither handoff.when nor handoff.intents can trigger it")
+	}
+	if t.WSPingIntervalMs > 0 && t.WSPongTimeoutMs > 0 && t.WSPongTimeoutMs <= t.WSPingIntervalMs {
+		add("ws_pong_timeout_ms (%d) must be longer than ws_ping_interval_ms (%d), or every client is dropped between pings", t.WSPongTimeoutMs, t.WSPingIntervalMs)
+	}
+	if t.ServiceAutoStartWaitS > 0 && t.WSPongTimeoutMs > 0 && t.ServiceAutoStartWaitS*1000 >= t.WSPongTimeoutMs {
+		add("service_autostart_wait_s (%d) must be below ws_pong_timeout_ms (%d), or a call can time out while its engine starts", t.ServiceAutoStartWaitS, t.WSPongTimeoutMs)
+	}
+	if t.WSSlowClientPolicy != ws.SlowClientDrop && t.WSSlowClientPolicy != ws.SlowClientClose {
+		add("ws_slow_client_policy must be %q or %q, got %q", ws.SlowClientDrop, ws.SlowClientClose, t.WSSlowClientPolicy)
+	}
+	policies := []string{orchestrator.AdmissionRefuse, orchestrator.AdmissionEvict, orchestrator.AdmissionPriority}
+	if p := t.VRAMAdmission.Policy; p != "" && !slices.Contains(policies, p) {
+		add("vram_admission.policy must be one of %s, got %q", strings.Join(policies, ", "), p)
+	}
+	for _, s := range t.VRAMAdmission.StagePriority {
+		if !slices.Contains(vramCategories, s) {
+			add("vram_admission.stage_priority: %q is not one of %s", s, strings.Join(vramCategories, ", "))
+		}
+	}
+	if t.TraceAudioDir != "" && c.postgresURL == "" {
+		add("trace_audio_dir archives traced runs but POSTGRES_URL is unset, so nothing is traced")
+	}
+	if t.CallSummary && c.postgresURL == "" {
+		add("call_summary stores summaries on trace sessions but POSTGRES_URL is unset, so nothing is traced")
+	}
+	slices.Sort(c.problems)
+}
+
+// fileKeys returns the top-level keys of a gateway.json, and those of them
+// no setting reads, such as typos or settings of removed features.
+func fileKeys(data []byte) (keys map[string]bool, unknown []string) {
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(data, &raw) != nil {
+		return nil, nil
+	}
+	known := map[string]bool{}
+	for _, f := range reflect.VisibleFields(reflect.TypeFor[tuning]()) {
+		known[settingName(f)] = true
+	}
+	keys = map[string]bool{}
+	for k := range raw {
+		keys[k] = true
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	slices.Sort(unknown)
+	return keys, unknown
+}
+
+func settingName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
+
+// tuningSettings lists every gateway.json setting with its resolved value,
+// marked by whether the file set it.
+func tuningSettings(t tuning, fileKeys map[string]bool) []setting {
+	var rows []setting
+	v := reflect.ValueOf(t)
+	for _, f := range reflect.VisibleFields(v.Type()) {
+		name := settingName(f)
+		value, _ := json.Marshal(v.FieldByIndex(f.Index).Interface())
+		source := "default"
+		if fileKeys[name] {
+			source = "gateway.json"
+		}
+		rows = append(rows, setting{name, string(value), source})
+	}
+	return rows
+}
+
+// printStartupTable writes the resolved configuration and dependency
+// status as aligned columns, for a person reading the startup output.
+func printStartupTable(w io.Writer, c *startupConfig, rep health.Report) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, s := range append(slices.Clone(c.env), tuningSettings(c.tuning, c.fileKeys)...) {
+		value := s.value
+		if len(value) > tableValueMax {
+			value = value[:tableValueMax-3] + "..."
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.name, value, s.source)
+	}
+	fmt.Fprintln(tw, "\nDEPENDENCY\tSTATUS\tDETAIL")
+	for _, r := range rep.Checks {
+		detail := r.Version
+		if r.Error != "" {
+			detail = r.Error
+		}
+		status := r.Status
+		if r.Critical {
+			status += " (critical)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Name, status, detail)
+	}
+	tw.Flush()
+}
+
+// logDependencies logs every dependency that didn't answer the startup
+// probe. A backend that is down isn't fatal: it may come up after the
+// gateway, or be started on demand.
+func logDependencies(rep health.Report) {
+	for _, r := range rep.Checks {
+		if r.Status == health.StatusUp {
+			continue
+		}
+		if r.Critical {
+			slog.Error("critical dependency down", "name", r.Name, "error", r.Error)
+			continue
+		}
+		slog.Warn("dependency down", "name", r.Name, "error", r.Error)
+	}
+}
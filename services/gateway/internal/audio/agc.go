@@ -0,0 +1,87 @@
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// DefaultAGCTargetDB is the RMS level speech is normalized to.
+	DefaultAGCTargetDB = -20.0
+
+	agcMaxGainDB = 30.0  // loudest boost applied to quiet callers
+	agcMinGainDB = -10.0 // strongest cut applied to hot microphones
+	agcGateDB    = -55.0 // chunks below this are treated as silence, not speech
+
+	agcAttack  = 20 * time.Millisecond  // how fast gain drops when the level jumps
+	agcRelease = 400 * time.Millisecond // how fast gain rises toward the target
+	agcDecay   = 2 * time.Second        // how fast gain returns to unity in silence
+
+	// agcLimitKnee is where the soft limiter starts compressing peaks; output
+	// approaches but never reaches full scale.
+	agcLimitKnee = 0.9
+)
+
+// AGC is an automatic gain control stage: it raises quiet speech toward a
+// target RMS and soft-limits peaks so boosted audio doesn't clip. Gain
+// changes are smoothed across chunks, and during silence the gain eases back
+// to unity so background noise isn't lifted over the VAD threshold.
+type AGC struct {
+	sampleRate int
+	targetDB   float64
+	gainDB     float64
+}
+
+// NewAGC creates a gain control for audio at sampleRate. A targetDB outside
+// (-60, 0) dBFS, including the zero value, selects DefaultAGCTargetDB.
+func NewAGC(sampleRate int, targetDB float64) *AGC {
+	if targetDB >= 0 || targetDB <= -60 {
+		targetDB = DefaultAGCTargetDB
+	}
+	return &AGC{sampleRate: sampleRate, targetDB: targetDB}
+}
+
+// Process applies gain to the chunk in place and returns it.
+func (a *AGC) Process(samples []float32) []float32 {
+	if len(samples) == 0 {
+		return samples
+	}
+	dur := time.Duration(len(samples)) * time.Second / time.Duration(a.sampleRate)
+	level := computeEnergyDB(samples)
+
+	desired, tau := 0.0, agcDecay
+	if level > agcGateDB {
+		desired = math.Max(agcMinGainDB, math.Min(agcMaxGainDB, a.targetDB-level))
+		tau = agcRelease
+		if desired < a.gainDB {
+			tau = agcAttack
+		}
+	}
+
+	prev := dbToGain(a.gainDB)
+	a.gainDB += (desired - a.gainDB) * (1 - math.Exp(-dur.Seconds()/tau.Seconds()))
+	next := dbToGain(a.gainDB)
+
+	// ramp linearly from the previous gain to avoid zipper noise at chunk edges
+	step := (next - prev) / float64(len(samples))
+	for i, s := range samples {
+		samples[i] = softLimit(float64(s) * (prev + step*float64(i+1)))
+	}
+	return samples
+}
+
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// softLimit passes samples below the knee unchanged and compresses anything
+// above it smoothly into the remaining headroom.
+func softLimit(x float64) float32 {
+	mag := math.Abs(x)
+	if mag <= agcLimitKnee {
+		return float32(x)
+	}
+	headroom := 1 - agcLimitKnee
+	limited := agcLimitKnee + headroom*math.Tanh((mag-agcLimitKnee)/headroom)
+	return float32(math.Copysign(limited, x))
+}
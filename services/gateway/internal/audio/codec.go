@@ -8,26 +8,53 @@ const (
 	CodecPCM      Codec = "pcm"
 	CodecG711Ulaw Codec = "g711_ulaw"
 	CodecG711Alaw Codec = "g711_alaw"
+	CodecG722     Codec = "g722"     // 64 kbit/s wideband telephony, 16 kHz
+	CodecOpusRTP  Codec = "opus_rtp" // one RTP packet per frame carrying Opus
+	// CodecFile is a snippet sent as one audio file's bytes (WAV, MP3,
+	// Ogg, ...), decoded whole by DecodeFile rather than frame by frame.
+	CodecFile Codec = "file"
 )
 
-// decoder holds a codec's decode function and its fixed output sample rate.
-// A rate of 0 means "use the caller-supplied sampleRate" (e.g. PCM passthrough).
+// decoder holds a codec's decode function, which appends to its first
+// argument, and its fixed output sample rate. A rate of 0 means "use the
+// caller-supplied sampleRate" (e.g. PCM passthrough).
 type decoder struct {
-	fn   func([]byte) []float32
+	fn   func([]float32, []byte) []float32
 	rate int
 }
 
 // decoders maps each supported codec to its decode function and output sample rate.
 var decoders = map[Codec]decoder{
-	CodecPCM:      {fn: decodePCM, rate: 0},
-	CodecG711Ulaw: {fn: decodeG711Ulaw, rate: 8000},
-	CodecG711Alaw: {fn: decodeG711Alaw, rate: 8000},
+	CodecPCM:      {fn: appendPCM, rate: 0},
+	CodecG711Ulaw: {fn: appendG711Ulaw, rate: 8000},
+	CodecG711Alaw: {fn: appendG711Alaw, rate: 8000},
+	CodecG722:     {fn: func(dst []float32, b []byte) []float32 { return newG722Decoder().process(dst, b) }, rate: 16000},
+}
+
+// stream is a codec that carries state from one chunk to the next. decode
+// appends to dst.
+type stream interface {
+	decode(dst []float32, data []byte) ([]float32, error)
+	close()
+}
+
+// streams maps each stateful codec to a constructor and its output sample rate.
+var streams = map[Codec]struct {
+	open func() (stream, error)
+	rate int
+}{
+	CodecG722:    {open: func() (stream, error) { return newG722Decoder(), nil }, rate: 16000},
+	CodecOpusRTP: {open: func() (stream, error) { return newOpusRTPDecoder() }, rate: opusRate},
 }
 
 // Decode converts encoded audio bytes to float32 PCM samples normalized to [-1, 1].
-// Returns samples and the sample rate.
+// Returns samples and the sample rate. Stateful codecs start from a fresh
+// state; a call's audio goes through a Decoder instead.
 func Decode(data []byte, codec Codec, sampleRate int) ([]float32, int, error) {
 	dec, ok := decoders[codec]
+	if !ok && streams[codec].open != nil {
+		return nil, 0, fmt.Errorf("codec %s needs a Decoder", codec)
+	}
 	if !ok {
 		return nil, 0, fmt.Errorf("unsupported codec: %s", codec)
 	}
@@ -35,5 +62,64 @@ func Decode(data []byte, codec Codec, sampleRate int) ([]float32, int, error) {
 	if rate == 0 {
 		rate = sampleRate
 	}
-	return dec.fn(data), rate, nil
+	return dec.fn(nil, data), rate, nil
+}
+
+// Decoder decodes one call's audio in a single codec, keeping the state
+// that G.722 prediction and Opus-in-RTP sequencing carry between chunks.
+type Decoder struct {
+	codec      Codec
+	sampleRate int
+	stream     stream // nil for stateless codecs
+}
+
+// NewDecoder creates a decoder for codec. sampleRate is the rate of PCM
+// input; the other codecs have a fixed rate. Opus-in-RTP needs ffmpeg on
+// the PATH.
+func NewDecoder(codec Codec, sampleRate int) (*Decoder, error) {
+	d := &Decoder{codec: codec, sampleRate: sampleRate}
+	s, ok := streams[codec]
+	if !ok {
+		if _, ok = decoders[codec]; !ok {
+			return nil, fmt.Errorf("unsupported codec: %s", codec)
+		}
+		return d, nil
+	}
+	st, err := s.open()
+	if err != nil {
+		return nil, fmt.Errorf("%s decoder: %w", codec, err)
+	}
+	d.stream = st
+	return d, nil
+}
+
+// Codec is the codec d decodes.
+func (d *Decoder) Codec() Codec { return d.codec }
+
+// Decode converts one chunk (for Opus-in-RTP, one RTP packet) to float32
+// PCM samples and returns them with their sample rate.
+func (d *Decoder) Decode(data []byte) ([]float32, int, error) {
+	return d.Append(nil, data)
+}
+
+// Append is Decode appending the samples to dst, so a caller can decode
+// into a buffer from GetSamples.
+func (d *Decoder) Append(dst []float32, data []byte) ([]float32, int, error) {
+	if d.stream == nil {
+		dec := decoders[d.codec]
+		rate := dec.rate
+		if rate == 0 {
+			rate = d.sampleRate
+		}
+		return dec.fn(dst, data), rate, nil
+	}
+	samples, err := d.stream.decode(dst, data)
+	return samples, streams[d.codec].rate, err
+}
+
+// Close releases the decoder, stopping its ffmpeg process if it has one.
+func (d *Decoder) Close() {
+	if d.stream != nil {
+		d.stream.close()
+	}
 }
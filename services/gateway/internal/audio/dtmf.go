@@ -0,0 +1,156 @@
+package audio
+
+import "math"
+
+const (
+	// dtmfBlockDuration is the Goertzel analysis window. 25.6 ms (205 samples
+	// at 8 kHz, the classic choice) resolves the 73 Hz spacing of DTMF rows.
+	dtmfBlockDuration = 0.0256
+
+	// dtmfConfirmBlocks is how many consecutive blocks must agree before a
+	// digit is reported (~50 ms, the ITU minimum tone duration).
+	dtmfConfirmBlocks = 2
+
+	// dtmfMinEnergyDB ignores blocks too quiet to carry a keypad tone.
+	dtmfMinEnergyDB = -45
+
+	// dtmfMinToneRatio is the share of block energy the two tones must hold.
+	// A clean dual tone scores 0.5 and one 1.5% off frequency (the ITU
+	// acceptance limit) about 0.25; speech spreads energy and scores far lower.
+	dtmfMinToneRatio = 0.15
+
+	// Twist limits bound the power ratio between the row and column tones
+	// (8 dB normal / 4 dB reverse twist, per ITU Q.24).
+	dtmfMaxNormalTwist  = 6.31 // 8 dB: row tone stronger
+	dtmfMaxReverseTwist = 2.51 // 4 dB: column tone stronger
+)
+
+var (
+	dtmfRows = [4]float64{697, 770, 852, 941}
+	dtmfCols = [4]float64{1209, 1336, 1477, 1633}
+	dtmfKeys = [4][4]byte{
+		{'1', '2', '3', 'A'},
+		{'4', '5', '6', 'B'},
+		{'7', '8', '9', 'C'},
+		{'*', '0', '#', 'D'},
+	}
+)
+
+// DTMFDetector finds in-band keypad tones with the Goertzel algorithm.
+// Feed it audio in arbitrary chunk sizes; each key press is reported once,
+// when the tone has been held for dtmfConfirmBlocks windows.
+type DTMFDetector struct {
+	sampleRate int
+	blockSize  int
+	rowCoeffs  [4]float64
+	colCoeffs  [4]float64
+
+	pending  []float32
+	last     byte // digit in the previous block (0 = none)
+	run      int  // consecutive blocks with last
+	reported bool // last has already been reported
+}
+
+// NewDTMFDetector creates a detector for audio at sampleRate.
+func NewDTMFDetector(sampleRate int) *DTMFDetector {
+	d := &DTMFDetector{
+		sampleRate: sampleRate,
+		blockSize:  int(float64(sampleRate) * dtmfBlockDuration),
+	}
+	for i := range 4 {
+		d.rowCoeffs[i] = goertzelCoeff(dtmfRows[i], sampleRate)
+		d.colCoeffs[i] = goertzelCoeff(dtmfCols[i], sampleRate)
+	}
+	return d
+}
+
+// Process analyzes a chunk and returns newly pressed digits, plus whether a
+// tone was present in the chunk (so callers can keep it away from ASR).
+func (d *DTMFDetector) Process(samples []float32) ([]byte, bool) {
+	d.pending = append(d.pending, samples...)
+	var digits []byte
+	toneSeen := false
+	for len(d.pending) >= d.blockSize {
+		digit := d.detectBlock(d.pending[:d.blockSize])
+		d.pending = d.pending[d.blockSize:]
+		toneSeen = toneSeen || digit != 0
+		if key, ok := d.debounce(digit); ok {
+			digits = append(digits, key)
+		}
+	}
+	// keep the remainder from pinning a large backing array
+	d.pending = append([]float32(nil), d.pending...)
+	return digits, toneSeen
+}
+
+// debounce tracks consecutive detections and reports a digit once per press.
+func (d *DTMFDetector) debounce(digit byte) (byte, bool) {
+	if digit != d.last {
+		d.last, d.run, d.reported = digit, 0, false
+	}
+	if digit == 0 {
+		return 0, false
+	}
+	d.run++
+	if d.run < dtmfConfirmBlocks || d.reported {
+		return 0, false
+	}
+	d.reported = true
+	return digit, true
+}
+
+// detectBlock returns the digit keyed in one analysis window, or 0.
+func (d *DTMFDetector) detectBlock(block []float32) byte {
+	if computeEnergyDB(block) < dtmfMinEnergyDB {
+		return 0
+	}
+	row, rowPower, rowSecond := strongest(block, d.rowCoeffs)
+	col, colPower, colSecond := strongest(block, d.colCoeffs)
+
+	energy := 0.0
+	for _, s := range block {
+		energy += float64(s) * float64(s)
+	}
+	// Goertzel power of a pure tone is (A·N/2)²; normalizing by N·energy puts
+	// a clean dual tone at 0.5 regardless of level.
+	if (rowPower+colPower)/(float64(len(block))*energy) < dtmfMinToneRatio {
+		return 0
+	}
+	if rowPower > colPower*dtmfMaxNormalTwist || colPower > rowPower*dtmfMaxReverseTwist {
+		return 0
+	}
+	// the winning tone must clearly dominate its group
+	if rowSecond*dtmfMaxNormalTwist > rowPower || colSecond*dtmfMaxNormalTwist > colPower {
+		return 0
+	}
+	return dtmfKeys[row][col]
+}
+
+// strongest returns the index and power of the strongest of four tones and
+// the power of the runner-up.
+func strongest(block []float32, coeffs [4]float64) (int, float64, float64) {
+	best, bestPower, second := 0, 0.0, 0.0
+	for i, c := range coeffs {
+		p := goertzelPower(block, c)
+		if p > bestPower {
+			best, bestPower, second = i, p, bestPower
+			continue
+		}
+		second = math.Max(second, p)
+	}
+	return best, bestPower, second
+}
+
+func goertzelCoeff(freq float64, sampleRate int) float64 {
+	return 2 * math.Cos(2*math.Pi*freq/float64(sampleRate))
+}
+
+// goertzelPower returns the squared magnitude of one DFT bin.
+func goertzelPower(block []float32, coeff float64) float64 {
+	var s1, s2 float64
+	for _, x := range block {
+		s0 := float64(x) + coeff*s1 - s2
+		s2, s1 = s1, s0
+	}
+	return s1*s1 + s2*s2 - coeff*s1*s2
+}
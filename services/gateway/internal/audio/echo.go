@@ -0,0 +1,183 @@
+package audio
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// echoDecimation downsamples mic and reference audio before correlation.
+	// Speech energy sits well below 2 kHz, so 16 kHz / 4 keeps enough signal
+	// while cutting the correlation cost 16x.
+	echoDecimation = 4
+
+	// echoMaxDelay is the largest speaker→mic delay searched for: client
+	// playback buffering plus acoustic path.
+	echoMaxDelay = 300 * time.Millisecond
+
+	// defaultEchoThreshold is the normalized cross-correlation above which a
+	// mic chunk is treated as the agent's own playback.
+	defaultEchoThreshold = 0.45
+
+	// echoMinEnergyDB skips correlation for chunks that are already silent.
+	echoMinEnergyDB = -60
+)
+
+// playback is one TTS clip placed on the playback timeline.
+type playback struct {
+	start   time.Time
+	samples []float32 // decimated
+}
+
+func (pb playback) end(rate int) time.Time {
+	return pb.start.Add(time.Duration(len(pb.samples)) * time.Second / time.Duration(rate))
+}
+
+// EchoSuppressor detects mic audio that is the client's speakers replaying
+// TTS output. Sent TTS clips are laid out back-to-back on a playback
+// timeline; each mic chunk is mapped to its capture time (from the mic
+// sample count, so backlogged chunks processed late still line up) and
+// correlated against the reference audio playing at that moment. Chunks
+// that match are silenced before VAD so the agent can't trigger itself.
+// Safe for concurrent use: TTS is registered from the pipeline's TTS
+// goroutine while mic chunks arrive on the read loop.
+type EchoSuppressor struct {
+	mu         sync.Mutex
+	sampleRate int // decimated rate
+	threshold  float64
+
+	micStart   time.Time // wall time of the first mic sample
+	micSamples int64     // mic samples seen, at the full rate
+
+	refs    []playback
+	playEnd time.Time
+}
+
+// NewEchoSuppressor creates a suppressor for mic audio at sampleRate.
+func NewEchoSuppressor(sampleRate int) *EchoSuppressor {
+	return &EchoSuppressor{
+		sampleRate: sampleRate / echoDecimation,
+		threshold:  defaultEchoThreshold,
+	}
+}
+
+// AddPlayback registers TTS audio (at the mic sample rate) sent to the client
+// at now. Clips queue behind any playback still in progress.
+func (e *EchoSuppressor) AddPlayback(samples []float32, now time.Time) {
+	if len(samples) == 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	start := now
+	if e.playEnd.After(start) {
+		start = e.playEnd
+	}
+	pb := playback{start: start, samples: decimate(samples)}
+	e.refs = append(e.refs, pb)
+	e.playEnd = pb.end(e.sampleRate)
+}
+
+// Process returns the chunk, zeroed in place if it correlates with recent
+// playback, and whether it was classified as echo.
+func (e *EchoSuppressor) Process(chunk []float32, now time.Time) ([]float32, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.micStart.IsZero() {
+		e.micStart = now
+	}
+	fullRate := e.sampleRate * echoDecimation
+	captured := e.micStart.Add(time.Duration(e.micSamples) * time.Second / time.Duration(fullRate))
+	e.micSamples += int64(len(chunk))
+	e.pruneLocked(captured)
+
+	if len(e.refs) == 0 || computeEnergyDB(chunk) < echoMinEnergyDB {
+		return chunk, false
+	}
+	if e.maxCorrelationLocked(decimate(chunk), captured) < e.threshold {
+		return chunk, false
+	}
+	clear(chunk)
+	return chunk, true
+}
+
+// maxCorrelationLocked searches the reference audio that was playing
+// between captured-echoMaxDelay and captured for the best match.
+func (e *EchoSuppressor) maxCorrelationLocked(mic []float32, captured time.Time) float64 {
+	best := 0.0
+	windowStart := captured.Add(-echoMaxDelay)
+	for _, pb := range e.refs {
+		if pb.end(e.sampleRate).Before(windowStart) || pb.start.After(captured) {
+			continue
+		}
+		lo := e.offset(pb, windowStart)
+		hi := e.offset(pb, captured)
+		best = math.Max(best, bestNCC(mic, pb.samples, lo, hi))
+	}
+	return best
+}
+
+// offset converts a wall time to a sample index into the clip (may be
+// negative or past the end; bestNCC clamps).
+func (e *EchoSuppressor) offset(pb playback, t time.Time) int {
+	return int(t.Sub(pb.start).Seconds() * float64(e.sampleRate))
+}
+
+// pruneLocked drops clips that finished before the oldest delay we search.
+func (e *EchoSuppressor) pruneLocked(captured time.Time) {
+	cutoff := captured.Add(-echoMaxDelay)
+	keep := e.refs[:0]
+	for _, pb := range e.refs {
+		if pb.end(e.sampleRate).After(cutoff) {
+			keep = append(keep, pb)
+		}
+	}
+	e.refs = keep
+}
+
+// bestNCC returns the maximum normalized cross-correlation of mic against
+// ref[lag:lag+len(mic)] for lag in [lo, hi].
+func bestNCC(mic, ref []float32, lo, hi int) float64 {
+	lo = max(lo, 0)
+	hi = min(hi, len(ref)-len(mic))
+	micEnergy := dot(mic, mic)
+	if micEnergy == 0 {
+		return 0
+	}
+	best := 0.0
+	for lag := lo; lag <= hi; lag++ {
+		seg := ref[lag : lag+len(mic)]
+		refEnergy := dot(seg, seg)
+		if refEnergy == 0 {
+			continue
+		}
+		ncc := math.Abs(dot(mic, seg)) / math.Sqrt(micEnergy*refEnergy)
+		best = math.Max(best, ncc)
+	}
+	return best
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// decimate averages groups of echoDecimation samples (a crude low-pass
+// adequate for correlation, not for listening).
+func decimate(samples []float32) []float32 {
+	out := make([]float32, len(samples)/echoDecimation)
+	for i := range out {
+		var sum float32
+		for j := range echoDecimation {
+			sum += samples[i*echoDecimation+j]
+		}
+		out[i] = sum / echoDecimation
+	}
+	return out
+}
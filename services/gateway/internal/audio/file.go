@@ -0,0 +1,49 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+)
+
+// IsAudioFile sniffs the start of an upload for an audio container: WAV,
+// Ogg, FLAC, MP4/M4A, or MP3 (an ID3 tag or a valid frame header). Raw
+// PCM is what's left.
+func IsAudioFile(data []byte) bool {
+	if len(data) < 12 {
+		return false
+	}
+	for _, magic := range []string{"RIFF", "OggS", "fLaC", "ID3"} {
+		if bytes.HasPrefix(data, []byte(magic)) {
+			return true
+		}
+	}
+	return string(data[4:8]) == "ftyp" || mp3Frame(binary.BigEndian.Uint32(data))
+}
+
+// mp3Frame reports whether h is a plausible MPEG audio frame header. Every
+// field is checked, not just the sync word, since raw PCM can start with
+// the sync bits too.
+func mp3Frame(h uint32) bool {
+	sync := h>>21 == 0x7FF
+	version := h >> 19 & 3 // 1 is reserved
+	layer := h >> 17 & 3   // 0 is reserved
+	bitrate := h >> 12 & 0xF
+	rate := h >> 10 & 3
+	return sync && version != 1 && layer != 0 && bitrate != 0 && bitrate != 0xF && rate != 3
+}
+
+// DecodeFile decodes an uploaded audio file and returns its samples, still
+// interleaved, with the channel count and sample rate. 16-bit PCM WAV is
+// parsed directly. Every other container, and WAV in other sample formats,
+// goes through ffmpeg, which must be on the PATH.
+func DecodeFile(ctx context.Context, data []byte) ([]float32, int, int, error) {
+	if samples, channels, rate, err := parseWAV(data); err == nil {
+		return samples, channels, rate, nil
+	}
+	wav, err := ffmpeg(ctx, data, OutputWAV)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return parseWAV(wav)
+}
@@ -0,0 +1,76 @@
+package audio
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// MaxFrameDuration is the most audio one streamed frame may carry. Clients
+// send 20-100 ms chunks; a frame of seconds is a bug, and would make the
+// VAD judge seconds of audio as one energy reading.
+const MaxFrameDuration = time.Second
+
+// SampleRates are the PCM input rates a session may declare.
+var SampleRates = []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000}
+
+// FrameError is streamed audio that doesn't match its session's declared
+// format, with a machine-readable code for the client.
+type FrameError struct {
+	Code string // e.g. "frame_too_large"
+	Msg  string
+}
+
+func (e *FrameError) Error() string { return e.Msg }
+
+func frameErr(code, format string, args ...any) *FrameError {
+	return &FrameError{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+// CheckFormat reports a declared codec or PCM sample rate that streamed
+// audio can't be decoded with.
+func CheckFormat(codec Codec, sampleRate int) error {
+	_, stateless := decoders[codec]
+	if _, stateful := streams[codec]; !stateless && !stateful {
+		return frameErr("unsupported_codec", "unsupported codec %q", codec)
+	}
+	if codec == CodecPCM && !slices.Contains(SampleRates, sampleRate) {
+		return frameErr("unsupported_sample_rate", "pcm sample rate %d is not one of %v", sampleRate, SampleRates)
+	}
+	return nil
+}
+
+// FrameDuration is how much audio a frame of codec carries, or 0 when only
+// decoding can tell (Opus).
+func FrameDuration(data []byte, codec Codec, sampleRate int) time.Duration {
+	bytesPerSec := map[Codec]int{
+		CodecPCM:      2 * sampleRate,
+		CodecG711Ulaw: 8000,
+		CodecG711Alaw: 8000,
+		CodecG722:     8000,
+	}[codec]
+	if bytesPerSec == 0 {
+		return 0
+	}
+	return time.Duration(len(data)) * time.Second / time.Duration(bytesPerSec)
+}
+
+// CheckFrame validates one streamed frame against its session's codec: it
+// must hold whole samples (or an RTP packet) and at most MaxFrameDuration.
+func CheckFrame(data []byte, codec Codec, sampleRate int) error {
+	if len(data) == 0 {
+		return frameErr("frame_empty", "empty audio frame")
+	}
+	if codec == CodecPCM && len(data)%2 != 0 {
+		return frameErr("frame_misaligned", "%d byte pcm frame is not whole 16-bit samples", len(data))
+	}
+	if codec == CodecOpusRTP {
+		if _, err := ParseRTP(data); err != nil {
+			return frameErr("frame_invalid_rtp", "%v", err)
+		}
+	}
+	if d := FrameDuration(data, codec, sampleRate); d > MaxFrameDuration {
+		return frameErr("frame_too_large", "%d byte frame carries %s of audio, over the %s limit", len(data), d, MaxFrameDuration)
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package audio
 
-import "math"
+import (
+	"math"
+	"slices"
+)
 
 var ulawTable [256]int16
 var alawTable [256]int16
@@ -41,18 +44,18 @@ func decodeAlawSample(b byte) int16 {
 	return sign * ((mantissa<<4 + 0x108) << (exponent - 1))
 }
 
-func decodeG711Ulaw(data []byte) []float32 {
-	samples := make([]float32, len(data))
-	for i, b := range data {
-		samples[i] = float32(ulawTable[b]) / math.MaxInt16
+func appendG711Ulaw(dst []float32, data []byte) []float32 {
+	dst = slices.Grow(dst, len(data))
+	for _, b := range data {
+		dst = append(dst, float32(ulawTable[b])/math.MaxInt16)
 	}
-	return samples
+	return dst
 }
 
-func decodeG711Alaw(data []byte) []float32 {
-	samples := make([]float32, len(data))
-	for i, b := range data {
-		samples[i] = float32(alawTable[b]) / math.MaxInt16
+func appendG711Alaw(dst []float32, data []byte) []float32 {
+	dst = slices.Grow(dst, len(data))
+	for _, b := range data {
+		dst = append(dst, float32(alawTable[b])/math.MaxInt16)
 	}
-	return samples
+	return dst
 }
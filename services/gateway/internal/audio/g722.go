@@ -0,0 +1,194 @@
+package audio
+
+import (
+	"math"
+	"slices"
+)
+
+// G.722 (ITU-T G.722 sub-band ADPCM) at 64 kbit/s: each byte carries a
+// 6-bit low-band and a 2-bit high-band code for one pair of 16 kHz output
+// samples. The tables and fixed-point arithmetic follow the ITU reference.
+
+var (
+	g722WL  = [8]int{-60, -30, 58, 172, 334, 538, 1198, 3042}
+	g722RL4 = [16]int{0, 7, 6, 5, 4, 3, 2, 1, 7, 6, 5, 4, 3, 2, 1, 0}
+	g722ILB = [32]int{
+		2048, 2093, 2139, 2186, 2233, 2282, 2332, 2383,
+		2435, 2489, 2543, 2599, 2656, 2714, 2774, 2834,
+		2896, 2960, 3025, 3091, 3158, 3228, 3298, 3371,
+		3444, 3520, 3597, 3676, 3756, 3838, 3922, 4008,
+	}
+	g722WH  = [3]int{0, -214, 798}
+	g722RH2 = [4]int{2, 1, 2, 1}
+	g722QM2 = [4]int{-7408, -1616, 7408, 1616}
+	g722QM4 = [16]int{
+		0, -20456, -12896, -8968, -6288, -4240, -2584, -1200,
+		20456, 12896, 8968, 6288, 4240, 2584, 1200, 0,
+	}
+	g722QM6 = [64]int{
+		-136, -136, -136, -136, -24808, -21904, -19008, -16704,
+		-14984, -13512, -12280, -11192, -10232, -9360, -8576, -7856,
+		-7192, -6576, -6000, -5456, -4944, -4464, -4008, -3576,
+		-3168, -2776, -2400, -2032, -1688, -1360, -1040, -728,
+		24808, 21904, 19008, 16704, 14984, 13512, 12280, 11192,
+		10232, 9360, 8576, 7856, 7192, 6576, 6000, 5456,
+		4944, 4464, 4008, 3576, 3168, 2776, 2400, 2032,
+		1688, 1360, 1040, 728, 432, 136,
+	}
+	// g722QMF is the receive quadrature mirror filter that recombines the bands.
+	g722QMF = [12]int{3, -11, 12, 32, -210, 951, 3876, -805, 362, -156, 53, -11}
+)
+
+// g722Band is the adaptive predictor and quantizer state of one sub-band.
+type g722Band struct {
+	s, sp, sz int
+	r, p      [3]int
+	a, ap     [3]int
+	b, bp, d  [7]int
+	sg        [7]int
+	nb, det   int
+}
+
+// g722Decoder keeps the predictor state across chunks; G.722 is adaptive,
+// so decoding each chunk from a fresh state would click at every boundary.
+type g722Decoder struct {
+	band [2]g722Band // low, high
+	x    [24]int     // QMF delay line
+}
+
+func newG722Decoder() *g722Decoder {
+	d := &g722Decoder{}
+	d.band[0].det = 32
+	d.band[1].det = 8
+	return d
+}
+
+func (d *g722Decoder) decode(dst []float32, data []byte) ([]float32, error) {
+	return d.process(dst, data), nil
+}
+
+func (d *g722Decoder) close() {}
+
+func (d *g722Decoder) process(dst []float32, data []byte) []float32 {
+	out := slices.Grow(dst, len(data)*2)
+	lo, hi := &d.band[0], &d.band[1]
+	for _, code := range data {
+		ilow := int(code & 0x3F)
+		ihigh := int(code>>6) & 0x03
+
+		// low band: inverse quantize, then adapt the scale factor
+		rlow := clampInt(lo.s+(lo.det*g722QM6[ilow])>>15, -16384, 16383)
+		ilow >>= 2
+		dlow := (lo.det * g722QM4[ilow]) >> 15
+		lo.nb = clampInt((lo.nb*127)>>7+g722WL[g722RL4[ilow]], 0, 18432)
+		lo.det = g722Scale(lo.nb, 8)
+		lo.adapt(dlow)
+
+		// high band
+		dhigh := (hi.det * g722QM2[ihigh]) >> 15
+		rhigh := clampInt(hi.s+dhigh, -16384, 16383)
+		hi.nb = clampInt((hi.nb*127)>>7+g722WH[g722RH2[ihigh]], 0, 22528)
+		hi.det = g722Scale(hi.nb, 10)
+		hi.adapt(dhigh)
+
+		copy(d.x[:], d.x[2:])
+		d.x[22] = rlow + rhigh
+		d.x[23] = rlow - rhigh
+		var out1, out2 int
+		for i := range 12 {
+			out2 += d.x[2*i] * g722QMF[i]
+			out1 += d.x[2*i+1] * g722QMF[11-i]
+		}
+		out = append(out,
+			float32(saturate16(out1>>11))/math.MaxInt16,
+			float32(saturate16(out2>>11))/math.MaxInt16)
+	}
+	return out
+}
+
+// g722Scale turns a log scale factor into the linear quantizer step.
+func g722Scale(nb, bias int) int {
+	shift := bias - nb>>11
+	v := g722ILB[(nb>>6)&31]
+	if shift < 0 {
+		return (v << -shift) << 2
+	}
+	return (v >> shift) << 2
+}
+
+// adapt reconstructs the band's signal from the quantized difference d and
+// updates its pole-zero predictor.
+func (b *g722Band) adapt(d int) {
+	b.d[0] = d
+	b.r[0] = saturate16(b.s + d)
+	b.p[0] = saturate16(b.sz + d)
+
+	// second pole
+	for i := range 3 {
+		b.sg[i] = b.p[i] >> 15
+	}
+	wd1 := saturate16(b.a[1] << 2)
+	wd2 := wd1
+	if b.sg[0] == b.sg[1] {
+		wd2 = -wd1
+	}
+	wd2 = min(wd2, 32767)
+	wd3 := -128
+	if b.sg[0] == b.sg[2] {
+		wd3 = 128
+	}
+	wd3 += wd2 >> 7
+	wd3 += (b.a[2] * 32512) >> 15
+	b.ap[2] = clampInt(wd3, -12288, 12288)
+
+	// first pole
+	wd1 = -192
+	if b.sg[0] == b.sg[1] {
+		wd1 = 192
+	}
+	b.ap[1] = saturate16(wd1 + (b.a[1]*32640)>>15)
+	limit := saturate16(15360 - b.ap[2])
+	b.ap[1] = clampInt(b.ap[1], -limit, limit)
+
+	// zeros
+	wd1 = 128
+	if d == 0 {
+		wd1 = 0
+	}
+	b.sg[0] = d >> 15
+	for i := 1; i < 7; i++ {
+		b.sg[i] = b.d[i] >> 15
+		wd2 := -wd1
+		if b.sg[i] == b.sg[0] {
+			wd2 = wd1
+		}
+		b.bp[i] = saturate16(wd2 + (b.b[i]*32640)>>15)
+	}
+
+	for i := 6; i > 0; i-- {
+		b.d[i] = b.d[i-1]
+		b.b[i] = b.bp[i]
+	}
+	for i := 2; i > 0; i-- {
+		b.r[i] = b.r[i-1]
+		b.p[i] = b.p[i-1]
+		b.a[i] = b.ap[i]
+	}
+
+	// predict the next sample
+	b.sp = saturate16((b.a[1]*saturate16(b.r[1]+b.r[1]))>>15 + (b.a[2]*saturate16(b.r[2]+b.r[2]))>>15)
+	b.sz = 0
+	for i := 6; i > 0; i-- {
+		b.sz += (b.b[i] * saturate16(b.d[i]+b.d[i])) >> 15
+	}
+	b.sz = saturate16(b.sz)
+	b.s = saturate16(b.sp + b.sz)
+}
+
+func saturate16(v int) int {
+	return clampInt(v, math.MinInt16, math.MaxInt16)
+}
+
+func clampInt(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
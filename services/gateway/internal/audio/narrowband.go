@@ -0,0 +1,78 @@
+package audio
+
+import "math"
+
+const (
+	narrowbandRate   = 8000
+	narrowbandLowHz  = 300
+	narrowbandHighHz = 3400
+)
+
+// butterworthQ4 are the section Qs of a 4th-order Butterworth filter built
+// from two cascaded biquads (24 dB/octave roll-off at each band edge).
+var butterworthQ4 = [2]float64{0.5412, 1.3066}
+
+// Narrowband simulates a telephone channel: audio is taken down to 8 kHz,
+// band-limited to 300–3400 Hz, and brought back to the input rate so the
+// rest of the pipeline sees call-center conditions. Filter state carries
+// across chunks, so feed it one session's audio in order.
+type Narrowband struct {
+	sampleRate int
+	sections   []biquad
+}
+
+// NewNarrowband creates a telephone-channel simulator for audio at sampleRate.
+func NewNarrowband(sampleRate int) *Narrowband {
+	n := &Narrowband{sampleRate: sampleRate}
+	for _, q := range butterworthQ4 {
+		n.sections = append(n.sections,
+			newHighPass(narrowbandLowHz, q, narrowbandRate),
+			newLowPass(narrowbandHighHz, q, narrowbandRate),
+		)
+	}
+	return n
+}
+
+// Process returns the chunk as it would sound after a narrowband call path.
+func (n *Narrowband) Process(samples []float32) []float32 {
+	down := Resample(samples, n.sampleRate, narrowbandRate)
+	for i := range n.sections {
+		n.sections[i].process(down)
+	}
+	return Resample(down, narrowbandRate, n.sampleRate)
+}
+
+// biquad is a direct-form-I second-order IIR section (RBJ cookbook).
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func newLowPass(freq, q float64, sampleRate int) biquad {
+	w := 2 * math.Pi * freq / float64(sampleRate)
+	alpha := math.Sin(w) / (2 * q)
+	c := math.Cos(w)
+	return normalizeBiquad((1-c)/2, 1-c, (1-c)/2, 1+alpha, -2*c, 1-alpha)
+}
+
+func newHighPass(freq, q float64, sampleRate int) biquad {
+	w := 2 * math.Pi * freq / float64(sampleRate)
+	alpha := math.Sin(w) / (2 * q)
+	c := math.Cos(w)
+	return normalizeBiquad((1+c)/2, -(1 + c), (1+c)/2, 1+alpha, -2*c, 1-alpha)
+}
+
+func normalizeBiquad(b0, b1, b2, a0, a1, a2 float64) biquad {
+	return biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+}
+
+// process filters samples in place.
+func (f *biquad) process(samples []float32) {
+	for i, s := range samples {
+		x := float64(s)
+		y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+		f.x2, f.x1 = f.x1, x
+		f.y2, f.y1 = f.y1, y
+		samples[i] = float32(y)
+	}
+}
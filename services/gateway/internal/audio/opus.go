@@ -0,0 +1,214 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// opusRate is the clock of Opus RTP timestamps and of the decoded output.
+const opusRate = 48000
+
+// opusRTPDecoder decodes Opus RTP packets (RFC 7587) through one ffmpeg
+// process per call: payloads are muxed into an Ogg stream on its stdin and
+// PCM is read back from its stdout as it is decoded, so a chunk's samples
+// may surface on a later call to decode.
+type opusRTPDecoder struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	ogg   oggWriter
+	seq   rtpSequencer
+
+	mu  sync.Mutex
+	pcm []float32
+	err error // set once ffmpeg exits
+}
+
+func newOpusRTPDecoder() (*opusRTPDecoder, error) {
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error",
+		"-fflags", "nobuffer", "-probesize", "32", "-analyzeduration", "0",
+		"-f", "ogg", "-i", "pipe:0",
+		"-f", "s16le", "-ac", "1", "-ar", fmt.Sprint(opusRate), "-flush_packets", "1", "pipe:1")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+
+	d := &opusRTPDecoder{cmd: cmd, stdin: stdin, ogg: oggWriter{serial: 1}}
+	go d.read(stdout, &stderr)
+	if err = d.ogg.writeHeaders(stdin); err != nil {
+		d.close()
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+	return d, nil
+}
+
+func (d *opusRTPDecoder) read(stdout io.Reader, stderr *bytes.Buffer) {
+	buf := make([]byte, 4096)
+	var carry []byte // odd byte of a sample split across reads
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			data := append(carry, buf[:n]...)
+			even := len(data) &^ 1
+			samples := decodePCM(data[:even])
+			carry = append([]byte(nil), data[even:]...)
+			d.mu.Lock()
+			d.pcm = append(d.pcm, samples...)
+			d.mu.Unlock()
+		}
+		if err != nil {
+			break
+		}
+	}
+	werr := d.cmd.Wait()
+	d.mu.Lock()
+	d.err = fmt.Errorf("ffmpeg opus decoder exited: %v: %s", werr, bytes.TrimSpace(stderr.Bytes()))
+	d.mu.Unlock()
+}
+
+// decode feeds one RTP packet to ffmpeg and returns the PCM decoded so far.
+func (d *opusRTPDecoder) decode(dst []float32, data []byte) ([]float32, error) {
+	pkt, err := ParseRTP(data)
+	if err != nil {
+		return nil, err
+	}
+	if d.seq.accept(pkt) && len(pkt.Payload) > 0 {
+		if err = d.ogg.writePacket(d.stdin, pkt.Payload, opusSamples(pkt.Payload)); err != nil {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if d.err != nil {
+				return nil, d.err
+			}
+			return nil, fmt.Errorf("ffmpeg: %w", err)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return nil, d.err
+	}
+	out := append(dst, d.pcm...)
+	d.pcm = d.pcm[:0]
+	return out, nil
+}
+
+func (d *opusRTPDecoder) close() {
+	d.stdin.Close()
+	d.cmd.Process.Kill()
+}
+
+// opusSamples is the duration of an Opus packet at 48 kHz, from its TOC
+// byte (RFC 6716 section 3.1).
+func opusSamples(pkt []byte) int {
+	toc := pkt[0]
+	config := int(toc >> 3)
+	var frame int
+	if config < 12 { // SILK: 10, 20, 40, 60 ms
+		frame = [4]int{480, 960, 1920, 2880}[config&3]
+	} else if config < 16 { // hybrid: 10, 20 ms
+		frame = [2]int{480, 960}[config&1]
+	} else { // CELT: 2.5, 5, 10, 20 ms
+		frame = [4]int{120, 240, 480, 960}[config&3]
+	}
+	frames := 1
+	if toc&3 == 1 || toc&3 == 2 {
+		frames = 2
+	}
+	if toc&3 == 3 && len(pkt) > 1 {
+		frames = int(pkt[1] & 0x3F)
+	}
+	return frame * frames
+}
+
+// oggWriter muxes Opus packets into an Ogg stream (RFC 3533, RFC 7845),
+// one packet per page so ffmpeg can decode each as soon as it arrives.
+type oggWriter struct {
+	serial  uint32
+	page    uint32
+	granule int64
+}
+
+// writeHeaders writes the OpusHead and OpusTags pages: mono, no pre-skip,
+// no output gain. ffmpeg downmixes stereo packets to the one channel.
+func (w *oggWriter) writeHeaders(out io.Writer) error {
+	head := []byte("OpusHead\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(head[12:], opusRate)
+	if err := w.writePage(out, head, 0x02); err != nil {
+		return err
+	}
+	vendor := "gateway"
+	tags := binary.LittleEndian.AppendUint32([]byte("OpusTags"), uint32(len(vendor)))
+	tags = binary.LittleEndian.AppendUint32(append(tags, vendor...), 0)
+	return w.writePage(out, tags, 0)
+}
+
+func (w *oggWriter) writePacket(out io.Writer, pkt []byte, samples int) error {
+	w.granule += int64(samples)
+	return w.writePage(out, pkt, 0)
+}
+
+// errOggPacketTooLarge is returned for a packet that doesn't fit one page.
+var errOggPacketTooLarge = errors.New("ogg: packet larger than one page")
+
+func (w *oggWriter) writePage(out io.Writer, pkt []byte, headerType byte) error {
+	segments := len(pkt)/255 + 1
+	if segments > 255 {
+		return errOggPacketTooLarge
+	}
+	page := make([]byte, 27, 27+segments+len(pkt))
+	copy(page, "OggS")
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:], uint64(w.granule))
+	binary.LittleEndian.PutUint32(page[14:], w.serial)
+	binary.LittleEndian.PutUint32(page[18:], w.page)
+	page[26] = byte(segments)
+	for range segments - 1 {
+		page = append(page, 255)
+	}
+	page = append(page, byte(len(pkt)%255))
+	page = append(page, pkt...)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+	w.page++
+	_, err := out.Write(page)
+	return err
+}
+
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// oggCRC is the page checksum: CRC-32 with polynomial 0x04C11DB7, no
+// reflection, zero initial value.
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
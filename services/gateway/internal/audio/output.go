@@ -0,0 +1,92 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// OutputFormat is the container TTS audio is delivered to a client in.
+type OutputFormat string
+
+const (
+	OutputWAV   OutputFormat = "wav"
+	OutputPCM16 OutputFormat = "pcm16" // raw 16-bit little-endian mono
+	OutputMP3   OutputFormat = "mp3"
+	OutputOpus  OutputFormat = "opus" // Opus in an Ogg container
+)
+
+// ffmpegOutputs holds the ffmpeg output options for formats the gateway
+// can't encode itself. WAV is also listed: it's how MP3 from a backend is
+// decoded before re-encoding as PCM.
+var ffmpegOutputs = map[OutputFormat][]string{
+	OutputWAV:  {"-f", "wav"},
+	OutputMP3:  {"-f", "mp3", "-b:a", "64k"},
+	OutputOpus: {"-c:a", "libopus", "-b:a", "32k", "-f", "ogg"},
+}
+
+// ValidOutputFormat reports whether f is a supported output format.
+func ValidOutputFormat(f OutputFormat) bool {
+	_, ok := ffmpegOutputs[f]
+	return ok || f == OutputPCM16
+}
+
+// Container sniffs synthesized audio: WAV (RIFF), MP3 (ID3 tag or frame
+// sync), or "" when unknown.
+func Container(data []byte) OutputFormat {
+	if len(data) >= 4 && string(data[:4]) == "RIFF" {
+		return OutputWAV
+	}
+	if len(data) >= 3 && string(data[:3]) == "ID3" {
+		return OutputMP3
+	}
+	if len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0 {
+		return OutputMP3
+	}
+	return ""
+}
+
+// Transcode converts TTS audio (WAV or MP3) to format and returns it with
+// its sample rate for pcm16 (0 for self-describing containers). Audio
+// already in format is returned unchanged. MP3, Opus, and decoding MP3
+// need ffmpeg on the PATH.
+func Transcode(ctx context.Context, data []byte, format OutputFormat) ([]byte, int, error) {
+	src := Container(data)
+	if src == format {
+		return data, 0, nil
+	}
+	if format != OutputPCM16 {
+		out, err := ffmpeg(ctx, data, format)
+		return out, 0, err
+	}
+	if src != OutputWAV {
+		wav, err := ffmpeg(ctx, data, OutputWAV)
+		if err != nil {
+			return nil, 0, err
+		}
+		data = wav
+	}
+	samples, rate, err := DecodeWAV(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return EncodePCM16(samples), rate, nil
+}
+
+func ffmpeg(ctx context.Context, data []byte, format OutputFormat) ([]byte, error) {
+	opts, ok := ffmpegOutputs[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+	args := append([]string{"-hide_banner", "-loglevel", "error", "-i", "pipe:0"}, opts...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(args, "pipe:1")...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg %s: %w: %s", format, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
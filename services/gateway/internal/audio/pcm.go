@@ -3,14 +3,40 @@ package audio
 import (
 	"encoding/binary"
 	"math"
+	"slices"
 )
 
 func decodePCM(data []byte) []float32 {
+	return appendPCM(nil, data)
+}
+
+func appendPCM(dst []float32, data []byte) []float32 {
 	n := len(data) / 2
-	samples := make([]float32, n)
+	dst = slices.Grow(dst, n)
 	for i := range n {
 		s := int16(binary.LittleEndian.Uint16(data[i*2:]))
-		samples[i] = float32(s) / math.MaxInt16
+		dst = append(dst, float32(s)/math.MaxInt16)
+	}
+	return dst
+}
+
+// EncodePCM16 converts float32 samples to raw 16-bit little-endian PCM.
+func EncodePCM16(samples []float32) []byte {
+	buf := make([]byte, len(samples)*2)
+	putPCM16(buf, samples)
+	return buf
+}
+
+// Deinterleave splits interleaved multi-channel samples into one slice per
+// channel. A trailing partial frame is dropped.
+func Deinterleave(samples []float32, channels int) [][]float32 {
+	n := len(samples) / channels
+	out := make([][]float32, channels)
+	for c := range out {
+		out[c] = make([]float32, n)
+		for i := range n {
+			out[c][i] = samples[i*channels+c]
+		}
 	}
-	return samples
+	return out
 }
@@ -0,0 +1,140 @@
+package audio
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	// polyphaseZeros is the prototype filter's half-width in zero crossings
+	// of its sinc, which sets how sharp the anti-aliasing cut is.
+	polyphaseZeros = 16
+	// polyphaseRolloff places the cutoff just under the lower Nyquist rate,
+	// so the transition band ends before anything can alias.
+	polyphaseRolloff = 0.92
+	// polyphaseBeta is the Kaiser window's shape (about 80 dB stopband).
+	polyphaseBeta = 8.0
+)
+
+// polyphase converts between rates at the ratio up/down: conceptually it
+// upsamples by up, low-passes, and keeps every down-th sample, but only
+// computes the kept samples, each from one phase of the filter. Input is
+// one continuous stream, so chunk boundaries leave no seams.
+type polyphase struct {
+	up, down int64
+	bank     [][]float32 // bank[phase][k] weighs the k-th input back
+	center   int64       // the filter's centre, in upsampled samples
+	buf      []float32   // input from index start on
+	start    int64
+	next     int64 // index of the next output sample
+}
+
+func newPolyphase(srcRate, dstRate int) *polyphase {
+	g := gcd(srcRate, dstRate)
+	p := &polyphase{up: int64(dstRate / g), down: int64(srcRate / g)}
+	p.bank = polyphaseBank(int(p.up), int(p.down))
+	p.center = int64(len(p.bank[0])) * p.up / 2
+	return p
+}
+
+// appendTo appends every output sample the input so far reaches, holding
+// back the last few until the filter's look-ahead has arrived.
+func (p *polyphase) appendTo(dst, samples []float32) []float32 {
+	p.buf = append(p.buf, samples...)
+	end := p.start + int64(len(p.buf))
+	for {
+		u := p.next*p.down + p.center
+		newest := u / p.up
+		if newest >= end {
+			break
+		}
+		var sum float32
+		for k, h := range p.bank[u%p.up] {
+			i := newest - int64(k) - p.start
+			if i < 0 {
+				break // before the stream began
+			}
+			sum += h * p.buf[i]
+		}
+		dst = append(dst, sum)
+		p.next++
+	}
+
+	// The next output reaches back at most one phase's length.
+	drop := max(0, len(p.buf)-len(p.bank[0]))
+	p.buf = p.buf[:copy(p.buf, p.buf[drop:])]
+	p.start += int64(drop)
+	return dst
+}
+
+type bankKey struct{ up, down int }
+
+// banks caches polyphaseBank results, which sessions share read-only.
+var banks sync.Map // bankKey -> [][]float32
+
+// polyphaseBank designs the Kaiser-windowed sinc low-pass for an up/down
+// ratio and splits it into up phases. Each phase sums to about 1, so gain
+// is unity either way.
+func polyphaseBank(up, down int) [][]float32 {
+	key := bankKey{up, down}
+	if b, ok := banks.Load(key); ok {
+		return b.([][]float32)
+	}
+
+	// The cutoff, as a fraction of the upsampled rate, is under the lower
+	// of the two Nyquist rates.
+	wide := max(up, down)
+	fc := polyphaseRolloff / (2 * float64(wide))
+	taps := (2*polyphaseZeros*wide + up - 1) / up // per phase
+	n := taps * up
+	center := float64(n / 2)
+
+	h := make([]float64, n)
+	var sum float64
+	for j := range h {
+		x := float64(j) - center
+		sinc := 2 * fc
+		if x != 0 {
+			sinc = math.Sin(2*math.Pi*fc*x) / (math.Pi * x)
+		}
+		h[j] = sinc * kaiser(x/center)
+		sum += h[j]
+	}
+
+	bank := make([][]float32, up)
+	for r := range bank {
+		bank[r] = make([]float32, taps)
+		for k := range taps {
+			bank[r][k] = float32(h[r+k*up] * float64(up) / sum)
+		}
+	}
+	b, _ := banks.LoadOrStore(key, bank)
+	return b.([][]float32)
+}
+
+// kaiser is the Kaiser window at x in [-1, 1].
+func kaiser(x float64) float64 {
+	if x < -1 || x > 1 {
+		return 0
+	}
+	return besselI0(polyphaseBeta*math.Sqrt(1-x*x)) / besselI0(polyphaseBeta)
+}
+
+// besselI0 is the zeroth-order modified Bessel function of the first kind,
+// summed as a power series.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		f := x / (2 * float64(k))
+		term *= f * f
+		sum += term
+	}
+	return sum
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,26 @@
+package audio
+
+import "sync"
+
+// maxPooledSamples caps the buffers kept for reuse (one second at 48 kHz),
+// so one long snippet doesn't pin a large array in the pool.
+const maxPooledSamples = 48000
+
+// samplePool holds the scratch buffers of the per-chunk hot path. At 100
+// calls sending 20 ms chunks, a fresh slice per decode and resample step is
+// thousands of short-lived allocations a second for the GC to chase.
+var samplePool = sync.Pool{New: func() any { return new([]float32) }}
+
+// GetSamples returns an empty sample buffer from the pool to append to.
+func GetSamples() *[]float32 {
+	return samplePool.Get().(*[]float32)
+}
+
+// PutSamples returns b to the pool. Nothing may hold on to its contents.
+func PutSamples(b *[]float32) {
+	if cap(*b) > maxPooledSamples {
+		return
+	}
+	*b = (*b)[:0]
+	samplePool.Put(b)
+}
@@ -0,0 +1,77 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// rtpHeaderLen is the fixed part of an RTP header, before CSRCs.
+const rtpHeaderLen = 12
+
+// RTPPacket is one RTP packet (RFC 3550) with its header fields parsed and
+// CSRCs, header extension, and padding stripped from the payload.
+type RTPPacket struct {
+	PayloadType uint8
+	Marker      bool
+	Sequence    uint16
+	Timestamp   uint32
+	SSRC        uint32
+	Payload     []byte
+}
+
+// ParseRTP parses an RTP packet. Payload aliases b.
+func ParseRTP(b []byte) (RTPPacket, error) {
+	if len(b) < rtpHeaderLen {
+		return RTPPacket{}, fmt.Errorf("rtp: %d byte packet is shorter than a header", len(b))
+	}
+	if v := b[0] >> 6; v != 2 {
+		return RTPPacket{}, fmt.Errorf("rtp: version %d, want 2", v)
+	}
+	pkt := RTPPacket{
+		PayloadType: b[1] & 0x7F,
+		Marker:      b[1]&0x80 != 0,
+		Sequence:    binary.BigEndian.Uint16(b[2:]),
+		Timestamp:   binary.BigEndian.Uint32(b[4:]),
+		SSRC:        binary.BigEndian.Uint32(b[8:]),
+	}
+
+	end := len(b)
+	if b[0]&0x20 != 0 {
+		pad := int(b[end-1])
+		if pad == 0 || pad > end-rtpHeaderLen {
+			return RTPPacket{}, errors.New("rtp: invalid padding")
+		}
+		end -= pad
+	}
+	off := rtpHeaderLen + int(b[0]&0x0F)*4
+	if b[0]&0x10 != 0 {
+		if off+4 > end {
+			return RTPPacket{}, errors.New("rtp: truncated header extension")
+		}
+		off += 4 + int(binary.BigEndian.Uint16(b[off+2:]))*4
+	}
+	if off > end {
+		return RTPPacket{}, errors.New("rtp: truncated header")
+	}
+	pkt.Payload = b[off:end]
+	return pkt, nil
+}
+
+// rtpSequencer drops duplicate and late packets, which a jitter-free
+// decoder can't place, and follows SSRC changes (a new stream after a
+// transfer or re-INVITE).
+type rtpSequencer struct {
+	started bool
+	ssrc    uint32
+	last    uint16
+}
+
+// accept reports whether pkt is newer than every packet seen on its stream.
+func (s *rtpSequencer) accept(pkt RTPPacket) bool {
+	if s.started && pkt.SSRC == s.ssrc && int16(pkt.Sequence-s.last) <= 0 {
+		return false
+	}
+	s.started, s.ssrc, s.last = true, pkt.SSRC, pkt.Sequence
+	return true
+}
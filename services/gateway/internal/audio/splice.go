@@ -0,0 +1,44 @@
+package audio
+
+import "math"
+
+// Splice parameters for sentence clips played back to back.
+const (
+	spliceSilenceDB = -50 // quieter than this counts as leading/trailing silence
+	spliceMarginMs  = 20  // silence kept at each end so words aren't clipped
+	spliceFadeMs    = 5   // fade at each end
+)
+
+// SpliceClip prepares one sentence of TTS audio to be played right after
+// the previous one. Engines pad clips with silence of their own, and often
+// start or stop off zero, which clicks where two clips meet. Leading and
+// trailing silence is trimmed to a short margin and both ends are faded,
+// so consecutive clips cross through zero and form a continuous signal,
+// with any pause between them the configured one. A clip that is silent
+// throughout is returned unchanged.
+func SpliceClip(samples []float32, sampleRate int) []float32 {
+	threshold := float32(math.Pow(10, spliceSilenceDB/20.0))
+	first, last := -1, -1
+	for i, s := range samples {
+		if s > threshold || s < -threshold {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return samples
+	}
+	margin := sampleRate * spliceMarginMs / 1000
+	start, end := max(first-margin, 0), min(last+margin+1, len(samples))
+	out := append([]float32(nil), samples[start:end]...)
+
+	fade := min(sampleRate*spliceFadeMs/1000, len(out)/2)
+	for i := range fade {
+		g := float32(0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(fade)))
+		out[i] *= g
+		out[len(out)-1-i] *= g
+	}
+	return out
+}
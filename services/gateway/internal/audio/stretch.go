@@ -0,0 +1,75 @@
+package audio
+
+import "math"
+
+// Time-stretch window parameters, in milliseconds.
+const (
+	stretchFrameMs = 30 // analysis window; a few pitch periods of speech
+	stretchSeekMs  = 10 // how far a frame may shift to line up with the last
+)
+
+// TimeStretch changes the duration of samples by 1/speed without changing
+// pitch, using WSOLA (waveform-similarity overlap-add): Hann-windowed
+// frames are taken every speed×hop from the input and overlap-added every
+// hop, each shifted within a small range to the position that best
+// continues the waveform already written, so periods don't cancel out.
+// speed is clamped to 0.5-2, beyond which speech smears.
+func TimeStretch(samples []float32, sampleRate int, speed float64) []float32 {
+	if speed <= 0 || speed == 1 || sampleRate <= 0 {
+		return samples
+	}
+	speed = min(max(speed, 0.5), 2)
+	frame := sampleRate * stretchFrameMs / 1000
+	hop := frame / 2
+	seek := sampleRate * stretchSeekMs / 1000
+	if len(samples) < frame+seek {
+		return samples
+	}
+
+	window := make([]float32, frame)
+	for i := range window {
+		window[i] = float32(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(frame)))
+	}
+
+	outLen := int(float64(len(samples)) / speed)
+	out := make([]float32, outLen+frame)
+	prev := 0 // input position of the last frame written
+	for k := 0; ; k++ {
+		pos := 0
+		if k > 0 {
+			pos = bestOverlap(samples, prev+hop, int(float64(k*hop)*speed), seek, frame-hop)
+		}
+		at := k * hop
+		if pos+frame > len(samples) || at >= outLen {
+			break
+		}
+		for i, w := range window {
+			out[at+i] += w * samples[pos+i]
+		}
+		prev = pos
+	}
+	return out[:outLen]
+}
+
+// bestOverlap finds the frame start within seek of target whose first n
+// samples correlate best with those at natural, where the last frame would
+// have continued.
+func bestOverlap(samples []float32, natural, target, seek, n int) int {
+	lo := max(target-seek, 0)
+	hi := min(target+seek, len(samples)-2*n) // a whole frame must fit
+	if natural+n > len(samples) || hi < lo {
+		return target
+	}
+	ref := samples[natural : natural+n]
+	best, bestScore := target, math.Inf(-1)
+	for pos := lo; pos <= hi; pos++ {
+		var score float64
+		for i, r := range ref {
+			score += float64(r * samples[pos+i])
+		}
+		if score > bestScore {
+			best, bestScore = pos, score
+		}
+	}
+	return best
+}
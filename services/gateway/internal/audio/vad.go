@@ -2,6 +2,7 @@ package audio
 
 import (
 	"math"
+	"sync"
 	"time"
 )
 
@@ -14,6 +15,7 @@ type VADConfig struct {
 	SampleRate           int
 	CalibrationDuration  time.Duration // noise floor calibration window (0 = disabled)
 	AdaptiveMarginDB     float64       // dB above noise floor for speech threshold
+	PauseProbe           time.Duration // report a pause after this much silence, for endpointing (0 = disabled)
 }
 
 // DefaultVADConfig returns sensible defaults for call center audio.
@@ -45,6 +47,12 @@ type VAD struct {
 	calibrationStart   time.Time
 	calibrationReadings []float64
 	threshold          float64
+
+	// endpointing: the current pause can be given its own silence timeout
+	probed  bool
+	mu      sync.Mutex
+	pause   uint64
+	timeout time.Duration // override for the current pause (0 = cfg.SilenceTimeout)
 }
 
 // NewVAD creates a VAD with the given config.
@@ -63,6 +71,14 @@ func NewVAD(cfg VADConfig) *VAD {
 type VADResult struct {
 	SpeechEnded bool
 	Audio       []float32
+	// SpeechStart and SpeechEnd are when an ended utterance's first and
+	// last speech chunks arrived, excluding the silence that ended it.
+	SpeechStart, SpeechEnd time.Time
+	// Paused reports that speech stopped for PauseProbe without ending yet;
+	// Audio is a copy of the utterance so far. Pass Pause to
+	// SetSilenceTimeout to decide how long this pause may last.
+	Paused bool
+	Pause  uint64
 }
 
 // Process feeds an audio chunk into the VAD and returns completed speech segments.
@@ -118,6 +134,9 @@ func (v *VAD) handleSpeech(samples []float32, now time.Time) VADResult {
 	v.lastSpeechTime = now
 	v.buffer = append(v.buffer, samples...)
 	v.preSpeech = v.preSpeech[:0]
+	if v.probed {
+		v.endPause()
+	}
 	return VADResult{}
 }
 
@@ -133,11 +152,17 @@ func (v *VAD) handleSilence(samples []float32, now time.Time) VADResult {
 	silenceDur := now.Sub(v.lastSpeechTime)
 	speechDur := now.Sub(v.speechStart)
 
-	if silenceDur < v.cfg.SilenceTimeout {
+	if silenceDur < v.silenceTimeout() {
+		if v.cfg.PauseProbe > 0 && !v.probed && silenceDur >= v.cfg.PauseProbe && speechDur >= v.cfg.MinSpeechDuration {
+			return v.startPause()
+		}
 		return VADResult{}
 	}
 
 	v.isSpeech = false
+	if v.probed {
+		v.endPause()
+	}
 
 	if speechDur < v.cfg.MinSpeechDuration {
 		v.buffer = v.buffer[:0]
@@ -146,7 +171,50 @@ func (v *VAD) handleSilence(samples []float32, now time.Time) VADResult {
 
 	audio := v.buffer
 	v.buffer = nil
-	return VADResult{SpeechEnded: true, Audio: audio}
+	return VADResult{SpeechEnded: true, Audio: audio, SpeechStart: v.speechStart, SpeechEnd: v.lastSpeechTime}
+}
+
+// startPause reports a new pause with a snapshot of the utterance so far.
+func (v *VAD) startPause() VADResult {
+	v.probed = true
+	v.mu.Lock()
+	v.pause++
+	pause := v.pause
+	v.mu.Unlock()
+	return VADResult{Paused: true, Pause: pause, Audio: append([]float32(nil), v.buffer...)}
+}
+
+// endPause drops the current pause's timeout override; a late
+// SetSilenceTimeout for it is ignored.
+func (v *VAD) endPause() {
+	v.probed = false
+	v.mu.Lock()
+	v.pause++
+	v.timeout = 0
+	v.mu.Unlock()
+}
+
+// SetSilenceTimeout sets how much silence ends the utterance during pause,
+// replacing SilenceTimeout until speech resumes (0 keeps SilenceTimeout).
+// Returns false if the pause is already over. Safe to call from another
+// goroutine.
+func (v *VAD) SetSilenceTimeout(pause uint64, d time.Duration) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if pause != v.pause {
+		return false
+	}
+	v.timeout = d
+	return true
+}
+
+func (v *VAD) silenceTimeout() time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.timeout > 0 {
+		return v.timeout
+	}
+	return v.cfg.SilenceTimeout
 }
 
 func (v *VAD) updatePreSpeech(samples []float32) {
@@ -157,17 +225,6 @@ func (v *VAD) updatePreSpeech(samples []float32) {
 	}
 }
 
-// Flush returns any buffered speech audio and resets the VAD.
-func (v *VAD) Flush() []float32 {
-	if len(v.buffer) == 0 {
-		return nil
-	}
-	audio := v.buffer
-	v.buffer = nil
-	v.isSpeech = false
-	return audio
-}
-
 func computeEnergyDB(samples []float32) float64 {
 	if len(samples) == 0 {
 		return -100
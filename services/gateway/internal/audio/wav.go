@@ -2,15 +2,46 @@ package audio
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"math"
 )
 
+const wavHeaderLen = 44
+
 // SamplesToWAV encodes float32 PCM samples as a WAV byte slice.
 func SamplesToWAV(samples []float32, sampleRate int) []byte {
-	dataLen := len(samples) * 2
-	totalLen := 44 + dataLen
+	buf := make([]byte, wavHeaderLen+len(samples)*2)
+	putWAVHeader(buf, len(samples), sampleRate)
+	putPCM16(buf[wavHeaderLen:], samples)
+	return buf
+}
+
+// WriteWAV streams samples to w as a WAV file, without building the whole
+// file in memory first.
+func WriteWAV(w io.Writer, samples []float32, sampleRate int) error {
+	var buf [4096]byte
+	putWAVHeader(buf[:wavHeaderLen], len(samples), sampleRate)
+	if _, err := w.Write(buf[:wavHeaderLen]); err != nil {
+		return err
+	}
+	for len(samples) > 0 {
+		n := min(len(samples), len(buf)/2)
+		putPCM16(buf[:n*2], samples[:n])
+		if _, err := w.Write(buf[:n*2]); err != nil {
+			return err
+		}
+		samples = samples[n:]
+	}
+	return nil
+}
+
+// putWAVHeader writes the 44-byte header of a 16-bit mono WAV file.
+func putWAVHeader(buf []byte, samples, sampleRate int) {
+	dataLen := samples * 2
+	totalLen := wavHeaderLen + dataLen
 
-	buf := make([]byte, totalLen)
 	copy(buf[0:4], "RIFF")
 	binary.LittleEndian.PutUint32(buf[4:8], uint32(totalLen-8))
 	copy(buf[8:12], "WAVE")
@@ -24,12 +55,72 @@ func SamplesToWAV(samples []float32, sampleRate int) []byte {
 	binary.LittleEndian.PutUint16(buf[34:36], 16)                   // bits per sample
 	copy(buf[36:40], "data")
 	binary.LittleEndian.PutUint32(buf[40:44], uint32(dataLen))
+}
 
+func putPCM16(buf []byte, samples []float32) {
 	for i, s := range samples {
 		clamped := max(-1.0, min(1.0, s))
 		val := int16(clamped * math.MaxInt16)
-		binary.LittleEndian.PutUint16(buf[44+i*2:], uint16(val))
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(val))
+	}
+}
+
+// DecodeWAV parses a 16-bit PCM WAV file and returns mono float32 samples
+// (multi-channel input is averaged down) and the sample rate. Chunks other
+// than "fmt " and "data" are skipped.
+func DecodeWAV(data []byte) ([]float32, int, error) {
+	samples, channels, rate, err := parseWAV(data)
+	if err != nil {
+		return nil, 0, err
 	}
+	return Downmix(samples, channels), rate, nil
+}
 
-	return buf
+// parseWAV is DecodeWAV keeping the channels interleaved.
+func parseWAV(data []byte) ([]float32, int, int, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, 0, errors.New("wav: missing RIFF/WAVE header")
+	}
+	var channels, bits, rate int
+	off := 12
+	for off+8 <= len(data) {
+		id := string(data[off : off+4])
+		size := int(binary.LittleEndian.Uint32(data[off+4 : off+8]))
+		body := data[off+8:]
+		if size > len(body) {
+			size = len(body) // tolerate streamed files with a placeholder size
+		}
+		if id == "fmt " && size >= 16 {
+			if format := binary.LittleEndian.Uint16(body[0:2]); format != 1 {
+				return nil, 0, 0, fmt.Errorf("wav: unsupported format %d (want PCM)", format)
+			}
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			rate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bits = int(binary.LittleEndian.Uint16(body[14:16]))
+		}
+		if id == "data" {
+			if bits != 16 || channels < 1 {
+				return nil, 0, 0, fmt.Errorf("wav: unsupported layout (%d-bit, %d channels)", bits, channels)
+			}
+			return decodePCM(body[:size]), channels, rate, nil
+		}
+		off += 8 + size + size%2 // chunks are word-aligned
+	}
+	return nil, 0, 0, errors.New("wav: no data chunk")
+}
+
+// Downmix averages interleaved channels into mono.
+func Downmix(samples []float32, channels int) []float32 {
+	if channels == 1 {
+		return samples
+	}
+	out := make([]float32, len(samples)/channels)
+	for i := range out {
+		var sum float32
+		for c := range channels {
+			sum += samples[i*channels+c]
+		}
+		out[i] = sum / float32(channels)
+	}
+	return out
 }
@@ -0,0 +1,205 @@
+// Package auth implements static API key authentication with per-key scopes.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+)
+
+// Scope grants access to a class of routes.
+type Scope string
+
+const (
+	ScopeCall  Scope = "call"  // open call sessions, synthesize audio, chat completions
+	ScopeRead  Scope = "read"  // read-only API (models, traces, GPU)
+	ScopeAdmin Scope = "admin" // everything, including service/model control
+
+	ScopeSupervise Scope = "supervise" // monitor live calls and whisper to the agent
+)
+
+// Identity is the authenticated caller attached to the request context.
+type Identity struct {
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+	Tenant string  `json:"tenant,omitempty"` // confines calls and traces to one tenant ("" = all)
+}
+
+// Allows reports whether the identity holds the scope. Admin implies all scopes.
+func (id Identity) Allows(s Scope) bool {
+	return slices.Contains(id.Scopes, ScopeAdmin) || slices.Contains(id.Scopes, s)
+}
+
+// Keys maps SHA-256 digests of API keys to identities. Keys are stored
+// hashed so the lookup doesn't leak key prefixes through timing.
+type Keys struct {
+	byDigest map[[sha256.Size]byte]Identity
+}
+
+// Enabled reports whether any keys are configured. With no keys the
+// middleware is a passthrough so local development needs no setup.
+func (k *Keys) Enabled() bool {
+	return k != nil && len(k.byDigest) > 0
+}
+
+func (k *Keys) lookup(key string) (Identity, bool) {
+	id, ok := k.byDigest[sha256.Sum256([]byte(key))]
+	return id, ok
+}
+
+// keyEntry is one key in the JSON keys file.
+type keyEntry struct {
+	Key    string  `json:"key"`
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+	Tenant string  `json:"tenant"`
+}
+
+// LoadKeys builds the key set from an inline spec and/or a JSON file.
+// The inline spec is "key:scope+scope,key2:scope" (e.g. from GATEWAY_API_KEYS);
+// the file holds [{"key": "...", "name": "...", "scopes": ["call"], "tenant": "..."}].
+func LoadKeys(spec, path string) (*Keys, error) {
+	var entries []keyEntry
+	for i, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, scopes, _ := strings.Cut(item, ":")
+		e := keyEntry{Key: key, Name: fmt.Sprintf("env-%d", i)}
+		for _, s := range strings.Split(scopes, "+") {
+			if s != "" {
+				e.Scopes = append(e.Scopes, Scope(s))
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read api keys file: %w", err)
+		}
+		var fileEntries []keyEntry
+		if err = json.Unmarshal(data, &fileEntries); err != nil {
+			return nil, fmt.Errorf("parse api keys file: %w", err)
+		}
+		entries = append(entries, fileEntries...)
+	}
+
+	keys := &Keys{byDigest: make(map[[sha256.Size]byte]Identity, len(entries))}
+	for _, e := range entries {
+		if e.Key == "" {
+			continue
+		}
+		if len(e.Scopes) == 0 {
+			e.Scopes = []Scope{ScopeRead}
+		}
+		keys.byDigest[sha256.Sum256([]byte(e.Key))] = Identity{Name: e.Name, Scopes: e.Scopes, Tenant: e.Tenant}
+	}
+	return keys, nil
+}
+
+type ctxKey struct{}
+
+// FromContext returns the authenticated identity, if any.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(ctxKey{}).(Identity)
+	return id, ok
+}
+
+// TenantOf returns the tenant bound to the request's API key, or "" when
+// the key has none or auth is off.
+func TenantOf(r *http.Request) string {
+	id, _ := FromContext(r.Context())
+	return id.Tenant
+}
+
+// publicPaths are reachable without a key (load balancer probes, scraping).
+var publicPaths = map[string]bool{
+	"/health":          true,
+	"/api/health/deep": true,
+	"/metrics":         true,
+}
+
+// requiredScope maps a request to the scope it needs.
+func requiredScope(r *http.Request) Scope {
+	if strings.HasPrefix(r.URL.Path, "/ws/monitor/") {
+		return ScopeSupervise
+	}
+	if r.URL.Path == "/ws/call" || r.URL.Path == "/v1/realtime" || r.URL.Path == "/api/synthesize" || r.URL.Path == "/v1/chat/completions" {
+		return ScopeCall
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return ScopeRead
+	}
+	return ScopeAdmin
+}
+
+// realtimeKeyProtocol prefixes the API key in the WebSocket subprotocol list,
+// which is how browser OpenAI Realtime clients authenticate.
+const realtimeKeyProtocol = "openai-insecure-api-key."
+
+// extractKey reads the key from "Authorization: Bearer", X-API-Key, the
+// Realtime key subprotocol, or the api_key query parameter (browsers can't
+// set headers on WebSocket/EventSource).
+func extractKey(r *http.Request) string {
+	if v := r.Header.Get("Authorization"); strings.HasPrefix(v, "Bearer ") {
+		return strings.TrimPrefix(v, "Bearer ")
+	}
+	if v := r.Header.Get("X-API-Key"); v != "" {
+		return v
+	}
+	for _, proto := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		if key, ok := strings.CutPrefix(strings.TrimSpace(proto), realtimeKeyProtocol); ok {
+			return key
+		}
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// Middleware rejects requests without a valid key (401) or with a key that
+// lacks the route's scope (403). A nil or empty key set disables auth.
+func Middleware(keys *Keys, next http.Handler) http.Handler {
+	if !keys.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := extractKey(r)
+		if key == "" {
+			reject(w, r, "missing", http.StatusUnauthorized)
+			return
+		}
+		id, ok := keys.lookup(key)
+		if !ok {
+			reject(w, r, "invalid", http.StatusUnauthorized)
+			return
+		}
+		if scope := requiredScope(r); !id.Allows(scope) {
+			reject(w, r, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, id)))
+	})
+}
+
+func reject(w http.ResponseWriter, r *http.Request, reason string, status int) {
+	metrics.AuthFailures.WithLabelValues(reason).Inc()
+	slog.Warn("auth rejected", "reason", reason, "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gateway"`)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,76 @@
+// Package experiment splits calls between engine combinations for A/B
+// comparison. An experiment is a list of variants, each taking a percentage
+// of calls and overriding some of their engines; results are read back from
+// the trace store, where every session records its variant.
+package experiment
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+)
+
+// Variant is one arm of an experiment. Empty engine fields leave the call's
+// own choice in place.
+type Variant struct {
+	Name      string `json:"name"`
+	Weight    int    `json:"weight"` // percent of the experiment's calls
+	ASREngine string `json:"asr_engine,omitempty"`
+	ASRModel  string `json:"asr_model,omitempty"`
+	LLMEngine string `json:"llm_engine,omitempty"`
+	LLMModel  string `json:"llm_model,omitempty"`
+	TTSEngine string `json:"tts_engine,omitempty"`
+}
+
+// Experiment is a traffic split across variants. Weights may sum to less
+// than 100; the remaining calls stay out of the experiment.
+type Experiment struct {
+	Variants []Variant `json:"variants"`
+}
+
+// Validate checks that the experiment has named, distinct variants whose
+// weights fit in 100%.
+func (e Experiment) Validate() error {
+	if len(e.Variants) == 0 {
+		return errors.New("no variants")
+	}
+	seen := map[string]bool{}
+	total := 0
+	for _, v := range e.Variants {
+		if v.Name == "" {
+			return errors.New("variant without a name")
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("duplicate variant %q", v.Name)
+		}
+		if v.Weight < 0 {
+			return fmt.Errorf("variant %q has a negative weight", v.Name)
+		}
+		seen[v.Name] = true
+		total += v.Weight
+	}
+	if total > 100 {
+		return fmt.Errorf("weights sum to %d%%", total)
+	}
+	return nil
+}
+
+// Assign picks the variant for a call. The same experiment ID and key
+// always land in the same variant, so a reconnecting session keeps its arm;
+// an empty key assigns at random. Returns false for calls outside the split.
+func (e Experiment) Assign(id, key string) (Variant, bool) {
+	bucket := rand.IntN(100)
+	if key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(id + "\x00" + key))
+		bucket = int(h.Sum32() % 100)
+	}
+	for _, v := range e.Variants {
+		if bucket < v.Weight {
+			return v, true
+		}
+		bucket -= v.Weight
+	}
+	return Variant{}, false
+}
@@ -0,0 +1,80 @@
+package experiment
+
+import "github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/trace"
+
+// Results compares an experiment's variants against its baseline, the
+// first variant configured.
+type Results struct {
+	Experiment string          `json:"experiment"`
+	Baseline   string          `json:"baseline"`
+	Variants   []VariantResult `json:"variants"`
+}
+
+// VariantResult is one variant's traced totals and its difference from
+// the baseline.
+type VariantResult struct {
+	trace.VariantStats
+	Weight    int     `json:"weight"` // current share (0 = no longer configured)
+	ErrorRate float64 `json:"error_rate"`
+	Delta     *Delta  `json:"delta,omitempty"` // nil for the baseline
+}
+
+// Delta is a variant minus the baseline: negative latency and WER are
+// improvements.
+type Delta struct {
+	MeanMs    float64  `json:"mean_ms"`
+	P50Ms     float64  `json:"p50_ms"`
+	P95Ms     float64  `json:"p95_ms"`
+	MeanWER   *float64 `json:"mean_wer,omitempty"` // nil unless both were scored
+	ErrorRate float64  `json:"error_rate"`
+}
+
+// Summarize lays out stats in the experiment's variant order, adding
+// configured variants with no traffic yet and keeping traced ones that
+// have since been removed from the config.
+func Summarize(id string, e Experiment, stats []trace.VariantStats) Results {
+	byName := make(map[string]trace.VariantStats, len(stats))
+	for _, s := range stats {
+		byName[s.Variant] = s
+	}
+	res := Results{Experiment: id}
+	for _, v := range e.Variants {
+		s, ok := byName[v.Name]
+		if !ok {
+			s = trace.VariantStats{Variant: v.Name}
+		}
+		delete(byName, v.Name)
+		res.Variants = append(res.Variants, VariantResult{VariantStats: s, Weight: v.Weight})
+	}
+	for _, s := range stats {
+		if _, ok := byName[s.Variant]; ok {
+			res.Variants = append(res.Variants, VariantResult{VariantStats: s})
+		}
+	}
+	if len(res.Variants) == 0 {
+		return res
+	}
+
+	for i := range res.Variants {
+		v := &res.Variants[i]
+		if v.Runs > 0 {
+			v.ErrorRate = float64(v.Errors) / float64(v.Runs)
+		}
+	}
+	base := res.Variants[0]
+	res.Baseline = base.Variant
+	for i := 1; i < len(res.Variants); i++ {
+		v := &res.Variants[i]
+		v.Delta = &Delta{
+			MeanMs:    v.MeanMs - base.MeanMs,
+			P50Ms:     v.P50Ms - base.P50Ms,
+			P95Ms:     v.P95Ms - base.P95Ms,
+			ErrorRate: v.ErrorRate - base.ErrorRate,
+		}
+		if v.MeanWER != nil && base.MeanWER != nil {
+			d := *v.MeanWER - *base.MeanWER
+			v.Delta.MeanWER = &d
+		}
+	}
+	return res
+}
@@ -0,0 +1,140 @@
+// Package flow implements scripted call flows: a small state machine whose
+// current state constrains the agent's system prompt and advances on the
+// caller's words or on signal markers the LLM emits.
+package flow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Flow is a parsed flow definition, shared by every session that runs it.
+//
+//	name: support
+//	initial: greeting
+//	states:
+//	  greeting:
+//	    prompt: Greet the caller and ask how you can help.
+//	    transitions:
+//	      - to: identify
+//	        signal: need_identified
+//	        when: the caller has said what they need
+//	  identify:
+//	    prompt: Ask for the caller's account number.
+//	    transitions:
+//	      - to: resolve
+//	        match: '\b\d{6,}\b'
+type Flow struct {
+	Name    string           `yaml:"name"`
+	Initial string           `yaml:"initial"`
+	States  map[string]State `yaml:"states"`
+}
+
+// State is one step of a flow.
+type State struct {
+	// Prompt is appended to the session's system prompt while in this state.
+	Prompt string `yaml:"prompt"`
+	// Tools names the tools the agent may call in this state. It is reported
+	// to the client with each flow_state event; engines without tool support
+	// ignore it.
+	Tools       []string     `yaml:"tools"`
+	Transitions []Transition `yaml:"transitions"`
+}
+
+// Transition moves the session to another state. Match is an RE2 regular
+// expression checked against the caller's transcript before the LLM runs;
+// Signal is a marker the LLM ends its reply with, as [[signal]], and When
+// tells it when to do so.
+type Transition struct {
+	To     string `yaml:"to"`
+	Signal string `yaml:"signal"`
+	When   string `yaml:"when"`
+	Match  string `yaml:"match"`
+
+	re *regexp.Regexp
+}
+
+// signalName restricts signals to what signalPattern can recognize.
+var signalName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Load reads and validates a flow definition. A missing name defaults to
+// the file name without its extension.
+func Load(path string) (*Flow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Flow
+	if err = yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if f.Name == "" {
+		f.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err = f.validate(); err != nil {
+		return nil, fmt.Errorf("flow %s: %w", f.Name, err)
+	}
+	return &f, nil
+}
+
+// LoadDir loads every .yaml and .yml flow in dir, keyed by name. A missing
+// directory yields no flows.
+func LoadDir(dir string) (map[string]*Flow, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	flows := map[string]*Flow{}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		f, err := Load(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := flows[f.Name]; dup {
+			return nil, fmt.Errorf("flow %s defined twice in %s", f.Name, dir)
+		}
+		flows[f.Name] = f
+	}
+	return flows, nil
+}
+
+func (f *Flow) validate() error {
+	if _, ok := f.States[f.Initial]; !ok {
+		return fmt.Errorf("initial state %q not defined", f.Initial)
+	}
+	for name, st := range f.States {
+		for i := range st.Transitions {
+			t := &st.Transitions[i]
+			if _, ok := f.States[t.To]; !ok {
+				return fmt.Errorf("state %s: transition to undefined state %q", name, t.To)
+			}
+			if t.Signal == "" && t.Match == "" {
+				return fmt.Errorf("state %s: transition to %s needs a signal or match", name, t.To)
+			}
+			if t.Signal != "" && !signalName.MatchString(t.Signal) {
+				return fmt.Errorf("state %s: signal %q must be lowercase letters, digits, and underscores", name, t.Signal)
+			}
+			if t.Match == "" {
+				continue
+			}
+			re, err := regexp.Compile(t.Match)
+			if err != nil {
+				return fmt.Errorf("state %s: match %q: %w", name, t.Match, err)
+			}
+			t.re = re
+		}
+	}
+	return nil
+}
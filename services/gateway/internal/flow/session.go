@@ -0,0 +1,177 @@
+package flow
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maxSignalLen bounds how much streamed text SignalFilter holds back while
+// waiting for a marker's closing "]]".
+const maxSignalLen = 64
+
+// signalPattern finds [[signal]] markers in LLM output.
+var signalPattern = regexp.MustCompile(`\[\[([a-z0-9_]+)\]\]`)
+
+// Session is one call's position in a flow. Methods are safe for
+// concurrent use and nil-safe, so a session without a flow needs no checks.
+type Session struct {
+	flow *Flow
+
+	mu    sync.Mutex
+	state string
+}
+
+// NewSession starts a session at the flow's initial state.
+func NewSession(f *Flow) *Session {
+	return &Session{flow: f, state: f.Initial}
+}
+
+// Name returns the flow's name, or "" for a nil session.
+func (s *Session) Name() string {
+	if s == nil {
+		return ""
+	}
+	return s.flow.Name
+}
+
+// State returns the current state name.
+func (s *Session) State() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Tools returns the tools allowed in the current state.
+func (s *Session) Tools() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flow.States[s.state].Tools
+}
+
+// Prompt returns the system prompt addition for the current state: its
+// instructions plus the signals the LLM may emit to move on.
+func (s *Session) Prompt() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.flow.States[s.state]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n\nCall script, current step %q: %s", s.state, st.Prompt)
+	for _, t := range st.Transitions {
+		if t.Signal == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\nWhen %s, end your reply with [[%s]].", orDefault(t.When, "this step is done"), t.Signal)
+	}
+	return b.String()
+}
+
+// OnTranscript advances on the first match transition the caller's words
+// satisfy. Returns the new state, or "" if the state didn't change.
+func (s *Session) OnTranscript(transcript string) string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.flow.States[s.state].Transitions {
+		if t.re != nil && t.re.MatchString(transcript) {
+			s.state = t.To
+			return t.To
+		}
+	}
+	return ""
+}
+
+// OnResponse strips signal markers from the LLM's reply and advances on the
+// first one the current state has a transition for. Returns the cleaned
+// reply and the new state ("" if unchanged).
+func (s *Session) OnResponse(response string) (string, string) {
+	if s == nil {
+		return response, ""
+	}
+	signals := signalPattern.FindAllStringSubmatch(response, -1)
+	cleaned := strings.TrimSpace(signalPattern.ReplaceAllString(response, ""))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sig := range signals {
+		for _, t := range s.flow.States[s.state].Transitions {
+			if t.Signal == sig[1] {
+				s.state = t.To
+				return cleaned, t.To
+			}
+		}
+	}
+	return cleaned, ""
+}
+
+func orDefault(val, fallback string) string {
+	if val != "" {
+		return val
+	}
+	return fallback
+}
+
+// SignalFilter removes [[signal]] markers from a token stream so they are
+// neither spoken nor shown. Text that might start a marker is held back until
+// it is known not to be one; Flush returns whatever is still held.
+type SignalFilter struct {
+	pending strings.Builder
+}
+
+// Filter returns the portion of token that is safe to emit.
+func (f *SignalFilter) Filter(token string) string {
+	f.pending.WriteString(token)
+	text := f.pending.String()
+	f.pending.Reset()
+
+	var out strings.Builder
+	for {
+		open := strings.Index(text, "[[")
+		if open < 0 {
+			// a trailing "[" may be the start of a marker
+			if strings.HasSuffix(text, "[") {
+				out.WriteString(text[:len(text)-1])
+				f.pending.WriteString("[")
+				return out.String()
+			}
+			out.WriteString(text)
+			return out.String()
+		}
+		out.WriteString(text[:open])
+		text = text[open:]
+		closing := strings.Index(text, "]]")
+		if closing < 0 {
+			if len(text) > maxSignalLen {
+				out.WriteString(text)
+				return out.String()
+			}
+			f.pending.WriteString(text)
+			return out.String()
+		}
+		marker := text[:closing+2]
+		if !signalPattern.MatchString(marker) {
+			out.WriteString(marker)
+		}
+		text = text[closing+2:]
+	}
+}
+
+// Flush returns held-back text at the end of the stream.
+func (f *SignalFilter) Flush() string {
+	s := f.pending.String()
+	f.pending.Reset()
+	return s
+}
@@ -0,0 +1,161 @@
+// Package health probes the gateway's dependencies for /api/health/deep.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Verdicts, from best to worst.
+const (
+	// Ready means every dependency answered.
+	Ready = "ready"
+	// Degraded means an optional dependency is down; calls still work
+	// without it (e.g. no tracing, or one ASR engine fewer).
+	Degraded = "degraded"
+	// Unavailable means a critical dependency is down and calls would fail.
+	Unavailable = "unavailable"
+)
+
+// Status of one dependency.
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Probe checks one dependency and returns its version ("" if it doesn't
+// report one).
+type Probe func(ctx context.Context) (version string, err error)
+
+// Check is one dependency to probe.
+type Check struct {
+	Name     string
+	Critical bool // calls fail without it
+	Probe    Probe
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Version   string  `json:"version,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of every check and the overall verdict.
+type Report struct {
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+	Checks    []Result  `json:"checks"`
+}
+
+// Checker runs its checks concurrently, each bounded by timeout, and caches
+// the report for ttl so frequent load balancer polls don't hammer the
+// dependencies.
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+	ttl     time.Duration
+
+	mu   sync.Mutex
+	last *Report
+}
+
+// NewChecker creates a checker over checks, in reporting order.
+func NewChecker(checks []Check, timeout, ttl time.Duration) *Checker {
+	return &Checker{checks: checks, timeout: timeout, ttl: ttl}
+}
+
+// Report returns the cached report, or probes every dependency if it is
+// older than ttl. Concurrent callers wait for the same probe.
+func (c *Checker) Report(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last != nil && time.Since(c.last.CheckedAt) < c.ttl {
+		return *c.last
+	}
+	rep := c.run(ctx)
+	c.last = &rep
+	return rep
+}
+
+func (c *Checker) run(ctx context.Context) Report {
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, chk := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.probe(ctx, chk)
+		}()
+	}
+	wg.Wait()
+
+	rep := Report{Status: Ready, CheckedAt: time.Now(), Checks: results}
+	for _, r := range results {
+		if r.Status == StatusUp {
+			continue
+		}
+		if r.Critical {
+			rep.Status = Unavailable
+			break
+		}
+		rep.Status = Degraded
+	}
+	return rep
+}
+
+func (c *Checker) probe(ctx context.Context, chk Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := time.Now()
+	version, err := chk.Probe(ctx)
+	res := Result{
+		Name:      chk.Name,
+		Status:    StatusUp,
+		Critical:  chk.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Version:   version,
+	}
+	if err != nil {
+		res.Status = StatusDown
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// HTTP probes url, which must answer 2xx. With versionKey set, the version
+// is read from that field of a JSON response body.
+func HTTP(url, versionKey string) Probe {
+	return func(ctx context.Context) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return "", fmt.Errorf("%s returned %s", url, resp.Status)
+		}
+		if versionKey == "" {
+			io.Copy(io.Discard, resp.Body)
+			return "", nil
+		}
+		var body map[string]any
+		if json.NewDecoder(resp.Body).Decode(&body) != nil {
+			return "", nil
+		}
+		v, _ := body[versionKey].(string)
+		return v, nil
+	}
+}
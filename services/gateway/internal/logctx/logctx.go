@@ -0,0 +1,68 @@
+// Package logctx carries log attributes in a context, so every line logged
+// while handling a call names the session and run without each call site
+// adding them. Log with the slog *Context functions and install Handler on
+// the default logger.
+package logctx
+
+import (
+	"context"
+	"log/slog"
+)
+
+type ctxKey struct{}
+
+// With returns a copy of ctx whose log lines also carry args, given as
+// slog key-value pairs. A key already in ctx takes the new value.
+func With(ctx context.Context, args ...any) context.Context {
+	var r slog.Record
+	r.Add(args...)
+	added := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		added = append(added, a)
+		return true
+	})
+	prev, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	attrs := make([]slog.Attr, 0, len(prev)+len(added))
+	for _, a := range prev {
+		if !hasKey(added, a.Key) {
+			attrs = append(attrs, a)
+		}
+	}
+	return context.WithValue(ctx, ctxKey{}, append(attrs, added...))
+}
+
+func hasKey(attrs []slog.Attr, key string) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler adds the attributes carried by a record's context to it before
+// passing it on.
+type Handler struct {
+	slog.Handler
+}
+
+// NewHandler wraps h.
+func NewHandler(h slog.Handler) *Handler {
+	return &Handler{Handler: h}
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, _ := ctx.Value(ctxKey{}).([]slog.Attr); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{Handler: h.Handler.WithGroup(name)}
+}
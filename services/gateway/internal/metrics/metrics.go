@@ -0,0 +1,163 @@
+// Package metrics defines the gateway's Prometheus collectors.
+// Collectors are registered on the default registry and exposed at /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// StageDuration observes ASR, LLM, and TTS latency per call, labelled by the
+// engine and model that served it so backends can be compared directly.
+var StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "pipeline_stage_duration_seconds",
+	Help:    "Pipeline stage latency, by stage, engine, and model.",
+	Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32},
+}, []string{"stage", "engine", "model"})
+
+// Errors counts failed pipeline stage calls.
+var Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pipeline_errors_total",
+	Help: "Failed pipeline stage calls, by stage, engine, and model.",
+}, []string{"stage", "engine", "model"})
+
+// LLMTokens counts LLM prompt and completion tokens as reported by the provider.
+// cached_prompt tokens, read from the provider's prompt cache, are also
+// counted as prompt.
+var LLMTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pipeline_llm_tokens_total",
+	Help: "LLM tokens consumed, by engine, model, and type (prompt, cached_prompt, or completion).",
+}, []string{"engine", "model", "type"})
+
+// LLMTokensPerSecond observes LLM generation throughput: completion tokens
+// over the time from the first token to the end of the reply, so long
+// answers that slow down show up even when time to first token doesn't.
+var LLMTokensPerSecond = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "pipeline_llm_tokens_per_second",
+	Help:    "LLM completion tokens per second of generation, by engine and model.",
+	Buckets: []float64{5, 10, 20, 30, 40, 60, 80, 120, 160, 240},
+}, []string{"engine", "model"})
+
+// TTSCharacters counts characters sent to TTS, the unit hosted TTS APIs bill by.
+var TTSCharacters = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pipeline_tts_characters_total",
+	Help: "Characters synthesized, by TTS engine.",
+}, []string{"engine"})
+
+// CostUSD accumulates estimated spend from the configured per-model pricing.
+var CostUSD = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pipeline_cost_usd_total",
+	Help: "Estimated cost in USD, by stage, engine, and model.",
+}, []string{"stage", "engine", "model"})
+
+// LLMFallbacks counts turns retried on the next engine in the fallback chain.
+var LLMFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pipeline_llm_fallbacks_total",
+	Help: "LLM requests retried on a fallback engine, by failed engine, next engine, and reason.",
+}, []string{"from", "to", "reason"})
+
+// EngineDegraded is 1 while an engine's circuit breaker is open and its
+// requests fail fast or go to the fallback engine.
+var EngineDegraded = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "pipeline_engine_degraded",
+	Help: "Whether an engine's circuit breaker is open (1) or not (0), by stage and engine.",
+}, []string{"stage", "engine"})
+
+// BreakerTrips counts circuit breakers opening after consecutive failures.
+var BreakerTrips = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pipeline_breaker_trips_total",
+	Help: "Circuit breakers opened after consecutive failures, by stage and engine.",
+}, []string{"stage", "engine"})
+
+// HTTPInFlight tracks requests in flight on each backend's pooled HTTP
+// client, response bodies included, to set against ASR_POOL_SIZE and
+// TTS_POOL_SIZE.
+var HTTPInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "pipeline_http_in_flight",
+	Help: "Requests in flight on a backend's pooled HTTP client, by stage and engine.",
+}, []string{"stage", "engine"})
+
+// HTTPPoolExhausted counts requests started while every pooled connection
+// was busy. They dial a connection of their own, which is closed rather
+// than kept idle afterwards; a steady rate means the pool is too small.
+var HTTPPoolExhausted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pipeline_http_pool_exhausted_total",
+	Help: "Requests started beyond a backend's HTTP pool size, by stage and engine.",
+}, []string{"stage", "engine"})
+
+// SemanticCacheLookups counts semantic cache queries by result.
+var SemanticCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pipeline_semantic_cache_lookups_total",
+	Help: "Semantic response cache lookups, by result (hit, miss, error).",
+}, []string{"result"})
+
+// Intents counts caller turns by classified intent.
+var Intents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pipeline_intents_total",
+	Help: "Caller turns classified into an intent, by intent.",
+}, []string{"intent"})
+
+// ExperimentTurns and ExperimentTurnDuration break voice turns down by A/B
+// experiment variant, so arms can be compared live as well as from traces.
+var ExperimentTurns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pipeline_experiment_turns_total",
+	Help: "Voice turns in an A/B experiment, by experiment, variant, and trace status.",
+}, []string{"experiment", "variant", "status"})
+
+var ExperimentTurnDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "pipeline_experiment_turn_duration_seconds",
+	Help:    "End-to-end latency of successful voice turns in an A/B experiment, by experiment and variant.",
+	Buckets: []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32},
+}, []string{"experiment", "variant"})
+
+// AudioFrames counts sequenced audio frames the jitter buffer could not
+// pass through as received.
+var AudioFrames = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_audio_frames_total",
+	Help: "Sequenced audio frames handled by the jitter buffer, by outcome (late, duplicate, concealed, skipped).",
+}, []string{"outcome"})
+
+// AuthFailures counts requests rejected by API key auth (missing, invalid, forbidden).
+var AuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_auth_failures_total",
+	Help: "Requests rejected by API key authentication, by reason.",
+}, []string{"reason"})
+
+// RateLimited counts requests and WebSocket messages rejected by a rate limiter.
+var RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_rate_limited_total",
+	Help: "Requests or messages rejected by a rate limiter or quota, by limiter.",
+}, []string{"limiter"})
+
+// SlowClientDrops counts outbound WebSocket frames discarded because a
+// client's send queue was full.
+var SlowClientDrops = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ws_slow_client_drops_total",
+	Help: "Outbound WebSocket frames dropped for slow clients, by frame kind (audio or event).",
+}, []string{"kind"})
+
+// InvalidAudioFrames counts talk-mode audio frames dropped for not matching
+// the session's declared codec and sample rate.
+var InvalidAudioFrames = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_invalid_audio_frames_total",
+	Help: "Inbound audio frames dropped as malformed, by reason (frame_too_large, frame_misaligned...).",
+}, []string{"reason"})
+
+// GPUVRAMUsedMB and GPUVRAMTotalMB mirror the control server's GPU snapshot,
+// so alerts can fire on VRAM exhaustion without the dashboard open.
+var GPUVRAMUsedMB = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gpu_vram_used_mb",
+	Help: "VRAM in use in MB, by GPU device.",
+}, []string{"device"})
+
+var GPUVRAMTotalMB = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gpu_vram_total_mb",
+	Help: "VRAM capacity in MB, by GPU device.",
+}, []string{"device"})
+
+// ServiceUp is 1 while an orchestrator-managed service is running and
+// passing its health probe, 0 otherwise.
+var ServiceUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "orchestrator_service_up",
+	Help: "Whether a managed service is running and healthy (1) or not (0), by service.",
+}, []string{"name"})
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"slices"
 	"strings"
@@ -40,13 +41,45 @@ func ListLLMModels(ctx context.Context, ollamaURL string) ([]string, error) {
 
 	names := make([]string, 0, len(result.Models))
 	for _, m := range result.Models {
-		if !strings.Contains(m.Name, "embed") {
+		if !isEmbeddingModel(m.Name) {
 			names = append(names, m.Name)
 		}
 	}
 	return names, nil
 }
 
+// ModelSize returns an installed model's size in bytes from Ollama /api/tags,
+// or 0 if it isn't installed. Loaded VRAM is roughly this plus the KV cache.
+func ModelSize(ctx context.Context, ollamaURL, model string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", ollamaURL+"/api/tags", nil)
+	if err != nil {
+		return 0, err
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("ollama tags status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Models []LoadedLLM `json:"models"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	for _, m := range result.Models {
+		if m.Name == model || m.Name == model+":latest" {
+			return m.Size, nil
+		}
+	}
+	return 0, nil
+}
+
 // LoadedLLM describes a model currently loaded in Ollama.
 type LoadedLLM struct {
 	Name string `json:"name"`
@@ -136,13 +169,25 @@ func UnloadAllLLMs(ctx context.Context, ollamaURL string) error {
 	return nil
 }
 
-// PreloadLLM triggers Ollama to load a model into GPU VRAM.
+// PreloadLLM triggers Ollama to load a model into GPU VRAM and keep it there.
 func PreloadLLM(ctx context.Context, ollamaURL, model string) error {
-	body, err := json.Marshal(map[string]any{"model": model, "keep_alive": -1})
+	return KeepAlive(ctx, ollamaURL, model, -1)
+}
+
+// KeepAlive loads a model if needed and sets how long Ollama keeps it in VRAM
+// after its last request: a duration string such as "30m", or -1 for
+// indefinitely. Embedding models are loaded through /api/embed, since they
+// can't serve /api/generate.
+func KeepAlive(ctx context.Context, ollamaURL, model string, keepAlive any) error {
+	endpoint := "/api/generate"
+	if isEmbeddingModel(model) {
+		endpoint = "/api/embed"
+	}
+	body, err := json.Marshal(map[string]any{"model": model, "keep_alive": keepAlive})
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequestWithContext(ctx, "POST", ollamaURL+"/api/generate", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", ollamaURL+endpoint, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
@@ -161,3 +206,19 @@ func PreloadLLM(ctx context.Context, ollamaURL, model string) error {
 	}
 	return nil
 }
+
+// PinModels preloads each model with an indefinite keep-alive so the first
+// call doesn't pay a cold load. Failures are logged and skipped.
+func PinModels(ctx context.Context, ollamaURL string, models []string) {
+	for _, m := range models {
+		if err := PreloadLLM(ctx, ollamaURL, m); err != nil {
+			slog.Warn("pin model", "model", m, "error", err)
+			continue
+		}
+		slog.Info("model pinned", "model", m)
+	}
+}
+
+func isEmbeddingModel(model string) bool {
+	return strings.Contains(model, "embed")
+}
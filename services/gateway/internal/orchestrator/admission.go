@@ -0,0 +1,258 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/models"
+)
+
+const (
+	// AdmissionRefuse rejects a start or preload that would not fit in VRAM.
+	AdmissionRefuse = "refuse"
+	// AdmissionEvict frees VRAM by stopping services and unloading models in
+	// EvictPriority order, and only refuses when that isn't enough.
+	AdmissionEvict = "evict"
+	// AdmissionPriority, in scheduler.go, picks the victims itself.
+
+	// defaultHeadroomMB is the VRAM kept free for CUDA contexts and KV cache growth.
+	defaultHeadroomMB = 512
+
+	bytesPerMB = 1 << 20
+)
+
+// AdmissionConfig maps what each service or model is expected to occupy in
+// VRAM and what to do when it won't fit.
+type AdmissionConfig struct {
+	Policy     string `json:"policy"`      // AdmissionRefuse (default), AdmissionEvict, or AdmissionPriority
+	HeadroomMB int    `json:"headroom_mb"` // 0 selects defaultHeadroomMB
+	// EstimatesMB is keyed by service name, or by model name for a specific
+	// whisper model file or Ollama model. Ollama models without an entry fall
+	// back to their size on disk.
+	EstimatesMB map[string]int `json:"estimates_mb"`
+	// EvictPriority lists service and Ollama model names, evicted first to last.
+	EvictPriority []string `json:"evict_priority"`
+	// StagePriority lists categories ("tts", "asr", "llm") in the order the
+	// priority policy evicts them (empty = tts, asr, llm).
+	StagePriority []string `json:"stage_priority"`
+	// ActiveWindowS is how long after its last request a service or model
+	// is in use and safe from the priority policy (0 = 120).
+	ActiveWindowS int `json:"active_window_s"`
+}
+
+// AdmissionError is returned when a start or preload would exceed VRAM.
+// It marshals to the body of the 409 the gateway returns.
+type AdmissionError struct {
+	Target     string          `json:"target"`
+	RequiredMB int             `json:"required_mb"`
+	FreeMB     int             `json:"free_mb"`
+	HeadroomMB int             `json:"headroom_mb"`
+	Evicted    []string        `json:"evicted,omitempty"`
+	GPU        json.RawMessage `json:"gpu"`
+}
+
+func (e *AdmissionError) Error() string {
+	return fmt.Sprintf("insufficient VRAM for %s: need %d MB (+%d MB headroom), %d MB free", e.Target, e.RequiredMB, e.HeadroomMB, e.FreeMB)
+}
+
+// Admission checks the GPU snapshot before a service start or model preload.
+// A nil Admission, or a snapshot without a VRAM total (no control server),
+// admits everything.
+type Admission struct {
+	cfg       AdmissionConfig
+	ollamaURL string
+	snapshot  func() []byte
+	mgr       *HTTPControlManager
+	sched     schedule
+}
+
+// NewAdmission creates an admission check. snapshot returns the current GPU
+// JSON ({"vram_total_mb", "vram_used_mb", "processes"}), or nil if unknown.
+func NewAdmission(cfg AdmissionConfig, ollamaURL string, snapshot func() []byte) *Admission {
+	if cfg.HeadroomMB <= 0 {
+		cfg.HeadroomMB = defaultHeadroomMB
+	}
+	return &Admission{cfg: cfg, ollamaURL: ollamaURL, snapshot: snapshot}
+}
+
+// SetAdmission makes Start (and so EnsureRunning) check VRAM first.
+func (h *HTTPControlManager) SetAdmission(a *Admission) {
+	h.admission = a
+	if a != nil {
+		a.mgr = h
+	}
+}
+
+// AdmitService checks that starting name fits. params is the raw start
+// query; its model= value selects a per-model estimate and its device=
+// value checks that GPU instead of the primary one. A service that is
+// already running is admitted, since the control server won't restart it.
+func (a *Admission) AdmitService(ctx context.Context, name string, params ...string) error {
+	if a == nil {
+		return nil
+	}
+	if info, _ := a.mgr.Status(ctx, name); info != nil && info.Status != StatusStopped {
+		return nil
+	}
+	estimate, device := a.cfg.EstimatesMB[name], ""
+	if len(params) > 0 {
+		q, _ := url.ParseQuery(params[0])
+		if mb, ok := a.cfg.EstimatesMB[q.Get("model")]; ok {
+			estimate = mb
+		}
+		device = q.Get("device")
+	}
+	return a.admit(ctx, name, device, estimate)
+}
+
+// AdmitSwap checks that a second instance of name running model fits
+// beside the one already running.
+func (a *Admission) AdmitSwap(ctx context.Context, name, model string) error {
+	if a == nil {
+		return nil
+	}
+	estimate, ok := a.cfg.EstimatesMB[model]
+	if !ok {
+		estimate = a.cfg.EstimatesMB[name]
+	}
+	return a.admit(ctx, name, "", estimate)
+}
+
+// AdmitModel checks that loading an Ollama model fits. Already-loaded
+// models are always admitted.
+func (a *Admission) AdmitModel(ctx context.Context, model string) error {
+	if a == nil {
+		return nil
+	}
+	loaded, _ := models.ListLoadedLLMs(ctx, a.ollamaURL)
+	if slices.ContainsFunc(loaded, func(m models.LoadedLLM) bool { return m.Name == model }) {
+		return nil
+	}
+	estimate, ok := a.cfg.EstimatesMB[model]
+	if !ok {
+		size, err := models.ModelSize(ctx, a.ollamaURL, model)
+		if err != nil {
+			slog.Warn("admission model size", "model", model, "error", err)
+		}
+		estimate = int(size / bytesPerMB)
+	}
+	return a.admit(ctx, model, "", estimate)
+}
+
+type gpuUsage struct {
+	TotalMB int `json:"vram_total_mb"`
+	UsedMB  int `json:"vram_used_mb"`
+}
+
+// admit checks estimateMB against device ("card1" or "1"; "" = primary GPU).
+func (a *Admission) admit(ctx context.Context, target, device string, estimateMB int) error {
+	if estimateMB <= 0 {
+		return nil
+	}
+	raw, usage := a.usage(device)
+	if usage.TotalMB == 0 || a.fits(usage, estimateMB) {
+		return nil
+	}
+
+	var evicted []string
+	for _, victim := range a.evictOrder(ctx, target) {
+		if victim.Name == target || !a.evict(ctx, victim.Name) {
+			continue
+		}
+		evicted = append(evicted, victim.Name)
+		raw, usage = a.usage(device)
+		a.decide(Decision{Target: target, Action: "evict", Victim: victim.Name, Category: victim.Category, RequiredMB: estimateMB, FreeMB: usage.TotalMB - usage.UsedMB})
+		if a.fits(usage, estimateMB) {
+			slog.Info("vram admission evicted", "target", target, "evicted", evicted)
+			a.decide(Decision{Target: target, Action: "admit", RequiredMB: estimateMB, FreeMB: usage.TotalMB - usage.UsedMB})
+			return nil
+		}
+	}
+
+	a.decide(Decision{Target: target, Action: "refuse", RequiredMB: estimateMB, FreeMB: usage.TotalMB - usage.UsedMB})
+	return &AdmissionError{
+		Target:     target,
+		RequiredMB: estimateMB,
+		FreeMB:     usage.TotalMB - usage.UsedMB,
+		HeadroomMB: a.cfg.HeadroomMB,
+		Evicted:    evicted,
+		GPU:        raw,
+	}
+}
+
+// evictOrder is what the policy may evict to make room for target: the
+// configured list, the scheduler's idle residents, or nothing.
+func (a *Admission) evictOrder(ctx context.Context, target string) []Resident {
+	if a.cfg.Policy == AdmissionPriority {
+		return a.victims(ctx, target)
+	}
+	if a.cfg.Policy != AdmissionEvict {
+		return nil
+	}
+	order := make([]Resident, len(a.cfg.EvictPriority))
+	for i, name := range a.cfg.EvictPriority {
+		order[i] = Resident{Name: name}
+	}
+	return order
+}
+
+func (a *Admission) usage(device string) (json.RawMessage, gpuUsage) {
+	var snap struct {
+		gpuUsage
+		Devices []struct {
+			ID    string `json:"id"`
+			Index int    `json:"index"`
+			gpuUsage
+		} `json:"devices"`
+	}
+	raw := a.snapshot()
+	if raw != nil {
+		_ = json.Unmarshal(raw, &snap)
+	}
+	if device == "" {
+		return raw, snap.gpuUsage
+	}
+	for _, d := range snap.Devices {
+		if d.ID == device || strconv.Itoa(d.Index) == device {
+			return raw, d.gpuUsage
+		}
+	}
+	return raw, gpuUsage{} // unknown device: let the control server reject it
+}
+
+func (a *Admission) fits(u gpuUsage, estimateMB int) bool {
+	return u.UsedMB+estimateMB+a.cfg.HeadroomMB <= u.TotalMB
+}
+
+// evict stops a running managed service or unloads a loaded Ollama model.
+// Returns false when victim held no VRAM.
+func (a *Admission) evict(ctx context.Context, victim string) bool {
+	if a.mgr.Manages(victim) {
+		info, _ := a.mgr.Status(ctx, victim)
+		if info == nil || info.Status == StatusStopped {
+			return false
+		}
+		slog.Info("vram admission stopping service", "name", victim)
+		if _, err := a.mgr.Stop(ctx, victim); err != nil {
+			slog.Warn("vram admission stop", "name", victim, "error", err)
+			return false
+		}
+		return true
+	}
+
+	loaded, _ := models.ListLoadedLLMs(ctx, a.ollamaURL)
+	if !slices.ContainsFunc(loaded, func(m models.LoadedLLM) bool { return m.Name == victim }) {
+		return false
+	}
+	slog.Info("vram admission unloading model", "model", victim)
+	if err := models.UnloadLLM(ctx, a.ollamaURL, victim); err != nil {
+		slog.Warn("vram admission unload", "model", victim, "error", err)
+		return false
+	}
+	return true
+}
@@ -5,10 +5,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
 )
 
+// healthPollInterval is how often EnsureRunning re-probes a starting service.
+const healthPollInterval = 500 * time.Millisecond
+
 // ServiceStatus represents the lifecycle state of a managed service.
 type ServiceStatus string
 
@@ -23,17 +31,19 @@ type ServiceInfo struct {
 	Name     string        `json:"name"`
 	Status   ServiceStatus `json:"status"`
 	Category string        `json:"category"`
+	Model    string        `json:"model,omitempty"` // active model file, for services that report one
 }
 
 // ServiceMeta holds static metadata for a managed service.
 type ServiceMeta struct {
-	Category   string // "tts" or "stt"
+	Category   string // "tts" or "asr"
 	HealthURL  string // URL to probe for readiness
 	ControlURL string // URL of HTTP control server for start/stop/status
 }
 
 // Registry is a whitelist of services the orchestrator may manage.
 type Registry struct {
+	mu       sync.RWMutex
 	services map[string]ServiceMeta
 }
 
@@ -44,12 +54,27 @@ func NewRegistry(services map[string]ServiceMeta) *Registry {
 
 // Lookup returns metadata for a service, or false if not whitelisted.
 func (r *Registry) Lookup(name string) (ServiceMeta, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	m, ok := r.services[name]
 	return m, ok
 }
 
+// setHealthURL repoints a service's health probe, e.g. after a swap moved
+// it to another port.
+func (r *Registry) setHealthURL(name, url string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.services[name]; ok {
+		m.HealthURL = url
+		r.services[name] = m
+	}
+}
+
 // Names returns all registered service names.
 func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	names := make([]string, 0, len(r.services))
 	for k := range r.services {
 		names = append(names, k)
@@ -61,6 +86,11 @@ func (r *Registry) Names() []string {
 type HTTPControlManager struct {
 	httpClient *http.Client
 	registry   *Registry
+	admission  *Admission
+
+	// swapMu serializes model switches so concurrent sessions asking for
+	// different models don't interleave stop and start calls.
+	swapMu sync.Mutex
 }
 
 // NewHTTPControlManager creates a manager backed by HTTP control endpoints.
@@ -86,11 +116,15 @@ func (h *HTTPControlManager) resolveControlURL(name string) (string, error) {
 // Start launches a service via its HTTP control server.
 // Returns the raw GPU JSON from the control server response.
 // Optional params are appended as query string (e.g. "model=ggml-large-v3.bin").
+// With admission set, a start that won't fit in VRAM fails with *AdmissionError.
 func (h *HTTPControlManager) Start(ctx context.Context, name string, params ...string) (json.RawMessage, error) {
 	controlURL, err := h.resolveControlURL(name)
 	if err != nil {
 		return nil, err
 	}
+	if err = h.admission.AdmitService(ctx, name, params...); err != nil {
+		return nil, err
+	}
 	url := controlURL + "/start"
 	if len(params) > 0 {
 		url += "?" + params[0]
@@ -164,7 +198,8 @@ func (h *HTTPControlManager) Status(ctx context.Context, name string) (*ServiceI
 	defer resp.Body.Close()
 
 	var result struct {
-		Running bool `json:"running"`
+		Running bool   `json:"running"`
+		Model   string `json:"model"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return info, nil
@@ -172,6 +207,7 @@ func (h *HTTPControlManager) Status(ctx context.Context, name string) (*ServiceI
 	if !result.Running {
 		return info, nil
 	}
+	info.Model = result.Model
 
 	info.Status = StatusRunning
 
@@ -184,6 +220,82 @@ func (h *HTTPControlManager) Status(ctx context.Context, name string) (*ServiceI
 	return info, nil
 }
 
+// Manages reports whether name is a service in the registry.
+func (h *HTTPControlManager) Manages(name string) bool {
+	_, ok := h.registry.Lookup(name)
+	return ok
+}
+
+// EnsureRunning starts a service if it is stopped, then waits until its
+// health probe passes (when it has one) or ctx is done. Returns the GPU JSON
+// from the start call, or nil if the service was already running.
+func (h *HTTPControlManager) EnsureRunning(ctx context.Context, name string) (json.RawMessage, error) {
+	info, err := h.Status(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Status == StatusHealthy {
+		return nil, nil
+	}
+
+	var gpu json.RawMessage
+	if info.Status == StatusStopped {
+		slog.Info("auto-starting service", "name", name)
+		if gpu, err = h.Start(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	return gpu, h.waitHealthy(ctx, name)
+}
+
+// EnsureModel is EnsureRunning for a specific model: a service running a
+// different model is restarted on this one. An empty model accepts whatever
+// is loaded. The switch interrupts other sessions on the same service.
+func (h *HTTPControlManager) EnsureModel(ctx context.Context, name, model string) (json.RawMessage, error) {
+	if model == "" {
+		return h.EnsureRunning(ctx, name)
+	}
+	h.swapMu.Lock()
+	defer h.swapMu.Unlock()
+
+	info, err := h.Status(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Status != StatusStopped && info.Model == model {
+		return nil, h.waitHealthy(ctx, name)
+	}
+	if info.Status != StatusStopped {
+		slog.Info("switching service model", "name", name, "from", info.Model, "to", model)
+		if _, err = h.Stop(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	gpu, err := h.Start(ctx, name, "model="+url.QueryEscape(model))
+	if err != nil {
+		return nil, err
+	}
+	return gpu, h.waitHealthy(ctx, name)
+}
+
+// waitHealthy polls a service's health URL until it responds 200 or ctx is done.
+func (h *HTTPControlManager) waitHealthy(ctx context.Context, name string) error {
+	meta, _ := h.registry.Lookup(name)
+	if meta.HealthURL == "" {
+		return nil
+	}
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+	for !probeHealth(ctx, h.httpClient, meta.HealthURL) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for %s health: %w", name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
 // StatusAll returns the status of every registered service.
 func (h *HTTPControlManager) StatusAll(ctx context.Context) ([]ServiceInfo, error) {
 	names := h.registry.Names()
@@ -195,6 +307,24 @@ func (h *HTTPControlManager) StatusAll(ctx context.Context) ([]ServiceInfo, erro
 	return results, nil
 }
 
+// ReportMetrics sets the service-up gauge for every registered service. A
+// service without a health URL counts as up while its process runs.
+func (h *HTTPControlManager) ReportMetrics(ctx context.Context) {
+	for _, name := range h.registry.Names() {
+		info, _ := h.Status(ctx, name)
+		meta, _ := h.registry.Lookup(name)
+		up := info.Status == StatusHealthy || (info.Status == StatusRunning && meta.HealthURL == "")
+		metrics.ServiceUp.WithLabelValues(name).Set(boolGauge(up))
+	}
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 // probeHealth sends a GET to the given URL and returns true if the response is 200 OK.
 func probeHealth(ctx context.Context, client *http.Client, url string) bool {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
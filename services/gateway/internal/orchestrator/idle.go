@@ -0,0 +1,125 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// maxIdleCheckInterval bounds how often the watchdog polls service status.
+	maxIdleCheckInterval = 30 * time.Second
+
+	// idleStopTimeout caps a single watchdog stop call.
+	idleStopTimeout = 30 * time.Second
+)
+
+// IdleWatchdog stops managed services that have received no traffic for a
+// configured duration, releasing the VRAM they pin. Traffic is reported via
+// Touch; a service found running without any recorded traffic (e.g. started
+// by hand) gets a full timeout from when the watchdog first sees it.
+// All methods are nil-safe so callers don't need to check whether idle
+// shutdown is enabled.
+type IdleWatchdog struct {
+	mgr      *HTTPControlManager
+	timeout  time.Duration
+	onStop   func(name string, gpu json.RawMessage)
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+}
+
+// NewIdleWatchdog creates a watchdog that stops services idle for timeout.
+// onStop is called after each idle stop with the control server's GPU JSON.
+// Returns nil (disabled) when timeout <= 0.
+func NewIdleWatchdog(mgr *HTTPControlManager, timeout time.Duration, onStop func(name string, gpu json.RawMessage)) *IdleWatchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	return &IdleWatchdog{
+		mgr:      mgr,
+		timeout:  timeout,
+		onStop:   onStop,
+		lastUsed: make(map[string]time.Time),
+	}
+}
+
+// Touch records traffic for a service. Names not in the registry are ignored,
+// so it can be called with any engine name.
+func (w *IdleWatchdog) Touch(name string) {
+	if w == nil {
+		return
+	}
+	if _, ok := w.mgr.registry.Lookup(name); !ok {
+		return
+	}
+	w.mu.Lock()
+	w.lastUsed[name] = time.Now()
+	w.mu.Unlock()
+}
+
+// Run polls service status until ctx is cancelled.
+func (w *IdleWatchdog) Run(ctx context.Context) {
+	if w == nil {
+		return
+	}
+	ticker := time.NewTicker(min(w.timeout/4, maxIdleCheckInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(ctx)
+		}
+	}
+}
+
+func (w *IdleWatchdog) check(ctx context.Context) {
+	for _, name := range w.mgr.registry.Names() {
+		info, err := w.mgr.Status(ctx, name)
+		if err != nil || info.Status == StatusStopped {
+			w.forget(name)
+			continue
+		}
+		if w.idleFor(name) < w.timeout {
+			continue
+		}
+		w.stop(ctx, name)
+	}
+}
+
+// idleFor returns how long a running service has gone without traffic,
+// starting its clock now if it has none recorded.
+func (w *IdleWatchdog) idleFor(name string) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	last, ok := w.lastUsed[name]
+	if !ok {
+		w.lastUsed[name] = time.Now()
+		return 0
+	}
+	return time.Since(last)
+}
+
+func (w *IdleWatchdog) forget(name string) {
+	w.mu.Lock()
+	delete(w.lastUsed, name)
+	w.mu.Unlock()
+}
+
+func (w *IdleWatchdog) stop(ctx context.Context, name string) {
+	slog.Info("stopping idle service", "name", name, "idle_timeout", w.timeout)
+	stopCtx, cancel := context.WithTimeout(ctx, idleStopTimeout)
+	defer cancel()
+	gpu, err := w.mgr.Stop(stopCtx, name)
+	if err != nil {
+		slog.Warn("idle stop", "name", name, "error", err)
+		return
+	}
+	w.forget(name)
+	if w.onStop != nil {
+		w.onStop(name, gpu)
+	}
+}
@@ -0,0 +1,184 @@
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/models"
+)
+
+const (
+	// AdmissionPriority frees VRAM by evicting idle services and models,
+	// cheapest stage first (StagePriority) and least recently used within a
+	// stage. Anything in use is never evicted, nor is the LLM model last used.
+	AdmissionPriority = "priority"
+
+	// CategoryLLM is the category of Ollama models; services carry theirs
+	// ("tts" or "asr") in the registry.
+	CategoryLLM = "llm"
+
+	// defaultActiveWindow is how long after its last request a service or
+	// model counts as in use.
+	defaultActiveWindow = 2 * time.Minute
+
+	// maxDecisions is how many recent decisions Decisions returns.
+	maxDecisions = 50
+)
+
+// defaultStagePriority evicts TTS first: a voice reloads in seconds, while
+// an LLM reload stalls every call's next reply.
+var defaultStagePriority = []string{"tts", "asr", CategoryLLM}
+
+// Resident is a running service or loaded Ollama model, as the scheduler
+// sees it.
+type Resident struct {
+	Name     string    `json:"name"`
+	Category string    `json:"category"` // "tts", "asr", or "llm"
+	LastUsed time.Time `json:"last_used,omitzero"`
+	// Active residents are never evicted: used within the active window,
+	// or the LLM model last used.
+	Active bool `json:"active"`
+}
+
+// Decision is one step of an admission that found VRAM short: a victim
+// evicted, then the target admitted or refused.
+type Decision struct {
+	Time       time.Time `json:"time"`
+	Target     string    `json:"target"`
+	Action     string    `json:"action"`           // "evict", "admit", or "refuse"
+	Victim     string    `json:"victim,omitempty"` // evict: the service or model stopped
+	Category   string    `json:"category,omitempty"`
+	RequiredMB int       `json:"required_mb"`
+	FreeMB     int       `json:"free_mb"` // after the step
+}
+
+// schedule tracks when each service and model last served a request, and
+// the decisions made because of it.
+type schedule struct {
+	mu        sync.Mutex
+	lastUsed  map[string]time.Time
+	lastLLM   string
+	decisions []Decision
+	onDecide  func(Decision)
+}
+
+// Touch records a request served by a service or, with category "llm", an
+// Ollama model, which becomes the active LLM. Nil-safe, like
+// IdleWatchdog.Touch.
+func (a *Admission) Touch(name, category string) {
+	if a == nil || name == "" {
+		return
+	}
+	s := &a.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastUsed == nil {
+		s.lastUsed = map[string]time.Time{}
+	}
+	s.lastUsed[name] = time.Now()
+	if category == CategoryLLM {
+		s.lastLLM = name
+	}
+}
+
+// OnDecision registers a hook called with every decision, e.g. to stream
+// them to the GPU panel. Set before serving.
+func (a *Admission) OnDecision(fn func(Decision)) {
+	if a != nil {
+		a.sched.onDecide = fn
+	}
+}
+
+// Decisions returns the most recent decisions, oldest first.
+func (a *Admission) Decisions() []Decision {
+	if a == nil {
+		return nil
+	}
+	a.sched.mu.Lock()
+	defer a.sched.mu.Unlock()
+	return append([]Decision{}, a.sched.decisions...)
+}
+
+func (a *Admission) decide(d Decision) {
+	d.Time = time.Now()
+	s := &a.sched
+	s.mu.Lock()
+	s.decisions = append(s.decisions, d)
+	if len(s.decisions) > maxDecisions {
+		s.decisions = s.decisions[len(s.decisions)-maxDecisions:]
+	}
+	s.mu.Unlock()
+	slog.Info("vram schedule", "target", d.Target, "action", d.Action, "victim", d.Victim, "required_mb", d.RequiredMB, "free_mb", d.FreeMB)
+	if s.onDecide != nil {
+		s.onDecide(d)
+	}
+}
+
+// Residents lists the running managed services and loaded Ollama models,
+// in the order the priority policy would evict them; active ones last.
+func (a *Admission) Residents(ctx context.Context) []Resident {
+	if a == nil {
+		return nil
+	}
+	rs := []Resident{}
+	for _, name := range a.mgr.registry.Names() {
+		if info, _ := a.mgr.Status(ctx, name); info == nil || info.Status == StatusStopped {
+			continue
+		}
+		meta, _ := a.mgr.registry.Lookup(name)
+		rs = append(rs, Resident{Name: name, Category: meta.Category})
+	}
+	loaded, _ := models.ListLoadedLLMs(ctx, a.ollamaURL)
+	for _, m := range loaded {
+		rs = append(rs, Resident{Name: m.Name, Category: CategoryLLM})
+	}
+
+	window := time.Duration(a.cfg.ActiveWindowS) * time.Second
+	if window <= 0 {
+		window = defaultActiveWindow
+	}
+	a.sched.mu.Lock()
+	for i := range rs {
+		r := &rs[i]
+		r.LastUsed = a.sched.lastUsed[r.Name]
+		r.Active = time.Since(r.LastUsed) < window || (r.Category == CategoryLLM && r.Name == a.sched.lastLLM)
+	}
+	a.sched.mu.Unlock()
+
+	stages := a.cfg.StagePriority
+	if len(stages) == 0 {
+		stages = defaultStagePriority
+	}
+	rank := func(category string) int {
+		if i := slices.Index(stages, category); i >= 0 {
+			return i
+		}
+		return len(stages)
+	}
+	slices.SortStableFunc(rs, func(x, y Resident) int {
+		if x.Active != y.Active {
+			if x.Active {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Or(cmp.Compare(rank(x.Category), rank(y.Category)), x.LastUsed.Compare(y.LastUsed))
+	})
+	return rs
+}
+
+// victims are the residents the priority policy may evict for target, in
+// eviction order.
+func (a *Admission) victims(ctx context.Context, target string) []Resident {
+	var out []Resident
+	for _, r := range a.Residents(ctx) {
+		if !r.Active && r.Name != target {
+			out = append(out, r)
+		}
+	}
+	return out
+}
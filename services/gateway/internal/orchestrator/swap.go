@@ -0,0 +1,105 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ErrSwapUnsupported means the control server can't run a second instance
+// of the service (no alt_port) or the service isn't running, so a model
+// change needs a plain stop and start.
+var ErrSwapUnsupported = errors.New("model swap unsupported")
+
+// SwapResult describes a completed swap. The new instance is serving at
+// URL; the old one is still running on PreviousPort until StopInstance.
+type SwapResult struct {
+	URL          string          `json:"url"`
+	Port         int             `json:"port"`
+	PreviousPort int             `json:"previous_port"`
+	GPU          json.RawMessage `json:"gpu,omitempty"`
+}
+
+// Swap starts model as a second instance of name beside the running one and
+// points the service's health probe at it. The caller moves traffic to
+// SwapResult.URL, then stops the old instance with StopInstance.
+func (h *HTTPControlManager) Swap(ctx context.Context, name, model string) (*SwapResult, error) {
+	controlURL, err := h.resolveControlURL(name)
+	if err != nil {
+		return nil, err
+	}
+	h.swapMu.Lock()
+	defer h.swapMu.Unlock()
+
+	// both instances hold VRAM until the old one stops
+	if err = h.admission.AdmitSwap(ctx, name, model); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", controlURL+"/swap?model="+url.QueryEscape(model), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.swapClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("swap %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotImplemented || resp.StatusCode == http.StatusConflict {
+		return nil, ErrSwapUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("swap %s: status %d: %s", name, resp.StatusCode, body)
+	}
+
+	var res SwapResult
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("decode swap %s: %w", name, err)
+	}
+	meta, _ := h.registry.Lookup(name)
+	if res.URL, err = withPort(meta.HealthURL, res.Port); err != nil {
+		return nil, err
+	}
+	h.registry.setHealthURL(name, res.URL)
+	return &res, nil
+}
+
+// StopInstance stops only the instance of name listening on port.
+func (h *HTTPControlManager) StopInstance(ctx context.Context, name string, port int) (json.RawMessage, error) {
+	controlURL, err := h.resolveControlURL(name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", controlURL+"/stop?port="+strconv.Itoa(port), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("stop %s:%d: %w", name, port, err)
+	}
+	defer resp.Body.Close()
+	return extractGPU(resp)
+}
+
+// swapClient has no timeout of its own: a swap waits for the new instance
+// to load its model, which can outlast httpClient's, so ctx bounds it.
+func (h *HTTPControlManager) swapClient() *http.Client {
+	return &http.Client{Transport: h.httpClient.Transport}
+}
+
+// withPort returns raw with its port replaced.
+func withPort(raw string, port int) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("parse service url %q: %w", raw, err)
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+	return u.String(), nil
+}
@@ -8,6 +8,9 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
@@ -15,7 +18,10 @@ import (
 
 // ASROptions holds per-call ASR tuning parameters.
 type ASROptions struct {
-	Prompt string
+	Prompt   string
+	Diarize  bool   // request tinydiarize speaker turns (needs a -tdrz model)
+	Language string // spoken language code, "auto" to detect, "" for the server default
+	Model    string // model for backends that pick one per request ("" = the loaded model)
 }
 
 // ASRTranscriber produces transcriptions from audio samples.
@@ -25,9 +31,12 @@ type ASRTranscriber interface {
 
 // ASRResult holds the transcription output.
 type ASRResult struct {
-	Text         string  `json:"text"`
-	LatencyMs    float64 `json:"latency_ms"`
-	NoSpeechProb float64 `json:"no_speech_prob"`
+	Text         string           `json:"text"`
+	LatencyMs    float64          `json:"latency_ms"`
+	NoSpeechProb float64          `json:"no_speech_prob"`
+	Speakers     []SpeakerSegment `json:"speakers,omitempty"`
+	Language     string           `json:"language,omitempty"` // detected or requested language code
+	Degraded     *EngineDegraded  `json:"degraded,omitempty"` // set when the requested engine's breaker was open
 }
 
 // ASRRouter dispatches to the correct ASR backend based on engine name.
@@ -43,18 +52,26 @@ func NewASRRouter(backends map[string]ASRTranscriber, fallback string) *ASRRoute
 
 // Transcribe routes to the correct backend and transcribes the audio.
 func (r *ASRRouter) Transcribe(ctx context.Context, samples []float32, engine string, opts ASROptions) (*ASRResult, error) {
-	backend, err := r.Route(engine)
+	backend, name, deg, err := r.acquire(engine)
 	if err != nil {
 		return nil, err
 	}
-	return backend.Transcribe(ctx, samples, opts)
+	if deg != nil {
+		opts.Model = "" // the override is for the engine that was skipped
+	}
+	result, err := backend.Transcribe(ctx, samples, opts)
+	r.release(ctx, name, err)
+	if result != nil {
+		result.Degraded = deg
+	}
+	return result, err
 }
 
 // MultipartASRClient sends audio as multipart WAV to any whisper-compatible HTTP endpoint.
 // Different backends only vary by endpoint path (e.g. /inference for whisper.cpp,
 // /transcribe for ROCm whisper). The label field is used in error messages and logs.
 type MultipartASRClient struct {
-	url           string
+	url           atomic.Pointer[string] // swapped by SetURL during a model hot-swap
 	endpoint      string
 	label         string
 	defaultPrompt string // fallback prompt when ASROptions.Prompt is empty
@@ -63,13 +80,25 @@ type MultipartASRClient struct {
 
 // NewASRClient creates a client for whisper.cpp (/inference endpoint).
 func NewASRClient(url string, poolSize int, prompt string) *MultipartASRClient {
-	return &MultipartASRClient{
-		url:           url,
+	c := &MultipartASRClient{
 		endpoint:      "/inference",
 		label:         "whisper",
 		defaultPrompt: prompt,
-		client:        NewPooledHTTPClient(poolSize, 30*time.Second),
+		client:        NewPooledHTTPClient("asr", "whisper", poolSize, 30*time.Second),
 	}
+	c.url.Store(&url)
+	return c
+}
+
+// URL returns the server base URL requests currently go to.
+func (c *MultipartASRClient) URL() string {
+	return *c.url.Load()
+}
+
+// SetURL points new requests at another server; in-flight ones finish
+// against the old one.
+func (c *MultipartASRClient) SetURL(url string) {
+	c.url.Store(&url)
 }
 
 // Transcribe sends float32 audio samples (16kHz mono) as multipart WAV and returns the transcript.
@@ -81,12 +110,12 @@ func (c *MultipartASRClient) Transcribe(ctx context.Context, samples []float32,
 		prompt = opts.Prompt
 	}
 
-	body, contentType, err := buildMultipartAudio(samples, prompt)
+	body, contentType, err := buildMultipartAudio(samples, prompt, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", c.url+c.endpoint, body)
+	req, err := body.request(ctx, c.URL()+c.endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("create %s request: %w", c.label, err)
 	}
@@ -110,44 +139,147 @@ func (c *MultipartASRClient) Transcribe(ctx context.Context, samples []float32,
 
 	latency := time.Since(start)
 
-	return &ASRResult{
+	asrResult := &ASRResult{
 		Text:         result.Text,
 		LatencyMs:    float64(latency.Milliseconds()),
 		NoSpeechProb: result.NoSpeechProb,
-	}, nil
+		Language:     normalizeLanguage(result.Language),
+	}
+	if asrResult.Language == "" && opts.Language != "auto" {
+		asrResult.Language = opts.Language
+	}
+	if opts.Diarize {
+		asrResult.Speakers = diarizeSegments(result.Segments, result.Text)
+		asrResult.Text = strings.ReplaceAll(result.Text, speakerTurnMarker, "")
+	}
+	return asrResult, nil
 }
 
 type whisperResponse struct {
-	Text         string  `json:"text"`
-	NoSpeechProb float64 `json:"no_speech_prob"`
+	Text         string           `json:"text"`
+	NoSpeechProb float64          `json:"no_speech_prob"`
+	Segments     []whisperSegment `json:"segments,omitempty"`
+	Language     string           `json:"language,omitempty"`
+}
+
+// whisperSegment is one segment of a verbose_json response.
+type whisperSegment struct {
+	Text            string `json:"text"`
+	SpeakerTurnNext bool   `json:"speaker_turn_next"`
 }
 
 // --- shared helpers ---
 
-func buildMultipartAudio(samples []float32, prompt string) (*bytes.Buffer, string, error) {
-	wavData := audio.SamplesToWAV(samples, 16000)
+// maxPooledBody caps the request bodies kept for reuse: a turn's utterance
+// is well under this, a long uploaded snippet isn't worth pinning.
+const maxPooledBody = 4 << 20
 
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
+// bodyPool holds multipart upload buffers, so each turn's WAV upload
+// reuses a grown buffer instead of growing a fresh one.
+var bodyPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
 
-	part, err := writer.CreateFormFile("file", "audio.wav")
+// pooledBody is a request body from bodyPool. The transport closes a
+// request's body once it is done with it, which returns the buffer.
+type pooledBody struct {
+	*bytes.Buffer
+	once sync.Once
+}
+
+func newPooledBody() *pooledBody {
+	return &pooledBody{Buffer: bodyPool.Get().(*bytes.Buffer)}
+}
+
+func (b *pooledBody) Close() error {
+	b.once.Do(func() {
+		if b.Cap() > maxPooledBody {
+			return
+		}
+		b.Reset()
+		bodyPool.Put(b.Buffer)
+	})
+	return nil
+}
+
+// request creates a POST of the body to url. The length is set by hand:
+// http.NewRequest only knows it for its own reader types.
+func (b *pooledBody) request(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, b)
 	if err != nil {
-		return nil, "", fmt.Errorf("create form file: %w", err)
+		b.Close()
+		return nil, err
 	}
+	req.ContentLength = int64(b.Len())
+	return req, nil
+}
 
-	if _, err = part.Write(wavData); err != nil {
-		return nil, "", fmt.Errorf("write wav data: %w", err)
+// writeWAVPart adds samples to writer as a 16 kHz WAV file part.
+func writeWAVPart(writer *multipart.Writer, samples []float32) error {
+	part, err := writer.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return fmt.Errorf("create form file: %w", err)
 	}
+	if err = audio.WriteWAV(part, samples, 16000); err != nil {
+		return fmt.Errorf("write wav data: %w", err)
+	}
+	return nil
+}
 
-	if prompt != "" {
-		if err = writer.WriteField("initial_prompt", prompt); err != nil {
-			return nil, "", fmt.Errorf("write prompt field: %w", err)
+// multipartBody builds a multipart request body, with the parts write
+// adds, in a buffer from bodyPool.
+func multipartBody(write func(*multipart.Writer) error) (*pooledBody, string, error) {
+	body := newPooledBody()
+	writer := multipart.NewWriter(body)
+	err := write(writer)
+	if err == nil {
+		if err = writer.Close(); err != nil {
+			err = fmt.Errorf("close writer: %w", err)
 		}
 	}
-
-	if err = writer.Close(); err != nil {
-		return nil, "", fmt.Errorf("close writer: %w", err)
+	if err != nil {
+		body.Close()
+		return nil, "", err
 	}
+	return body, writer.FormDataContentType(), nil
+}
+
+func buildMultipartAudio(samples []float32, prompt string, opts ASROptions) (*pooledBody, string, error) {
+	return multipartBody(func(writer *multipart.Writer) error {
+		err := writeWAVPart(writer, samples)
+		if err != nil {
+			return err
+		}
 
-	return &body, writer.FormDataContentType(), nil
+		if prompt != "" {
+			if err = writer.WriteField("initial_prompt", prompt); err != nil {
+				return fmt.Errorf("write prompt field: %w", err)
+			}
+		}
+
+		if opts.Diarize {
+			if err = writer.WriteField("tinydiarize", "true"); err != nil {
+				return fmt.Errorf("write tinydiarize field: %w", err)
+			}
+		}
+
+		if opts.Language != "" {
+			if err = writer.WriteField("language", opts.Language); err != nil {
+				return fmt.Errorf("write language field: %w", err)
+			}
+		}
+
+		// whisper.cpp ignores model; servers that load models on demand use it.
+		if opts.Model != "" {
+			if err = writer.WriteField("model", opts.Model); err != nil {
+				return fmt.Errorf("write model field: %w", err)
+			}
+		}
+
+		// verbose_json carries segment speaker turns and the detected language.
+		if opts.Diarize || opts.Language == "auto" {
+			if err = writer.WriteField("response_format", "verbose_json"); err != nil {
+				return fmt.Errorf("write response_format field: %w", err)
+			}
+		}
+		return nil
+	})
 }
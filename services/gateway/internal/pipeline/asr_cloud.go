@@ -0,0 +1,219 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+)
+
+// --- OpenAI transcription backend (/v1/audio/transcriptions) ---
+
+// OpenAIASRClient transcribes with OpenAI's hosted speech-to-text models.
+type OpenAIASRClient struct {
+	baseURL       string
+	apiKey        string
+	model         string
+	defaultPrompt string
+	client        *http.Client
+}
+
+// NewOpenAIASRClient creates a client for baseURL (e.g.
+// "https://api.openai.com") that uses model ("whisper-1",
+// "gpt-4o-transcribe", ...) unless a request names another.
+func NewOpenAIASRClient(baseURL, apiKey, model string, poolSize int, prompt string) *OpenAIASRClient {
+	return &OpenAIASRClient{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		apiKey:        apiKey,
+		model:         model,
+		defaultPrompt: prompt,
+		client:        NewPooledHTTPClient("asr", "openai", poolSize, 30*time.Second),
+	}
+}
+
+type openaiTranscription struct {
+	Text     string `json:"text"`
+	Language string `json:"language,omitempty"` // verbose_json: language name, e.g. "english"
+	Segments []struct {
+		NoSpeechProb float64 `json:"no_speech_prob"`
+	} `json:"segments,omitempty"`
+}
+
+// Transcribe uploads the samples as WAV. whisper-* models are asked for
+// verbose_json, which adds the detected language and per-segment
+// no-speech probability; newer models only return text. Diarize is
+// ignored.
+func (c *OpenAIASRClient) Transcribe(ctx context.Context, samples []float32, opts ASROptions) (*ASRResult, error) {
+	start := time.Now()
+
+	model := c.model
+	if opts.Model != "" {
+		model = opts.Model
+	}
+	fields := map[string]string{"model": model, "response_format": "json"}
+	if strings.HasPrefix(model, "whisper") {
+		fields["response_format"] = "verbose_json"
+	}
+	fields["prompt"] = c.defaultPrompt
+	if opts.Prompt != "" {
+		fields["prompt"] = opts.Prompt
+	}
+	if opts.Language != "" && opts.Language != "auto" {
+		fields["language"] = normalizeLanguage(opts.Language)
+	}
+
+	body, contentType, err := buildMultipartWAV(samples, fields)
+	if err != nil {
+		return nil, err
+	}
+	req, err := body.request(ctx, c.baseURL+"/v1/audio/transcriptions")
+	if err != nil {
+		return nil, fmt.Errorf("create openai asr request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("openai asr request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("openai asr status %d: %s", resp.StatusCode, string(respBody))
+	}
+	var result openaiTranscription
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode openai asr response: %w", err)
+	}
+
+	asrResult := &ASRResult{
+		Text:      strings.TrimSpace(result.Text),
+		LatencyMs: float64(time.Since(start).Milliseconds()),
+		Language:  languageCode(result.Language),
+	}
+	if asrResult.Language == "" && opts.Language != "auto" {
+		asrResult.Language = fields["language"]
+	}
+	for _, seg := range result.Segments {
+		asrResult.NoSpeechProb += seg.NoSpeechProb / float64(len(result.Segments))
+	}
+	return asrResult, nil
+}
+
+// buildMultipartWAV encodes samples as a 16 kHz WAV file part plus the
+// non-empty fields.
+func buildMultipartWAV(samples []float32, fields map[string]string) (*pooledBody, string, error) {
+	return multipartBody(func(writer *multipart.Writer) error {
+		if err := writeWAVPart(writer, samples); err != nil {
+			return err
+		}
+		for name, val := range fields {
+			if val == "" {
+				continue
+			}
+			if err := writer.WriteField(name, val); err != nil {
+				return fmt.Errorf("write %s field: %w", name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// --- Azure Speech-to-Text backend (REST for short audio) ---
+
+// azureLocales picks the recognition locale for a bare language code.
+var azureLocales = map[string]string{
+	"en": "en-US", "es": "es-ES", "fr": "fr-FR", "de": "de-DE",
+	"it": "it-IT", "pt": "pt-BR", "nl": "nl-NL", "pl": "pl-PL",
+	"ru": "ru-RU", "uk": "uk-UA", "tr": "tr-TR", "ar": "ar-SA",
+	"hi": "hi-IN", "zh": "zh-CN", "ja": "ja-JP", "ko": "ko-KR",
+	"vi": "vi-VN", "sv": "sv-SE",
+}
+
+// AzureASRClient transcribes with Azure Speech's short-audio REST API,
+// which accepts up to 60 seconds per request.
+type AzureASRClient struct {
+	endpoint string
+	key      string
+	locale   string
+	client   *http.Client
+}
+
+// NewAzureASRClient creates a client for a Speech resource in region,
+// recognizing locale (e.g. "en-US") unless a request sets a language.
+func NewAzureASRClient(key, region, locale string, poolSize int) *AzureASRClient {
+	return &AzureASRClient{
+		endpoint: fmt.Sprintf("https://%s.stt.speech.microsoft.com/speech/recognition/conversation/cognitiveservices/v1", region),
+		key:      key,
+		locale:   locale,
+		client:   NewPooledHTTPClient("asr", "azure", poolSize, 30*time.Second),
+	}
+}
+
+type azureRecognition struct {
+	RecognitionStatus string `json:"RecognitionStatus"`
+	DisplayText       string `json:"DisplayText"`
+}
+
+// Transcribe posts the samples as WAV. The REST API can't detect the
+// language, so "auto" uses the configured locale; Prompt, Diarize, and
+// Model are ignored. A NoMatch result is reported as silence.
+func (c *AzureASRClient) Transcribe(ctx context.Context, samples []float32, opts ASROptions) (*ASRResult, error) {
+	start := time.Now()
+
+	locale := c.locale
+	if loc, ok := azureLocales[normalizeLanguage(opts.Language)]; ok {
+		locale = loc
+	}
+	if strings.Contains(opts.Language, "-") {
+		locale = opts.Language
+	}
+
+	u := c.endpoint + "?" + url.Values{"language": {locale}, "format": {"simple"}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(audio.SamplesToWAV(samples, 16000)))
+	if err != nil {
+		return nil, fmt.Errorf("create azure asr request: %w", err)
+	}
+	req.Header.Set("Content-Type", "audio/wav; codecs=audio/pcm; samplerate=16000")
+	req.Header.Set("Ocp-Apim-Subscription-Key", c.key)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("azure asr request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("azure asr status %d: %s", resp.StatusCode, string(respBody))
+	}
+	var result azureRecognition
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode azure asr response: %w", err)
+	}
+
+	asrResult := &ASRResult{
+		LatencyMs: float64(time.Since(start).Milliseconds()),
+		Language:  normalizeLanguage(locale),
+	}
+	if result.RecognitionStatus == "NoMatch" || result.RecognitionStatus == "InitialSilenceTimeout" {
+		asrResult.NoSpeechProb = 1
+		return asrResult, nil
+	}
+	if result.RecognitionStatus != "Success" {
+		return nil, fmt.Errorf("azure asr recognition status %s", result.RecognitionStatus)
+	}
+	asrResult.Text = result.DisplayText
+	return asrResult, nil
+}
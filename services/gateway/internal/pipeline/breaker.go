@@ -0,0 +1,128 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+)
+
+// defaultBreakerCooldown is how long an open breaker fails fast when
+// cooldown_s is unset.
+const defaultBreakerCooldown = 30 * time.Second
+
+// ErrCircuitOpen is returned for a request to an engine whose breaker is
+// open when there is no healthy engine to send it to instead.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// BreakerConfig configures the circuit breakers around ASR, LLM, and TTS
+// engines, so a wedged sidecar fails fast instead of making every call
+// wait out its client timeout.
+type BreakerConfig struct {
+	Failures  int `json:"failures"`   // consecutive failures that open an engine's breaker (0 = off)
+	CooldownS int `json:"cooldown_s"` // how long it stays open before one trial request (0 = 30)
+}
+
+// EngineDegraded describes a request that skipped an engine with an open
+// breaker. Fallback is the engine that served it instead ("" = none, the
+// request failed fast).
+type EngineDegraded struct {
+	Stage    string `json:"stage"`
+	Engine   string `json:"engine"`
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// Breakers tracks one breaker per stage and engine. A breaker opens after
+// Failures consecutive failures and rejects requests for the cooldown.
+// After that, one trial request is let through (half-open): success closes
+// the breaker, failure opens it for another cooldown.
+type Breakers struct {
+	failures int
+	cooldown time.Duration
+
+	mu     sync.Mutex
+	states map[string]*breaker // by stage + "/" + engine
+}
+
+type breaker struct {
+	failures  int       // consecutive
+	openUntil time.Time // zero while closed
+	trial     bool      // half-open: the trial request is in flight
+}
+
+// NewBreakers returns nil when cfg.Failures is 0, which leaves every
+// engine unguarded.
+func NewBreakers(cfg BreakerConfig) *Breakers {
+	if cfg.Failures <= 0 {
+		return nil
+	}
+	cooldown := defaultBreakerCooldown
+	if cfg.CooldownS > 0 {
+		cooldown = time.Duration(cfg.CooldownS) * time.Second
+	}
+	return &Breakers{failures: cfg.Failures, cooldown: cooldown, states: map[string]*breaker{}}
+}
+
+// Allow reports whether a request to engine may proceed. An open breaker
+// whose cooldown has passed allows one trial request.
+func (b *Breakers) Allow(stage, engine string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br := b.states[stage+"/"+engine]
+	if br == nil || br.openUntil.IsZero() {
+		return true
+	}
+	if br.trial || time.Now().Before(br.openUntil) {
+		return false
+	}
+	br.trial = true
+	return true
+}
+
+// Done records the outcome of a request Allow let through. Requests the
+// caller cancelled (barge-in, hangup) say nothing about the engine and
+// don't count.
+func (b *Breakers) Done(ctx context.Context, stage, engine string, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := stage + "/" + engine
+	br := b.states[key]
+	if br == nil {
+		br = &breaker{}
+		b.states[key] = br
+	}
+	if ctx.Err() != nil {
+		br.trial = false
+		return
+	}
+	if err == nil {
+		if !br.openUntil.IsZero() {
+			slog.InfoContext(ctx, "circuit closed", "stage", stage, "engine", engine)
+			metrics.EngineDegraded.WithLabelValues(stage, engine).Set(0)
+		}
+		*br = breaker{}
+		return
+	}
+
+	br.failures++
+	tripped := br.openUntil.IsZero() && br.failures >= b.failures
+	if !tripped && !br.trial {
+		return
+	}
+	if tripped {
+		slog.WarnContext(ctx, "circuit open", "stage", stage, "engine", engine, "failures", br.failures, "cooldown", b.cooldown, "error", err)
+		metrics.BreakerTrips.WithLabelValues(stage, engine).Inc()
+		metrics.EngineDegraded.WithLabelValues(stage, engine).Set(1)
+	}
+	br.openUntil = time.Now().Add(b.cooldown)
+	br.trial = false
+}
@@ -0,0 +1,54 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Budgets caps how long a turn may spend in each stage, so a slow backend
+// ends the turn with a budget_exceeded event instead of holding the caller
+// for the client's own timeout. 0 leaves a stage to its backend default.
+type Budgets struct {
+	ASRMs   int `json:"asr_timeout_ms"`  // one transcription
+	LLMMs   int `json:"llm_timeout_ms"`  // the whole reply stream
+	TTSMs   int `json:"tts_timeout_ms"`  // each sentence
+	TotalMs int `json:"total_budget_ms"` // the turn, from end of speech (or the message) to its last audio
+}
+
+// budgetError is a stage cut short by a budget. Key names the budget that
+// ran out, so a total budget that expires mid-stage still names the stage
+// that was running.
+type budgetError struct {
+	Stage string // asr, llm, or tts
+	Key   string // asr_timeout_ms, llm_timeout_ms, tts_timeout_ms, or total_budget_ms
+	Ms    int
+}
+
+func (e *budgetError) Error() string {
+	return fmt.Sprintf("%s exceeded %s (%d ms)", e.Stage, e.Key, e.Ms)
+}
+
+func (e *budgetError) event() Event {
+	return Event{Type: "budget_exceeded", Stage: e.Stage, Reason: e.Key, BudgetMs: e.Ms}
+}
+
+// withBudget bounds ctx by ms under key; 0 returns ctx unbounded.
+func withBudget(ctx context.Context, key string, ms int) (context.Context, context.CancelFunc) {
+	if ms <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, time.Duration(ms)*time.Millisecond, &budgetError{Key: key, Ms: ms})
+}
+
+// overBudget turns err from stage, run under ctx, into a budgetError when
+// it failed because a budget on ctx ran out. Other errors, cancellation by
+// barge-in or hangup included, are returned as they are.
+func overBudget(ctx context.Context, stage string, err error) error {
+	var be *budgetError
+	if err == nil || !errors.As(context.Cause(ctx), &be) {
+		return err
+	}
+	return &budgetError{Stage: stage, Key: be.Key, Ms: be.Ms}
+}
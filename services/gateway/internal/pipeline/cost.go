@@ -0,0 +1,41 @@
+package pipeline
+
+// LLMPrice is the price of an LLM model in USD per 1K tokens. Prompt
+// tokens read from the provider's prompt cache cost CachedPromptPer1K
+// (0 = the full prompt price).
+type LLMPrice struct {
+	PromptPer1K       float64 `json:"prompt_per_1k"`
+	CachedPromptPer1K float64 `json:"cached_prompt_per_1k,omitempty"`
+	CompletionPer1K   float64 `json:"completion_per_1k"`
+}
+
+// Pricing estimates the cost of LLM and TTS usage. Models and engines
+// without an entry (e.g. local Ollama, Piper) cost nothing.
+// A nil *Pricing prices everything at zero.
+type Pricing struct {
+	LLM map[string]LLMPrice // model → price
+	TTS map[string]float64  // TTS engine → USD per 1K characters
+}
+
+// LLMCost returns the estimated cost of one completion. cachedTokens are
+// the part of promptTokens read from the prompt cache.
+func (p *Pricing) LLMCost(model string, promptTokens, cachedTokens, completionTokens int) float64 {
+	if p == nil {
+		return 0
+	}
+	price := p.LLM[model]
+	cachedPrice := price.PromptPer1K
+	if price.CachedPromptPer1K > 0 {
+		cachedPrice = price.CachedPromptPer1K
+	}
+	prompt := float64(promptTokens-cachedTokens)*price.PromptPer1K + float64(cachedTokens)*cachedPrice
+	return (prompt + float64(completionTokens)*price.CompletionPer1K) / 1000
+}
+
+// TTSCost returns the estimated cost of synthesizing chars characters.
+func (p *Pricing) TTSCost(engine string, chars int) float64 {
+	if p == nil {
+		return 0
+	}
+	return float64(chars) * p.TTS[engine] / 1000
+}
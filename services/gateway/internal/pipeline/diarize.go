@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// speakerTurnMarker is the token tinydiarize models (e.g. ggml-small.en-tdrz)
+// emit in plain-text output where the speaker changes.
+const speakerTurnMarker = "[SPEAKER_TURN]"
+
+// SpeakerSegment is a span of transcript attributed to one speaker.
+// tinydiarize only detects turn changes, not voice identity, so labels
+// alternate between two speakers — a fit for two-party call recordings.
+type SpeakerSegment struct {
+	Speaker string `json:"speaker"`
+	Text    string `json:"text"`
+}
+
+// speakerLabel returns the label for the n-th speaker turn (0-based).
+func speakerLabel(turn int) string {
+	return fmt.Sprintf("speaker_%d", turn%2+1)
+}
+
+// diarizeSegments groups whisper segments into speaker turns using the
+// speaker_turn_next flag. Falls back to splitting on inline turn markers
+// when the server returned plain text only.
+func diarizeSegments(segments []whisperSegment, text string) []SpeakerSegment {
+	if len(segments) == 0 {
+		return diarizeText(text)
+	}
+	var out []SpeakerSegment
+	var cur strings.Builder
+	turn := 0
+	for _, seg := range segments {
+		cur.WriteString(strings.ReplaceAll(seg.Text, speakerTurnMarker, ""))
+		if !seg.SpeakerTurnNext {
+			continue
+		}
+		out = appendSegment(out, turn, cur.String())
+		cur.Reset()
+		turn++
+	}
+	return appendSegment(out, turn, cur.String())
+}
+
+// diarizeText splits plain-text output on inline [SPEAKER_TURN] markers.
+func diarizeText(text string) []SpeakerSegment {
+	var out []SpeakerSegment
+	for i, part := range strings.Split(text, speakerTurnMarker) {
+		out = appendSegment(out, i, part)
+	}
+	return out
+}
+
+func appendSegment(out []SpeakerSegment, turn int, text string) []SpeakerSegment {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return out
+	}
+	return append(out, SpeakerSegment{Speaker: speakerLabel(turn), Text: text})
+}
+
+// formatSpeakerTranscript renders segments as "speaker_1: ..." lines so the
+// LLM sees who said what.
+func formatSpeakerTranscript(segments []SpeakerSegment) string {
+	lines := make([]string, len(segments))
+	for i, seg := range segments {
+		lines[i] = seg.Speaker + ": " + seg.Text
+	}
+	return strings.Join(lines, "\n")
+}
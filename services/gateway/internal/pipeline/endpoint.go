@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+)
+
+// EndpointConfig configures dynamic end-of-turn detection. When the caller
+// pauses, the utterance so far is transcribed and the pause is given a
+// short timeout if the caller sounds finished, or a long one if they
+// stopped mid-sentence. Other pauses keep the VAD's silence timeout.
+type EndpointConfig struct {
+	Enabled      bool `json:"enabled"`
+	ProbeMs      int  `json:"probe_ms"`      // silence before the partial transcript is taken
+	CompleteMs   int  `json:"complete_ms"`   // timeout after terminal punctuation
+	IncompleteMs int  `json:"incomplete_ms"` // timeout after a trailing conjunction, filler, or comma
+}
+
+// withDefaults fills knobs left at zero.
+func (c EndpointConfig) withDefaults() EndpointConfig {
+	if c.ProbeMs <= 0 {
+		c.ProbeMs = 300
+	}
+	if c.CompleteMs <= 0 {
+		c.CompleteMs = 500
+	}
+	if c.IncompleteMs <= 0 {
+		c.IncompleteMs = 2000
+	}
+	return c
+}
+
+// holdWords are words a speaker rarely ends a turn on: after them the
+// caller is most likely pausing to think.
+var holdWords = map[string]bool{
+	"and": true, "but": true, "or": true, "so": true, "because": true,
+	"if": true, "then": true, "that": true, "which": true, "with": true,
+	"to": true, "of": true, "for": true, "the": true, "a": true, "an": true,
+	"my": true, "is": true, "um": true, "uh": true, "like": true, "just": true,
+}
+
+// endpointDecision picks the silence timeout for a pause from the partial
+// transcript, with the reason it was chosen. A zero timeout keeps the
+// VAD's own.
+func (c EndpointConfig) endpointDecision(partial string) (time.Duration, string) {
+	text := strings.TrimSpace(partial)
+	if text == "" {
+		return 0, "no_transcript"
+	}
+	last := text[len(text)-1]
+	if sentenceEnders[last] {
+		return time.Duration(c.CompleteMs) * time.Millisecond, "terminal_punctuation"
+	}
+	if last == ',' || strings.HasSuffix(text, "—") || strings.HasSuffix(text, "...") {
+		return time.Duration(c.IncompleteMs) * time.Millisecond, "trailing_comma"
+	}
+	words := strings.Fields(strings.ToLower(text))
+	if holdWords[strings.Trim(words[len(words)-1], `"'-`)] {
+		return time.Duration(c.IncompleteMs) * time.Millisecond, "mid_sentence"
+	}
+	return 0, "no_cue"
+}
+
+// endpointInfo is the last endpointing decision, recorded in the trace of
+// the run the utterance starts.
+type endpointInfo struct {
+	at        time.Time
+	partial   string
+	reason    string
+	timeoutMs int64
+}
+
+// endpointer runs partial transcriptions for pauses and remembers the
+// latest decision.
+type endpointer struct {
+	cfg EndpointConfig
+
+	mu   sync.Mutex
+	last *endpointInfo
+}
+
+// probeEndpoint transcribes the utterance so far in the background and
+// sets the pause's silence timeout from it. The transcription is bounded
+// by the long timeout: a decision that late would change nothing.
+func (p *Pipeline) probeEndpoint(ctx context.Context, pause audio.VADResult, asrEngine string) {
+	ep := p.endpoint
+	go func() {
+		start := time.Now()
+		probeCtx, cancel := context.WithTimeout(ctx, time.Duration(ep.cfg.IncompleteMs)*time.Millisecond)
+		defer cancel()
+		result, err := p.cfg.ASRClient.Transcribe(probeCtx, pause.Audio, asrEngine, ASROptions{Prompt: p.cfg.ASRPrompt, Language: p.cfg.Language, Model: p.cfg.ASRModel})
+		if err != nil {
+			slog.DebugContext(ctx, "endpoint probe", "error", err)
+			return
+		}
+		timeout, reason := ep.cfg.endpointDecision(result.Text)
+		// a pause that already ended (or was talked through) keeps no decision
+		if !p.vad.SetSilenceTimeout(pause.Pause, timeout) {
+			slog.DebugContext(ctx, "endpoint decision too late", "reason", reason)
+			return
+		}
+		slog.DebugContext(ctx, "endpoint", "reason", reason, "timeout_ms", timeout.Milliseconds())
+
+		ep.mu.Lock()
+		ep.last = &endpointInfo{at: start, partial: result.Text, reason: reason, timeoutMs: timeout.Milliseconds()}
+		ep.mu.Unlock()
+	}()
+}
+
+// traceEndpoint records the decision that ended the utterance as an
+// "endpoint" span of runID. Decisions from pauses the caller talked
+// through are superseded by later ones before a run starts.
+func (p *Pipeline) traceEndpoint(runID string) {
+	if p.endpoint == nil || p.cfg.Tracer == nil {
+		return
+	}
+	p.endpoint.mu.Lock()
+	info := p.endpoint.last
+	p.endpoint.last = nil
+	p.endpoint.mu.Unlock()
+	if info == nil {
+		return
+	}
+	output := fmt.Sprintf("reason=%s timeout_ms=%d", info.reason, info.timeoutMs)
+	p.traceSpan(p.startSpan(runID, ""), "endpoint", info.at, info.partial, output, nil)
+}
@@ -0,0 +1,165 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// fillerRenderTimeout bounds the background synthesis of one filler phrase.
+	fillerRenderTimeout = 30 * time.Second
+
+	// comfortNoiseMs and comfortNoiseAmplitude shape the fallback played when
+	// no phrase is rendered yet: a second of very quiet white noise (~-50 dBFS),
+	// enough to tell the caller the line is still open.
+	comfortNoiseMs        = 1000
+	comfortNoiseAmplitude = 0.003
+)
+
+// FillerConfig configures thinking audio played when the LLM is slow to
+// produce its first sentence.
+type FillerConfig struct {
+	ThresholdMs  int      `json:"threshold_ms"`  // wait before filling dead air (0 = off)
+	Phrases      []string `json:"phrases"`       // spoken fillers, picked at random
+	ComfortNoise bool     `json:"comfort_noise"` // play quiet noise when no phrase is rendered yet
+}
+
+// FillerCache pre-renders filler phrases per TTS engine and voice. Phrases
+// are synthesized in the background on first use, so the first slow turn in
+// a new voice gets comfort noise (or nothing) and later ones get speech.
+type FillerCache struct {
+	cfg   FillerConfig
+	tts   *TTSRouter
+	noise []byte
+	next  atomic.Uint32
+
+	mu       sync.Mutex
+	audio    map[string][]byte
+	inFlight map[string]bool
+}
+
+// NewFillerCache returns nil (disabled) when no threshold is configured or
+// there is nothing to play.
+func NewFillerCache(cfg FillerConfig, tts *TTSRouter) *FillerCache {
+	if cfg.ThresholdMs <= 0 || (len(cfg.Phrases) == 0 && !cfg.ComfortNoise) {
+		return nil
+	}
+	return &FillerCache{
+		cfg:      cfg,
+		tts:      tts,
+		noise:    comfortNoiseWAV(comfortNoiseMs, ttsSilenceSampleRate, comfortNoiseAmplitude),
+		audio:    map[string][]byte{},
+		inFlight: map[string]bool{},
+	}
+}
+
+// Threshold is how long to wait for the first sentence before filling.
+func (f *FillerCache) Threshold() time.Duration {
+	return time.Duration(f.cfg.ThresholdMs) * time.Millisecond
+}
+
+// Get returns filler audio for engine and opts, or nil if nothing is ready.
+// A phrase that isn't rendered yet is queued for background synthesis.
+func (f *FillerCache) Get(engine string, opts TTSOptions) []byte {
+	if len(f.cfg.Phrases) > 0 {
+		phrase := f.cfg.Phrases[int(f.next.Add(1))%len(f.cfg.Phrases)]
+		if wav := f.lookup(engine, opts, phrase); wav != nil {
+			return wav
+		}
+	}
+	if f.cfg.ComfortNoise {
+		return f.noise
+	}
+	return nil
+}
+
+func (f *FillerCache) lookup(engine string, opts TTSOptions, phrase string) []byte {
+	key := ttsCacheKey(engine, opts) + "|" + phrase
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if wav, ok := f.audio[key]; ok {
+		return wav
+	}
+	if !f.inFlight[key] {
+		f.inFlight[key] = true
+		go f.render(key, engine, opts, phrase)
+	}
+	return nil
+}
+
+func (f *FillerCache) render(key, engine string, opts TTSOptions, phrase string) {
+	ctx, cancel := context.WithTimeout(context.Background(), fillerRenderTimeout)
+	defer cancel()
+	result, err := f.tts.Synthesize(ctx, phrase, engine, opts)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.inFlight, key)
+	if err != nil {
+		slog.Warn("filler render", "engine", engine, "voice", opts.Voice, "error", err)
+		return
+	}
+	f.audio[key] = result.Audio
+}
+
+// fillerTimer plays filler audio if the first sentence of a response hasn't
+// reached TTS within the threshold. At most one filler plays per response.
+type fillerTimer struct {
+	mu    sync.Mutex
+	done  bool
+	timer *time.Timer
+}
+
+// startFiller arms the filler for one response. Returns nil when fillers
+// are disabled; a nil *fillerTimer is safe to stop.
+func (p *Pipeline) startFiller(ttsEngine string, onEvent EventCallback) *fillerTimer {
+	if p.cfg.Filler == nil {
+		return nil
+	}
+	ft := &fillerTimer{}
+	opts := p.ttsOptions()
+	ft.timer = time.AfterFunc(p.cfg.Filler.Threshold(), func() {
+		ft.mu.Lock()
+		defer ft.mu.Unlock()
+		if ft.done {
+			return
+		}
+		ft.done = true
+		wav := p.cfg.Filler.Get(p.cfg.TTSClient.Resolve(ttsEngine), opts)
+		if wav == nil {
+			return
+		}
+		slog.Info("filler audio", "session_id", p.cfg.SessionID, "threshold_ms", p.cfg.Filler.cfg.ThresholdMs)
+		onEvent(Event{Type: "tts_ready", Audio: wav, Filler: true})
+		p.trackPlayback(wav)
+	})
+	return ft
+}
+
+// stop cancels the filler once real speech is on its way. If the filler is
+// mid-send, stop waits for it so it isn't played over the response.
+func (ft *fillerTimer) stop() {
+	if ft == nil {
+		return
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.done = true
+	ft.timer.Stop()
+}
+
+// comfortNoiseWAV generates white noise of peak amplitude (0–1) as 16-bit
+// mono WAV.
+func comfortNoiseWAV(ms, sampleRate int, amplitude float64) []byte {
+	buf := silenceWAV(ms, sampleRate)
+	for i := 44; i+1 < len(buf); i += 2 {
+		s := int16((rand.Float64()*2 - 1) * amplitude * 32767)
+		binary.LittleEndian.PutUint16(buf[i:], uint16(s))
+	}
+	return buf
+}
@@ -0,0 +1,207 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// handoffSignal is the marker the LLM ends its reply with to escalate.
+	handoffSignal = "[[escalate]]"
+
+	// handoffSummaryTimeout bounds the LLM call that summarizes the
+	// conversation for the human agent.
+	handoffSummaryTimeout = 20 * time.Second
+
+	// handoffWebhookTimeout bounds one handoff notification.
+	handoffWebhookTimeout = 5 * time.Second
+
+	handoffSummaryPrompt = "Summarize this call for the human agent taking it over, in two or three sentences: who the caller is, what they want, and what has been tried. Write only the summary."
+)
+
+// HandoffConfig configures escalation of a call to a human.
+type HandoffConfig struct {
+	Enabled     bool     `json:"enabled"`
+	When        string   `json:"when"`         // condition given to the LLM, e.g. "the caller asks for a person"
+	Intents     []string `json:"intents"`      // intents that escalate on their own, e.g. "escalate"
+	Webhook     string   `json:"webhook"`      // URL notified with the summary ("" = none)
+	HoldMessage string   `json:"hold_message"` // spoken on hold and to every later caller turn ("" = silence)
+}
+
+// Handoff escalates calls to a human: it summarizes the conversation,
+// notifies the webhook, and holds the call so the agent stops answering.
+type Handoff struct {
+	cfg    HandoffConfig
+	client *http.Client
+}
+
+// NewHandoff returns nil (disabled) unless escalation is enabled.
+func NewHandoff(cfg HandoffConfig) *Handoff {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.When == "" {
+		cfg.When = "the caller asks for a human or you cannot help them"
+	}
+	return &Handoff{cfg: cfg, client: &http.Client{Timeout: handoffWebhookTimeout}}
+}
+
+// prompt is the instruction that lets the LLM escalate.
+func (h *Handoff) prompt() string {
+	return fmt.Sprintf("\n\nIf %s, tell the caller you are transferring them to a person and end your reply with %s.", h.cfg.When, handoffSignal)
+}
+
+// handoffNotification is the JSON body POSTed to the handoff webhook.
+type handoffNotification struct {
+	SessionID string    `json:"session_id"`
+	Reason    string    `json:"reason"`
+	Summary   string    `json:"summary"`
+	Turns     []Turn    `json:"turns"`
+	Time      time.Time `json:"time"`
+}
+
+// notify POSTs n to the webhook. Failures are logged; the call is on hold
+// either way.
+func (h *Handoff) notify(ctx context.Context, n handoffNotification) {
+	if h.cfg.Webhook == "" {
+		return
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.Webhook, bytes.NewReader(body))
+	if err != nil {
+		slog.WarnContext(ctx, "handoff webhook", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		slog.WarnContext(ctx, "handoff webhook", "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.WarnContext(ctx, "handoff webhook", "status", resp.StatusCode)
+	}
+}
+
+// handoffPrompt is the escalation instruction for the system prompt, or ""
+// when escalation is off or the call is already on hold.
+func (p *Pipeline) handoffPrompt() string {
+	if p.cfg.Handoff == nil || p.onHold.Load() {
+		return ""
+	}
+	return p.cfg.Handoff.prompt()
+}
+
+// applyHandoffSignal strips the escalation marker from the response text
+// and marks the turn for handoff if it was there.
+func (p *Pipeline) applyHandoffSignal(result *LLMResult) {
+	if p.cfg.Handoff == nil || !strings.Contains(result.Text, handoffSignal) {
+		return
+	}
+	result.Text = strings.TrimSpace(strings.ReplaceAll(result.Text, handoffSignal, ""))
+	p.handoffReason = "llm"
+}
+
+// escalateOnIntent marks the turn for handoff when intent is one that
+// escalates on its own.
+func (p *Pipeline) escalateOnIntent(intent string) {
+	if p.cfg.Handoff != nil && slices.Contains(p.cfg.Handoff.cfg.Intents, intent) {
+		p.handoffReason = "intent:" + intent
+	}
+}
+
+// finishHandoff runs at the end of a turn marked for handoff: the call goes
+// on hold, the hold message plays, and the summary is sent as a
+// handoff_requested event and to the webhook in the background.
+func (p *Pipeline) finishHandoff(ctx context.Context, ttsEngine string, onEvent EventCallback) {
+	reason := p.handoffReason
+	p.handoffReason = ""
+	if reason == "" || p.onHold.Swap(true) {
+		return
+	}
+	slog.InfoContext(ctx, "handoff", "reason", reason)
+	p.holdTurn(ctx, ttsEngine, onEvent)
+
+	turns := p.History()
+	go func() {
+		summaryCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), handoffSummaryTimeout)
+		defer cancel()
+		summary, err := p.summarize(summaryCtx, turns)
+		if err != nil {
+			slog.WarnContext(ctx, "handoff summary", "error", err)
+		}
+		onEvent(Event{Type: "handoff_requested", Text: summary, Reason: reason})
+		for i := range turns {
+			turns[i] = Turn{User: p.cfg.Redactor.Redact(turns[i].User), Assistant: p.cfg.Redactor.Redact(turns[i].Assistant)}
+		}
+		p.cfg.Handoff.notify(summaryCtx, handoffNotification{
+			SessionID: p.cfg.SessionID,
+			Reason:    reason,
+			Summary:   p.cfg.Redactor.Redact(summary),
+			Turns:     turns,
+			Time:      time.Now().UTC(),
+		})
+	}()
+}
+
+// summarize asks the session's LLM for a handover summary of turns.
+func (p *Pipeline) summarize(ctx context.Context, turns []Turn) (string, error) {
+	var b strings.Builder
+	for _, t := range turns {
+		fmt.Fprintf(&b, "Caller: %s\nAgent: %s\n", t.User, t.Assistant)
+	}
+	result, err := p.cfg.LLMClient.Chat(ctx, []Message{{Role: RoleUser, Content: b.String()}}, handoffSummaryPrompt, p.cfg.LLMModel, p.cfg.LLMEngine, func(string) {}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// holdTurn plays the hold message as a reply of its own: on entering hold,
+// and in place of the agent's answer to every caller turn while on hold.
+func (p *Pipeline) holdTurn(ctx context.Context, ttsEngine string, onEvent EventCallback) {
+	p.speakHold(ctx, ttsEngine, onEvent)
+	onEvent(Event{Type: "metrics"})
+}
+
+// speakHold sends the hold message as the agent's reply, with audio when
+// TTS is on.
+func (p *Pipeline) speakHold(ctx context.Context, ttsEngine string, onEvent EventCallback) {
+	msg := p.cfg.Handoff.cfg.HoldMessage
+	if msg == "" {
+		return
+	}
+	onEvent(Event{Type: "llm_token", Token: msg})
+	if ttsEngine != "" && p.cfg.TTSClient != nil {
+		result, err := p.cfg.TTSClient.Synthesize(ctx, msg, ttsEngine, p.ttsOptions())
+		if err != nil {
+			slog.WarnContext(ctx, "hold message tts", "error", err)
+		}
+		if err == nil && ctx.Err() == nil {
+			p.sendSpeech(result.Audio, result.LatencyMs, onEvent)
+		}
+	}
+	onEvent(Event{Type: "llm_done", Text: msg})
+}
+
+// OnHold reports whether the call was handed off and awaits a human.
+func (p *Pipeline) OnHold() bool {
+	return p.onHold.Load()
+}
+
+// ReleaseHold returns a held call to the agent, e.g. when no human is
+// available after all. The next caller turn is answered normally.
+func (p *Pipeline) ReleaseHold() {
+	p.onHold.Store(false)
+}
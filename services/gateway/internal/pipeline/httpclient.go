@@ -1,20 +1,75 @@
 package pipeline
 
 import (
+	"io"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
 )
 
 // NewPooledHTTPClient creates an http.Client with connection pooling and tuned transport.
-func NewPooledHTTPClient(poolSize int, timeout time.Duration) *http.Client {
+// Requests in flight and requests beyond poolSize are reported per stage and engine.
+func NewPooledHTTPClient(stage, engine string, poolSize int, timeout time.Duration) *http.Client {
 	return &http.Client{
 		Timeout: timeout,
-		Transport: &http.Transport{
-			MaxIdleConns:          poolSize,
-			MaxIdleConnsPerHost:   poolSize,
-			IdleConnTimeout:       90 * time.Second,
-			ResponseHeaderTimeout: 30 * time.Second,
-			ForceAttemptHTTP2:     true,
+		Transport: &pooledTransport{
+			base: &http.Transport{
+				MaxIdleConns:          poolSize,
+				MaxIdleConnsPerHost:   poolSize,
+				IdleConnTimeout:       90 * time.Second,
+				ResponseHeaderTimeout: 30 * time.Second,
+				ForceAttemptHTTP2:     true,
+			},
+			size:      int64(poolSize),
+			inFlight:  metrics.HTTPInFlight.WithLabelValues(stage, engine),
+			exhausted: metrics.HTTPPoolExhausted.WithLabelValues(stage, engine),
 		},
 	}
 }
+
+// pooledTransport counts a request as in flight until its response body is
+// closed, since the connection stays busy until then.
+type pooledTransport struct {
+	base      http.RoundTripper
+	size      int64
+	active    atomic.Int64
+	inFlight  prometheus.Gauge
+	exhausted prometheus.Counter
+}
+
+func (t *pooledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.active.Add(1) > t.size {
+		t.exhausted.Inc()
+	}
+	t.inFlight.Inc()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.done()
+		return nil, err
+	}
+	resp.Body = &countedBody{ReadCloser: resp.Body, done: t.done}
+	return resp, nil
+}
+
+func (t *pooledTransport) done() {
+	t.active.Add(-1)
+	t.inFlight.Dec()
+}
+
+// countedBody calls done once, on the first Close.
+type countedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *countedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
@@ -0,0 +1,201 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+)
+
+const (
+	// intentTimeout bounds one classification; it runs before the LLM, so a
+	// slow classifier delays the reply by at most this much.
+	intentTimeout = 1500 * time.Millisecond
+
+	// intentWebhookTimeout bounds one routing notification.
+	intentWebhookTimeout = 5 * time.Second
+)
+
+// IntentConfig configures intent classification of caller turns.
+type IntentConfig struct {
+	Model   string                 `json:"model"`   // Ollama model for few-shot classification ("" = off)
+	Intents map[string]IntentRoute `json:"intents"` // intent label → description and routing
+}
+
+// IntentRoute describes one intent and what happens when a call enters it.
+type IntentRoute struct {
+	Description  string   `json:"description"`             // shown to the classifier
+	Examples     []string `json:"examples,omitempty"`      // few-shot caller phrasings
+	SystemPrompt string   `json:"system_prompt,omitempty"` // replaces the session prompt while the intent holds ("" = keep it)
+	Webhook      string   `json:"webhook,omitempty"`       // URL notified when a call enters the intent ("" = none)
+}
+
+// IntentRouter classifies caller turns and applies per-intent routing.
+type IntentRouter struct {
+	url    string
+	model  string
+	routes map[string]IntentRoute
+	prompt string
+	client *http.Client
+	hooks  *http.Client
+}
+
+// NewIntentRouter returns nil (disabled) when no model or intents are
+// configured.
+func NewIntentRouter(cfg IntentConfig, ollamaURL string) *IntentRouter {
+	if cfg.Model == "" || len(cfg.Intents) == 0 {
+		return nil
+	}
+	return &IntentRouter{
+		url:    strings.TrimRight(ollamaURL, "/"),
+		model:  cfg.Model,
+		routes: cfg.Intents,
+		prompt: intentPrompt(cfg.Intents),
+		client: &http.Client{Timeout: intentTimeout},
+		hooks:  &http.Client{Timeout: intentWebhookTimeout},
+	}
+}
+
+// intentPrompt builds the few-shot classification prompt, listing intents
+// in name order so the prompt is stable across restarts.
+func intentPrompt(intents map[string]IntentRoute) string {
+	var b strings.Builder
+	b.WriteString("Classify the caller's latest message into one intent. Reply with the intent name only, or \"none\" if no intent fits.\n\nIntents:\n")
+	names := make([]string, 0, len(intents))
+	for name := range intents {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		route := intents[name]
+		fmt.Fprintf(&b, "- %s: %s\n", name, route.Description)
+		for _, ex := range route.Examples {
+			fmt.Fprintf(&b, "  e.g. %q\n", ex)
+		}
+	}
+	return b.String()
+}
+
+// Classify returns the intent of text, or "" when none fits.
+func (r *IntentRouter) Classify(ctx context.Context, text string) (string, error) {
+	body, err := json.Marshal(map[string]any{
+		"model":   r.model,
+		"stream":  false,
+		"options": map[string]any{"temperature": 0},
+		"messages": []Message{
+			{Role: "system", Content: r.prompt},
+			{Role: RoleUser, Content: text},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("intent request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("intent http: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("intent status %d", resp.StatusCode)
+	}
+
+	var out struct {
+		Message Message `json:"message"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("intent decode: %w", err)
+	}
+	label := strings.ToLower(strings.Trim(strings.TrimSpace(out.Message.Content), `."'`))
+	if _, ok := r.routes[label]; !ok {
+		return "", nil
+	}
+	return label, nil
+}
+
+// SystemPrompt returns intent's prompt override, or "" to keep the
+// session's own.
+func (r *IntentRouter) SystemPrompt(intent string) string {
+	return r.routes[intent].SystemPrompt
+}
+
+// intentNotification is the JSON body POSTed to an intent's webhook.
+type intentNotification struct {
+	SessionID  string    `json:"session_id"`
+	Intent     string    `json:"intent"`
+	Previous   string    `json:"previous,omitempty"`
+	Transcript string    `json:"transcript"`
+	Time       time.Time `json:"time"`
+}
+
+// notify POSTs n to the intent's webhook in the background. Failures are
+// logged; routing is best-effort and never holds up the call.
+func (r *IntentRouter) notify(n intentNotification) {
+	url := r.routes[n.Intent].Webhook
+	if url == "" {
+		return
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return
+	}
+	go func() {
+		resp, err := r.hooks.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			slog.Warn("intent webhook", "session_id", n.SessionID, "intent", n.Intent, "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			slog.Warn("intent webhook", "session_id", n.SessionID, "intent", n.Intent, "status", resp.StatusCode)
+		}
+	}()
+}
+
+// classifyIntent labels the caller's turn and emits an intent event. When
+// the call moves into a new intent, its prompt override takes effect from
+// this turn and its webhook is notified. Turns that fit no intent keep the
+// current one, so follow-up questions stay routed.
+func (p *Pipeline) classifyIntent(ctx context.Context, text string, onEvent EventCallback, runID string) {
+	if p.cfg.Intents == nil {
+		return
+	}
+	span, start := p.startSpan(runID, ""), time.Now()
+	intent, err := p.cfg.Intents.Classify(ctx, text)
+	p.traceSpan(span, "intent", start, text, intent, err)
+	observeStage("intent", "ollama", p.cfg.Intents.model, start, err)
+	if err != nil {
+		slog.WarnContext(ctx, "intent classification failed", "error", err)
+		return
+	}
+	if intent == "" {
+		return
+	}
+	metrics.Intents.WithLabelValues(intent).Inc()
+	onEvent(Event{Type: "intent", Text: intent})
+	p.escalateOnIntent(intent)
+	if intent == p.intent {
+		return
+	}
+	slog.InfoContext(ctx, "intent", "intent", intent, "previous", p.intent)
+	p.cfg.Intents.notify(intentNotification{
+		SessionID:  p.cfg.SessionID,
+		Intent:     intent,
+		Previous:   p.intent,
+		Transcript: p.cfg.Redactor.Redact(text),
+		Time:       time.Now().UTC(),
+	})
+	p.intent = intent
+}
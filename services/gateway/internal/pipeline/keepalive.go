@@ -0,0 +1,127 @@
+package pipeline
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+)
+
+const (
+	// keepAliveFrameMs is the length of one comfort noise frame. Frames are
+	// sent at real-time pace, so the client's playback queue never runs
+	// ahead of the response by more than one frame.
+	keepAliveFrameMs = 200
+
+	defaultKeepAliveDelayMs = 500
+	defaultKeepAliveDB      = -50.0
+)
+
+// ComfortNoiseConfig configures comfort noise streamed while a voice turn
+// is thinking (ASR, cache and intent lookups, the LLM's first sentence),
+// so carriers and callers don't take the silence for a dropped call.
+type ComfortNoiseConfig struct {
+	Enabled bool
+	DelayMs int     // dead air before the noise starts (0 = 500)
+	LevelDB float64 // noise level in dBFS (0 = -50)
+}
+
+// keepAlive streams comfort noise frames until the turn's first speech.
+type keepAlive struct {
+	frame []byte
+	stop  chan struct{}
+
+	mu         sync.Mutex
+	done       bool
+	quietUntil time.Time // a filler clip is playing; don't talk over it
+}
+
+// startKeepAlive begins streaming comfort noise for a turn. The returned
+// callback wraps onEvent and ends the noise at the turn's first response
+// audio or its metrics; the returned func ends it unconditionally and is
+// safe to call more than once.
+func (p *Pipeline) startKeepAlive(ttsEngine string, onEvent EventCallback) (EventCallback, func()) {
+	cfg := p.cfg.ComfortNoise
+	if !cfg.Enabled || ttsEngine == "" || p.cfg.TTSClient == nil {
+		return onEvent, func() {}
+	}
+	if cfg.DelayMs <= 0 {
+		cfg.DelayMs = defaultKeepAliveDelayMs
+	}
+	if cfg.LevelDB == 0 {
+		cfg.LevelDB = defaultKeepAliveDB
+	}
+	ka := &keepAlive{
+		frame: comfortNoiseWAV(keepAliveFrameMs, ttsSilenceSampleRate, math.Pow(10, cfg.LevelDB/20)),
+		stop:  make(chan struct{}),
+	}
+	go ka.run(time.Duration(cfg.DelayMs)*time.Millisecond, onEvent)
+
+	wrapped := func(e Event) {
+		if e.Type == "tts_ready" && e.Filler {
+			ka.quiet(e.Audio)
+		}
+		if (e.Type == "tts_ready" && !e.Filler) || e.Type == "metrics" {
+			ka.halt()
+		}
+		onEvent(e)
+	}
+	return wrapped, ka.halt
+}
+
+func (ka *keepAlive) run(delay time.Duration, onEvent EventCallback) {
+	select {
+	case <-ka.stop:
+		return
+	case <-time.After(delay):
+	}
+	ticker := time.NewTicker(keepAliveFrameMs * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if !ka.send(onEvent) {
+			return
+		}
+		select {
+		case <-ka.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// send emits one noise frame unless the noise was halted. Holding the lock
+// across the send keeps a frame from landing after the response's audio.
+func (ka *keepAlive) send(onEvent EventCallback) bool {
+	ka.mu.Lock()
+	defer ka.mu.Unlock()
+	if ka.done {
+		return false
+	}
+	if time.Now().Before(ka.quietUntil) {
+		return true
+	}
+	onEvent(Event{Type: "tts_ready", Audio: ka.frame, Filler: true})
+	return true
+}
+
+// quiet pauses the noise for the length of a filler clip.
+func (ka *keepAlive) quiet(wav []byte) {
+	samples, rate, err := audio.DecodeWAV(wav)
+	if err != nil || rate == 0 {
+		return
+	}
+	ka.mu.Lock()
+	defer ka.mu.Unlock()
+	ka.quietUntil = time.Now().Add(time.Duration(len(samples)) * time.Second / time.Duration(rate))
+}
+
+func (ka *keepAlive) halt() {
+	ka.mu.Lock()
+	defer ka.mu.Unlock()
+	if ka.done {
+		return
+	}
+	ka.done = true
+	close(ka.stop)
+}
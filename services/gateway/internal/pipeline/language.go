@@ -0,0 +1,53 @@
+package pipeline
+
+import "strings"
+
+// languageNames maps whisper language codes to names used in the LLM
+// instruction. Codes outside the map are passed through as-is.
+var languageNames = map[string]string{
+	"en": "English", "es": "Spanish", "fr": "French", "de": "German",
+	"it": "Italian", "pt": "Portuguese", "nl": "Dutch", "pl": "Polish",
+	"ru": "Russian", "uk": "Ukrainian", "tr": "Turkish", "ar": "Arabic",
+	"hi": "Hindi", "zh": "Chinese", "ja": "Japanese", "ko": "Korean",
+	"vi": "Vietnamese", "sv": "Swedish",
+}
+
+// languageName returns a human-readable name for a language code.
+func languageName(code string) string {
+	if name, ok := languageNames[code]; ok {
+		return name
+	}
+	return code
+}
+
+// languageCode maps a language name ("english") back to its code, or ""
+// when the name is unknown.
+func languageCode(name string) string {
+	for code, n := range languageNames {
+		if strings.EqualFold(n, name) {
+			return code
+		}
+	}
+	return ""
+}
+
+// normalizeLanguage lowercases a language code and strips a region suffix
+// ("en-US" → "en"). whisper.cpp and OpenAI's verbose_json report the full
+// name ("english"), which becomes its code.
+func normalizeLanguage(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if named := languageCode(code); named != "" {
+		return named
+	}
+	base, _, _ := strings.Cut(code, "-")
+	return base
+}
+
+// languageInstruction is appended to the system prompt when the caller is
+// speaking something other than English, so the reply matches the caller.
+func languageInstruction(code string) string {
+	if code == "" || code == "en" {
+		return ""
+	}
+	return "\n\nThe caller is speaking " + languageName(code) + ". Respond in " + languageName(code) + "."
+}
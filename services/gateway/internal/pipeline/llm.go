@@ -5,22 +5,61 @@ import (
 	"time"
 )
 
-// LLMChatClient produces streaming chat completions from a user message.
+// Message roles used in chat history.
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is one role-tagged conversation turn. The system prompt is passed
+// separately since each provider places it differently.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// LLMChatClient produces streaming chat completions from a conversation
+// ending in the current user message. Thinking, for models that stream it,
+// comes through onToken between <think> and </think>, the way reasoning
+// models on Ollama emit it; AgentLLM splits it from the reply.
 type LLMChatClient interface {
-	Chat(ctx context.Context, userMessage, systemPrompt, model string, onToken TokenCallback) (*LLMResult, error)
+	Chat(ctx context.Context, messages []Message, systemPrompt, model string, onToken TokenCallback) (*LLMResult, error)
 }
 
 // LLMResult holds the complete LLM response with timing.
 type LLMResult struct {
-	Text               string  `json:"text"`
-	Thinking           string  `json:"thinking,omitempty"`
-	LatencyMs          float64 `json:"latency_ms"`
-	TimeToFirstTokenMs float64 `json:"ttft_ms"`
+	Text               string        `json:"text"`
+	Thinking           string        `json:"thinking,omitempty"`
+	LatencyMs          float64       `json:"latency_ms"`
+	TimeToFirstTokenMs float64       `json:"ttft_ms"`
+	Engine             string        `json:"engine,omitempty"`
+	Model              string        `json:"model,omitempty"`
+	PromptTokens       int           `json:"prompt_tokens,omitempty"`
+	CachedPromptTokens int           `json:"cached_prompt_tokens,omitempty"` // of PromptTokens, read from the provider's prompt cache
+	CompletionTokens   int           `json:"completion_tokens,omitempty"`
+	TokensPerSecond    float64       `json:"tokens_per_second,omitempty"` // generation rate after the first token
+	CostUSD            float64       `json:"cost_usd,omitempty"`          // estimate from configured pricing
+	Fallbacks          []LLMFallback `json:"fallbacks,omitempty"`
+}
+
+// LLMFallback records one failed attempt that was retried on the next engine.
+type LLMFallback struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
 }
 
 // TokenCallback is called for each streamed token.
 type TokenCallback func(token string)
 
+// FallbackCallback is called when a turn moves on to the next engine,
+// before that engine has streamed anything.
+type FallbackCallback func(LLMFallback)
+
 type streamResult struct {
-	ttft time.Time
+	ttft               time.Time
+	promptTokens       int
+	cachedPromptTokens int
+	completionTokens   int
 }
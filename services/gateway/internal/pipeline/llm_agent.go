@@ -2,24 +2,49 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/nlpodyssey/openai-agents-go/agents"
 	"github.com/nlpodyssey/openai-agents-go/modelsettings"
 	"github.com/openai/openai-go/v2/packages/param"
+	"github.com/openai/openai-go/v2/responses"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+)
+
+// Fallback reasons reported in LLMFallback and the fallback metric.
+const (
+	fallbackReasonError       = "error"
+	fallbackReasonTTFTBudget  = "ttft_budget"
+	fallbackReasonCircuitOpen = "circuit_open"
 )
 
+// errTTFTBudget is returned when an attempt produced no token within the budget.
+var errTTFTBudget = errors.New("no token within latency budget")
+
 // AgentLLM routes LLM requests to the correct provider using the openai-agents-go SDK.
 // Engines registered via RegisterRaw bypass the SDK and use a direct HTTP client.
 type AgentLLM struct {
 	providers  map[string]agents.ModelProvider
 	rawClients map[string]LLMChatClient
 	models     map[string]string // engine → default model
+	params     map[string]OpenAIParams
 	fallback   string
 	maxTokens  int
+
+	// fallbackChain is the ordered list of engines tried after the requested
+	// engine fails. ttftBudget aborts an attempt that has produced no token
+	// within the budget so the next engine can take over (0 = no budget).
+	fallbackChain []string
+	ttftBudget    time.Duration
+
+	breakers *Breakers // nil = no circuit breaking
+	onRoute  func(engine, model string)
 }
 
 // NewAgentLLM creates a new AgentLLM with the given fallback engine and max tokens.
@@ -28,6 +53,7 @@ func NewAgentLLM(fallback string, maxTokens int) *AgentLLM {
 		providers:  make(map[string]agents.ModelProvider),
 		rawClients: make(map[string]LLMChatClient),
 		models:     make(map[string]string),
+		params:     make(map[string]OpenAIParams),
 		fallback:   fallback,
 		maxTokens:  maxTokens,
 	}
@@ -39,12 +65,39 @@ func (a *AgentLLM) Register(engine string, provider agents.ModelProvider, defaul
 	a.models[engine] = defaultModel
 }
 
+// SetParams sets the request parameters of an SDK engine. Raw clients take
+// theirs at construction.
+func (a *AgentLLM) SetParams(engine string, p OpenAIParams) {
+	a.params[engine] = p
+}
+
 // RegisterRaw adds a direct HTTP client for engines that bypass the SDK (e.g. completions-only models).
 func (a *AgentLLM) RegisterRaw(engine string, client LLMChatClient, defaultModel string) {
 	a.rawClients[engine] = client
 	a.models[engine] = defaultModel
 }
 
+// SetFallbackChain configures the ordered engines to retry on when the
+// requested engine errors or misses the time-to-first-token budget.
+// Unregistered engines in the chain are skipped at request time.
+func (a *AgentLLM) SetFallbackChain(chain []string, ttftBudget time.Duration) {
+	a.fallbackChain = chain
+	a.ttftBudget = ttftBudget
+}
+
+// OnRoute registers a hook called with the engine and model of every
+// attempt (e.g. to record which model is in use). Set before serving.
+func (a *AgentLLM) OnRoute(fn func(engine, model string)) {
+	a.onRoute = fn
+}
+
+// SetBreakers guards every engine with a circuit breaker from b. An engine
+// whose breaker is open is skipped for the next one in the fallback chain.
+// Set before serving.
+func (a *AgentLLM) SetBreakers(b *Breakers) {
+	a.breakers = b
+}
+
 // Engines returns the names of all registered backends.
 func (a *AgentLLM) Engines() []string {
 	seen := make(map[string]bool, len(a.providers)+len(a.rawClients))
@@ -71,16 +124,150 @@ func (a *AgentLLM) Has(engine string) bool {
 	return ok
 }
 
-// Chat streams a completion from the resolved provider.
+// ModelFor returns the model a request to engine runs on: the override
+// when set, otherwise the engine's registered default.
+func (a *AgentLLM) ModelFor(engine, model string) string {
+	if model != "" {
+		return model
+	}
+	if m := a.models[engine]; m != "" {
+		return m
+	}
+	return a.models[a.fallback]
+}
+
+// Chat streams a completion from the requested engine, retrying on the
+// fallback chain when an attempt fails before emitting any token. Once a
+// token has reached onToken the turn is committed to that engine, since
+// downstream consumers (TTS, client) have already seen its output.
+// Thinking is kept out of onToken and the result's Text: it streams to
+// onThinking (nil = dropped) and ends up in Thinking. Each switch to the
+// next engine goes to onFallback (nil = none) as it happens, and all of
+// them end up in Fallbacks.
+func (a *AgentLLM) Chat(ctx context.Context, messages []Message, systemPrompt, model, engine string, onToken, onThinking TokenCallback, onFallback FallbackCallback) (*LLMResult, error) {
+	attempts := a.attemptOrder(engine)
+	var fallbacks []LLMFallback
+
+	for i, eng := range attempts {
+		useModel := model
+		if i > 0 {
+			useModel = "" // caller's model override only applies to the requested engine
+		}
+		var result *LLMResult
+		var emitted bool
+		err := fmt.Errorf("llm %s: %w", eng, ErrCircuitOpen)
+		if a.breakers.Allow("llm", eng) {
+			if a.onRoute != nil {
+				a.onRoute(eng, a.ModelFor(eng, useModel))
+			}
+			result, emitted, err = a.chatAttempt(ctx, messages, systemPrompt, useModel, eng, onToken, onThinking)
+			a.breakers.Done(ctx, "llm", eng, err)
+		}
+		if err == nil {
+			result.Engine = eng
+			result.Model = a.ModelFor(eng, useModel)
+			result.Fallbacks = fallbacks
+			return result, nil
+		}
+		last := i == len(attempts)-1
+		if emitted || last || ctx.Err() != nil {
+			return nil, err
+		}
+
+		next := attempts[i+1]
+		reason := fallbackReasonError
+		if errors.Is(err, errTTFTBudget) {
+			reason = fallbackReasonTTFTBudget
+		}
+		if errors.Is(err, ErrCircuitOpen) {
+			reason = fallbackReasonCircuitOpen
+		}
+		slog.WarnContext(ctx, "llm fallback", "from", eng, "to", next, "reason", reason, "error", err)
+		metrics.LLMFallbacks.WithLabelValues(eng, next, reason).Inc()
+		fallback := LLMFallback{From: eng, To: next, Reason: reason, Error: err.Error()}
+		fallbacks = append(fallbacks, fallback)
+		if onFallback != nil {
+			onFallback(fallback)
+		}
+	}
+	return nil, fmt.Errorf("no llm provider for engine %q", engine)
+}
+
+// attemptOrder returns the requested engine followed by the registered
+// fallback chain engines, without duplicates.
+func (a *AgentLLM) attemptOrder(engine string) []string {
+	order := []string{engine}
+	for _, eng := range a.fallbackChain {
+		if eng == engine || !a.Has(eng) {
+			continue
+		}
+		order = append(order, eng)
+	}
+	return order
+}
+
+// Attempt states shared between the token callback and the TTFT timer.
+const (
+	attemptWaiting int32 = iota
+	attemptStreaming
+	attemptTimedOut
+)
+
+// chatAttempt runs one engine with the TTFT budget enforced. Reports whether
+// any token, thinking included, was forwarded so the caller knows if retry
+// is safe.
+func (a *AgentLLM) chatAttempt(ctx context.Context, messages []Message, systemPrompt, model, engine string, onToken, onThinking TokenCallback) (*LLMResult, bool, error) {
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var state atomic.Int32
+	var split thinkSplitter
+	emit := func(text, thinking string) {
+		if thinking != "" && onThinking != nil {
+			onThinking(thinking)
+		}
+		if text != "" && onToken != nil {
+			onToken(text)
+		}
+	}
+	forward := func(token string) {
+		if state.CompareAndSwap(attemptWaiting, attemptStreaming) || state.Load() == attemptStreaming {
+			emit(split.split(token))
+		}
+	}
+
+	if a.ttftBudget > 0 {
+		timer := time.AfterFunc(a.ttftBudget, func() {
+			if state.CompareAndSwap(attemptWaiting, attemptTimedOut) {
+				cancel()
+			}
+		})
+		defer timer.Stop()
+	}
+
+	result, err := a.chatOnce(attemptCtx, messages, systemPrompt, model, engine, forward)
+	emitted := state.Load() == attemptStreaming
+	if state.Load() == attemptTimedOut {
+		return nil, false, fmt.Errorf("llm %s: %w (%s)", engine, errTTFTBudget, a.ttftBudget)
+	}
+	if err != nil {
+		return result, emitted, err
+	}
+	emit(split.flush())
+	result.Text, result.Thinking = splitThinking(result.Text)
+	return result, emitted, nil
+}
+
+// chatOnce streams a completion from a single engine.
 // Lookup order: try raw HTTP clients first (completions-only models that
 // bypass the SDK), then fall back to SDK providers (openai-agents-go).
-func (a *AgentLLM) Chat(ctx context.Context, userMessage, systemPrompt, model, engine string, onToken TokenCallback) (*LLMResult, error) {
+func (a *AgentLLM) chatOnce(ctx context.Context, messages []Message, systemPrompt, model, engine string, onToken TokenCallback) (*LLMResult, error) {
 	if raw, ok := a.rawClients[engine]; ok {
 		useModel := model
 		if useModel == "" {
 			useModel = a.models[engine]
 		}
-		return raw.Chat(ctx, userMessage, systemPrompt, useModel, onToken)
+		return raw.Chat(ctx, messages, systemPrompt, useModel, onToken)
 	}
 
 	provider, useModel, err := a.resolve(engine, model)
@@ -88,12 +275,15 @@ func (a *AgentLLM) Chat(ctx context.Context, userMessage, systemPrompt, model, e
 		return nil, err
 	}
 
+	settings := modelsettings.ModelSettings{
+		MaxTokens:    param.NewOpt(int64(a.maxTokens)),
+		IncludeUsage: param.NewOpt(true),
+	}
+	a.params[engine].apply(&settings)
 	agent := agents.New("assistant").
 		WithInstructions(systemPrompt).
 		WithModel(useModel).
-		WithModelSettings(modelsettings.ModelSettings{
-			MaxTokens: param.NewOpt(int64(a.maxTokens)),
-		})
+		WithModelSettings(settings)
 
 	runner := agents.Runner{Config: agents.RunConfig{
 		ModelProvider:   provider,
@@ -103,7 +293,7 @@ func (a *AgentLLM) Chat(ctx context.Context, userMessage, systemPrompt, model, e
 
 	start := time.Now()
 
-	events, errCh, err := runner.RunStreamedChan(ctx, agent, userMessage)
+	events, errCh, err := runner.RunInputStreamedChan(ctx, agent, responseInputs(messages))
 	if err != nil {
 		return nil, fmt.Errorf("llm stream start: %w", err)
 	}
@@ -119,7 +309,7 @@ func (a *AgentLLM) Chat(ctx context.Context, userMessage, systemPrompt, model, e
 		return nil, fmt.Errorf("llm stream: %w", streamErr)
 	}
 	if streamErr != nil {
-		slog.Warn("llm stream ended with error after receiving tokens", "error", streamErr)
+		slog.WarnContext(ctx, "llm stream ended with error after receiving tokens", "error", streamErr)
 	}
 
 	latency := time.Since(start)
@@ -133,14 +323,32 @@ func (a *AgentLLM) Chat(ctx context.Context, userMessage, systemPrompt, model, e
 		Text:               textBuf.String(),
 		LatencyMs:          float64(latency.Milliseconds()),
 		TimeToFirstTokenMs: ttft,
+		PromptTokens:       sr.promptTokens,
+		CachedPromptTokens: sr.cachedPromptTokens,
+		CompletionTokens:   sr.completionTokens,
 	}, nil
 }
 
+// responseInputs converts chat history to Responses API input messages.
+func responseInputs(messages []Message) []agents.TResponseInputItem {
+	items := make([]agents.TResponseInputItem, len(messages))
+	for i, m := range messages {
+		items[i] = responses.ResponseInputItemParamOfMessage(m.Content, responses.EasyInputMessageRole(m.Role))
+	}
+	return items
+}
+
 func handleStreamEvent(ev agents.StreamEvent, sr *streamResult, onToken TokenCallback, textBuf *strings.Builder) {
 	raw, ok := ev.(agents.RawResponsesStreamEvent)
 	if !ok {
 		return
 	}
+	if raw.Data.Type == "response.completed" {
+		sr.promptTokens = int(raw.Data.Response.Usage.InputTokens)
+		sr.cachedPromptTokens = int(raw.Data.Response.Usage.InputTokensDetails.CachedTokens)
+		sr.completionTokens = int(raw.Data.Response.Usage.OutputTokens)
+		return
+	}
 	if raw.Data.Type != "response.output_text.delta" {
 		return
 	}
@@ -13,38 +13,56 @@ import (
 
 // AnthropicClient implements LLMChatClient using the native Anthropic Messages API.
 type AnthropicClient struct {
-	baseURL    string
-	apiKey     string
-	maxTokens  int
-	httpClient *http.Client
+	baseURL        string
+	apiKey         string
+	maxTokens      int
+	promptCaching  bool
+	thinkingBudget int
+	httpClient     *http.Client
 }
 
 // NewAnthropicClient creates an AnthropicClient backed by an HTTP/1.1 transport
-// optimised for SSE streaming (HTTP/2 frame buffering adds visible latency).
-func NewAnthropicClient(baseURL, apiKey string, maxTokens int) *AnthropicClient {
+// optimised for SSE streaming. With promptCaching, the system prompt is
+// marked for Anthropic's prompt cache: it is the same on every turn, so
+// later turns read it from the cache at a fraction of the input price
+// (prompts under the model's minimum cacheable length are sent as usual).
+// A positive thinkingBudget turns on extended thinking with that many
+// tokens to think in; the thinking streams wrapped in <think> tags.
+func NewAnthropicClient(baseURL, apiKey string, maxTokens int, promptCaching bool, thinkingBudget int) *AnthropicClient {
 	return &AnthropicClient{
-		baseURL:   strings.TrimRight(baseURL, "/"),
-		apiKey:    apiKey,
-		maxTokens: maxTokens,
-		httpClient: &http.Client{
-			Timeout: 120 * time.Second,
-			Transport: &http.Transport{
-				MaxIdleConns:        10,
-				MaxIdleConnsPerHost: 10,
-				IdleConnTimeout:     90 * time.Second,
-				ForceAttemptHTTP2:   false, // HTTP/1.1 chunked delivers SSE tokens immediately
-				DisableCompression:  true,  // avoid gzip buffering on streamed responses
-			},
-		},
+		baseURL:        strings.TrimRight(baseURL, "/"),
+		apiKey:         apiKey,
+		maxTokens:      maxTokens,
+		promptCaching:  promptCaching,
+		thinkingBudget: thinkingBudget,
+		httpClient:     newStreamingHTTPClient(),
 	}
 }
 
 type anthropicReq struct {
-	Model     string           `json:"model"`
-	MaxTokens int              `json:"max_tokens"`
-	System    string           `json:"system,omitempty"`
-	Messages  []anthropicMsg   `json:"messages"`
-	Stream    bool             `json:"stream"`
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    any                `json:"system,omitempty"` // string, or []anthropicBlock to cache it
+	Messages  []anthropicMsg     `json:"messages"`
+	Thinking  *anthropicThinking `json:"thinking,omitempty"`
+	Stream    bool               `json:"stream"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+// anthropicBlock is a text content block; CacheControl makes it the end of
+// the cached prefix.
+type anthropicBlock struct {
+	Type         string         `json:"type"`
+	Text         string         `json:"text"`
+	CacheControl *anthropicMark `json:"cache_control,omitempty"`
+}
+
+type anthropicMark struct {
+	Type string `json:"type"`
 }
 
 type anthropicMsg struct {
@@ -53,26 +71,53 @@ type anthropicMsg struct {
 }
 
 type anthropicSSEData struct {
-	Type  string          `json:"type"`
-	Delta json.RawMessage `json:"delta,omitempty"`
+	Type    string          `json:"type"`
+	Delta   json.RawMessage `json:"delta,omitempty"`
+	Usage   *anthropicUsage `json:"usage,omitempty"` // message_delta: cumulative output tokens
+	Message *struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message,omitempty"` // message_start: input tokens
 	Error *struct {
 		Message string `json:"message"`
 	} `json:"error,omitempty"`
 }
 
+// anthropicUsage counts input in three parts: uncached, written to the
+// cache, and read from it.
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+}
+
 type anthropicDelta struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Thinking string `json:"thinking"` // thinking_delta
 }
 
-func (c *AnthropicClient) Chat(ctx context.Context, userMessage, systemPrompt, model string, onToken TokenCallback) (*LLMResult, error) {
-	body, err := json.Marshal(anthropicReq{
+func (c *AnthropicClient) Chat(ctx context.Context, messages []Message, systemPrompt, model string, onToken TokenCallback) (*LLMResult, error) {
+	msgs := make([]anthropicMsg, len(messages))
+	for i, m := range messages {
+		msgs[i] = anthropicMsg{Role: m.Role, Content: m.Content}
+	}
+
+	var system any = systemPrompt
+	if c.promptCaching && systemPrompt != "" {
+		system = []anthropicBlock{{Type: "text", Text: systemPrompt, CacheControl: &anthropicMark{Type: "ephemeral"}}}
+	}
+	reqBody := anthropicReq{
 		Model:     model,
 		MaxTokens: c.maxTokens,
-		System:    systemPrompt,
-		Messages:  []anthropicMsg{{Role: "user", Content: userMessage}},
+		System:    system,
+		Messages:  msgs,
 		Stream:    true,
-	})
+	}
+	if c.thinkingBudget > 0 {
+		reqBody.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: c.thinkingBudget}
+	}
+	body, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("anthropic marshal: %w", err)
 	}
@@ -84,6 +129,9 @@ func (c *AnthropicClient) Chat(ctx context.Context, userMessage, systemPrompt, m
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("x-api-key", c.apiKey)
 	req.Header.Set("anthropic-version", "2023-06-01")
+	if c.promptCaching {
+		req.Header.Set("anthropic-beta", "prompt-caching-2024-07-31")
+	}
 
 	start := time.Now()
 
@@ -99,6 +147,17 @@ func (c *AnthropicClient) Chat(ctx context.Context, userMessage, systemPrompt, m
 
 	var textBuf strings.Builder
 	var ttft time.Time
+	var usage anthropicUsage
+	thinking := false // inside a thinking block
+	emit := func(token string) {
+		if ttft.IsZero() {
+			ttft = time.Now()
+		}
+		textBuf.WriteString(token)
+		if onToken != nil {
+			onToken(token)
+		}
+	}
 
 	scanner := bufio.NewScanner(resp.Body)
 	for scanner.Scan() {
@@ -123,6 +182,19 @@ func (c *AnthropicClient) Chat(ctx context.Context, userMessage, systemPrompt, m
 			break
 		}
 
+		if evt.Message != nil {
+			u := evt.Message.Usage
+			usage.InputTokens, usage.CacheCreationInputTokens, usage.CacheReadInputTokens = u.InputTokens, u.CacheCreationInputTokens, u.CacheReadInputTokens
+		}
+		if evt.Usage != nil {
+			usage.OutputTokens = evt.Usage.OutputTokens
+		}
+
+		if evt.Type == "content_block_stop" && thinking {
+			thinking = false
+			emit(thinkClose)
+			continue
+		}
 		if evt.Type != "content_block_delta" {
 			continue
 		}
@@ -132,13 +204,16 @@ func (c *AnthropicClient) Chat(ctx context.Context, userMessage, systemPrompt, m
 			continue
 		}
 
-		if ttft.IsZero() {
-			ttft = time.Now()
+		token := delta.Text
+		if delta.Type == "thinking_delta" {
+			token = delta.Thinking
+			if !thinking {
+				thinking = true
+				token = thinkOpen + token
+			}
 		}
-
-		textBuf.WriteString(delta.Text)
-		if onToken != nil {
-			onToken(delta.Text)
+		if token != "" {
+			emit(token)
 		}
 	}
 
@@ -152,5 +227,8 @@ func (c *AnthropicClient) Chat(ctx context.Context, userMessage, systemPrompt, m
 		Text:               textBuf.String(),
 		LatencyMs:          float64(latency.Milliseconds()),
 		TimeToFirstTokenMs: ttftMs,
+		PromptTokens:       usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens,
+		CachedPromptTokens: usage.CacheReadInputTokens,
+		CompletionTokens:   usage.OutputTokens,
 	}, nil
 }
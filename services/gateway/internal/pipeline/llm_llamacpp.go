@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LlamaCppClient implements LLMChatClient against llama.cpp's native
+// /completion endpoint. That endpoint takes a raw prompt, so the
+// conversation is flattened into a plain "User:/Assistant:" transcript.
+type LlamaCppClient struct {
+	baseURL    string
+	maxTokens  int
+	httpClient *http.Client
+}
+
+// NewLlamaCppClient creates a client for a llama.cpp server. The server
+// serves whichever model it was started with, so the model name passed to
+// Chat is only used for labelling.
+func NewLlamaCppClient(baseURL string, maxTokens int) *LlamaCppClient {
+	return &LlamaCppClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		maxTokens:  maxTokens,
+		httpClient: newStreamingHTTPClient(),
+	}
+}
+
+type llamaCppReq struct {
+	Prompt      string   `json:"prompt"`
+	NPredict    int      `json:"n_predict,omitempty"`
+	Stop        []string `json:"stop"`
+	Stream      bool     `json:"stream"`
+	CachePrompt bool     `json:"cache_prompt"`
+}
+
+type llamaCppChunk struct {
+	Content         string `json:"content"`
+	Stop            bool   `json:"stop"`
+	TokensEvaluated int    `json:"tokens_evaluated"`
+	TokensPredicted int    `json:"tokens_predicted"`
+}
+
+// llamaCppSpeakers labels each role in the flattened prompt.
+var llamaCppSpeakers = map[string]string{
+	RoleUser:      "User",
+	RoleAssistant: "Assistant",
+}
+
+func (c *LlamaCppClient) Chat(ctx context.Context, messages []Message, systemPrompt, model string, onToken TokenCallback) (*LLMResult, error) {
+	body, err := json.Marshal(llamaCppReq{
+		Prompt:      llamaCppPrompt(messages, systemPrompt),
+		NPredict:    c.maxTokens,
+		Stop:        []string{"\nUser:", "\nAssistant:"},
+		Stream:      true,
+		CachePrompt: true, // reuse the KV cache for the shared conversation prefix
+	})
+	if err != nil {
+		return nil, fmt.Errorf("llama.cpp marshal: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/completion", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("llama.cpp request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("llama.cpp do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("llama.cpp status %d", resp.StatusCode)
+	}
+
+	var textBuf strings.Builder
+	var ttft time.Time
+	result := &LLMResult{}
+
+	err = scanSSE(resp.Body, func(payload string) bool {
+		var chunk llamaCppChunk
+		if json.Unmarshal([]byte(payload), &chunk) != nil {
+			return true
+		}
+		if chunk.Content != "" {
+			if ttft.IsZero() {
+				ttft = time.Now()
+			}
+			textBuf.WriteString(chunk.Content)
+			if onToken != nil {
+				onToken(chunk.Content)
+			}
+		}
+		if chunk.Stop {
+			result.PromptTokens = chunk.TokensEvaluated
+			result.CompletionTokens = chunk.TokensPredicted
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("llama.cpp read: %w", err)
+	}
+
+	result.Text = strings.TrimSpace(textBuf.String())
+	result.LatencyMs = float64(time.Since(start).Milliseconds())
+	result.TimeToFirstTokenMs = msSince(start, ttft)
+	return result, nil
+}
+
+// llamaCppPrompt renders the system prompt and conversation as a transcript
+// ending with an open "Assistant:" turn for the model to complete.
+func llamaCppPrompt(messages []Message, systemPrompt string) string {
+	var b strings.Builder
+	if systemPrompt != "" {
+		b.WriteString(systemPrompt)
+		b.WriteString("\n\n")
+	}
+	for _, m := range messages {
+		b.WriteString(llamaCppSpeakers[m.Role])
+		b.WriteString(": ")
+		b.WriteString(m.Content)
+		b.WriteString("\n")
+	}
+	b.WriteString("Assistant:")
+	return b.String()
+}
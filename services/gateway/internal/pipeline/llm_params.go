@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nlpodyssey/openai-agents-go/modelsettings"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+	"github.com/openai/openai-go/v2/packages/param"
+	"github.com/openai/openai-go/v2/responses"
+	"github.com/openai/openai-go/v2/shared"
+)
+
+// OpenAIParams are request parameters for an engine on an OpenAI-compatible
+// API (openai, ollama, vllm). A fixed seed and temperature 0 make replies
+// reproducible, e.g. for evaluation runs. Unset fields are left to the
+// server's defaults.
+type OpenAIParams struct {
+	Seed        *int64   `json:"seed,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	// ResponseFormat is "text" or "json_object" ("" = text).
+	ResponseFormat string `json:"response_format,omitempty"`
+}
+
+// Validate reports a response_format the APIs don't accept.
+func (p OpenAIParams) Validate() error {
+	if p.ResponseFormat != "" && p.ResponseFormat != "text" && p.ResponseFormat != "json_object" {
+		return fmt.Errorf("response_format must be \"text\" or \"json_object\", got %q", p.ResponseFormat)
+	}
+	return nil
+}
+
+// apply sets p on an SDK request, whichever API the engine's provider uses.
+// The Responses API has no seed, so it is only sent to Chat Completions.
+func (p OpenAIParams) apply(s *modelsettings.ModelSettings) {
+	if p.Temperature != nil {
+		s.Temperature = param.NewOpt(*p.Temperature)
+	}
+	jsonObject := p.ResponseFormat == "json_object"
+	s.CustomizeChatCompletionsRequest = func(_ context.Context, req *openai.ChatCompletionNewParams, opts []option.RequestOption) (*openai.ChatCompletionNewParams, []option.RequestOption, error) {
+		if p.Seed != nil {
+			req.Seed = param.NewOpt(*p.Seed)
+		}
+		if jsonObject {
+			req.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{OfJSONObject: &shared.ResponseFormatJSONObjectParam{}}
+		}
+		return req, opts, nil
+	}
+	s.CustomizeResponsesRequest = func(_ context.Context, req *responses.ResponseNewParams, opts []option.RequestOption) (*responses.ResponseNewParams, []option.RequestOption, error) {
+		if jsonObject {
+			req.Text.Format = responses.ResponseFormatTextConfigUnionParam{OfJSONObject: &shared.ResponseFormatJSONObjectParam{}}
+		}
+		return req, opts, nil
+	}
+}
+
+// responseFormat is the response_format field of a raw Chat Completions
+// request (nil = text).
+func (p OpenAIParams) responseFormat() *chatResponseFormat {
+	if p.ResponseFormat != "json_object" {
+		return nil
+	}
+	return &chatResponseFormat{Type: "json_object"}
+}
+
+type chatResponseFormat struct {
+	Type string `json:"type"`
+}
@@ -0,0 +1,49 @@
+package pipeline
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// newStreamingHTTPClient returns an HTTP/1.1 client tuned for SSE streaming
+// (HTTP/2 frame buffering and gzip both add visible token latency).
+func newStreamingHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 120 * time.Second,
+		Transport: &http.Transport{
+			MaxIdleConns:        10,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+			ForceAttemptHTTP2:   false, // HTTP/1.1 chunked delivers SSE tokens immediately
+			DisableCompression:  true,  // avoid gzip buffering on streamed responses
+		},
+	}
+}
+
+// scanSSE calls fn with the payload of each "data: " line until fn returns
+// false, the body ends, or the stream sends [DONE].
+func scanSSE(body io.Reader, fn func(payload string) bool) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		payload, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if payload == "[DONE]" || !fn(payload) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// msSince returns the milliseconds from start to t, or 0 if t is unset.
+func msSince(start, t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.Sub(start).Milliseconds())
+}
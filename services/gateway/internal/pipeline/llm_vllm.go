@@ -0,0 +1,150 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAIChatClient implements LLMChatClient against an OpenAI-compatible
+// /v1/chat/completions server such as vLLM, streaming over SSE.
+type OpenAIChatClient struct {
+	baseURL    string
+	apiKey     string
+	maxTokens  int
+	params     OpenAIParams
+	httpClient *http.Client
+}
+
+// NewOpenAIChatClient creates a client for an OpenAI-compatible chat server.
+// apiKey may be empty for servers started without --api-key.
+func NewOpenAIChatClient(baseURL, apiKey string, maxTokens int, params OpenAIParams) *OpenAIChatClient {
+	return &OpenAIChatClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		maxTokens:  maxTokens,
+		params:     params,
+		httpClient: newStreamingHTTPClient(),
+	}
+}
+
+type chatCompletionReq struct {
+	Model          string              `json:"model"`
+	Messages       []Message           `json:"messages"`
+	MaxTokens      int                 `json:"max_tokens,omitempty"`
+	Seed           *int64              `json:"seed,omitempty"`
+	Temperature    *float64            `json:"temperature,omitempty"`
+	ResponseFormat *chatResponseFormat `json:"response_format,omitempty"`
+	Stream         bool                `json:"stream"`
+	StreamOptions  struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+type chatCompletionChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens        int `json:"prompt_tokens"`
+		CompletionTokens    int `json:"completion_tokens"`
+		PromptTokensDetails struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"prompt_tokens_details"`
+	} `json:"usage,omitempty"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (c *OpenAIChatClient) Chat(ctx context.Context, messages []Message, systemPrompt, model string, onToken TokenCallback) (*LLMResult, error) {
+	reqBody := chatCompletionReq{
+		Model:          model,
+		MaxTokens:      c.maxTokens,
+		Seed:           c.params.Seed,
+		Temperature:    c.params.Temperature,
+		ResponseFormat: c.params.responseFormat(),
+		Stream:         true,
+	}
+	reqBody.StreamOptions.IncludeUsage = true
+	if systemPrompt != "" {
+		reqBody.Messages = append(reqBody.Messages, Message{Role: "system", Content: systemPrompt})
+	}
+	reqBody.Messages = append(reqBody.Messages, messages...)
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("chat completions marshal: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("chat completions request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	start := time.Now()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("chat completions do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("chat completions status %d", resp.StatusCode)
+	}
+
+	var textBuf strings.Builder
+	var ttft time.Time
+	result := &LLMResult{}
+	var streamErr error
+
+	err = scanSSE(resp.Body, func(payload string) bool {
+		var chunk chatCompletionChunk
+		if json.Unmarshal([]byte(payload), &chunk) != nil {
+			return true
+		}
+		if chunk.Error != nil {
+			streamErr = fmt.Errorf("chat completions stream error: %s", chunk.Error.Message)
+			return false
+		}
+		if chunk.Usage != nil {
+			result.PromptTokens = chunk.Usage.PromptTokens
+			result.CachedPromptTokens = chunk.Usage.PromptTokensDetails.CachedTokens
+			result.CompletionTokens = chunk.Usage.CompletionTokens
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			if ttft.IsZero() {
+				ttft = time.Now()
+			}
+			textBuf.WriteString(choice.Delta.Content)
+			if onToken != nil {
+				onToken(choice.Delta.Content)
+			}
+		}
+		return true
+	})
+	if streamErr != nil {
+		return nil, streamErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("chat completions read: %w", err)
+	}
+
+	result.Text = textBuf.String()
+	result.LatencyMs = float64(time.Since(start).Milliseconds())
+	result.TimeToFirstTokenMs = msSince(start, ttft)
+	return result, nil
+}
@@ -0,0 +1,255 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/trace"
+)
+
+// moderationTimeout bounds one classifier call; moderation holds back the
+// reply's text and speech, so a slow classifier must not stall it for long.
+const moderationTimeout = 3 * time.Second
+
+// Moderator screens a sentence of LLM output before it is sent or spoken.
+type Moderator interface {
+	Moderate(ctx context.Context, sentence string) (*ModerationFlag, error)
+}
+
+// ModerationFlag describes a sentence a Moderator objected to. Replacement is
+// sent and spoken instead of the sentence; an empty Replacement drops it.
+type ModerationFlag struct {
+	Source      string `json:"source"`   // "deny_list" or "classifier"
+	Category    string `json:"category"` // rule or classifier label
+	Action      string `json:"action"`   // "rewrite" or "block"
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// ModerationConfig configures the moderation stage. Deny-list rules run
+// first; the classifier only sees sentences that pass them.
+type ModerationConfig struct {
+	DenyList        []ModerationRule `json:"deny_list"`
+	ClassifierModel string           `json:"classifier_model"` // Ollama model, e.g. "llama-guard3:1b" ("" = off)
+	BlockedMessage  string           `json:"blocked_message"`  // spoken in place of blocked sentences ("" = silence)
+}
+
+// ModerationRule is one deny-list entry. With a Replacement, matches are
+// rewritten in place; without one, the whole sentence is blocked.
+type ModerationRule struct {
+	Pattern     string `json:"pattern"` // RE2 regular expression
+	Category    string `json:"category"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// NewModerator builds the moderation stage from config. Returns nil when no
+// rules or classifier are configured.
+func NewModerator(cfg ModerationConfig, ollamaURL string) (Moderator, error) {
+	var chain moderatorChain
+	if len(cfg.DenyList) > 0 {
+		dl, err := newDenyList(cfg.DenyList, cfg.BlockedMessage)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, dl)
+	}
+	if cfg.ClassifierModel != "" {
+		chain = append(chain, newOllamaModerator(ollamaURL, cfg.ClassifierModel, cfg.BlockedMessage))
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// moderatorChain runs moderators in order and stops at the first flag.
+// A rewrite is then spoken as-is; it is not re-screened.
+type moderatorChain []Moderator
+
+func (c moderatorChain) Moderate(ctx context.Context, sentence string) (*ModerationFlag, error) {
+	for _, m := range c {
+		flag, err := m.Moderate(ctx, sentence)
+		if err != nil || flag != nil {
+			return flag, err
+		}
+	}
+	return nil, nil
+}
+
+// screen holds LLM output back a sentence at a time and passes each through
+// the Moderator before any of it is emitted, so llm_token events, TTS, the
+// history, traces and the semantic cache only ever see screened text. A nil
+// *screen (no Moderator) passes tokens straight through.
+type screen struct {
+	p       *Pipeline
+	ctx     context.Context
+	onEvent EventCallback
+	parent  trace.SpanRef
+	pending strings.Builder
+	reply   strings.Builder // screened text so far
+}
+
+func (p *Pipeline) newScreen(ctx context.Context, onEvent EventCallback, parent trace.SpanRef) *screen {
+	if p.cfg.Moderator == nil {
+		return nil
+	}
+	return &screen{p: p, ctx: ctx, onEvent: onEvent, parent: parent}
+}
+
+// add returns the text token lets through: all of it without a Moderator,
+// otherwise the screened sentences it completes ("" while one is pending).
+func (s *screen) add(token string) string {
+	if s == nil {
+		return token
+	}
+	s.pending.WriteString(token)
+	buf := s.pending.String()
+	end := screenBoundary(buf)
+	if end < 0 {
+		return ""
+	}
+	s.pending.Reset()
+	s.pending.WriteString(buf[end:])
+	return s.check(buf[:end])
+}
+
+// flush screens what is left pending at the end of the stream.
+func (s *screen) flush() string {
+	if s == nil {
+		return ""
+	}
+	rest := s.pending.String()
+	s.pending.Reset()
+	return s.check(rest)
+}
+
+// check moderates one chunk, keeping the whitespace around it so the
+// screened reply reads like the streamed one.
+func (s *screen) check(chunk string) string {
+	out := chunk
+	if sentence := strings.TrimSpace(chunk); sentence != "" {
+		i := strings.Index(chunk, sentence)
+		out = chunk[:i] + s.p.moderate(s.ctx, sentence, s.onEvent, s.parent) + chunk[i+len(sentence):]
+	}
+	s.reply.WriteString(out)
+	return out
+}
+
+// apply replaces the reply's text with the screened text.
+func (s *screen) apply(result *LLMResult) {
+	if s != nil && result != nil {
+		result.Text = strings.TrimSpace(s.reply.String())
+	}
+}
+
+// screenBoundary is the end of the last complete sentence or line in text,
+// or -1 when there is none yet.
+func screenBoundary(text string) int {
+	for i := len(text) - 1; i > 0; i-- {
+		if text[i] == '\n' || isWordBoundary(text[i]) && sentenceEnders[text[i-1]] {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// --- Deny list (regular expressions) ---
+
+type denyRule struct {
+	re *regexp.Regexp
+	ModerationRule
+}
+
+type denyList struct {
+	rules          []denyRule
+	blockedMessage string
+}
+
+func newDenyList(rules []ModerationRule, blockedMessage string) (*denyList, error) {
+	dl := &denyList{blockedMessage: blockedMessage}
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("moderation rule %q: %w", r.Pattern, err)
+		}
+		dl.rules = append(dl.rules, denyRule{re: re, ModerationRule: r})
+	}
+	return dl, nil
+}
+
+func (d *denyList) Moderate(_ context.Context, sentence string) (*ModerationFlag, error) {
+	for _, r := range d.rules {
+		if !r.re.MatchString(sentence) {
+			continue
+		}
+		if r.Replacement != "" {
+			return &ModerationFlag{Source: "deny_list", Category: r.Category, Action: "rewrite", Replacement: r.re.ReplaceAllString(sentence, r.Replacement)}, nil
+		}
+		return &ModerationFlag{Source: "deny_list", Category: r.Category, Action: "block", Replacement: d.blockedMessage}, nil
+	}
+	return nil, nil
+}
+
+// --- Ollama classifier (Llama Guard style "safe" / "unsafe\n<category>") ---
+
+type ollamaModerator struct {
+	url            string
+	model          string
+	blockedMessage string
+	client         *http.Client
+}
+
+func newOllamaModerator(ollamaURL, model, blockedMessage string) *ollamaModerator {
+	return &ollamaModerator{
+		url:            strings.TrimRight(ollamaURL, "/"),
+		model:          model,
+		blockedMessage: blockedMessage,
+		client:         &http.Client{Timeout: moderationTimeout},
+	}
+}
+
+func (o *ollamaModerator) Moderate(ctx context.Context, sentence string) (*ModerationFlag, error) {
+	body, err := json.Marshal(map[string]any{
+		"model":  o.model,
+		"stream": false,
+		"messages": []Message{
+			{Role: RoleUser, Content: "Customer service question."},
+			{Role: RoleAssistant, Content: sentence},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("moderation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("moderation http: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation status %d", resp.StatusCode)
+	}
+
+	var out struct {
+		Message Message `json:"message"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("moderation decode: %w", err)
+	}
+
+	verdict, category, _ := strings.Cut(strings.TrimSpace(out.Message.Content), "\n")
+	if !strings.EqualFold(strings.TrimSpace(verdict), "unsafe") {
+		return nil, nil
+	}
+	return &ModerationFlag{Source: "classifier", Category: strings.TrimSpace(category), Action: "block", Replacement: o.blockedMessage}, nil
+}
@@ -3,14 +3,22 @@ package pipeline
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/denoise"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/flow"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/logctx"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/redact"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/trace"
 )
 
@@ -27,8 +35,8 @@ const (
 	// the LLM producer and the TTS consumer before back-pressure kicks in.
 	sentenceChannelBuffer = 4
 
-	// ttsSilenceSampleRate is the sample rate used when generating
-	// inter-sentence silence WAV chunks.
+	// ttsSilenceSampleRate is the sample rate of inter-sentence silence
+	// after a clip whose own rate can't be read (e.g. MP3).
 	ttsSilenceSampleRate = 24000
 )
 
@@ -62,12 +70,21 @@ type Config struct {
 	TTSClient           *TTSRouter
 	VADConfig           audio.VADConfig
 	SessionID           string
+	Tenant              string // isolates shared state such as the semantic cache ("" = untenanted)
+	Experiment          string // A/B experiment the session is in, labelling its turn metrics
+	Variant             string // the session's arm of Experiment ("" = not in one)
 	SystemPrompt        string
 	LLMModel            string
 	LLMEngine           string
 	Denoiser            *denoise.Denoiser
+	// DenoisePerChunk runs the Denoiser on every chunk ahead of the VAD
+	// (false = on each speech segment once the VAD has cut it).
+	DenoisePerChunk     bool
+	// Resampler brings caller audio to 16 kHz ("" = linear).
+	Resampler           audio.ResampleMode
 	NoiseSuppression    bool
 	ASRPrompt            string
+	ASRModel             string // per-session ASR model ("" = whatever the engine has loaded)
 	ConfidenceThreshold  float64
 	ReferenceTranscript  string
 	TTSSpeed             float64
@@ -77,28 +94,108 @@ type Config struct {
 	ClassifyClient       *ClassifyClient
 	AudioClassification  bool
 	Tracer               *trace.Tracer
+	Pricing              *Pricing // cost estimates for LLM tokens and TTS characters
+	History              []Turn // prior turns restored from a resumed session
+	Diarization          bool   // label speaker turns in transcripts (snippet mode)
+	Stereo               bool   // snippet audio is 2-channel PCM: caller left, agent right
+	Language             string            // ASR language: "" server default, "auto" detect, or a code
+	TTSVoices            map[string]string // language code → TTS voice override
+	EchoSuppression      bool              // ignore mic audio that matches our own TTS playback
+	DTMF                 bool              // detect in-band keypad tones (talk mode)
+	Narrowband           bool              // simulate an 8 kHz 300–3400 Hz telephone channel
+	AGC                  bool              // normalize quiet callers before VAD
+	AGCTargetDB          float64           // AGC target RMS in dBFS (0 = audio.DefaultAGCTargetDB)
+	Moderator            Moderator         // screens LLM sentences before they are sent or spoken (nil = off)
+	Redactor             *redact.Redactor  // masks PII in logged text (nil = log verbatim)
+	Filler               *FillerCache      // thinking audio when the first sentence is slow (nil = off)
+	ComfortNoise         ComfortNoiseConfig // keep-alive noise while a voice turn is thinking
+	Flow                 *flow.Session     // scripted call flow constraining the prompt (nil = open chat)
+	SemanticCache        *SemanticCache    // answers repeated questions without the LLM (nil = off)
+	CacheBypass          bool              // skip the semantic cache for this session
+	Intents              *IntentRouter     // classifies caller turns and routes on intent (nil = off)
+	Handoff              *Handoff          // lets the agent escalate the call to a human (nil = off)
+	OnHold               bool              // the call was already handed off (a replacement pipeline)
+	Endpointing          EndpointConfig    // dynamic end-of-turn silence timeout (talk mode)
+	Budgets              Budgets           // per-stage turn timeouts (zero = backend defaults)
+	StreamThinking       bool              // send the model's thinking as thinking_token events
+	Talk                 *TalkTracker      // the call's talk time so far (nil = the call starts now)
 }
 
-// turn holds one user→assistant exchange for conversation history.
-type turn struct {
-	user      string
-	assistant string
+// Turn holds one user→assistant exchange for conversation history.
+type Turn struct {
+	User      string
+	Assistant string
 }
 
 // Pipeline processes a single call session through ASR → LLM → TTS.
 type Pipeline struct {
 	cfg        Config
 	vad        *audio.VAD
-	history    []turn
+	history    []Turn
 	snippetBuf []float32
+	agentBuf   []float32 // right channel of stereo snippet audio
+	fileBuf    []byte    // snippet upload in an audio container, decoded on process
+	language   string // caller's current language code ("" = unknown)
+	intent     string // intent of the call so far ("" = none detected)
+	echo       *audio.EchoSuppressor
+	dtmf       *audio.DTMFDetector
+	narrowband *audio.Narrowband
+	agc        *audio.AGC
+	decoder    *audio.Decoder // opened on the first chunk
+	// resamplers carry the caller's (0) and stereo agent's (1) stream
+	// through resampling, opened on the first chunk.
+	resamplers [2]*audio.Resampler
+
+	guidanceMu sync.Mutex
+	guidance   []string // supervisor whispers, oldest first
+
+	historyMu sync.Mutex // History can be read while a turn appends
+	turn      turnState
+
+	onHold        atomic.Bool // handed off to a human; the agent no longer answers
+	handoffReason string      // why the current turn escalates ("" = it doesn't)
+
+	endpoint *endpointer // nil = fixed silence timeout
+	talk     *TalkTracker
+
+	degraded sync.Map // EngineDegraded -> true once reported to the client
 }
 
 // New creates a pipeline for a single call session.
 func New(cfg Config) *Pipeline {
-	return &Pipeline{
-		cfg: cfg,
-		vad: audio.NewVAD(cfg.VADConfig),
+	vadCfg := cfg.VADConfig
+	var ep *endpointer
+	if cfg.Endpointing.Enabled {
+		ep = &endpointer{cfg: cfg.Endpointing.withDefaults()}
+		vadCfg.PauseProbe = time.Duration(ep.cfg.ProbeMs) * time.Millisecond
 	}
+	p := &Pipeline{
+		cfg:      cfg,
+		vad:      audio.NewVAD(vadCfg),
+		history:  cfg.History,
+		endpoint: ep,
+		talk:     cfg.Talk,
+	}
+	if p.talk == nil {
+		p.talk = NewTalkTracker()
+	}
+	p.onHold.Store(cfg.OnHold && cfg.Handoff != nil)
+	if cfg.Language != "auto" {
+		p.language = normalizeLanguage(cfg.Language)
+	}
+	if cfg.EchoSuppression {
+		p.echo = audio.NewEchoSuppressor(16000)
+	}
+	if cfg.DTMF {
+		p.dtmf = audio.NewDTMFDetector(16000)
+	}
+	if cfg.Narrowband {
+		p.narrowband = audio.NewNarrowband(16000)
+	}
+	if cfg.AGC {
+		p.agc = audio.NewAGC(16000, cfg.AGCTargetDB)
+	}
+	return p
 }
 
 // Event represents a pipeline output sent back to the client.
@@ -113,8 +210,32 @@ type Event struct {
 	LatencyMs       float64 `json:"latency_ms,omitempty"`
 	NoSpeechProb    float64 `json:"no_speech_prob"`
 	WER             float64 `json:"wer"`
+	CostUSD         float64 `json:"cost_usd,omitempty"`
 	NoiseSuppressed bool            `json:"noise_suppressed"`
 	Emotion         *ClassifyResult `json:"emotion,omitempty"`
+	SessionID       string          `json:"session_id,omitempty"`
+	Resumed         bool            `json:"resumed,omitempty"`
+	Fallback        *LLMFallback    `json:"fallback,omitempty"`
+	Moderation      *ModerationFlag `json:"moderation,omitempty"`
+	Speakers        []SpeakerSegment `json:"speakers,omitempty"`
+	Language        string           `json:"language,omitempty"`
+	Digit           string           `json:"digit,omitempty"`
+	Filler          bool             `json:"filler,omitempty"` // tts_ready carrying thinking audio, not the response
+	Tools           []string         `json:"tools,omitempty"`  // flow_state: tools allowed in the new state
+	AudioFormat     string           `json:"audio_format,omitempty"` // tts_ready: container of the audio frame (tts_output_format)
+	SampleRate      int              `json:"sample_rate,omitempty"`  // tts_ready: rate of pcm16 audio
+	Score           float64          `json:"score,omitempty"`        // cache_hit: similarity of the matched question
+	Reason          string           `json:"reason,omitempty"`       // handoff_requested: "llm" or "intent:<name>"; session_limit: the limit reached
+	Code            string           `json:"code,omitempty"`         // error: machine-readable cause, e.g. "frame_too_large"
+	Turn            int              `json:"turn,omitempty"`         // the session's turn that produced the event, from 1
+	Sentence        int              `json:"sentence,omitempty"`     // tts_ready: the sentence's place in its reply, from 1
+	Pause           bool             `json:"pause,omitempty"`        // tts_ready: silence between sentences
+	Degraded        *EngineDegraded  `json:"degraded,omitempty"`     // engine_degraded: the engine skipped and its stand-in
+	Stage           string           `json:"stage,omitempty"`        // budget_exceeded: the stage that ran out of time
+	BudgetMs        int              `json:"budget_ms,omitempty"`    // budget_exceeded: the budget, named by reason
+	Channel         string           `json:"channel,omitempty"`      // transcript: "caller" or "agent" for stereo snippets
+	TokensPerSecond float64          `json:"tokens_per_second,omitempty"` // llm_done: generation rate after the first token
+	TalkTime        *trace.TalkTime  `json:"talk_time,omitempty"`         // session_ended: how the call's time was spent
 	Audio           []byte          `json:"-"`
 }
 
@@ -122,90 +243,402 @@ type Event struct {
 type EventCallback func(Event)
 
 // ProcessChunk decodes, resamples, and VAD-processes an audio chunk.
-// If the VAD detects end-of-speech, runs the full ASR → LLM → TTS pipeline.
+// If the VAD detects end-of-speech, starts the full ASR → LLM → TTS pipeline
+// as a new turn in the background, cancelling any reply still in flight
+// (barge-in). Turn errors are reported through onEvent.
 func (p *Pipeline) ProcessChunk(ctx context.Context, data []byte, codec audio.Codec, sampleRate int, ttsEngine, asrEngine string, onEvent EventCallback) error {
-	samples, srcRate, err := audio.Decode(data, codec, sampleRate)
+	// The chunk is decoded and resampled into pooled buffers, which the
+	// steps below work on in place; the VAD copies what it keeps.
+	decoded, chunk := audio.GetSamples(), audio.GetSamples()
+	defer audio.PutSamples(decoded)
+	defer audio.PutSamples(chunk)
+
+	samples, srcRate, err := p.decode(*decoded, data, codec, sampleRate)
+	*decoded = samples
 	if err != nil {
 		return fmt.Errorf("decode: %w", err)
 	}
 
-	resampled := audio.Resample(samples, srcRate, 16000)
+	resampled := p.resample(*chunk, samples, srcRate)
+	*chunk = resampled
+
+	// Keypad tones are reported as dtmf events and kept out of VAD/ASR,
+	// which would otherwise treat them as speech.
+	if p.dtmf != nil {
+		digits, tone := p.dtmf.Process(resampled)
+		for _, d := range digits {
+			p.ProcessDTMF(string(d), onEvent)
+		}
+		if tone {
+			clear(resampled)
+		}
+	}
+
+	// Silence mic audio that is the client's speakers replaying our TTS,
+	// so the VAD doesn't re-trigger on the agent's own voice.
+	if p.echo != nil {
+		resampled, _ = p.echo.Process(resampled, time.Now())
+	}
 
 	// RNNoise expects 48 kHz internally and resamples from 16 kHz+.
 	// G.711 input arrives at 8 kHz — too low for RNNoise, so skip denoising.
-	if p.cfg.Denoiser != nil && srcRate >= 16000 {
+	denoising := p.cfg.Denoiser != nil && srcRate >= 16000
+	if denoising && p.cfg.DenoisePerChunk {
 		resampled = p.cfg.Denoiser.Denoise(resampled)
 	}
 
+	// Lift quiet microphones over the VAD threshold; with per-chunk
+	// denoising it runs after, so the boost isn't spent on background noise.
+	if p.agc != nil {
+		resampled = p.agc.Process(resampled)
+	}
+
 	result := p.vad.Process(resampled)
 
+	if result.Paused {
+		p.probeEndpoint(ctx, result, asrEngine)
+		return nil
+	}
 	if !result.SpeechEnded {
 		return nil
 	}
-
-	return p.runFullPipeline(ctx, result.Audio, ttsEngine, asrEngine, onEvent)
+	p.talk.callerSpeech(result.SpeechStart, result.SpeechEnd)
+
+	// Otherwise the VAD's energy threshold gates denoising: chunks under it
+	// are silence whether denoised or not, and most of a call is silence,
+	// so only the speech segment it cut is denoised, in one pass on the
+	// turn's goroutine while the VAD goes on with the next chunks.
+	p.startTurn(ctx, onEvent, func(ctx context.Context, onEvent EventCallback) error {
+		speech := result.Audio
+		if denoising && !p.cfg.DenoisePerChunk {
+			speech = p.cfg.Denoiser.Denoise(speech)
+		}
+		return p.runFullPipeline(ctx, speech, ttsEngine, asrEngine, onEvent)
+	})
+	return nil
 }
 
 // ProcessChunkNoVAD decodes and resamples audio, appending to the snippet buffer
-// without VAD processing. Used in snippet mode.
+// without VAD processing. Used in snippet mode. A snippet in the file codec
+// is kept as bytes instead and decoded as a whole by ProcessBuffered,
+// ignoring sampleRate. Without a codec, the first chunk is sniffed for an
+// audio file (WAV, MP3, Ogg, ...), and anything else is PCM. A declared
+// codec is never sniffed: quiet µ-law or PCM can pass for an MP3 header.
 func (p *Pipeline) ProcessChunkNoVAD(data []byte, codec audio.Codec, sampleRate int) error {
-	samples, srcRate, err := audio.Decode(data, codec, sampleRate)
+	if p.fileBuf != nil || len(p.snippetBuf) == 0 && len(p.agentBuf) == 0 && isFileSnippet(data, codec) {
+		p.fileBuf = append(p.fileBuf, data...)
+		return nil
+	}
+	if codec == "" {
+		codec = audio.CodecPCM
+	}
+	decoded := audio.GetSamples()
+	defer audio.PutSamples(decoded)
+	samples, srcRate, err := p.decode(*decoded, data, codec, sampleRate)
+	*decoded = samples
 	if err != nil {
 		return fmt.Errorf("decode: %w", err)
 	}
+	if p.cfg.Stereo {
+		p.appendStereo(samples, srcRate)
+		return nil
+	}
+
+	p.snippetBuf = p.resample(p.snippetBuf, samples, srcRate)
+	return nil
+}
+
+// isFileSnippet reports whether a snippet starting with data is an audio
+// file rather than frames in codec.
+func isFileSnippet(data []byte, codec audio.Codec) bool {
+	if codec == audio.CodecFile {
+		return true
+	}
+	return codec == "" && audio.IsAudioFile(data)
+}
+
+// appendStereo buffers interleaved caller (left) and agent (right) audio.
+func (p *Pipeline) appendStereo(samples []float32, srcRate int) {
+	ch := audio.Deinterleave(samples, 2)
+	p.snippetBuf = p.resample(p.snippetBuf, ch[0], srcRate)
+	// narrowband simulates the caller's phone line, which the agent isn't on
+	p.agentBuf = p.toRate(p.agentBuf, ch[1], srcRate, 1)
+}
 
-	resampled := audio.Resample(samples, srcRate, 16000)
-	p.snippetBuf = append(p.snippetBuf, resampled...)
+// decodeFile replaces the snippet buffers with the uploaded file's audio.
+// Stereo sessions keep a two-channel file's channels apart; everything
+// else is downmixed.
+func (p *Pipeline) decodeFile(ctx context.Context, data []byte) error {
+	samples, channels, rate, err := audio.DecodeFile(ctx, data)
+	if err != nil {
+		return fmt.Errorf("decode file: %w", err)
+	}
+	p.resamplers = [2]*audio.Resampler{} // a new stream
+	if p.cfg.Stereo && channels == 2 {
+		p.appendStereo(samples, rate)
+		return nil
+	}
+	p.snippetBuf = p.resample(nil, audio.Downmix(samples, channels), rate)
 	return nil
 }
 
+// decode runs a chunk through the session's decoder, so stateful codecs
+// (G.722, Opus-in-RTP) continue from the previous chunk, appending the
+// samples to dst.
+func (p *Pipeline) decode(dst []float32, data []byte, codec audio.Codec, sampleRate int) ([]float32, int, error) {
+	if p.decoder == nil || p.decoder.Codec() != codec {
+		if p.decoder != nil {
+			p.decoder.Close()
+		}
+		dec, err := audio.NewDecoder(codec, sampleRate)
+		if err != nil {
+			return dst, 0, err
+		}
+		p.decoder = dec
+	}
+	return p.decoder.Append(dst, data)
+}
+
+// resample brings decoded input to the pipeline's 16 kHz rate, passing it
+// through the telephone-channel simulation when the session selected
+// narrowband, and appends it to dst.
+func (p *Pipeline) resample(dst, samples []float32, srcRate int) []float32 {
+	if p.narrowband != nil {
+		return append(dst, p.narrowband.Process(p.toRate(nil, samples, srcRate, 0))...)
+	}
+	return p.toRate(dst, samples, srcRate, 0)
+}
+
+// toRate appends samples converted to 16 kHz to dst, through channel ch's
+// resampler, reopened if the input rate changes.
+func (p *Pipeline) toRate(dst, samples []float32, srcRate, ch int) []float32 {
+	r := p.resamplers[ch]
+	if r == nil || r.SrcRate() != srcRate {
+		r = audio.NewResampler(p.cfg.Resampler, srcRate, 16000)
+		p.resamplers[ch] = r
+	}
+	return r.Append(dst, samples)
+}
+
 // ProcessBuffered runs the full pipeline on accumulated snippet audio, then clears the buffer.
 func (p *Pipeline) ProcessBuffered(ctx context.Context, ttsEngine, asrEngine string, onEvent EventCallback) error {
+	turn, err := p.takeBuffered(ctx, ttsEngine, asrEngine)
+	if turn == nil || err != nil {
+		return err
+	}
+	return p.runTurn(ctx, onEvent, turn)
+}
+
+// StartBuffered is ProcessBuffered for the WebSocket read loop: the buffer
+// is taken (an uploaded file decoded) at once, and the turn runs in the
+// background so the loop can still see a cancel or a hang-up. Turn errors
+// are reported as events.
+func (p *Pipeline) StartBuffered(ctx context.Context, ttsEngine, asrEngine string, onEvent EventCallback) error {
+	turn, err := p.takeBuffered(ctx, ttsEngine, asrEngine)
+	if turn == nil || err != nil {
+		return err
+	}
+	p.startTurn(ctx, onEvent, turn)
+	return nil
+}
+
+// takeBuffered empties the snippet buffers into a turn that transcribes
+// them, or returns nil when nothing was buffered.
+func (p *Pipeline) takeBuffered(ctx context.Context, ttsEngine, asrEngine string) (func(context.Context, EventCallback) error, error) {
+	if p.fileBuf != nil {
+		file := p.fileBuf
+		p.fileBuf = nil
+		if err := p.decodeFile(ctx, file); err != nil {
+			return nil, err
+		}
+	}
 	if len(p.snippetBuf) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	buf := p.snippetBuf
 	p.snippetBuf = nil
-	return p.runFullPipeline(ctx, buf, ttsEngine, asrEngine, onEvent)
+	if p.cfg.Stereo {
+		agent := p.agentBuf
+		p.agentBuf = nil
+		return func(ctx context.Context, onEvent EventCallback) error {
+			return p.transcribeChannels(ctx, [][]float32{buf, agent}, asrEngine, onEvent)
+		}, nil
+	}
+	return func(ctx context.Context, onEvent EventCallback) error {
+		return p.runFullPipeline(ctx, buf, ttsEngine, asrEngine, onEvent)
+	}, nil
+}
+
+// ProcessDTMF reports a keypad digit, whether detected in-band or received
+// out of band (e.g. an RFC 2833 telephone-event relayed by a SIP bridge).
+// Invalid digits are ignored.
+func (p *Pipeline) ProcessDTMF(digit string, onEvent EventCallback) {
+	if len(digit) != 1 || !strings.Contains("0123456789*#ABCD", digit) {
+		return
+	}
+	slog.Info("dtmf", "session_id", p.cfg.SessionID, "digit", digit)
+	onEvent(Event{Type: "dtmf", Digit: digit})
 }
 
-// ProcessTextMessage runs LLM-only pipeline for a typed chat message (no ASR, no TTS).
+// ClearBuffer discards snippet audio accumulated by ProcessChunkNoVAD.
+func (p *Pipeline) ClearBuffer() {
+	p.snippetBuf = nil
+	p.agentBuf = nil
+	p.fileBuf = nil
+}
+
+// History returns a copy of the conversation so far, so a replacement
+// pipeline (e.g. after a session config change) can continue it.
+func (p *Pipeline) History() []Turn {
+	p.historyMu.Lock()
+	defer p.historyMu.Unlock()
+	return append([]Turn(nil), p.history...)
+}
+
+// ProcessTextMessage runs LLM-only pipeline for a typed chat message (no ASR, no TTS)
+// as a new turn, cancelling any reply still in flight.
 func (p *Pipeline) ProcessTextMessage(ctx context.Context, message string, onEvent EventCallback) error {
 	message = strings.TrimSpace(message)
 	if message == "" {
 		return nil
 	}
+	return p.runTurn(ctx, onEvent, func(ctx context.Context, onEvent EventCallback) error {
+		return p.chatTurn(ctx, message, onEvent)
+	})
+}
 
-	llmInput := p.formatInput(message)
-
-	llmResult, err := p.cfg.LLMClient.Chat(ctx, llmInput, p.cfg.SystemPrompt, p.cfg.LLMModel, p.cfg.LLMEngine, func(token string) {
-		onEvent(Event{Type: "llm_token", Token: token})
+// StartTextMessage is ProcessTextMessage for the WebSocket read loop: the
+// reply streams in the background, so the loop can still see a cancel, a
+// newer message, or a hang-up. Errors are reported as events.
+func (p *Pipeline) StartTextMessage(ctx context.Context, message string, onEvent EventCallback) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return
+	}
+	p.startTurn(ctx, onEvent, func(ctx context.Context, onEvent EventCallback) error {
+		return p.chatTurn(ctx, message, onEvent)
 	})
+}
+
+func (p *Pipeline) chatTurn(ctx context.Context, message string, onEvent EventCallback) error {
+	p.handoffReason = ""
+	ctx, cancel := withBudget(ctx, "total_budget_ms", p.cfg.Budgets.TotalMs)
+	defer cancel()
+	if p.onHold.Load() {
+		p.holdTurn(ctx, "", onEvent)
+		p.appendTurn(message, p.cfg.Handoff.cfg.HoldMessage)
+		return nil
+	}
+	p.advanceFlow(ctx, p.cfg.Flow.OnTranscript(message), onEvent)
+	p.classifyIntent(ctx, message, onEvent, "")
+
+	cached := p.lookupCache(ctx, message, "")
+	if cached.cacheHit() != nil {
+		_, llmResult, err := p.replayCached(ctx, cached, "", onEvent)
+		if err != nil {
+			return err
+		}
+		p.appendTurn(message, llmResult.Text)
+		onEvent(Event{Type: "metrics", LLMMs: llmResult.LatencyMs})
+		return nil
+	}
+
+	var signals flow.SignalFilter
+	llmStart := time.Now()
+	llmCtx, cancelLLM := withBudget(ctx, "llm_timeout_ms", p.cfg.Budgets.LLMMs)
+	defer cancelLLM()
+	scr := p.newScreen(llmCtx, onEvent, trace.SpanRef{})
+	emit := func(text string) {
+		if text != "" {
+			onEvent(Event{Type: "llm_token", Token: text})
+		}
+	}
+	llmResult, err := p.cfg.LLMClient.Chat(llmCtx, p.messages(message), p.systemPrompt(), p.cfg.LLMModel, p.cfg.LLMEngine, func(token string) {
+		if llmCtx.Err() != nil {
+			return
+		}
+		if token = signals.Filter(token); token != "" {
+			emit(scr.add(token))
+		}
+	}, p.onThinking(llmCtx, onEvent), p.onFallback(onEvent))
+	if err == nil {
+		err = llmCtx.Err() // a stream cut short by cancellation can still end cleanly
+	}
+	err = overBudget(llmCtx, "llm", err)
+	p.observeLLM(llmStart, llmResult, err)
 	if err != nil {
 		return fmt.Errorf("llm: %w", err)
 	}
+	emit(scr.add(signals.Flush()))
+	emit(scr.flush())
+	p.applyFlowSignals(ctx, llmResult, onEvent)
+	scr.apply(llmResult)
+	p.applyHandoffSignal(llmResult)
 
-	slog.Info("chat_response", "text", llmResult.Text, "llm_ms", llmResult.LatencyMs)
-	onEvent(Event{Type: "llm_done", Text: llmResult.Text, LatencyMs: llmResult.LatencyMs})
-	if llmResult.Thinking != "" {
-		onEvent(Event{Type: "thinking_done", Text: llmResult.Thinking})
-	}
+	slog.InfoContext(ctx, "chat_response", "text", p.cfg.Redactor.Redact(llmResult.Text), "llm_ms", llmResult.LatencyMs, "tokens_per_second", llmResult.TokensPerSecond)
+	onEvent(Event{Type: "llm_done", Text: llmResult.Text, LatencyMs: llmResult.LatencyMs, TokensPerSecond: llmResult.TokensPerSecond})
+	p.sendThinking(llmResult, onEvent)
 
-	p.history = append(p.history, turn{user: message, assistant: llmResult.Text})
+	p.storeCache(cached, message, llmResult.Text, "", ttsUsage{})
+	p.appendTurn(message, llmResult.Text)
 
 	onEvent(Event{Type: "metrics", LLMMs: llmResult.LatencyMs})
+	p.finishHandoff(ctx, "", onEvent)
 	return nil
 }
 
-// Flush processes any remaining buffered audio in the VAD.
-func (p *Pipeline) Flush(ctx context.Context, ttsEngine, asrEngine string, onEvent EventCallback) error {
-	remaining := p.vad.Flush()
-	if len(remaining) == 0 {
+// onThinking streams the model's thinking to the client as thinking_token
+// events, when the session asked for them. Thinking never reaches TTS or
+// the history; the whole of it follows the reply as thinking_done.
+// Thinking isn't screened, so it is withheld when a Moderator is set.
+func (p *Pipeline) onThinking(ctx context.Context, onEvent EventCallback) TokenCallback {
+	if !p.cfg.StreamThinking || p.cfg.Moderator != nil {
 		return nil
 	}
+	return func(token string) {
+		if ctx.Err() == nil {
+			onEvent(Event{Type: "thinking_token", Token: token})
+		}
+	}
+}
 
-	return p.runFullPipeline(ctx, remaining, ttsEngine, asrEngine, onEvent)
+// sendThinking sends the reply's thinking as thinking_done, unless a
+// Moderator is set (see onThinking).
+func (p *Pipeline) sendThinking(result *LLMResult, onEvent EventCallback) {
+	if result.Thinking != "" && p.cfg.Moderator == nil {
+		onEvent(Event{Type: "thinking_done", Text: result.Thinking})
+	}
+}
+
+// Speak makes the agent say text unprompted, e.g. an opening line before
+// the caller has said anything (outbound calls, IVR). It runs as a turn in
+// the background, so caller speech barges in as usual, and is kept in the
+// history as an agent line.
+func (p *Pipeline) Speak(ctx context.Context, text, ttsEngine string, onEvent EventCallback) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	p.startTurn(ctx, onEvent, func(ctx context.Context, onEvent EventCallback) error {
+		return p.speakTurn(ctx, text, ttsEngine, onEvent)
+	})
+}
+
+func (p *Pipeline) speakTurn(ctx context.Context, text, ttsEngine string, onEvent EventCallback) error {
+	start := time.Now()
+	onEvent(Event{Type: "llm_token", Token: text})
+	var tts ttsUsage
+	if ttsEngine != "" && p.cfg.TTSClient != nil {
+		tts = p.speakText(ctx, text, ttsEngine, onEvent)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	onEvent(Event{Type: "llm_done", Text: text})
+	p.appendTurn("", text)
+	onEvent(Event{Type: "metrics", TTSMs: tts.latencyMs, TotalMs: float64(time.Since(start).Milliseconds()), CostUSD: tts.costUSD})
+	return nil
 }
 
 // runFullPipeline executes the complete ASR → LLM → TTS chain for one speech segment.
@@ -213,72 +646,128 @@ func (p *Pipeline) Flush(ctx context.Context, ttsEngine, asrEngine string, onEve
 // LLM and TTS run concurrently via sentence pipelining (see streamLLMWithTTS).
 func (p *Pipeline) runFullPipeline(ctx context.Context, speechAudio []float32, ttsEngine, asrEngine string, onEvent EventCallback) error {
 	e2eStart := time.Now()
+	ctx, cancel := withBudget(ctx, "total_budget_ms", p.cfg.Budgets.TotalMs)
+	defer cancel()
+	p.handoffReason = ""
+	onEvent, stopNoise := p.startKeepAlive(ttsEngine, onEvent)
+	defer stopNoise()
 
 	runID := ""
 	if p.cfg.Tracer != nil {
 		runID = p.cfg.Tracer.StartRun()
+		p.cfg.Tracer.RecordAudio(runID, speechAudio)
+		p.traceEndpoint(runID)
+		ctx = logctx.With(ctx, "run_id", runID)
 	}
 
 	// Audio classification — fire-and-forget, parallel to ASR
 	if p.cfg.AudioClassification && p.cfg.ClassifyClient != nil {
 		audioSnap := make([]float32, len(speechAudio))
 		copy(audioSnap, speechAudio)
-		emotionCtx, emotionCancel := context.WithTimeout(context.Background(), emotionClassifyTimeout)
+		emotionCtx, emotionCancel := context.WithTimeout(context.WithoutCancel(ctx), emotionClassifyTimeout)
 		go func() { defer emotionCancel(); p.classifyEmotion(emotionCtx, audioSnap, onEvent, runID) }()
 	}
 
-	transcript, asrResult, err := p.runASR(ctx, speechAudio, asrEngine, runID)
+	transcript, asrResult, err := p.runASR(ctx, speechAudio, asrEngine, runID, onEvent)
 	if err != nil {
-		p.endRun(runID, e2eStart, "", "", "error")
+		p.endRun(runID, e2eStart, "", "", runStatus(ctx, err), trace.Usage{}, -1)
 		return fmt.Errorf("asr: %w", err)
 	}
 	if transcript == "" {
-		p.endRun(runID, e2eStart, asrResult.Text, "", "filtered")
+		p.endRun(runID, e2eStart, asrResult.Text, "", "filtered", trace.Usage{}, -1)
 		return nil
 	}
 
-	slog.Info("transcript", "text", transcript, "asr_ms", asrResult.LatencyMs, "no_speech_prob", asrResult.NoSpeechProb)
-	onEvent(Event{Type: "transcript", Text: transcript, LatencyMs: asrResult.LatencyMs})
-
-	wer := p.evaluateWER(transcript, asrResult)
-
-	// LLM→TTS sentence pipelining
-	llmInput := p.formatInput(transcript)
-	ttsLatencyMs, llmResult, err := p.streamLLMWithTTS(ctx, llmInput, ttsEngine, onEvent, runID)
+	slog.InfoContext(ctx, "transcript", "text", p.cfg.Redactor.Redact(transcript), "asr_ms", asrResult.LatencyMs, "no_speech_prob", asrResult.NoSpeechProb)
+	p.talk.callerWords(transcript)
+	onEvent(Event{Type: "transcript", Text: transcript, LatencyMs: asrResult.LatencyMs, Speakers: asrResult.Speakers})
+	p.updateLanguage(ctx, asrResult.Language, onEvent)
+	if p.onHold.Load() {
+		p.holdTurn(ctx, ttsEngine, onEvent)
+		p.appendTurn(transcript, p.cfg.Handoff.cfg.HoldMessage)
+		p.endRun(runID, e2eStart, transcript, "", "hold", trace.Usage{}, -1)
+		return nil
+	}
+	p.advanceFlow(ctx, p.cfg.Flow.OnTranscript(transcript), onEvent)
+	p.classifyIntent(ctx, transcript, onEvent, runID)
+
+	wer := p.evaluateWER(ctx, transcript, asrResult)
+
+	// LLM→TTS sentence pipelining, unless the question was answered before
+	var tts ttsUsage
+	var llmResult *LLMResult
+	cached := p.lookupCache(ctx, transcript, runID)
+	if cached.cacheHit() != nil {
+		tts, llmResult, err = p.replayCached(ctx, cached, ttsEngine, onEvent)
+	} else {
+		tts, llmResult, err = p.streamLLMWithTTS(ctx, transcript, ttsEngine, onEvent, runID)
+		if err == nil {
+			p.storeCache(cached, transcript, llmResult.Text, ttsEngine, tts)
+		}
+	}
 	if err != nil {
-		p.endRun(runID, e2eStart, transcript, "", "error")
+		p.endRun(runID, e2eStart, transcript, "", runStatus(ctx, err), trace.Usage{}, wer)
 		return fmt.Errorf("llm+tts: %w", err)
 	}
 
-	p.history = append(p.history, turn{user: transcript, assistant: llmResult.Text})
+	p.appendTurn(transcript, llmResult.Text)
 	e2eLatency := time.Since(e2eStart)
-	slog.Info("pipeline_done", "e2e_ms", e2eLatency.Milliseconds(), "asr_ms", asrResult.LatencyMs, "llm_ms", llmResult.LatencyMs, "tts_ms", ttsLatencyMs)
+	slog.InfoContext(ctx, "pipeline_done", "e2e_ms", e2eLatency.Milliseconds(), "asr_ms", asrResult.LatencyMs, "llm_ms", llmResult.LatencyMs, "tts_ms", tts.latencyMs)
 
 	onEvent(Event{
 		Type:            "metrics",
 		ASRMs:           asrResult.LatencyMs,
 		LLMMs:           llmResult.LatencyMs,
-		TTSMs:           ttsLatencyMs,
+		TTSMs:           tts.latencyMs,
 		TotalMs:         float64(e2eLatency.Milliseconds()),
 		NoSpeechProb:    asrResult.NoSpeechProb,
 		WER:             wer,
 		NoiseSuppressed: p.cfg.NoiseSuppression,
+		CostUSD:         llmResult.CostUSD + tts.costUSD,
 	})
 
-	p.endRun(runID, e2eStart, transcript, llmResult.Text, "ok")
+	p.endRun(runID, e2eStart, transcript, llmResult.Text, "ok", trace.Usage{
+		PromptTokens:     llmResult.PromptTokens,
+		CompletionTokens: llmResult.CompletionTokens,
+		TTSChars:         tts.chars,
+		CostUSD:          llmResult.CostUSD + tts.costUSD,
+	}, wer)
+	p.finishHandoff(ctx, ttsEngine, onEvent)
 	return nil
 }
 
+// runStatus is the trace status of a run that failed with err: "cancelled"
+// when the turn was cut short by barge-in or a disconnect. Running out of a
+// budget is an error.
+func runStatus(ctx context.Context, err error) string {
+	var be *budgetError
+	if ctx.Err() != nil && !errors.As(err, &be) {
+		return "cancelled"
+	}
+	return "error"
+}
+
 // runASR transcribes speech audio and filters noise/low-confidence results.
 // Returns empty transcript if filtered.
-func (p *Pipeline) runASR(ctx context.Context, speechAudio []float32, asrEngine, runID string) (string, *ASRResult, error) {
-	asrStart := time.Now()
-	asrResult, err := p.cfg.ASRClient.Transcribe(ctx, speechAudio, asrEngine, ASROptions{Prompt: p.cfg.ASRPrompt})
+func (p *Pipeline) runASR(ctx context.Context, speechAudio []float32, asrEngine, runID string, onEvent EventCallback) (string, *ASRResult, error) {
+	span, asrStart := p.startSpan(runID, ""), time.Now()
+	asrCtx, cancel := withBudget(ctx, "asr_timeout_ms", p.cfg.Budgets.ASRMs)
+	asrResult, err := p.cfg.ASRClient.Transcribe(asrCtx, speechAudio, asrEngine, ASROptions{Prompt: p.cfg.ASRPrompt, Diarize: p.cfg.Diarization, Language: p.cfg.Language, Model: p.cfg.ASRModel})
+	cancel()
+	err = overBudget(asrCtx, "asr", err)
 	asrOutput := ""
+	engine := p.cfg.ASRClient.Resolve(asrEngine)
+	var deg *EngineDegraded
 	if asrResult != nil {
 		asrOutput = asrResult.Text
+		deg = asrResult.Degraded
+	}
+	if deg == nil && errors.Is(err, ErrCircuitOpen) {
+		deg = &EngineDegraded{Stage: "asr", Engine: engine}
 	}
-	p.traceSpan(runID, "asr", asrStart, fmt.Sprintf("audio_samples=%d", len(speechAudio)), asrOutput, err)
+	p.reportDegraded(deg, onEvent)
+	p.traceSpan(span, "asr", asrStart, fmt.Sprintf("audio_samples=%d", len(speechAudio)), asrOutput, err)
+	observeStage("asr", servedBy(engine, deg), "", asrStart, err)
 	if err != nil {
 		return "", nil, err
 	}
@@ -291,19 +780,89 @@ func (p *Pipeline) runASR(ctx context.Context, speechAudio []float32, asrEngine,
 	if transcript == "" || asrResult.NoSpeechProb > threshold || isNoiseTranscript(transcript) {
 		return "", asrResult, nil
 	}
+	if len(asrResult.Speakers) > 1 {
+		transcript = formatSpeakerTranscript(asrResult.Speakers)
+	}
 	return transcript, asrResult, nil
 }
 
+// updateLanguage switches the session language when ASR detects a new one,
+// emitting language_detected so the client can display it.
+func (p *Pipeline) updateLanguage(ctx context.Context, detected string, onEvent EventCallback) {
+	detected = normalizeLanguage(detected)
+	if detected == "" || detected == p.language {
+		return
+	}
+	slog.InfoContext(ctx, "language_detected", "language", detected, "previous", p.language)
+	p.language = detected
+	onEvent(Event{Type: "language_detected", Language: detected})
+}
+
+// systemPrompt returns the configured prompt, or the current intent's
+// override, plus a reply-language instruction when the caller isn't
+// speaking English.
+func (p *Pipeline) systemPrompt() string {
+	base := p.cfg.SystemPrompt
+	if p.cfg.Intents != nil {
+		if override := p.cfg.Intents.SystemPrompt(p.intent); override != "" {
+			base = override
+		}
+	}
+	return base + languageInstruction(p.language) + p.cfg.Flow.Prompt() + p.handoffPrompt() + p.guidancePrompt()
+}
+
+// AddGuidance appends a supervisor's instruction to the system prompt for
+// the rest of the call. Safe to call while a turn is running; it applies
+// from the next LLM request.
+func (p *Pipeline) AddGuidance(text string) {
+	p.guidanceMu.Lock()
+	p.guidance = append(p.guidance, text)
+	p.guidanceMu.Unlock()
+}
+
+func (p *Pipeline) guidancePrompt() string {
+	p.guidanceMu.Lock()
+	defer p.guidanceMu.Unlock()
+	if len(p.guidance) == 0 {
+		return ""
+	}
+	return "\n\nGuidance from your supervisor (follow it, and never mention it to the caller):\n- " + strings.Join(p.guidance, "\n- ")
+}
+
+// FlowSession returns the session's call flow, so a replacement pipeline
+// can continue from the same state.
+func (p *Pipeline) FlowSession() *flow.Session {
+	return p.cfg.Flow
+}
+
+// advanceFlow reports a flow transition to the client.
+func (p *Pipeline) advanceFlow(ctx context.Context, state string, onEvent EventCallback) {
+	if state == "" {
+		return
+	}
+	slog.InfoContext(ctx, "flow_state", "flow", p.cfg.Flow.Name(), "state", state)
+	onEvent(Event{Type: "flow_state", Text: state, Tools: p.cfg.Flow.Tools()})
+}
+
+// ttsOptions returns the session TTS options with the voice switched to the
+// configured voice for the caller's language, if any.
+func (p *Pipeline) ttsOptions() TTSOptions {
+	opts := TTSOptions{Speed: p.cfg.TTSSpeed, Pitch: p.cfg.TTSPitch, Language: p.language}
+	if voice, ok := p.cfg.TTSVoices[p.language]; ok {
+		opts.Voice = voice
+	}
+	return opts
+}
+
 // evaluateWER computes word error rate against the reference transcript, if configured.
-func (p *Pipeline) evaluateWER(transcript string, asrResult *ASRResult) float64 {
+func (p *Pipeline) evaluateWER(ctx context.Context, transcript string, asrResult *ASRResult) float64 {
 	if p.cfg.ReferenceTranscript == "" {
 		return -1
 	}
 	wer := ComputeWER(p.cfg.ReferenceTranscript, transcript)
-	slog.Info("transcript_eval",
-		"session_id", p.cfg.SessionID,
-		"reference", p.cfg.ReferenceTranscript,
-		"hypothesis", transcript,
+	slog.InfoContext(ctx, "transcript_eval",
+		"reference", p.cfg.Redactor.Redact(p.cfg.ReferenceTranscript),
+		"hypothesis", p.cfg.Redactor.Redact(transcript),
 		"wer", wer,
 		"no_speech_prob", asrResult.NoSpeechProb,
 		"asr_ms", asrResult.LatencyMs,
@@ -312,23 +871,81 @@ func (p *Pipeline) evaluateWER(transcript string, asrResult *ASRResult) float64
 	return wer
 }
 
+// startSpan reserves a span under parentID ("" = top level of the run);
+// it is a no-op reference when tracing is off.
+func (p *Pipeline) startSpan(runID, parentID string) trace.SpanRef {
+	return p.cfg.Tracer.StartSpan(runID, parentID)
+}
+
 // traceSpan records a completed span if tracing is enabled.
-func (p *Pipeline) traceSpan(runID, name string, start time.Time, input, output string, err error) {
-	if p.cfg.Tracer == nil || runID == "" {
+func (p *Pipeline) traceSpan(span trace.SpanRef, name string, start time.Time, input, output string, err error) {
+	if p.cfg.Tracer == nil || span.ID == "" {
 		return
 	}
 	status, errMsg := "ok", ""
 	if err != nil {
 		status, errMsg = "error", err.Error()
 	}
-	p.cfg.Tracer.RecordSpan(runID, name, start, float64(time.Since(start).Milliseconds()), input, output, status, errMsg)
+	p.cfg.Tracer.RecordSpan(span, name, start, float64(time.Since(start).Milliseconds()), input, output, status, errMsg)
 }
 
-func (p *Pipeline) endRun(runID string, start time.Time, transcript, response, status string) {
+// observeStage records a stage call in the Prometheus stage metrics.
+func observeStage(stage, engine, model string, start time.Time, err error) {
+	if err != nil {
+		metrics.Errors.WithLabelValues(stage, engine, model).Inc()
+		return
+	}
+	metrics.StageDuration.WithLabelValues(stage, engine, model).Observe(time.Since(start).Seconds())
+}
+
+// observeLLM records an LLM call under the engine that answered it, or the
+// requested engine when every attempt failed. Successful results also get
+// their cost estimate and token counters.
+func (p *Pipeline) observeLLM(start time.Time, result *LLMResult, err error) {
+	if result == nil {
+		observeStage("llm", p.cfg.LLMEngine, p.cfg.LLMClient.ModelFor(p.cfg.LLMEngine, p.cfg.LLMModel), start, err)
+		return
+	}
+	observeStage("llm", result.Engine, result.Model, start, err)
+
+	result.CostUSD = p.cfg.Pricing.LLMCost(result.Model, result.PromptTokens, result.CachedPromptTokens, result.CompletionTokens)
+	metrics.LLMTokens.WithLabelValues(result.Engine, result.Model, "prompt").Add(float64(result.PromptTokens))
+	metrics.LLMTokens.WithLabelValues(result.Engine, result.Model, "cached_prompt").Add(float64(result.CachedPromptTokens))
+	metrics.LLMTokens.WithLabelValues(result.Engine, result.Model, "completion").Add(float64(result.CompletionTokens))
+	metrics.CostUSD.WithLabelValues("llm", result.Engine, result.Model).Add(result.CostUSD)
+	if err == nil {
+		result.TokensPerSecond = tokensPerSecond(result)
+	}
+	if result.TokensPerSecond > 0 {
+		metrics.LLMTokensPerSecond.WithLabelValues(result.Engine, result.Model).Observe(result.TokensPerSecond)
+	}
+}
+
+// tokensPerSecond is the generation rate of a reply: the tokens after the
+// first over the time after it, leaving out prompt processing. 0 when the
+// engine reported fewer than two completion tokens.
+func tokensPerSecond(r *LLMResult) float64 {
+	gen := r.LatencyMs - r.TimeToFirstTokenMs
+	if r.CompletionTokens < 2 || gen <= 0 {
+		return 0
+	}
+	return math.Round(float64(r.CompletionTokens-1)/(gen/1000)*10) / 10
+}
+
+// endRun closes a voice turn's trace run and counts it toward the session's
+// experiment variant. wer is -1 when the turn wasn't scored.
+func (p *Pipeline) endRun(runID string, start time.Time, transcript, response, status string, usage trace.Usage, wer float64) {
+	elapsed := time.Since(start)
+	if p.cfg.Variant != "" {
+		metrics.ExperimentTurns.WithLabelValues(p.cfg.Experiment, p.cfg.Variant, status).Inc()
+		if status == "ok" {
+			metrics.ExperimentTurnDuration.WithLabelValues(p.cfg.Experiment, p.cfg.Variant).Observe(elapsed.Seconds())
+		}
+	}
 	if p.cfg.Tracer == nil {
 		return
 	}
-	p.cfg.Tracer.EndRun(runID, float64(time.Since(start).Milliseconds()), transcript, response, status)
+	p.cfg.Tracer.EndRun(runID, float64(elapsed.Milliseconds()), transcript, response, status, usage, wer)
 }
 
 // noisePatterns are common ASR hallucinations from background noise.
@@ -360,29 +977,48 @@ func isNoiseTranscript(text string) bool {
 	return noisePatterns[lower]
 }
 
-// formatInput prepends conversation history to the current message.
-func (p *Pipeline) formatInput(current string) string {
-	if len(p.history) == 0 {
-		return current
-	}
-	var b strings.Builder
+// appendTurn adds an exchange to the conversation history and persists it
+// so the session can be resumed after a reconnect.
+func (p *Pipeline) appendTurn(user, assistant string) {
+	p.historyMu.Lock()
+	p.history = append(p.history, Turn{User: user, Assistant: assistant})
+	index := len(p.history) - 1
+	p.historyMu.Unlock()
+	p.cfg.Tracer.RecordTurn(index, user, assistant)
+}
+
+// hasHistory reports whether the conversation has any turns yet.
+func (p *Pipeline) hasHistory() bool {
+	p.historyMu.Lock()
+	defer p.historyMu.Unlock()
+	return len(p.history) > 0
+}
+
+// messages returns the conversation history as role-tagged turns followed
+// by the current user message.
+func (p *Pipeline) messages(current string) []Message {
+	p.historyMu.Lock()
+	defer p.historyMu.Unlock()
+	msgs := make([]Message, 0, 2*len(p.history)+1)
 	for _, t := range p.history {
-		fmt.Fprintf(&b, "User: %s\nAssistant: %s\n", t.user, t.assistant)
+		if t.User != "" { // agent-initiated lines (Speak) have no caller side
+			msgs = append(msgs, Message{Role: RoleUser, Content: t.User})
+		}
+		msgs = append(msgs, Message{Role: RoleAssistant, Content: t.Assistant})
 	}
-	fmt.Fprintf(&b, "User: %s", current)
-	return b.String()
+	return append(msgs, Message{Role: RoleUser, Content: current})
 }
 
 func (p *Pipeline) classifyEmotion(ctx context.Context, samples []float32, onEvent EventCallback, runID string) {
-	start := time.Now()
+	span, start := p.startSpan(runID, ""), time.Now()
 	result, err := p.cfg.ClassifyClient.ClassifyEmotion(ctx, samples)
 	out := ""
 	if result != nil {
 		out = fmt.Sprintf("label=%s conf=%.2f", result.Label, result.Confidence)
 	}
-	p.traceSpan(runID, "emotion_classify", start, fmt.Sprintf("samples=%d", len(samples)), out, err)
+	p.traceSpan(span, "emotion_classify", start, fmt.Sprintf("samples=%d", len(samples)), out, err)
 	if err != nil {
-		slog.Warn("emotion classification failed", "error", err)
+		slog.WarnContext(ctx, "emotion classification failed", "error", err)
 		return
 	}
 	onEvent(Event{Type: "classification", Emotion: result})
@@ -393,20 +1029,22 @@ func (p *Pipeline) classifyEmotion(ctx context.Context, samples []float32, onEve
 // when a sentence boundary is detected, the complete sentence is sent to a channel.
 // A goroutine (consumer) reads sentences and synthesizes audio via TTS in parallel,
 // so the first TTS audio is ready before the LLM finishes generating.
-func (p *Pipeline) streamLLMWithTTS(ctx context.Context, transcript, ttsEngine string, onEvent EventCallback, runID string) (float64, *LLMResult, error) {
+func (p *Pipeline) streamLLMWithTTS(ctx context.Context, transcript, ttsEngine string, onEvent EventCallback, runID string) (ttsUsage, *LLMResult, error) {
 	ttsEnabled := ttsEngine != "" && p.cfg.TTSClient != nil
 
 	var sentenceCh chan string
 	var ttsWg sync.WaitGroup
-	var totalTTSMs float64
+	var totalTTS ttsUsage
 	var ttsMu sync.Mutex
 
+	// per-sentence TTS runs while the LLM streams, so its spans nest under llm
+	llmSpan := p.startSpan(runID, "")
 	if ttsEnabled {
 		sentenceCh = make(chan string, sentenceChannelBuffer)
 		ttsWg.Add(1)
 		go func() {
 			defer ttsWg.Done()
-			p.consumeSentences(ctx, sentenceCh, ttsEngine, onEvent, &totalTTSMs, &ttsMu, runID)
+			p.consumeSentences(ctx, sentenceCh, ttsEngine, onEvent, &totalTTS, &ttsMu, llmSpan)
 		}()
 	}
 
@@ -414,22 +1052,54 @@ func (p *Pipeline) streamLLMWithTTS(ctx context.Context, transcript, ttsEngine s
 	// Code blocks (``` fenced) are sent to the frontend but omitted from TTS.
 	var sentenceBuf sentenceBuffer
 	var codeFilt codeFilter
+	var signals flow.SignalFilter
+
+	var filler *fillerTimer
+	if ttsEnabled {
+		filler = p.startFiller(ttsEngine, onEvent)
+	}
 
 	llmStart := time.Now()
-	llmResult, err := p.cfg.LLMClient.Chat(ctx, transcript, p.cfg.SystemPrompt, p.cfg.LLMModel, p.cfg.LLMEngine, func(token string) {
-		onEvent(Event{Type: "llm_token", Token: token})
+	llmCtx, cancelLLM := withBudget(ctx, "llm_timeout_ms", p.cfg.Budgets.LLMMs)
+	defer cancelLLM()
+	scr := p.newScreen(llmCtx, onEvent, llmSpan)
+	// emit sends screened text to the client and on to TTS.
+	emit := func(text string) {
+		if text == "" {
+			return
+		}
+		onEvent(Event{Type: "llm_token", Token: text})
 		if !ttsEnabled {
 			return
 		}
-		filtered := codeFilt.Filter(token)
+		filtered := codeFilt.Filter(text)
 		if filtered == "" {
 			return
 		}
 		s := sentenceBuf.Add(filtered)
 		if s != "" {
+			filler.stop()
 			sentenceCh <- s
 		}
-	})
+	}
+	onToken := func(token string) {
+		if llmCtx.Err() != nil {
+			return // the turn was cancelled or ran out of time; drop what the stream still delivers
+		}
+		if token = signals.Filter(token); token != "" {
+			emit(scr.add(token))
+		}
+	}
+	llmResult, err := p.cfg.LLMClient.Chat(llmCtx, p.messages(transcript), p.systemPrompt(), p.cfg.LLMModel, p.cfg.LLMEngine, onToken, p.onThinking(llmCtx, onEvent), p.onFallback(onEvent))
+	if err == nil {
+		err = llmCtx.Err() // a stream cut short by cancellation can still end cleanly
+	}
+	err = overBudget(llmCtx, "llm", err)
+	onToken(signals.Flush())
+	if llmCtx.Err() == nil {
+		emit(scr.flush())
+	}
+	filler.stop()
 
 	if ttsEnabled {
 		remainder := sentenceBuf.Flush()
@@ -444,35 +1114,124 @@ func (p *Pipeline) streamLLMWithTTS(ctx context.Context, transcript, ttsEngine s
 	if llmResult != nil {
 		llmOutput = llmResult.Text
 	}
-	p.traceSpan(runID, "llm", llmStart, transcript, llmOutput, err)
+	if scr != nil {
+		llmOutput = strings.TrimSpace(scr.reply.String())
+	}
+	p.traceSpan(llmSpan, "llm", llmStart, transcript, llmOutput, err)
+	p.observeLLM(llmStart, llmResult, err)
 
 	if err != nil {
-		return 0, nil, err
+		return ttsUsage{}, nil, err
 	}
+	// flow signals are read from the raw reply; the screened one has none
+	p.applyFlowSignals(ctx, llmResult, onEvent)
+	scr.apply(llmResult)
+	p.applyHandoffSignal(llmResult)
 
-	slog.Info("llm_response", "text", llmResult.Text, "thinking_len", len(llmResult.Thinking), "llm_ms", llmResult.LatencyMs, "ttft_ms", llmResult.TimeToFirstTokenMs)
-	onEvent(Event{Type: "llm_done", Text: llmResult.Text, LatencyMs: llmResult.LatencyMs})
-	if llmResult.Thinking != "" {
-		onEvent(Event{Type: "thinking_done", Text: llmResult.Thinking})
-	}
+	slog.InfoContext(ctx, "llm_response", "text", p.cfg.Redactor.Redact(llmResult.Text), "thinking_len", len(llmResult.Thinking), "llm_ms", llmResult.LatencyMs, "ttft_ms", llmResult.TimeToFirstTokenMs, "tokens_per_second", llmResult.TokensPerSecond)
+	onEvent(Event{Type: "llm_done", Text: llmResult.Text, LatencyMs: llmResult.LatencyMs, TokensPerSecond: llmResult.TokensPerSecond})
+	p.sendThinking(llmResult, onEvent)
 
 	ttsMu.Lock()
-	ttsMs := totalTTSMs
+	tts := totalTTS
 	ttsMu.Unlock()
 
-	return ttsMs, llmResult, nil
+	return tts, llmResult, nil
+}
+
+// applyFlowSignals strips flow signal markers from the response text, so
+// they stay out of history and llm_done, and advances the flow on them.
+func (p *Pipeline) applyFlowSignals(ctx context.Context, result *LLMResult, onEvent EventCallback) {
+	if p.cfg.Flow == nil {
+		return
+	}
+	text, state := p.cfg.Flow.OnResponse(result.Text)
+	result.Text = text
+	p.advanceFlow(ctx, state, onEvent)
+}
+
+// ttsUsage accumulates TTS latency, billing, and audio across a response's sentences.
+type ttsUsage struct {
+	latencyMs float64
+	chars     int
+	costUSD   float64
+	audio     [][]byte // synthesized sentences in order, for the semantic cache
+	partial   bool     // a sentence failed, so audio is incomplete
+}
+
+// onFallback sends an llm_fallback event as soon as an engine fails and the
+// turn moves on to the next, before its reply streams, and engine_degraded
+// for engines skipped because their breaker was open.
+func (p *Pipeline) onFallback(onEvent EventCallback) FallbackCallback {
+	return func(f LLMFallback) {
+		onEvent(Event{Type: "llm_fallback", Fallback: &f})
+		if f.Reason == fallbackReasonCircuitOpen {
+			p.reportDegraded(&EngineDegraded{Stage: "llm", Engine: f.From, Fallback: f.To}, onEvent)
+		}
+	}
+}
+
+// reportDegraded sends an engine_degraded event the first time this call
+// hits deg, so a call isn't told again on every sentence.
+func (p *Pipeline) reportDegraded(deg *EngineDegraded, onEvent EventCallback) {
+	if deg == nil {
+		return
+	}
+	if _, seen := p.degraded.LoadOrStore(*deg, true); !seen {
+		onEvent(Event{Type: "engine_degraded", Degraded: deg})
+	}
+}
+
+// servedBy is the engine that actually served a request for engine.
+func servedBy(engine string, deg *EngineDegraded) string {
+	if deg != nil && deg.Fallback != "" {
+		return deg.Fallback
+	}
+	return engine
 }
 
-func (p *Pipeline) consumeSentences(ctx context.Context, sentenceCh <-chan string, ttsEngine string, onEvent EventCallback, totalMs *float64, mu *sync.Mutex, runID string) {
-	ttsOpts := TTSOptions{Speed: p.cfg.TTSSpeed, Pitch: p.cfg.TTSPitch}
+// speakText synthesizes a complete text sentence by sentence, as if it
+// were streaming from the LLM.
+func (p *Pipeline) speakText(ctx context.Context, text, ttsEngine string, onEvent EventCallback) ttsUsage {
+	sentenceCh := make(chan string, sentenceChannelBuffer)
+	var total ttsUsage
+	var mu sync.Mutex
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.consumeSentences(ctx, sentenceCh, ttsEngine, onEvent, &total, &mu, trace.SpanRef{})
+	}()
+	var sentenceBuf sentenceBuffer
+	var codeFilt codeFilter
+	for _, word := range strings.SplitAfter(text, " ") {
+		if s := sentenceBuf.Add(codeFilt.Filter(word)); s != "" {
+			sentenceCh <- s
+		}
+	}
+	if rest := sentenceBuf.Flush(); rest != "" {
+		sentenceCh <- rest
+	}
+	close(sentenceCh)
+	<-done
+	return total
+}
+
+func (p *Pipeline) consumeSentences(ctx context.Context, sentenceCh <-chan string, ttsEngine string, onEvent EventCallback, total *ttsUsage, mu *sync.Mutex, parent trace.SpanRef) {
+	ttsOpts := p.ttsOptions()
 	for sentence := range sentenceCh {
-		if err := p.synthesizeSentence(ctx, sentence, ttsEngine, ttsOpts, onEvent, totalMs, mu, runID); err != nil {
-			return
+		if err := p.synthesizeSentence(ctx, sentence, ttsEngine, ttsOpts, onEvent, total, mu, parent); err != nil {
+			mu.Lock()
+			total.partial = true
+			mu.Unlock()
+			break
 		}
 	}
+	// keep draining so the LLM producer never blocks on a full channel
+	for range sentenceCh {
+	}
 }
 
-func (p *Pipeline) synthesizeSentence(ctx context.Context, sentence, ttsEngine string, ttsOpts TTSOptions, onEvent EventCallback, totalMs *float64, mu *sync.Mutex, runID string) error {
+func (p *Pipeline) synthesizeSentence(ctx context.Context, sentence, ttsEngine string, ttsOpts TTSOptions, onEvent EventCallback, total *ttsUsage, mu *sync.Mutex, parent trace.SpanRef) error {
 	sentence = StripMarkdown(sentence)
 	if sentence == "" {
 		return nil
@@ -481,26 +1240,111 @@ func (p *Pipeline) synthesizeSentence(ctx context.Context, sentence, ttsEngine s
 		sentence = NormalizeForSpeech(sentence)
 	}
 
-	ttsStart := time.Now()
-	ttsResult, err := p.cfg.TTSClient.Synthesize(ctx, sentence, ttsEngine, ttsOpts)
+	span, ttsStart := p.startSpan(parent.RunID, parent.ID), time.Now()
+	ttsCtx, cancel := withBudget(ctx, "tts_timeout_ms", p.cfg.Budgets.TTSMs)
+	ttsResult, err := p.cfg.TTSClient.Synthesize(ttsCtx, sentence, ttsEngine, ttsOpts)
+	cancel()
+	err = overBudget(ttsCtx, "tts", err)
 	ttsOutput := ""
 	if ttsResult != nil {
 		ttsOutput = fmt.Sprintf("audio_bytes=%d", len(ttsResult.Audio))
 	}
-	p.traceSpan(runID, "tts", ttsStart, sentence, ttsOutput, err)
+	p.traceSpan(span, "tts", ttsStart, sentence, ttsOutput, err)
+	engine := p.cfg.TTSClient.Resolve(ttsEngine)
+	var deg *EngineDegraded
+	if ttsResult != nil {
+		deg = ttsResult.Degraded
+	}
+	if deg == nil && errors.Is(err, ErrCircuitOpen) {
+		deg = &EngineDegraded{Stage: "tts", Engine: engine}
+	}
+	p.reportDegraded(deg, onEvent)
+	engine = servedBy(engine, deg)
+	observeStage("tts", engine, ttsOpts.Voice, ttsStart, err)
+	var be *budgetError
+	if errors.As(err, &be) {
+		// the rest of the reply goes unspoken, like a failed sentence
+		slog.WarnContext(ctx, "tts sentence", "error", err, "text", p.cfg.Redactor.Redact(sentence))
+		onEvent(be.event())
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err() // barge-in or hangup: don't play audio for a cancelled turn
+	}
 	if err != nil {
-		slog.Error("tts sentence", "error", err, "text", sentence)
+		slog.ErrorContext(ctx, "tts sentence", "error", err, "text", p.cfg.Redactor.Redact(sentence))
 		onEvent(Event{Type: "error", Text: err.Error()})
 		return err
 	}
 
+	chars := utf8.RuneCountInString(sentence)
+	cost := p.cfg.Pricing.TTSCost(engine, chars)
+	metrics.TTSCharacters.WithLabelValues(engine).Add(float64(chars))
+	metrics.CostUSD.WithLabelValues("tts", engine, ttsOpts.Voice).Add(cost)
+
 	mu.Lock()
-	*totalMs += ttsResult.LatencyMs
+	total.latencyMs += ttsResult.LatencyMs
+	total.chars += chars
+	total.costUSD += cost
+	total.audio = append(total.audio, ttsResult.Audio)
 	mu.Unlock()
-	onEvent(Event{Type: "tts_ready", Audio: ttsResult.Audio, LatencyMs: ttsResult.LatencyMs})
+	p.sendSpeech(ttsResult.Audio, ttsResult.LatencyMs, onEvent)
+	return nil
+}
+
+// sendSpeech emits one sentence of audio, followed by the configured
+// inter-sentence pause. WAV clips are spliced (see audio.SpliceClip) so
+// sentences join without clicks, and the pause matches their sample rate.
+func (p *Pipeline) sendSpeech(clip []byte, latencyMs float64, onEvent EventCallback) {
+	rate := ttsSilenceSampleRate
+	if samples, r, err := audio.DecodeWAV(clip); err == nil {
+		clip, rate = audio.SamplesToWAV(audio.SpliceClip(samples, r), r), r
+	}
+	onEvent(Event{Type: "tts_ready", Audio: clip, LatencyMs: latencyMs})
+	p.trackPlayback(clip)
+	p.talk.agentAudio(clip)
 
 	if p.cfg.InterSentencePauseMs > 0 {
-		onEvent(Event{Type: "tts_ready", Audio: silenceWAV(p.cfg.InterSentencePauseMs, ttsSilenceSampleRate)})
+		pause := silenceWAV(p.cfg.InterSentencePauseMs, rate)
+		onEvent(Event{Type: "tts_ready", Audio: pause, Pause: true})
+		p.trackPlayback(pause)
 	}
-	return nil
+}
+
+// moderate screens a sentence of the reply and returns the text to send
+// and speak: the sentence itself, a rewrite, or "" to drop it. If the
+// moderator fails the sentence is dropped, so unscreened output never
+// reaches the caller. A flagged sentence goes nowhere, not even the trace.
+func (p *Pipeline) moderate(ctx context.Context, sentence string, onEvent EventCallback, parent trace.SpanRef) string {
+	span, start := p.startSpan(parent.RunID, parent.ID), time.Now()
+	flag, err := p.cfg.Moderator.Moderate(ctx, sentence)
+	if err != nil {
+		flag = &ModerationFlag{Source: "error", Category: "unavailable", Action: "block"}
+	}
+	kept, output := sentence, "pass"
+	if flag != nil {
+		kept, output = flag.Replacement, flag.Action+": "+flag.Category
+	}
+	p.traceSpan(span, "moderation", start, kept, output, err)
+	if flag == nil {
+		return sentence
+	}
+
+	slog.WarnContext(ctx, "moderation flag", "source", flag.Source, "category", flag.Category, "action", flag.Action, "error", err)
+	onEvent(Event{Type: "moderation_flag", Moderation: flag})
+	return flag.Replacement
+}
+
+// trackPlayback registers sent TTS audio with the echo suppressor.
+// Non-WAV audio (e.g. MP3) can't be decoded here and is not tracked.
+func (p *Pipeline) trackPlayback(wav []byte) {
+	if p.echo == nil {
+		return
+	}
+	samples, rate, err := audio.DecodeWAV(wav)
+	if err != nil {
+		slog.Debug("echo suppression skipped clip", "error", err)
+		return
+	}
+	p.echo.AddPlayback(audio.Resample(samples, rate, 16000), time.Now())
 }
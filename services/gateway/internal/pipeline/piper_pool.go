@@ -0,0 +1,235 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// piperPool keeps long-lived piper processes for one voice so synthesis
+// skips the 100-300 ms model load of a fresh exec. Each process runs in
+// --json-input mode: one request line on stdin, one output path on stdout.
+//
+// The pool holds size slots; a slot is either an idle process or nil
+// (not yet started, or discarded after a failure). Processes are spawned
+// lazily and replaced automatically when they die.
+type piperPool struct {
+	model       string
+	config      string
+	lengthScale float64 // --length_scale for every process (1 = voice default)
+	slots       chan *piperProc
+
+	mu     sync.Mutex // orders put against close
+	closed bool
+}
+
+func newPiperPool(model, config string, lengthScale float64, size int) *piperPool {
+	size = max(size, 1)
+	p := &piperPool{model: model, config: config, lengthScale: lengthScale, slots: make(chan *piperProc, size)}
+	for range size {
+		p.slots <- nil
+	}
+	return p
+}
+
+// synthesize renders text to a WAV file on a pooled process and returns its bytes.
+func (p *piperPool) synthesize(ctx context.Context, text string) ([]byte, error) {
+	var proc *piperProc
+	select {
+	case proc = <-p.slots:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if !proc.alive() {
+		var err error
+		if proc, err = startPiperProc(p.model, p.config, p.lengthScale); err != nil {
+			p.slots <- nil
+			return nil, err
+		}
+	}
+
+	wav, err := proc.synthesize(ctx, text)
+	if err != nil {
+		// stdin/stdout may be out of step; never reuse the process
+		proc.kill()
+		proc = nil
+	}
+	p.put(proc)
+	return wav, err
+}
+
+// put returns proc to its slot, or stops it once the pool is closed.
+func (p *piperPool) put(proc *piperProc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed && proc != nil {
+		proc.kill()
+		proc = nil
+	}
+	p.slots <- proc
+}
+
+// close stops the idle processes. Busy ones stop when their request
+// finishes, so closing never cuts a sentence short.
+func (p *piperPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	taken := 0
+	for range cap(p.slots) {
+		select {
+		case proc := <-p.slots:
+			if proc.alive() {
+				proc.kill()
+			}
+			taken++
+		default:
+		}
+	}
+	for range taken {
+		p.slots <- nil
+	}
+}
+
+type piperProc struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	lines  chan string   // output paths printed by piper
+	done   chan struct{} // closed when the process exits
+	stderr *tailBuffer
+}
+
+// PiperVersion runs piper --version, checking that the binary the pools
+// start is installed.
+func PiperVersion(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "piper", "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("piper --version: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func startPiperProc(model, config string, lengthScale float64) (*piperProc, error) {
+	args := []string{
+		"--model", model,
+		"--config", config,
+		"--json-input",
+		"--output_dir", os.TempDir(),
+	}
+	if lengthScale != 1 {
+		args = append(args, "--length_scale", strconv.FormatFloat(lengthScale, 'f', 2, 64))
+	}
+	cmd := exec.Command("piper", args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("piper stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("piper stdout: %w", err)
+	}
+	stderr := &tailBuffer{limit: 4096}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("piper start: %w", err)
+	}
+
+	proc := &piperProc{
+		cmd:    cmd,
+		stdin:  stdin,
+		lines:  make(chan string, 1),
+		done:   make(chan struct{}),
+		stderr: stderr,
+	}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			proc.lines <- strings.TrimSpace(scanner.Text())
+		}
+	}()
+	go func() {
+		err := cmd.Wait()
+		slog.Debug("piper process exited", "model", model, "error", err)
+		close(proc.done)
+	}()
+
+	slog.Info("piper process started", "model", model, "length_scale", lengthScale, "pid", cmd.Process.Pid)
+	return proc, nil
+}
+
+func (pp *piperProc) alive() bool {
+	if pp == nil {
+		return false
+	}
+	select {
+	case <-pp.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (pp *piperProc) kill() {
+	if pp.cmd.Process != nil {
+		_ = pp.cmd.Process.Kill()
+	}
+}
+
+func (pp *piperProc) synthesize(ctx context.Context, text string) ([]byte, error) {
+	tmpFile, err := os.CreateTemp("", "piper-*.wav")
+	if err != nil {
+		return nil, fmt.Errorf("piper temp file: %w", err)
+	}
+	outPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(outPath)
+
+	line, err := json.Marshal(map[string]string{"text": text, "output_file": outPath})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := pp.stdin.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("piper write: %w", err)
+	}
+
+	select {
+	case <-pp.lines:
+		return os.ReadFile(outPath)
+	case <-pp.done:
+		return nil, fmt.Errorf("piper exited\n%s", pp.stderr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// tailBuffer keeps the last limit bytes written, for error reporting.
+type tailBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (t *tailBuffer) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, b...)
+	if over := len(t.buf) - t.limit; over > 0 {
+		t.buf = t.buf[over:]
+	}
+	return len(b), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
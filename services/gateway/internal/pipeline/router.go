@@ -1,6 +1,9 @@
 package pipeline
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 // Router is a generic backend dispatcher that maps engine names to backend
 // implementations. Both ASRRouter and TTSRouter are aliases for Router[T]
@@ -10,6 +13,9 @@ import "fmt"
 type Router[T any] struct {
 	backends map[string]T
 	fallback string
+	onRoute  func(engine string)
+	breakers *Breakers // nil = no circuit breaking
+	stage    string    // breaker key prefix: asr or tts
 }
 
 // NewRouter creates a router with the given backends and a fallback engine name
@@ -18,18 +24,66 @@ func NewRouter[T any](backends map[string]T, fallback string) *Router[T] {
 	return &Router[T]{backends: backends, fallback: fallback}
 }
 
+// OnRoute registers a hook called with the resolved engine name on every
+// successful Route (e.g. to record service activity). Set before serving.
+func (r *Router[T]) OnRoute(fn func(engine string)) {
+	r.onRoute = fn
+}
+
+// SetBreakers guards the backends with circuit breakers from b under
+// stage. Set before serving; a nil b leaves them unguarded.
+func (r *Router[T]) SetBreakers(b *Breakers, stage string) {
+	r.breakers, r.stage = b, stage
+}
+
+// acquire routes a request for engine past its circuit breaker. When the
+// breaker is open, the request goes to the fallback engine if that one's
+// breaker allows it, and fails fast with ErrCircuitOpen otherwise. It
+// returns the engine that will serve the request (pass it to release)
+// and, if the requested engine was skipped, how.
+func (r *Router[T]) acquire(engine string) (T, string, *EngineDegraded, error) {
+	name := r.Resolve(engine)
+	if r.breakers.Allow(r.stage, name) {
+		backend, err := r.Route(name)
+		return backend, name, nil, err
+	}
+	deg := &EngineDegraded{Stage: r.stage, Engine: name}
+	if name != r.fallback && r.Has(r.fallback) && r.breakers.Allow(r.stage, r.fallback) {
+		deg.Fallback = r.fallback
+		backend, err := r.Route(r.fallback)
+		return backend, r.fallback, deg, err
+	}
+	var zero T
+	return zero, name, deg, fmt.Errorf("%s %s: %w", r.stage, name, ErrCircuitOpen)
+}
+
+// release records the outcome of a request acquire let through.
+func (r *Router[T]) release(ctx context.Context, name string, err error) {
+	r.breakers.Done(ctx, r.stage, name, err)
+}
+
 // Route returns the backend for the given engine name, falling back to the default.
 func (r *Router[T]) Route(engine string) (T, error) {
-	if backend, ok := r.backends[engine]; ok {
-		return backend, nil
-	}
-	if backend, ok := r.backends[r.fallback]; ok {
+	name := r.Resolve(engine)
+	if backend, ok := r.backends[name]; ok {
+		if r.onRoute != nil {
+			r.onRoute(name)
+		}
 		return backend, nil
 	}
 	var zero T
 	return zero, fmt.Errorf("no backend for engine %q", engine)
 }
 
+// Resolve returns the engine name Route would dispatch to: engine itself when
+// registered, otherwise the fallback.
+func (r *Router[T]) Resolve(engine string) string {
+	if _, ok := r.backends[engine]; ok {
+		return engine
+	}
+	return r.fallback
+}
+
 // Has reports whether the router has a backend for the given engine name.
 func (r *Router[T]) Has(engine string) bool {
 	_, ok := r.backends[engine]
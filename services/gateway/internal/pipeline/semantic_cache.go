@@ -0,0 +1,382 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+)
+
+const (
+	// embedTimeout bounds one question embedding; the lookup sits between
+	// ASR and the LLM, so a slow embedder must not stall the turn for long.
+	embedTimeout = 2 * time.Second
+
+	// defaultCacheEntries caps the semantic cache when max_entries is unset.
+	defaultCacheEntries = 1000
+)
+
+// SemanticCacheConfig configures reuse of answers to repeated questions.
+type SemanticCacheConfig struct {
+	Threshold  float64 `json:"threshold"`   // cosine similarity needed to reuse an answer (0 = off)
+	MaxEntries int     `json:"max_entries"` // least recently used answers are evicted past this (0 = 1000)
+	TTLMin     int     `json:"ttl_min"`     // answers expire after this many minutes (0 = never)
+}
+
+// Embedder turns text into a vector for similarity search.
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// SemanticCache remembers answers by the embedding of the question that
+// produced them, so a near-identical question later in any session is
+// answered without an LLM call. Synthesized audio is kept per TTS engine
+// and voice alongside the answer. Entries live in memory only.
+type SemanticCache struct {
+	cfg   SemanticCacheConfig
+	embed Embedder
+
+	mu      sync.Mutex
+	entries []*cacheEntry
+}
+
+type cacheEntry struct {
+	scope    string // tenant, engine, model, and system prompt the answer was generated under
+	question string
+	vec      []float32
+	answer   string
+	audio    map[string][][]byte // TTS key → per-sentence audio
+	created  time.Time
+	used     time.Time
+}
+
+// CacheHit is a cached answer whose question matched a new one.
+type CacheHit struct {
+	Question string // the earlier question that matched
+	Answer   string
+	Score    float64 // cosine similarity to the new question
+	entry    *cacheEntry
+}
+
+// NewSemanticCache returns nil (disabled) when no threshold is configured.
+func NewSemanticCache(cfg SemanticCacheConfig, embed Embedder) *SemanticCache {
+	if cfg.Threshold <= 0 || embed == nil {
+		return nil
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = defaultCacheEntries
+	}
+	return &SemanticCache{cfg: cfg, embed: embed}
+}
+
+// Lookup embeds question and returns the closest unexpired answer in scope
+// at or above the threshold, or nil. The embedding is returned either way
+// so a fresh answer can be stored under it.
+func (c *SemanticCache) Lookup(ctx context.Context, scope, question string) (*CacheHit, []float32, error) {
+	vec, err := c.embed.Embed(ctx, question)
+	if err != nil {
+		return nil, nil, err
+	}
+	normalize(vec)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked(time.Now())
+	var best *cacheEntry
+	bestScore := c.cfg.Threshold
+	for _, e := range c.entries {
+		if e.scope != scope || len(e.vec) != len(vec) {
+			continue
+		}
+		if score := dot(e.vec, vec); score >= bestScore {
+			best, bestScore = e, score
+		}
+	}
+	if best == nil {
+		return nil, vec, nil
+	}
+	best.used = time.Now()
+	return &CacheHit{Question: best.question, Answer: best.answer, Score: bestScore, entry: best}, vec, nil
+}
+
+// Store caches answer for the question embedded as vec, with its audio
+// under ttsKey when audio is non-empty.
+func (c *SemanticCache) Store(scope, question string, vec []float32, answer, ttsKey string, audio [][]byte) {
+	now := time.Now()
+	e := &cacheEntry{scope: scope, question: question, vec: vec, answer: answer, audio: map[string][][]byte{}, created: now, used: now}
+	if len(audio) > 0 {
+		e.audio[ttsKey] = audio
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.cfg.MaxEntries {
+		c.evictLocked()
+	}
+	c.entries = append(c.entries, e)
+}
+
+// Audio returns the hit's audio for ttsKey, or nil if it was never
+// synthesized in that voice.
+func (c *SemanticCache) Audio(hit *CacheHit, ttsKey string) [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return hit.entry.audio[ttsKey]
+}
+
+// SetAudio records audio synthesized for a hit in a new voice.
+func (c *SemanticCache) SetAudio(hit *CacheHit, ttsKey string, audio [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hit.entry.audio[ttsKey] = audio
+}
+
+func (c *SemanticCache) expireLocked(now time.Time) {
+	if c.cfg.TTLMin <= 0 {
+		return
+	}
+	ttl := time.Duration(c.cfg.TTLMin) * time.Minute
+	kept := c.entries[:0]
+	for _, e := range c.entries {
+		if now.Sub(e.created) < ttl {
+			kept = append(kept, e)
+		}
+	}
+	clear(c.entries[len(kept):])
+	c.entries = kept
+}
+
+// evictLocked drops the least recently used entry.
+func (c *SemanticCache) evictLocked() {
+	oldest := 0
+	for i, e := range c.entries {
+		if e.used.Before(c.entries[oldest].used) {
+			oldest = i
+		}
+	}
+	c.entries = append(c.entries[:oldest], c.entries[oldest+1:]...)
+}
+
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	inv := float32(1 / math.Sqrt(sum))
+	for i := range v {
+		v[i] *= inv
+	}
+}
+
+// dot is the cosine similarity of two normalized vectors.
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// ttsCacheKey identifies audio rendered by engine with opts.
+func ttsCacheKey(engine string, opts TTSOptions) string {
+	return fmt.Sprintf("%s|%s|%s|%g|%g", engine, opts.Voice, opts.Language, opts.Speed, opts.Pitch)
+}
+
+// --- Ollama embeddings (/api/embed) ---
+
+type ollamaEmbedder struct {
+	url    string
+	model  string
+	client *http.Client
+}
+
+// NewOllamaEmbedder embeds text with an Ollama embedding model such as
+// "nomic-embed-text".
+func NewOllamaEmbedder(ollamaURL, model string) Embedder {
+	return &ollamaEmbedder{
+		url:    strings.TrimRight(ollamaURL, "/"),
+		model:  model,
+		client: &http.Client{Timeout: embedTimeout},
+	}
+}
+
+func (o *ollamaEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	var out struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := postEmbed(ctx, o.client, o.url+"/api/embed", map[string]any{"model": o.model, "input": text}, &out); err != nil {
+		return nil, err
+	}
+	if len(out.Embeddings) == 0 || len(out.Embeddings[0]) == 0 {
+		return nil, fmt.Errorf("embed: empty response")
+	}
+	return out.Embeddings[0], nil
+}
+
+// --- ONNX embeddings (embed sidecar, /embed) ---
+
+type onnxEmbedder struct {
+	url    string
+	client *http.Client
+}
+
+// NewONNXEmbedder embeds text through the embed sidecar, which runs a small
+// sentence embedding model on ONNX Runtime. Questions are then embedded off
+// the Ollama instance serving the chat LLM, so a burst of calls doesn't
+// queue cache lookups behind replies.
+func NewONNXEmbedder(embedURL string) Embedder {
+	return &onnxEmbedder{
+		url:    strings.TrimRight(embedURL, "/"),
+		client: &http.Client{Timeout: embedTimeout},
+	}
+}
+
+func (o *onnxEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	var out struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	if err := postEmbed(ctx, o.client, o.url+"/embed", map[string]any{"input": text}, &out); err != nil {
+		return nil, err
+	}
+	if len(out.Embedding) == 0 {
+		return nil, fmt.Errorf("embed: empty response")
+	}
+	return out.Embedding, nil
+}
+
+// postEmbed POSTs an embedding request as JSON and decodes the reply into out.
+func postEmbed(ctx context.Context, client *http.Client, url string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("embed request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("embed http: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("embed status %d", resp.StatusCode)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("embed decode: %w", err)
+	}
+	return nil
+}
+
+// cachedTurn is one turn's semantic cache query: the hit, if any, and the
+// question's embedding for storing a fresh answer. A nil *cachedTurn
+// (cache off, bypassed, or unavailable) never hits and stores nothing.
+type cachedTurn struct {
+	scope    string
+	vec      []float32
+	hit      *CacheHit
+	lookupMs float64
+}
+
+func (ct *cachedTurn) cacheHit() *CacheHit {
+	if ct == nil {
+		return nil
+	}
+	return ct.hit
+}
+
+// lookupCache queries the semantic cache for question. Answers are scoped
+// to the tenant, so one tenant's calls never hear another's. Only a call's
+// opening question is cached: a follow-up ("yes", "what about the second
+// one") means something else in every conversation, and its answer was
+// written from one caller's history. Sessions running a call flow are
+// never cached either: their answers depend on the script's state.
+func (p *Pipeline) lookupCache(ctx context.Context, question, runID string) *cachedTurn {
+	if p.cfg.SemanticCache == nil || p.cfg.CacheBypass || p.cfg.Flow != nil || p.hasHistory() {
+		return nil
+	}
+	scope := p.cfg.Tenant + "|" + p.cfg.LLMEngine + "|" + p.cfg.LLMModel + "|" + p.systemPrompt()
+	span, start := p.startSpan(runID, ""), time.Now()
+	hit, vec, err := p.cfg.SemanticCache.Lookup(ctx, scope, question)
+	result, output := "miss", "miss"
+	if hit != nil {
+		result, output = "hit", fmt.Sprintf("hit score=%.3f", hit.Score)
+	}
+	p.traceSpan(span, "cache_lookup", start, question, output, err)
+	if err != nil {
+		slog.WarnContext(ctx, "semantic cache lookup", "error", err)
+		metrics.SemanticCacheLookups.WithLabelValues("error").Inc()
+		return nil
+	}
+	metrics.SemanticCacheLookups.WithLabelValues(result).Inc()
+	return &cachedTurn{scope: scope, vec: vec, hit: hit, lookupMs: float64(time.Since(start).Milliseconds())}
+}
+
+// storeCache remembers a fresh answer. Its audio is kept only when every
+// sentence was synthesized.
+func (p *Pipeline) storeCache(ct *cachedTurn, question, answer, ttsEngine string, tts ttsUsage) {
+	if ct == nil || answer == "" || p.handoffReason != "" {
+		return // escalations are per caller, never a reusable answer
+	}
+	key, audio := "", tts.audio
+	if tts.partial {
+		audio = nil
+	}
+	if ttsEngine != "" && p.cfg.TTSClient != nil {
+		key = ttsCacheKey(p.cfg.TTSClient.Resolve(ttsEngine), p.ttsOptions())
+	}
+	p.cfg.SemanticCache.Store(ct.scope, question, ct.vec, answer, key, audio)
+}
+
+// replayCached answers a turn from the cache. The answer streams as a
+// single llm_token so clients render it like a generated one, and its
+// audio is replayed, or synthesized once in a voice it wasn't spoken in.
+func (p *Pipeline) replayCached(ctx context.Context, ct *cachedTurn, ttsEngine string, onEvent EventCallback) (ttsUsage, *LLMResult, error) {
+	hit := ct.hit
+	slog.InfoContext(ctx, "cache_hit", "score", hit.Score, "question", p.cfg.Redactor.Redact(hit.Question))
+	onEvent(Event{Type: "cache_hit", Score: hit.Score}) // the matched question is another caller's
+	onEvent(Event{Type: "llm_token", Token: hit.Answer})
+
+	var tts ttsUsage
+	if ttsEngine != "" && p.cfg.TTSClient != nil {
+		tts = p.speakCached(ctx, hit, ttsEngine, onEvent)
+	}
+	if err := ctx.Err(); err != nil {
+		return ttsUsage{}, nil, err
+	}
+	result := &LLMResult{Text: hit.Answer, Engine: "cache", LatencyMs: ct.lookupMs}
+	onEvent(Event{Type: "llm_done", Text: result.Text, LatencyMs: result.LatencyMs})
+	return tts, result, nil
+}
+
+func (p *Pipeline) speakCached(ctx context.Context, hit *CacheHit, ttsEngine string, onEvent EventCallback) ttsUsage {
+	key := ttsCacheKey(p.cfg.TTSClient.Resolve(ttsEngine), p.ttsOptions())
+	if clips := p.cfg.SemanticCache.Audio(hit, key); clips != nil {
+		for _, clip := range clips {
+			if ctx.Err() != nil {
+				break
+			}
+			p.sendSpeech(clip, 0, onEvent)
+		}
+		return ttsUsage{}
+	}
+
+	total := p.speakText(ctx, hit.Answer, ttsEngine, onEvent)
+	if !total.partial && ctx.Err() == nil {
+		p.cfg.SemanticCache.SetAudio(hit, key, total.audio)
+	}
+	return total
+}
@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// stereoChannels names the channels of a stereo snippet in the standard
+// call-recording layout.
+var stereoChannels = []string{"caller", "agent"}
+
+// transcribeChannels runs one ASR pass per channel of a stereo recording
+// and emits a transcript tagged with each channel. The recording is a
+// finished exchange, so nothing is sent to the LLM and no reply is spoken.
+func (p *Pipeline) transcribeChannels(ctx context.Context, channels [][]float32, asrEngine string, onEvent EventCallback) error {
+	for i, samples := range channels {
+		if len(samples) == 0 {
+			continue
+		}
+		transcript, asrResult, err := p.runASR(ctx, samples, asrEngine, "", onEvent)
+		if err != nil {
+			return fmt.Errorf("asr %s channel: %w", stereoChannels[i], err)
+		}
+		if transcript == "" {
+			continue
+		}
+		slog.InfoContext(ctx, "transcript", "channel", stereoChannels[i], "text", p.cfg.Redactor.Redact(transcript), "asr_ms", asrResult.LatencyMs)
+		onEvent(Event{Type: "transcript", Text: transcript, LatencyMs: asrResult.LatencyMs, Channel: stereoChannels[i]})
+	}
+	return nil
+}
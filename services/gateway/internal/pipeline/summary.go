@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/trace"
+)
+
+const (
+	// callSummaryTimeout bounds the LLM call that summarizes a finished call.
+	callSummaryTimeout = 30 * time.Second
+
+	callSummaryPrompt = `Summarize this customer call. Reply with only a JSON object of this shape:
+{"topics": ["what the caller asked about"], "resolution": "how the call ended, in one sentence", "action_items": ["follow-ups the agent or caller committed to"], "sentiment": "positive, neutral, or negative"}
+Lists may be empty. sentiment is the caller's, by the end of the call.`
+)
+
+// SummarizeCall asks the session's LLM for a summary of the conversation
+// so far: its topics, resolution, action items, and the caller's sentiment.
+// It runs once the call is over, so it is given its own time bound.
+func (p *Pipeline) SummarizeCall(ctx context.Context) (*trace.CallSummary, error) {
+	turns := p.History()
+	if len(turns) == 0 {
+		return nil, errors.New("no turns to summarize")
+	}
+	ctx, cancel := context.WithTimeout(ctx, callSummaryTimeout)
+	defer cancel()
+
+	var b strings.Builder
+	for _, t := range turns {
+		fmt.Fprintf(&b, "Caller: %s\nAgent: %s\n", t.User, t.Assistant)
+	}
+	result, err := p.cfg.LLMClient.Chat(ctx, []Message{{Role: RoleUser, Content: b.String()}}, callSummaryPrompt, p.cfg.LLMModel, p.cfg.LLMEngine, func(string) {}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := parseCallSummary(result.Text)
+	if err != nil {
+		return nil, err
+	}
+	sum.Model = p.cfg.LLMEngine + "/" + p.cfg.LLMClient.ModelFor(p.cfg.LLMEngine, p.cfg.LLMModel)
+	sum.CreatedAt = time.Now().UTC()
+	return sum, nil
+}
+
+// parseCallSummary reads the JSON object in an LLM reply, ignoring any
+// text or code fence the model put around it.
+func parseCallSummary(reply string) (*trace.CallSummary, error) {
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("call summary: no JSON object in reply %q", reply)
+	}
+	var sum trace.CallSummary
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &sum); err != nil {
+		return nil, fmt.Errorf("call summary: %w", err)
+	}
+	sum.Sentiment = strings.ToLower(strings.TrimSpace(sum.Sentiment))
+	return &sum, nil
+}
@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/trace"
+)
+
+// TalkTracker accounts a call's time between caller speech and agent audio,
+// for QA: how much each side talked, how often the caller talked over the
+// agent, and how fast. Agent audio is taken to play in real time from when
+// it is sent, queued behind audio still playing. It lives for the whole
+// call, so a pipeline rebuilt mid-call carries it over.
+type TalkTracker struct {
+	mu            sync.Mutex
+	start         time.Time
+	caller, agent time.Duration
+	overTalk      time.Duration
+	interruptions int
+	words         int
+	live          bool      // caller speech was segmented in real time (talk mode)
+	playingUntil  time.Time // when the agent audio sent so far finishes playing
+}
+
+// NewTalkTracker starts accounting a call from now.
+func NewTalkTracker() *TalkTracker {
+	return &TalkTracker{start: time.Now()}
+}
+
+// callerSpeech records a caller utterance the VAD segmented from live audio.
+func (t *TalkTracker) callerSpeech(start, end time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.live = true
+	t.caller += end.Sub(start)
+	if start.Before(t.playingUntil) {
+		t.interruptions++
+		overlapEnd := end
+		if t.playingUntil.Before(end) {
+			overlapEnd = t.playingUntil
+		}
+		t.overTalk += overlapEnd.Sub(start)
+	}
+}
+
+// callerWords counts the words of a caller transcript.
+func (t *TalkTracker) callerWords(transcript string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.words += len(strings.Fields(transcript))
+}
+
+// agentAudio records a clip of agent speech sent to the caller. Audio other
+// than WAV can't be timed here and isn't counted.
+func (t *TalkTracker) agentAudio(clip []byte) {
+	samples, rate, err := audio.DecodeWAV(clip)
+	if err != nil || rate <= 0 {
+		return
+	}
+	d := time.Duration(float64(len(samples)) / float64(rate) * float64(time.Second))
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.agent += d
+	if t.playingUntil.Before(now) {
+		t.playingUntil = now
+	}
+	t.playingUntil = t.playingUntil.Add(d)
+}
+
+// stopPlayback drops agent audio the client stopped playing: what was
+// queued to play after now.
+func (t *TalkTracker) stopPlayback() {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.playingUntil.After(now) {
+		t.agent -= t.playingUntil.Sub(now)
+		t.playingUntil = now
+	}
+}
+
+// Stats returns the call's talk time so far, or nil when caller speech
+// wasn't segmented live (snippet and text calls), so there is no timeline.
+func (t *TalkTracker) Stats() *trace.TalkTime {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.live {
+		return nil
+	}
+	duration := time.Since(t.start)
+	silence := max(duration-(t.caller+t.agent-t.overTalk), 0)
+	ratio := func(d time.Duration) float64 { return round3(d.Seconds() / duration.Seconds()) }
+	tt := &trace.TalkTime{
+		DurationSec:   round3(duration.Seconds()),
+		CallerSec:     round3(t.caller.Seconds()),
+		AgentSec:      round3(t.agent.Seconds()),
+		OverTalkSec:   round3(t.overTalk.Seconds()),
+		SilenceSec:    round3(silence.Seconds()),
+		CallerRatio:   ratio(t.caller),
+		AgentRatio:    ratio(t.agent),
+		OverTalkRatio: ratio(t.overTalk),
+		SilenceRatio:  ratio(silence),
+		Interruptions: t.interruptions,
+		CallerWords:   t.words,
+	}
+	if t.caller > 0 {
+		tt.CallerWPM = math.Round(float64(t.words) / t.caller.Minutes())
+	}
+	return tt
+}
+
+func round3(x float64) float64 {
+	return math.Round(x*1000) / 1000
+}
+
+// Talk returns the call's talk-time tracker.
+func (p *Pipeline) Talk() *TalkTracker {
+	return p.talk
+}
@@ -0,0 +1,93 @@
+package pipeline
+
+import "strings"
+
+// Reasoning models stream their thinking inline, between these tags, ahead
+// of the reply (Ollama's qwen3, deepseek-r1...). Raw clients whose API
+// streams thinking separately (Anthropic) wrap it the same way, so it is
+// split from the reply in one place.
+const (
+	thinkOpen  = "<think>"
+	thinkClose = "</think>"
+)
+
+// thinkSplitter separates thinking from reply text in a token stream, so a
+// tag split across tokens is still recognized. Text that might start a tag
+// is held back until it is known not to be one; flush returns whatever is
+// still held.
+type thinkSplitter struct {
+	pending  string
+	thinking bool // inside <think>
+	trimLead bool // just after </think>
+}
+
+// split returns the reply text and thinking text token completes.
+// Whitespace between the thinking and the reply is dropped.
+func (s *thinkSplitter) split(token string) (text, thinking string) {
+	buf := s.pending + token
+	s.pending = ""
+	var out, think strings.Builder
+	for buf != "" {
+		tag := thinkOpen
+		if s.thinking {
+			tag = thinkClose
+		}
+		chunk, rest, found := strings.Cut(buf, tag)
+		if !found {
+			keep := partialSuffix(buf, tag)
+			chunk, s.pending = buf[:len(buf)-keep], buf[len(buf)-keep:]
+		}
+		if s.thinking {
+			think.WriteString(chunk)
+		} else {
+			out.WriteString(s.reply(chunk))
+		}
+		if !found {
+			break
+		}
+		buf = rest
+		s.thinking = !s.thinking
+		s.trimLead = !s.thinking
+	}
+	return out.String(), think.String()
+}
+
+// reply returns a chunk of reply text, without the whitespace that
+// separates it from thinking before it.
+func (s *thinkSplitter) reply(chunk string) string {
+	if !s.trimLead {
+		return chunk
+	}
+	chunk = strings.TrimLeft(chunk, " \t\r\n")
+	s.trimLead = chunk == ""
+	return chunk
+}
+
+// flush returns held-back text at the end of the stream.
+func (s *thinkSplitter) flush() (text, thinking string) {
+	rest := s.pending
+	s.pending = ""
+	if s.thinking {
+		return "", rest
+	}
+	return s.reply(rest), ""
+}
+
+// partialSuffix is the length of the longest suffix of s that is a proper
+// prefix of tag.
+func partialSuffix(s, tag string) int {
+	for n := min(len(s), len(tag)-1); n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// splitThinking separates a whole reply's thinking from its text.
+func splitThinking(reply string) (text, thinking string) {
+	var s thinkSplitter
+	text, thinking = s.split(reply)
+	restText, restThinking := s.flush()
+	return text + restText, thinking + restThinking
+}
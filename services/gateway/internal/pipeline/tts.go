@@ -2,19 +2,29 @@ package pipeline
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
-	"os"
-	"os/exec"
+	"io"
+	"log/slog"
+	"math"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
 )
 
-// TTSOptions holds per-call TTS tuning parameters.
+// TTSOptions holds per-call TTS tuning parameters. Each backend maps Speed
+// and Pitch onto its own prosody controls and ignores what it can't honor.
 type TTSOptions struct {
-	Speed float64
-	Pitch float64
-	Voice string
+	Speed    float64 // speaking rate multiplier (0 or 1 = normal)
+	Pitch    float64 // pitch shift in semitones (0 = normal)
+	Voice    string
+	Language string // language code for backends with a language field
+	SSML     bool   // text is SSML; backends without SSML speak its text content
 }
 
 // TTSSynthesizer produces audio from text.
@@ -22,10 +32,18 @@ type TTSSynthesizer interface {
 	SynthesizeAudio(ctx context.Context, text string, opts TTSOptions) ([]byte, error)
 }
 
+// SpeedCapable is implemented by backends that honor TTSOptions.Speed
+// themselves. For the others the router time-stretches their WAV output,
+// so tts_speed works on every engine.
+type SpeedCapable interface {
+	SupportsSpeed() bool
+}
+
 // TTSResult holds synthesized audio with timing.
 type TTSResult struct {
-	Audio     []byte  `json:"-"`
-	LatencyMs float64 `json:"latency_ms"`
+	Audio     []byte          `json:"-"`
+	LatencyMs float64         `json:"latency_ms"`
+	Degraded  *EngineDegraded `json:"degraded,omitempty"` // set when the requested engine's breaker was open
 }
 
 // TTSRouter dispatches to the correct TTS backend based on engine name.
@@ -39,66 +57,183 @@ func NewTTSRouter(backends map[string]TTSSynthesizer, fallback string) *TTSRoute
 	return &TTSRouter{Router: NewRouter(backends, fallback)}
 }
 
+// Close stops the backends that run local processes (piper's pools).
+func (r *TTSRouter) Close() {
+	for name, backend := range r.backends {
+		if c, ok := backend.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				slog.Warn("tts backend close", "engine", name, "error", err)
+			}
+		}
+	}
+}
+
 // Synthesize routes to the correct backend, synthesizes audio, and records latency metrics.
 // If the backend supports SSML, wraps text with prosody/break tags.
 func (r *TTSRouter) Synthesize(ctx context.Context, text, engine string, opts TTSOptions) (*TTSResult, error) {
 	start := time.Now()
 
-	backend, err := r.Route(engine)
+	backend, name, deg, err := r.acquire(engine)
 	if err != nil {
 		return nil, err
 	}
 
+	if deg != nil {
+		opts.Voice = "" // voices belong to the engine that was skipped
+	}
 	audioData, err := backend.SynthesizeAudio(ctx, text, opts)
+	r.release(ctx, name, err)
 	if err != nil {
 		return nil, err
 	}
+	if s, ok := backend.(SpeedCapable); opts.Speed > 0 && opts.Speed != 1 && !(ok && s.SupportsSpeed()) {
+		audioData = stretchWAV(audioData, opts.Speed)
+	}
 
 	latency := time.Since(start)
 
 	return &TTSResult{
 		Audio:     audioData,
 		LatencyMs: float64(latency.Milliseconds()),
+		Degraded:  deg,
 	}, nil
 }
 
-// --- Piper backend (local neural TTS via piper CLI, returns WAV) ---
+// stretchWAV time-stretches WAV audio to speed. Other containers (MP3) are
+// returned as they are, since re-encoding them would cost more than the
+// stretch is worth.
+func stretchWAV(data []byte, speed float64) []byte {
+	if audio.Container(data) != audio.OutputWAV {
+		return data
+	}
+	samples, rate, err := audio.DecodeWAV(data)
+	if err != nil {
+		return data
+	}
+	return audio.SamplesToWAV(audio.TimeStretch(samples, rate, speed), rate)
+}
+
+// --- Piper backend (local neural TTS via pooled piper processes, returns WAV) ---
+
+// maxPiperPools caps the voice and rate pairs a piper backend keeps warm.
+// Both come from the caller, so past the cap the least recently used pool
+// is closed rather than letting processes pile up.
+const maxPiperPools = 4
 
 type piperSynthesizer struct {
 	modelDir string
 	voice    string
+	poolSize int
+
+	mu    sync.Mutex
+	pools map[string]*piperPool // keyed by voice and length scale; a piper process loads one model at one rate
+	order []string              // pool keys, least recently used first
 }
 
-func NewPiperSynthesizer(modelDir, voice string) TTSSynthesizer {
-	return &piperSynthesizer{modelDir: modelDir, voice: voice}
+// NewPiperSynthesizer creates a piper backend that keeps up to poolSize
+// warm processes per voice and rate, for up to maxPiperPools of them.
+func NewPiperSynthesizer(modelDir, voice string, poolSize int) TTSSynthesizer {
+	return &piperSynthesizer{modelDir: modelDir, voice: voice, poolSize: poolSize, pools: map[string]*piperPool{}}
 }
 
+// Close stops every pool's processes, for gateway shutdown.
+func (p *piperSynthesizer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pool := range p.pools {
+		pool.close()
+	}
+	clear(p.pools)
+	p.order = nil
+	return nil
+}
+
+func (p *piperSynthesizer) SupportsSpeed() bool { return true }
+
+// SynthesizeAudio speaks text with piper. Speed maps to piper's length
+// scale; piper has no pitch control, so Pitch is ignored, and SSML is
+// reduced to its text.
 func (p *piperSynthesizer) SynthesizeAudio(ctx context.Context, text string, opts TTSOptions) ([]byte, error) {
 	voice := p.voice
 	if opts.Voice != "" {
 		voice = opts.Voice
 	}
+	if opts.SSML {
+		text = SSMLText(text)
+	}
+	return p.pool(voice, piperLengthScale(opts.Speed)).synthesize(ctx, text)
+}
 
-	tmpFile, err := os.CreateTemp("", "piper-*.wav")
-	if err != nil {
-		return nil, fmt.Errorf("piper temp file: %w", err)
+// pool returns the processes for voice at lengthScale. Length scale is a
+// piper startup flag (its JSON input takes no per-line rate), so each rate
+// gets its own pool.
+func (p *piperSynthesizer) pool(voice string, lengthScale float64) *piperPool {
+	key := fmt.Sprintf("%s@%.2f", voice, lengthScale)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.order = slices.DeleteFunc(p.order, func(k string) bool { return k == key })
+	p.order = append(p.order, key)
+	if pool, ok := p.pools[key]; ok {
+		return pool
 	}
-	outPath := tmpFile.Name()
-	tmpFile.Close()
-	defer os.Remove(outPath)
-
-	cmd := exec.CommandContext(ctx, "piper",
-		"--model", filepath.Join(p.modelDir, voice+".onnx"),
-		"--config", filepath.Join(p.modelDir, voice+".onnx.json"),
-		"--output_file", outPath,
+
+	if len(p.pools) >= maxPiperPools {
+		oldest := p.order[0]
+		p.order = p.order[1:]
+		p.pools[oldest].close()
+		delete(p.pools, oldest)
+		slog.Info("piper pool evicted", "pool", oldest)
+	}
+	pool := newPiperPool(
+		filepath.Join(p.modelDir, voice+".onnx"),
+		filepath.Join(p.modelDir, voice+".onnx.json"),
+		lengthScale,
+		p.poolSize,
 	)
-	cmd.Stdin = strings.NewReader(text)
+	p.pools[key] = pool
+	return pool
+}
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("piper: %v\n%s", err, output)
+// piperLengthScale converts a speed multiplier to piper's length scale
+// (phoneme duration, so the inverse of speed), rounded to 0.05 so nearby
+// speeds share a pool and clamped to a range piper renders intelligibly.
+func piperLengthScale(speed float64) float64 {
+	if speed <= 0 {
+		return 1
 	}
+	scale := math.Round(20/speed) / 20
+	return min(max(scale, 0.5), 2)
+}
 
-	return os.ReadFile(outPath)
+// ssmlTag matches any markup tag, for stripping SSML that fails to parse.
+var ssmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// SSMLText returns the spoken text of an SSML document, for backends that
+// can't parse SSML. Breaks become commas so the pause isn't lost entirely.
+// Malformed markup just has its tags stripped.
+func SSMLText(ssml string) string {
+	dec := xml.NewDecoder(strings.NewReader(ssml))
+	dec.Strict = false
+	var b strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return collapseSpace(ssmlTag.ReplaceAllString(ssml, " "))
+		}
+		if cd, ok := tok.(xml.CharData); ok {
+			b.Write(cd)
+		}
+		if el, ok := tok.(xml.StartElement); ok && el.Name.Local == "break" {
+			b.WriteString(", ")
+		}
+	}
+	return collapseSpace(b.String())
 }
 
+// collapseSpace joins words with single spaces, keeping commas tight.
+func collapseSpace(s string) string {
+	return strings.ReplaceAll(strings.Join(strings.Fields(s), " "), " ,", ",")
+}
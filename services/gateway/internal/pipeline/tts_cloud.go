@@ -0,0 +1,221 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// cloudTTSTimeout bounds one managed-TTS request.
+const cloudTTSTimeout = 30 * time.Second
+
+// SSMLCapable is implemented by backends that parse SSML, so callers can
+// send markup to them instead of plain text.
+type SSMLCapable interface {
+	SupportsSSML() bool
+}
+
+// SupportsSSML reports whether engine's backend parses SSML.
+func (r *TTSRouter) SupportsSSML(engine string) bool {
+	backend, ok := r.backends[r.Resolve(engine)]
+	if !ok {
+		return false
+	}
+	s, ok := backend.(SSMLCapable)
+	return ok && s.SupportsSSML()
+}
+
+// speakTag matches the <speak> wrapper of an SSML document.
+var speakTag = regexp.MustCompile(`(?is)^\s*(<\?xml[^>]*\?>)?\s*<speak[^>]*>|</speak>\s*$`)
+
+// ssmlBody returns the content of a <speak> document, or text escaped as
+// SSML when it isn't markup.
+func ssmlBody(text string, isSSML bool) string {
+	if isSSML {
+		return speakTag.ReplaceAllString(text, "")
+	}
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
+
+// voiceLanguage takes the language code from a voice name such as
+// "en-US-JennyNeural".
+func voiceLanguage(voice string) string {
+	parts := strings.SplitN(voice, "-", 3)
+	if len(parts) < 3 {
+		return "en-US"
+	}
+	return parts[0] + "-" + parts[1]
+}
+
+// --- Azure Speech backend (REST, SSML in, 24 kHz WAV out) ---
+
+type azureSynthesizer struct {
+	endpoint   string
+	key        string
+	voice      string
+	httpClient *http.Client
+}
+
+// NewAzureSynthesizer creates an Azure Speech backend for a resource in
+// region (e.g. "eastus") using voice by default.
+func NewAzureSynthesizer(key, region, voice string, poolSize int) TTSSynthesizer {
+	return &azureSynthesizer{
+		endpoint:   fmt.Sprintf("https://%s.tts.speech.microsoft.com/cognitiveservices/v1", region),
+		key:        key,
+		voice:      voice,
+		httpClient: NewPooledHTTPClient("tts", "azure", poolSize, cloudTTSTimeout),
+	}
+}
+
+func (a *azureSynthesizer) SupportsSSML() bool  { return true }
+func (a *azureSynthesizer) SupportsSpeed() bool { return true }
+
+// SynthesizeAudio speaks text with Azure. Speed and Pitch become a prosody
+// element around the text; SSML input keeps its own markup inside it.
+func (a *azureSynthesizer) SynthesizeAudio(ctx context.Context, text string, opts TTSOptions) ([]byte, error) {
+	voice := a.voice
+	if opts.Voice != "" {
+		voice = opts.Voice
+	}
+	lang := voiceLanguage(voice)
+	if opts.Language != "" && opts.Language != "auto" {
+		lang = opts.Language
+	}
+	ssml := fmt.Sprintf(`<speak version="1.0" xmlns="http://www.w3.org/2001/10/synthesis" xml:lang="%s"><voice name="%s">%s</voice></speak>`,
+		lang, voice, prosody(ssmlBody(text, opts.SSML), opts))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, strings.NewReader(ssml))
+	if err != nil {
+		return nil, fmt.Errorf("azure tts request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/ssml+xml")
+	req.Header.Set("Ocp-Apim-Subscription-Key", a.key)
+	req.Header.Set("X-Microsoft-OutputFormat", "riff-24khz-16bit-mono-pcm")
+	req.Header.Set("User-Agent", "asr-llm-tts-gateway")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("azure tts do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("azure tts status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// prosody wraps body in a prosody element when Speed or Pitch is set.
+func prosody(body string, opts TTSOptions) string {
+	var attrs []string
+	if opts.Speed > 0 && opts.Speed != 1 {
+		attrs = append(attrs, fmt.Sprintf(`rate="%+.0f%%"`, (opts.Speed-1)*100))
+	}
+	if opts.Pitch != 0 {
+		attrs = append(attrs, fmt.Sprintf(`pitch="%+.1fst"`, opts.Pitch))
+	}
+	if len(attrs) == 0 {
+		return body
+	}
+	return "<prosody " + strings.Join(attrs, " ") + ">" + body + "</prosody>"
+}
+
+// --- Google Cloud Text-to-Speech backend (REST, API key, 24 kHz WAV out) ---
+
+type googleSynthesizer struct {
+	endpoint   string
+	voice      string
+	httpClient *http.Client
+}
+
+// NewGoogleSynthesizer creates a Google Cloud Text-to-Speech backend
+// authenticated with an API key, using voice (e.g. "en-US-Neural2-F") by
+// default.
+func NewGoogleSynthesizer(apiKey, voice string, poolSize int) TTSSynthesizer {
+	return &googleSynthesizer{
+		endpoint:   "https://texttospeech.googleapis.com/v1/text:synthesize?key=" + url.QueryEscape(apiKey),
+		voice:      voice,
+		httpClient: NewPooledHTTPClient("tts", "google", poolSize, cloudTTSTimeout),
+	}
+}
+
+func (g *googleSynthesizer) SupportsSSML() bool  { return true }
+func (g *googleSynthesizer) SupportsSpeed() bool { return true }
+
+type googleTTSReq struct {
+	Input struct {
+		Text string `json:"text,omitempty"`
+		SSML string `json:"ssml,omitempty"`
+	} `json:"input"`
+	Voice struct {
+		LanguageCode string `json:"languageCode"`
+		Name         string `json:"name"`
+	} `json:"voice"`
+	AudioConfig struct {
+		AudioEncoding   string  `json:"audioEncoding"`
+		SampleRateHertz int     `json:"sampleRateHertz"`
+		SpeakingRate    float64 `json:"speakingRate,omitempty"`
+		Pitch           float64 `json:"pitch,omitempty"` // semitones
+	} `json:"audioConfig"`
+}
+
+type googleTTSResp struct {
+	AudioContent []byte `json:"audioContent"` // base64 in JSON; LINEAR16 includes a WAV header
+}
+
+// SynthesizeAudio speaks text with Google. Speed and Pitch map directly to
+// speakingRate and pitch; SSML input is sent as-is.
+func (g *googleSynthesizer) SynthesizeAudio(ctx context.Context, text string, opts TTSOptions) ([]byte, error) {
+	var body googleTTSReq
+	if opts.SSML {
+		body.Input.SSML = "<speak>" + ssmlBody(text, true) + "</speak>"
+	} else {
+		body.Input.Text = text
+	}
+	body.Voice.Name = g.voice
+	if opts.Voice != "" {
+		body.Voice.Name = opts.Voice
+	}
+	body.Voice.LanguageCode = voiceLanguage(body.Voice.Name)
+	body.AudioConfig.AudioEncoding = "LINEAR16"
+	body.AudioConfig.SampleRateHertz = 24000
+	if opts.Speed > 0 {
+		body.AudioConfig.SpeakingRate = min(max(opts.Speed, 0.25), 4)
+	}
+	body.AudioConfig.Pitch = min(max(opts.Pitch, -20), 20)
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("google tts marshal: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("google tts request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("google tts do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("google tts status %d", resp.StatusCode)
+	}
+	var result googleTTSResp
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("google tts decode: %w", err)
+	}
+	return result.AudioContent, nil
+}
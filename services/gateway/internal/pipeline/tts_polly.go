@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+)
+
+// pollySampleRate is the rate requested for pcm output (Polly's maximum
+// for pcm is 16 kHz).
+const pollySampleRate = 16000
+
+// PollyConfig configures the AWS Polly backend.
+type PollyConfig struct {
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string // for temporary credentials; "" otherwise
+	Voice        string // e.g. "Joanna"
+	Engine       string // "neural" (default), "standard", "generative", "long-form"
+	OutputFormat string // "pcm" (default, returned as WAV) or "mp3"
+}
+
+// --- AWS Polly backend (REST with SigV4, SSML in, WAV or MP3 out) ---
+
+type pollySynthesizer struct {
+	cfg        PollyConfig
+	host       string
+	httpClient *http.Client
+}
+
+// NewPollySynthesizer creates a Polly backend signed with cfg's credentials.
+func NewPollySynthesizer(cfg PollyConfig, poolSize int) TTSSynthesizer {
+	if cfg.Engine == "" {
+		cfg.Engine = "neural"
+	}
+	if cfg.OutputFormat != "mp3" {
+		cfg.OutputFormat = "pcm"
+	}
+	return &pollySynthesizer{
+		cfg:        cfg,
+		host:       fmt.Sprintf("polly.%s.amazonaws.com", cfg.Region),
+		httpClient: NewPooledHTTPClient("tts", "polly", poolSize, cloudTTSTimeout),
+	}
+}
+
+func (p *pollySynthesizer) SupportsSSML() bool  { return true }
+func (p *pollySynthesizer) SupportsSpeed() bool { return true }
+
+type pollyReq struct {
+	Engine       string `json:"Engine"`
+	OutputFormat string `json:"OutputFormat"`
+	SampleRate   string `json:"SampleRate,omitempty"`
+	Text         string `json:"Text"`
+	TextType     string `json:"TextType"`
+	VoiceId      string `json:"VoiceId"`
+}
+
+// SynthesizeAudio speaks text with Polly. Text is always sent as SSML so
+// Speed can become a prosody rate; Pitch is only honored by the standard
+// engine, since neural voices reject prosody pitch.
+func (p *pollySynthesizer) SynthesizeAudio(ctx context.Context, text string, opts TTSOptions) ([]byte, error) {
+	voice := p.cfg.Voice
+	if opts.Voice != "" {
+		voice = opts.Voice
+	}
+	pitch := opts.Pitch
+	if p.cfg.Engine != "standard" {
+		pitch = 0
+	}
+	body := pollyReq{
+		Engine:       p.cfg.Engine,
+		OutputFormat: p.cfg.OutputFormat,
+		Text:         "<speak>" + pollyProsody(ssmlBody(text, opts.SSML), opts.Speed, pitch) + "</speak>",
+		TextType:     "ssml",
+		VoiceId:      voice,
+	}
+	if body.OutputFormat == "pcm" {
+		body.SampleRate = fmt.Sprint(pollySampleRate)
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("polly marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+p.host+"/v1/speech", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("polly request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	p.sign(req, payload, time.Now().UTC())
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("polly do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("polly status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil || body.OutputFormat != "pcm" {
+		return data, err
+	}
+	samples, _, err := audio.Decode(data, audio.CodecPCM, pollySampleRate)
+	if err != nil {
+		return nil, err
+	}
+	return audio.SamplesToWAV(samples, pollySampleRate), nil
+}
+
+// pollyProsody wraps body in a prosody element. Polly takes rate as a
+// percentage of normal and pitch as a relative percentage, not semitones.
+func pollyProsody(body string, speed, semitones float64) string {
+	var attrs []string
+	if speed > 0 && speed != 1 {
+		attrs = append(attrs, fmt.Sprintf(`rate="%.0f%%"`, min(max(speed, 0.2), 2)*100))
+	}
+	if semitones != 0 {
+		attrs = append(attrs, fmt.Sprintf(`pitch="%+.0f%%"`, (math.Pow(2, semitones/12)-1)*100))
+	}
+	if len(attrs) == 0 {
+		return body
+	}
+	return "<prosody " + strings.Join(attrs, " ") + ">" + body + "</prosody>"
+}
+
+// sign adds AWS Signature Version 4 headers for the polly service.
+func (p *pollySynthesizer) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + p.cfg.Region + "/polly/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := "content-type:application/json\nhost:" + p.host + "\nx-amz-date:" + amzDate + "\n"
+	signed := "content-type;host;x-amz-date"
+	if p.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.cfg.SessionToken)
+		headers += "x-amz-security-token:" + p.cfg.SessionToken + "\n"
+		signed += ";x-amz-security-token"
+	}
+
+	canonical := strings.Join([]string{req.Method, req.URL.Path, "", headers, signed, sha256Hex(payload)}, "\n")
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonical))}, "\n")
+
+	key := []byte("AWS4" + p.cfg.SecretKey)
+	for _, part := range []string{date, p.cfg.Region, "polly", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.cfg.AccessKey, scope, signed, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
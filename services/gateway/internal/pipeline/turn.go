@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/logctx"
+)
+
+// turnState tracks the session's in-flight turn (one user input and the
+// agent's streamed reply) so a newer input or a disconnect can cancel it.
+// Turns run one at a time: a new turn cancels the previous one and waits
+// for it to unwind before touching history.
+type turnState struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	n      int // turns begun so far
+}
+
+// noTurn is the already-closed done channel of a session with no turns yet.
+var noTurn = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// begin cancels any in-flight turn and registers a new one, numbered n
+// from 1. The caller must wait on prev before running, and call end when
+// finished.
+func (t *turnState) begin(ctx context.Context) (turnCtx context.Context, n int, prev <-chan struct{}, end func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.n++
+	prevDone := t.done
+	if prevDone == nil {
+		prevDone = noTurn
+	}
+	turnCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	t.cancel, t.done = cancel, done
+	return turnCtx, t.n, prevDone, func() {
+		cancel()
+		close(done)
+	}
+}
+
+// stop cancels the in-flight turn and returns a channel closed once it has
+// unwound.
+func (t *turnState) stop() <-chan struct{} {
+	t.mu.Lock()
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.mu.Unlock()
+	return t.wait()
+}
+
+// wait returns a channel closed once the in-flight turn has finished.
+func (t *turnState) wait() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done == nil {
+		return noTurn
+	}
+	return t.done
+}
+
+// runTurn runs fn as the session's current turn, cancelling the one in
+// flight. Everything logged with the turn's context names the session, and
+// fn sends its events through the onEvent it is given, which numbers them.
+func (p *Pipeline) runTurn(ctx context.Context, onEvent EventCallback, fn func(context.Context, EventCallback) error) error {
+	turnCtx, n, prev, end := p.turn.begin(logctx.With(ctx, "session_id", p.cfg.SessionID))
+	defer end()
+	onEvent = numberEvents(n, onEvent)
+	<-prev
+	return p.turnResult(turnCtx, fn(turnCtx, onEvent), onEvent)
+}
+
+// startTurn is runTurn in the background, for inputs that arrive on the
+// read loop (VAD-detected speech), so the loop keeps reading and can see
+// the caller speak again or hang up. Errors are reported as events.
+func (p *Pipeline) startTurn(ctx context.Context, onEvent EventCallback, fn func(context.Context, EventCallback) error) {
+	turnCtx, n, prev, end := p.turn.begin(logctx.With(ctx, "session_id", p.cfg.SessionID))
+	onEvent = numberEvents(n, onEvent)
+	go func() {
+		defer end()
+		<-prev
+		if err := p.turnResult(turnCtx, fn(turnCtx, onEvent), onEvent); err != nil {
+			slog.ErrorContext(turnCtx, "turn", "error", err)
+			onEvent(Event{Type: "error", Text: err.Error()})
+		}
+	}()
+}
+
+// numberEvents stamps every event of turn n with its number, and each
+// sentence of its speech with the sentence's place in the reply, so a
+// client can match audio to the text it speaks. An inter-sentence pause
+// shares the number of the sentence before it; filler audio has none.
+func numberEvents(n int, onEvent EventCallback) EventCallback {
+	var sentence atomic.Int32
+	return func(e Event) {
+		e.Turn = n
+		if e.Type == "tts_ready" && !e.Filler {
+			if e.Pause {
+				e.Sentence = int(sentence.Load())
+			} else {
+				e.Sentence = int(sentence.Add(1))
+			}
+		}
+		onEvent(e)
+	}
+}
+
+// turnResult reports a cancelled turn as turn_cancelled rather than an
+// error: whatever failed after cancellation failed because of it. A turn
+// that ran out of a budget is reported as budget_exceeded.
+func (p *Pipeline) turnResult(turnCtx context.Context, err error, onEvent EventCallback) error {
+	var be *budgetError
+	if errors.As(err, &be) && turnCtx.Err() == nil {
+		slog.WarnContext(turnCtx, "turn over budget", "stage", be.Stage, "budget", be.Key, "budget_ms", be.Ms)
+		onEvent(be.event())
+		return nil
+	}
+	if err == nil || turnCtx.Err() == nil {
+		return err
+	}
+	slog.InfoContext(turnCtx, "turn cancelled")
+	onEvent(Event{Type: "turn_cancelled"})
+	return nil
+}
+
+// CancelTurn stops the in-flight turn, if any: its LLM stream and TTS
+// requests are cancelled and no further tokens or audio are emitted. The
+// client is taken to have stopped playing agent audio too.
+func (p *Pipeline) CancelTurn() {
+	p.turn.stop()
+	p.talk.stopPlayback()
+}
+
+// Close cancels the in-flight turn and waits for it to unwind, then
+// releases the audio decoder. Call it when the session ends so nothing
+// keeps generating for a closed socket.
+func (p *Pipeline) Close() {
+	<-p.turn.stop()
+	if p.decoder != nil {
+		p.decoder.Close()
+	}
+}
+
+// Wait blocks until the in-flight turn, if any, finishes on its own, e.g.
+// before handing the conversation to a replacement pipeline.
+func (p *Pipeline) Wait() {
+	<-p.turn.wait()
+}
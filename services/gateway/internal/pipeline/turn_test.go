@@ -0,0 +1,240 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+)
+
+// runawayLLM streams while ignoring ctx, like a provider whose stream
+// doesn't notice cancellation. The first call sends one token and holds
+// until release is closed, then keeps streaming; later calls answer at once.
+type runawayLLM struct {
+	calls     atomic.Int32
+	streaming chan struct{} // closed once the first call has sent a token
+	cancelled chan struct{} // closed once the first call's ctx is done
+	release   chan struct{} // closed by the test to let the first call go on
+}
+
+func newRunawayLLM() *runawayLLM {
+	return &runawayLLM{streaming: make(chan struct{}), cancelled: make(chan struct{}), release: make(chan struct{})}
+}
+
+const secondAnswer = "Second answer."
+
+func (f *runawayLLM) Chat(ctx context.Context, _ []Message, _, _ string, onToken TokenCallback) (*LLMResult, error) {
+	if f.calls.Add(1) > 1 {
+		onToken(secondAnswer)
+		return &LLMResult{Text: secondAnswer}, nil
+	}
+	go func() {
+		<-ctx.Done()
+		close(f.cancelled)
+	}()
+	onToken("First ")
+	close(f.streaming)
+	<-f.release
+	for range 5 {
+		onToken("more. ")
+	}
+	return &LLMResult{Text: "First more. more. more. more. more."}, nil
+}
+
+type fakeASR struct{}
+
+func (fakeASR) Transcribe(context.Context, []float32, ASROptions) (*ASRResult, error) {
+	return &ASRResult{Text: "hello there"}, nil
+}
+
+type fakeTTS struct{}
+
+func (fakeTTS) SynthesizeAudio(context.Context, string, TTSOptions) ([]byte, error) {
+	return audio.SamplesToWAV(make([]float32, 160), 16000), nil
+}
+
+// eventLog collects a session's events from every turn.
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *eventLog) record(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+func (l *eventLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.events)
+}
+
+// tokensSince returns the llm_token text turn sent after the first n events.
+func (l *eventLog) tokensSince(n, turn int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var tokens []string
+	for _, e := range l.events[n:] {
+		if e.Type == "llm_token" && e.Turn == turn {
+			tokens = append(tokens, e.Token)
+		}
+	}
+	return tokens
+}
+
+func (l *eventLog) has(eventType string, turn int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.events {
+		if e.Type == eventType && e.Turn == turn {
+			return true
+		}
+	}
+	return false
+}
+
+// turnPaths start a turn through each way a reply is streamed: typed chat
+// (chatTurn) and transcribed audio (streamLLMWithTTS).
+var turnPaths = []struct {
+	name  string
+	start func(p *Pipeline, onEvent EventCallback) error
+}{
+	{"text", func(p *Pipeline, onEvent EventCallback) error {
+		return p.ProcessTextMessage(context.Background(), "hello there", onEvent)
+	}},
+	{"audio", func(p *Pipeline, onEvent EventCallback) error {
+		if err := p.ProcessChunkNoVAD(make([]byte, 3200), audio.CodecPCM, 16000); err != nil {
+			return err
+		}
+		return p.ProcessBuffered(context.Background(), "fake", "fake", onEvent)
+	}},
+}
+
+func newTurnPipeline(llm LLMChatClient) *Pipeline {
+	agent := NewAgentLLM("fake", 0)
+	agent.RegisterRaw("fake", llm, "fake-model")
+	return New(Config{
+		LLMClient: agent,
+		LLMEngine: "fake",
+		ASRClient: NewASRRouter(map[string]ASRTranscriber{"fake": fakeASR{}}, "fake"),
+		TTSClient: NewTTSRouter(map[string]TTSSynthesizer{"fake": fakeTTS{}}, "fake"),
+	})
+}
+
+// startTurn runs start in the background and returns its result channel.
+func startTurn(p *Pipeline, start func(*Pipeline, EventCallback) error, log *eventLog) <-chan error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- start(p, log.record) }()
+	return errCh
+}
+
+func await[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		panic("unreachable")
+	}
+}
+
+func TestCancelTurnDropsLateTokens(t *testing.T) {
+	for _, path := range turnPaths {
+		t.Run(path.name, func(t *testing.T) {
+			llm := newRunawayLLM()
+			p := newTurnPipeline(llm)
+			var log eventLog
+			errCh := startTurn(p, path.start, &log)
+			await(t, llm.streaming, "the first token")
+
+			mark := log.len()
+			p.CancelTurn()
+			close(llm.release)
+			if err := await(t, errCh, "the turn"); err != nil {
+				t.Fatalf("turn: %v", err)
+			}
+
+			if late := log.tokensSince(mark, 1); len(late) > 0 {
+				t.Errorf("llm_token after CancelTurn: %q", late)
+			}
+			if !log.has("turn_cancelled", 1) {
+				t.Error("no turn_cancelled event")
+			}
+			if h := p.History(); len(h) != 0 {
+				t.Errorf("cancelled turn was appended: %+v", h)
+			}
+		})
+	}
+}
+
+func TestNewTurnDropsPreviousTokens(t *testing.T) {
+	for _, path := range turnPaths {
+		t.Run(path.name, func(t *testing.T) {
+			llm := newRunawayLLM()
+			p := newTurnPipeline(llm)
+			var log eventLog
+			firstErr := startTurn(p, path.start, &log)
+			await(t, llm.streaming, "the first token")
+
+			secondErr := startTurn(p, path.start, &log)
+			await(t, llm.cancelled, "the second turn to cancel the first")
+			mark := log.len()
+			close(llm.release)
+			if err := await(t, firstErr, "the first turn"); err != nil {
+				t.Fatalf("first turn: %v", err)
+			}
+			if err := await(t, secondErr, "the second turn"); err != nil {
+				t.Fatalf("second turn: %v", err)
+			}
+
+			if late := log.tokensSince(mark, 1); len(late) > 0 {
+				t.Errorf("first turn's llm_token after the second began: %q", late)
+			}
+			if got := log.tokensSince(mark, 2); len(got) != 1 || got[0] != secondAnswer {
+				t.Errorf("second turn's tokens = %q, want [%q]", got, secondAnswer)
+			}
+			h := p.History()
+			if len(h) != 1 || h[0].Assistant != secondAnswer {
+				t.Errorf("history = %+v, want only the second turn", h)
+			}
+		})
+	}
+}
+
+func TestCloseDropsLateTokens(t *testing.T) {
+	for _, path := range turnPaths {
+		t.Run(path.name, func(t *testing.T) {
+			llm := newRunawayLLM()
+			p := newTurnPipeline(llm)
+			var log eventLog
+			errCh := startTurn(p, path.start, &log)
+			await(t, llm.streaming, "the first token")
+
+			closed := make(chan struct{})
+			go func() {
+				p.Close()
+				close(closed)
+			}()
+			await(t, llm.cancelled, "Close to cancel the turn")
+			mark := log.len()
+			close(llm.release)
+			await(t, closed, "Close")
+			if err := await(t, errCh, "the turn"); err != nil {
+				t.Fatalf("turn: %v", err)
+			}
+
+			if late := log.tokensSince(mark, 1); len(late) > 0 {
+				t.Errorf("llm_token after Close: %q", late)
+			}
+			if h := p.History(); len(h) != 0 {
+				t.Errorf("closed turn was appended: %+v", h)
+			}
+		})
+	}
+}
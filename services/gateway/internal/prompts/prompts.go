@@ -0,0 +1,170 @@
+// Package prompts stores named system prompts in SQLite. Every edit adds a
+// version rather than overwriting one, so a trace that recorded "billing"
+// version 3 can always be matched to the exact text the agent ran with.
+package prompts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // registers "sqlite3" driver
+)
+
+var (
+	// ErrNotFound is returned for a prompt name or version that doesn't exist.
+	ErrNotFound = errors.New("prompt not found")
+
+	// ErrExists is returned by Create for a name that is already taken.
+	ErrExists = errors.New("prompt already exists")
+
+	// ErrInvalidName is returned for names outside validName.
+	ErrInvalidName = errors.New("prompt names are 1-64 letters, digits, '-', '_', or '.'")
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS prompts (
+    name       TEXT    NOT NULL,
+    version    INTEGER NOT NULL,
+    text       TEXT    NOT NULL,
+    note       TEXT    NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (name, version)
+)`
+
+// Prompt is one version of a named prompt.
+type Prompt struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	Text      string    `json:"text"`
+	Note      string    `json:"note,omitempty"` // what changed in this version
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store persists prompts to a SQLite database file.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens (creating if needed) the prompt database at path.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("prompts open: %w", err)
+	}
+	// one writer at a time; SQLite serializes writes anyway
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("prompts schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// List returns the latest version of every prompt, by name.
+func (s *Store) List() ([]Prompt, error) {
+	return s.query(`
+		SELECT p.name, p.version, p.text, p.note, p.created_at
+		FROM prompts p
+		JOIN (SELECT name, MAX(version) AS version FROM prompts GROUP BY name) latest
+		  ON latest.name = p.name AND latest.version = p.version
+		ORDER BY p.name`)
+}
+
+// Versions returns every version of name, oldest first.
+func (s *Store) Versions(name string) ([]Prompt, error) {
+	versions, err := s.query(`SELECT name, version, text, note, created_at FROM prompts WHERE name = ? ORDER BY version`, name)
+	if err == nil && len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return versions, err
+}
+
+// Get returns a version of name (0 = the latest).
+func (s *Store) Get(name string, version int) (*Prompt, error) {
+	query := `SELECT name, version, text, note, created_at FROM prompts WHERE name = ? AND version = ?`
+	args := []any{name, version}
+	if version <= 0 {
+		query = `SELECT name, version, text, note, created_at FROM prompts WHERE name = ? ORDER BY version DESC LIMIT 1`
+		args = args[:1]
+	}
+	var p Prompt
+	err := s.db.QueryRow(query, args...).Scan(&p.Name, &p.Version, &p.Text, &p.Note, &p.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Create adds a new prompt as version 1.
+func (s *Store) Create(name, text, note string) (*Prompt, error) {
+	if !validName.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+	_, err := s.Get(name, 0)
+	if err == nil {
+		return nil, ErrExists
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	return s.insert(name, 1, text, note)
+}
+
+// Update adds the next version of an existing prompt.
+func (s *Store) Update(name, text, note string) (*Prompt, error) {
+	latest, err := s.Get(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.insert(name, latest.Version+1, text, note)
+}
+
+// Delete removes every version of name. Returns false if it didn't exist.
+func (s *Store) Delete(name string) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM prompts WHERE name = ?`, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Store) insert(name string, version int, text, note string) (*Prompt, error) {
+	p := &Prompt{Name: name, Version: version, Text: text, Note: note, CreatedAt: time.Now().UTC()}
+	_, err := s.db.Exec(`INSERT INTO prompts (name, version, text, note, created_at) VALUES (?, ?, ?, ?, ?)`,
+		p.Name, p.Version, p.Text, p.Note, p.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("prompts insert: %w", err)
+	}
+	return p, nil
+}
+
+func (s *Store) query(query string, args ...any) ([]Prompt, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Prompt
+	for rows.Next() {
+		var p Prompt
+		if err = rows.Scan(&p.Name, &p.Version, &p.Text, &p.Note, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
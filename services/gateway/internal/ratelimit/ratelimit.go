@@ -0,0 +1,135 @@
+// Package ratelimit provides per-client token buckets so a single
+// misbehaving client can't starve the shared ASR/LLM/TTS pools.
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/auth"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+)
+
+const (
+	// maxTrackedClients bounds the bucket map; past it, idle buckets are pruned.
+	maxTrackedClients = 10000
+
+	// idleBucketTTL is how long an untouched bucket is kept before pruning.
+	idleBucketTTL = 10 * time.Minute
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a set of token buckets keyed by client. All methods are
+// nil-safe: a nil Limiter allows everything.
+type Limiter struct {
+	mu      sync.Mutex
+	name    string
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+}
+
+// New creates a limiter refilling rate tokens/sec up to burst. The name
+// labels the rate-limited metric. Returns nil (unlimited) if rate <= 0.
+func New(name string, rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+	return &Limiter{name: name, rate: rate, burst: float64(burst), buckets: map[string]*bucket{}}
+}
+
+// Allow consumes one token for key. When denied, returns how long until a
+// token is available so callers can send Retry-After.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		l.pruneLocked(now)
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	metrics.RateLimited.WithLabelValues(l.name).Inc()
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+func (l *Limiter) pruneLocked(now time.Time) {
+	if len(l.buckets) < maxTrackedClients {
+		return
+	}
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > idleBucketTTL {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// ClientKey identifies the caller: the API key identity when authenticated,
+// otherwise the remote IP.
+func ClientKey(r *http.Request) string {
+	if id, ok := auth.FromContext(r.Context()); ok {
+		return "key:" + id.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// unlimitedPaths are never rate limited (probes and scraping).
+var unlimitedPaths = map[string]bool{
+	"/health":          true,
+	"/api/health/deep": true,
+	"/metrics":         true,
+}
+
+// Middleware rejects requests over the client's rate with 429 and a
+// Retry-After header. A nil limiter disables limiting.
+func Middleware(l *Limiter, next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unlimitedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, wait := l.Allow(ClientKey(r))
+		if !ok {
+			w.Header().Set("Retry-After", RetryAfter(wait))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RetryAfter formats a wait as whole seconds (rounded up, minimum 1).
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(wait.Seconds()))))
+}
@@ -0,0 +1,90 @@
+package redact
+
+import (
+	"regexp"
+	"strings"
+)
+
+// rule replaces matches of re with a placeholder. valid, when set, vetoes
+// matches that only look like PII (e.g. digit runs failing a Luhn check).
+type rule struct {
+	re          *regexp.Regexp
+	placeholder string
+	valid       func(match string) bool
+}
+
+// rules run in order: emails first (they may contain digits), then card
+// numbers before the shorter SSN and phone shapes they could contain.
+var rules = []rule{
+	{
+		re:          regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+		placeholder: "[EMAIL]",
+	},
+	{
+		re:          regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		placeholder: "[CARD]",
+		valid:       luhn,
+	},
+	{
+		re:          regexp.MustCompile(`\b\d{3}[- ]\d{2}[- ]\d{4}\b`),
+		placeholder: "[SSN]",
+	},
+	{
+		re:          regexp.MustCompile(`(?:\+?1[ .-]?)?(?:\(\d{3}\)|\b\d{3})[ .-]?\d{3}[ .-]?\d{4}\b`),
+		placeholder: "[PHONE]",
+	},
+}
+
+// Redactor masks credit card numbers, US social security numbers, phone
+// numbers, and email addresses in free text. A nil *Redactor leaves text
+// unchanged, so callers can hold one unconditionally.
+type Redactor struct{}
+
+// New returns a Redactor, or nil when enabled is false.
+func New(enabled bool) *Redactor {
+	if !enabled {
+		return nil
+	}
+	return &Redactor{}
+}
+
+// Redact returns s with PII replaced by placeholders such as "[CARD]".
+func (r *Redactor) Redact(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+	for _, ru := range rules {
+		s = ru.re.ReplaceAllStringFunc(s, func(m string) string {
+			if ru.valid != nil && !ru.valid(m) {
+				return m
+			}
+			return ru.placeholder
+		})
+	}
+	return s
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum used by
+// payment card numbers.
+func luhn(s string) bool {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,301 @@
+package trace
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+const (
+	// analyticsInterval is how often the analytics job recomputes the
+	// current and previous day.
+	analyticsInterval = time.Hour
+
+	// analyticsBackfillDays is how many days the job computes at startup,
+	// so days from before the gateway last ran are filled in.
+	analyticsBackfillDays = 30
+
+	// dayLayout is how days are written: UTC dates.
+	dayLayout = time.DateOnly
+)
+
+// DailyStats aggregates one UTC day of traced calls for reporting. Days are
+// kept after their sessions are pruned or purged, so they outlive the
+// traces they were computed from.
+type DailyStats struct {
+	Day          string                    `json:"day"`              // YYYY-MM-DD
+	Tenant       string                    `json:"tenant,omitempty"` // "" = every tenant
+	Calls        int                       `json:"calls"`            // sessions started on the day
+	AvgHandleSec float64                   `json:"avg_handle_sec"`   // mean length of those that ended
+	Runs         map[string]int            `json:"runs"`             // by status: ok, error, cancelled, filtered, hold
+	FilterRate   float64                   `json:"filter_rate"`      // share of runs filtered as noise or low confidence
+	Stages       map[string]StageStats     `json:"stages"`           // by span name: asr, llm, tts...
+	Engines      map[string]map[string]int `json:"engines"`          // calls per engine, by stage (asr, llm, tts)
+	Failures     map[string]int            `json:"failures"`         // failed spans by stage
+	CostUSD      float64                   `json:"cost_usd"`
+	ComputedAt   time.Time                 `json:"computed_at"`
+}
+
+// StageStats is the latency of one stage's successful spans.
+type StageStats struct {
+	Count int     `json:"count"`
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+}
+
+// RunAnalytics computes daily stats for every tenant and overall: the last
+// analyticsBackfillDays at startup, then today and yesterday (for calls
+// that ran past midnight) every analyticsInterval, until ctx is cancelled.
+// No-op when store is nil.
+func RunAnalytics(ctx context.Context, store *Store) {
+	if store == nil {
+		return
+	}
+	ticker := time.NewTicker(analyticsInterval)
+	defer ticker.Stop()
+	days := analyticsBackfillDays
+	for {
+		analyzeDays(store, time.Now().UTC(), days)
+		days = 2
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// analyzeDays computes and saves the stats of the n days up to now: an
+// overall row and one per tenant. Days without calls are skipped.
+func analyzeDays(store *Store, now time.Time, n int) {
+	today := now.Truncate(24 * time.Hour)
+	for i := n - 1; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i)
+		tenants, err := store.dayTenants(day)
+		if err != nil {
+			slog.Warn("analytics", "day", day.Format(dayLayout), "error", err)
+			return
+		}
+		if len(tenants) == 0 {
+			continue
+		}
+		if tenants[0] != "" {
+			tenants = append([]string{""}, tenants...)
+		}
+		for _, tenant := range tenants {
+			if err = store.analyzeDay(day, tenant); err != nil {
+				slog.Warn("analytics", "day", day.Format(dayLayout), "tenant", tenant, "error", err)
+			}
+		}
+	}
+}
+
+// dayTenants returns the tenants with calls on day, in order ("" for
+// untenanted calls).
+func (s *Store) dayTenants(day time.Time) ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT tenant FROM sessions WHERE started_at >= $1 AND started_at < $2 ORDER BY tenant`, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tenants []string
+	for rows.Next() {
+		var t string
+		if err = rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+// analyzeDay computes and stores, replacing, one day's stats for tenant.
+func (s *Store) analyzeDay(day time.Time, tenant string) error {
+	st, err := s.ComputeDailyStats(day, tenant)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO daily_stats (day, tenant, stats, computed_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (day, tenant) DO UPDATE SET stats = EXCLUDED.stats, computed_at = EXCLUDED.computed_at
+	`, st.Day, tenant, data, st.ComputedAt)
+	return err
+}
+
+// ComputeDailyStats aggregates the traces of day (a UTC date) for tenant
+// ("" = every tenant).
+func (s *Store) ComputeDailyStats(day time.Time, tenant string) (*DailyStats, error) {
+	from := day.UTC().Truncate(24 * time.Hour)
+	to := from.AddDate(0, 0, 1)
+	st := &DailyStats{
+		Day:        from.Format(dayLayout),
+		Tenant:     tenant,
+		Runs:       map[string]int{},
+		Stages:     map[string]StageStats{},
+		Engines:    map[string]map[string]int{},
+		Failures:   map[string]int{},
+		ComputedAt: time.Now().UTC(),
+	}
+	var avgHandle sql.NullFloat64
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), AVG(EXTRACT(EPOCH FROM ended_at - started_at)) FILTER (WHERE ended_at IS NOT NULL)
+		FROM sessions
+		WHERE started_at >= $1 AND started_at < $2 AND ($3 = '' OR tenant = $3)
+	`, from, to, tenant).Scan(&st.Calls, &avgHandle)
+	if err != nil {
+		return nil, err
+	}
+	st.AvgHandleSec = avgHandle.Float64
+	if err = s.dayRuns(st, from, to, tenant); err != nil {
+		return nil, err
+	}
+	if err = s.dayStages(st, from, to, tenant); err != nil {
+		return nil, err
+	}
+	if err = s.dayEngines(st, from, to, tenant); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// dayRuns counts the day's runs by status, and totals their cost.
+func (s *Store) dayRuns(st *DailyStats, from, to time.Time, tenant string) error {
+	rows, err := s.db.Query(`
+		SELECT r.status, COUNT(*), COALESCE(SUM(r.cost_usd), 0)
+		FROM runs r
+		JOIN sessions s ON s.id = r.session_id
+		WHERE r.started_at >= $1 AND r.started_at < $2 AND ($3 = '' OR s.tenant = $3)
+		GROUP BY r.status
+	`, from, to, tenant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	total := 0
+	for rows.Next() {
+		var status string
+		var n int
+		var cost float64
+		if err = rows.Scan(&status, &n, &cost); err != nil {
+			return err
+		}
+		st.Runs[status] = n
+		st.CostUSD += cost
+		total += n
+	}
+	if total > 0 {
+		st.FilterRate = float64(st.Runs["filtered"]) / float64(total)
+	}
+	return rows.Err()
+}
+
+// dayStages takes each stage's latency percentiles and failure count from
+// the day's spans.
+func (s *Store) dayStages(st *DailyStats, from, to time.Time, tenant string) error {
+	rows, err := s.db.Query(`
+		SELECT sp.name,
+		       COUNT(*) FILTER (WHERE sp.status = 'ok'),
+		       PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY sp.duration_ms) FILTER (WHERE sp.status = 'ok'),
+		       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY sp.duration_ms) FILTER (WHERE sp.status = 'ok'),
+		       COUNT(*) FILTER (WHERE sp.status = 'error')
+		FROM spans sp
+		JOIN runs r ON r.id = sp.run_id
+		JOIN sessions s ON s.id = r.session_id
+		WHERE sp.started_at >= $1 AND sp.started_at < $2 AND ($3 = '' OR s.tenant = $3)
+		GROUP BY sp.name
+	`, from, to, tenant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var ok, failed int
+		var p50, p95 sql.NullFloat64
+		if err = rows.Scan(&name, &ok, &p50, &p95, &failed); err != nil {
+			return err
+		}
+		if ok > 0 {
+			st.Stages[name] = StageStats{Count: ok, P50Ms: p50.Float64, P95Ms: p95.Float64}
+		}
+		if failed > 0 {
+			st.Failures[name] = failed
+		}
+	}
+	return rows.Err()
+}
+
+// dayEngines counts the engines the day's calls chose, from their session
+// metadata. Metadata is parsed here rather than in SQL: redaction may have
+// left a session's metadata unparseable, and it is skipped.
+func (s *Store) dayEngines(st *DailyStats, from, to time.Time, tenant string) error {
+	rows, err := s.db.Query(`
+		SELECT metadata FROM sessions
+		WHERE started_at >= $1 AND started_at < $2 AND ($3 = '' OR tenant = $3)
+	`, from, to, tenant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var metadata string
+		if err = rows.Scan(&metadata); err != nil {
+			return err
+		}
+		var meta struct {
+			ASREngine string `json:"asr_engine"`
+			LLMEngine string `json:"llm_engine"`
+			TTSEngine string `json:"tts_engine"`
+		}
+		if json.Unmarshal([]byte(metadata), &meta) != nil {
+			continue
+		}
+		st.countEngine("asr", meta.ASREngine)
+		st.countEngine("llm", meta.LLMEngine)
+		st.countEngine("tts", meta.TTSEngine)
+	}
+	return rows.Err()
+}
+
+func (st *DailyStats) countEngine(stage, engine string) {
+	if engine == "" {
+		return
+	}
+	if st.Engines[stage] == nil {
+		st.Engines[stage] = map[string]int{}
+	}
+	st.Engines[stage][engine]++
+}
+
+// ListDailyStats returns the stored stats of tenant ("" = every tenant)
+// for the days from through to, oldest first.
+func (s *Store) ListDailyStats(tenant string, from, to time.Time) ([]DailyStats, error) {
+	rows, err := s.db.Query(`
+		SELECT stats FROM daily_stats
+		WHERE tenant = $1 AND day >= $2 AND day <= $3
+		ORDER BY day ASC
+	`, tenant, from.Format(dayLayout), to.Format(dayLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []DailyStats{}
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var st DailyStats
+		if err = json.Unmarshal(data, &st); err != nil {
+			return nil, err
+		}
+		out = append(out, st)
+	}
+	return out, rows.Err()
+}
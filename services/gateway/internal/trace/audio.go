@@ -0,0 +1,87 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+)
+
+// archiveSampleRate is the rate of the speech segments the pipeline sends
+// to ASR, and so of every archived WAV.
+const archiveSampleRate = 16000
+
+// ErrNoAudio is returned by RunAudio for a run without archived audio.
+var ErrNoAudio = errors.New("no archived audio")
+
+// audioArchive keeps each run's post-VAD speech as <dir>/<session>/<run>.wav,
+// so a bad transcript can be replayed through another ASR engine. Audio is
+// never redacted; enable it only where raw caller speech may be stored.
+type audioArchive struct {
+	dir string
+}
+
+// SetAudioDir archives post-VAD speech under dir. Archived audio is removed
+// with its session.
+func (s *Store) SetAudioDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("trace audio dir: %w", err)
+	}
+	s.audio = &audioArchive{dir: dir}
+	return nil
+}
+
+// RunAudio returns a run's archived speech as 16 kHz mono WAV.
+func (s *Store) RunAudio(sessionID, runID string) ([]byte, error) {
+	path, ok := s.audio.path(sessionID, runID)
+	if !ok {
+		return nil, ErrNoAudio
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoAudio
+	}
+	return data, err
+}
+
+func (s *Store) saveAudio(sessionID, runID string, samples []float32) error {
+	path, ok := s.audio.path(sessionID, runID)
+	if !ok {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	return os.WriteFile(path, audio.SamplesToWAV(samples, archiveSampleRate), 0o640)
+}
+
+// path is where a run's audio lives. Reports false when archiving is off
+// or an ID could escape the archive directory.
+func (a *audioArchive) path(sessionID, runID string) (string, bool) {
+	if a == nil || !safeName(sessionID) || !safeName(runID) {
+		return "", false
+	}
+	return filepath.Join(a.dir, sessionID, runID+".wav"), true
+}
+
+// remove deletes the archived audio of each session.
+func (a *audioArchive) remove(sessionIDs []string) {
+	if a == nil {
+		return
+	}
+	for _, id := range sessionIDs {
+		if !safeName(id) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(a.dir, id)); err != nil {
+			slog.Warn("remove archived audio", "session_id", id, "error", err)
+		}
+	}
+}
+
+func safeName(id string) bool {
+	return id != "" && id != "." && id != ".." && filepath.Base(id) == id
+}
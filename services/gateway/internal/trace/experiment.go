@@ -0,0 +1,58 @@
+package trace
+
+import "database/sql"
+
+// VariantStats totals the traced runs of one experiment variant. Latency
+// covers successful runs; the error count is runs that failed outright
+// (cancelled, filtered, and held runs are neither).
+type VariantStats struct {
+	Variant  string   `json:"variant"`
+	Sessions int      `json:"sessions"`
+	Runs     int      `json:"runs"` // ok + error
+	Errors   int      `json:"errors"`
+	MeanMs   float64  `json:"mean_ms"`
+	P50Ms    float64  `json:"p50_ms"`
+	P95Ms    float64  `json:"p95_ms"`
+	WERRuns  int      `json:"wer_runs"`           // runs scored against a reference transcript
+	MeanWER  *float64 `json:"mean_wer,omitempty"` // nil when no run was scored
+	CostUSD  float64  `json:"cost_usd"`
+}
+
+// ExperimentStats returns per-variant totals for experiment, limited to
+// tenant's sessions ("" = every tenant).
+func (s *Store) ExperimentStats(experiment, tenant string) ([]VariantStats, error) {
+	rows, err := s.db.Query(`
+		SELECT s.variant,
+		       COUNT(DISTINCT s.id),
+		       COUNT(r.id) FILTER (WHERE r.status IN ('ok', 'error')),
+		       COUNT(r.id) FILTER (WHERE r.status = 'error'),
+		       AVG(r.duration_ms) FILTER (WHERE r.status = 'ok'),
+		       PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY r.duration_ms) FILTER (WHERE r.status = 'ok'),
+		       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY r.duration_ms) FILTER (WHERE r.status = 'ok'),
+		       COUNT(r.wer),
+		       AVG(r.wer),
+		       COALESCE(SUM(r.cost_usd), 0)
+		FROM sessions s
+		LEFT JOIN runs r ON r.session_id = s.id
+		WHERE s.experiment = $1 AND ($2 = '' OR s.tenant = $2)
+		GROUP BY s.variant
+		ORDER BY s.variant
+	`, experiment, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []VariantStats
+	for rows.Next() {
+		var v VariantStats
+		var mean, p50, p95 sql.NullFloat64
+		if err = rows.Scan(&v.Variant, &v.Sessions, &v.Runs, &v.Errors, &mean, &p50, &p95,
+			&v.WERRuns, &v.MeanWER, &v.CostUSD); err != nil {
+			return nil, err
+		}
+		v.MeanMs, v.P50Ms, v.P95Ms = mean.Float64, p50.Float64, p95.Float64
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,54 @@
+package trace
+
+import "time"
+
+// TurnMetrics is the latency of one traced run, the numbers a call's
+// metrics event carried at the time. Stage latencies sum the run's spans of
+// that stage, so TTS covers every sentence of the reply.
+type TurnMetrics struct {
+	RunID     string    `json:"run_id"`
+	StartedAt time.Time `json:"started_at"`
+	Status    string    `json:"status"`
+	ASRMs     float64   `json:"asr_ms"`
+	LLMMs     float64   `json:"llm_ms"`
+	TTSMs     float64   `json:"tts_ms"`
+	TotalMs   float64   `json:"total_ms"` // end to end
+	WER       *float64  `json:"wer,omitempty"`
+	CostUSD   float64   `json:"cost_usd"`
+}
+
+// SessionMetrics returns a session with the metrics of each of its runs,
+// oldest first, so a client can chart call quality over the call.
+func (s *Store) SessionMetrics(sessionID string) (*Session, []TurnMetrics, error) {
+	sess, _, err := s.GetSession(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT r.id, r.started_at, r.status, r.duration_ms, r.wer, r.cost_usd,
+		       COALESCE(SUM(sp.duration_ms) FILTER (WHERE sp.name = 'asr'), 0),
+		       COALESCE(SUM(sp.duration_ms) FILTER (WHERE sp.name = 'llm'), 0),
+		       COALESCE(SUM(sp.duration_ms) FILTER (WHERE sp.name = 'tts'), 0)
+		FROM runs r
+		LEFT JOIN spans sp ON sp.run_id = r.id
+		WHERE r.session_id = $1
+		GROUP BY r.id
+		ORDER BY r.started_at ASC
+	`, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	turns := []TurnMetrics{}
+	for rows.Next() {
+		var m TurnMetrics
+		if err = rows.Scan(&m.RunID, &m.StartedAt, &m.Status, &m.TotalMs, &m.WER, &m.CostUSD,
+			&m.ASRMs, &m.LLMMs, &m.TTSMs); err != nil {
+			return nil, nil, err
+		}
+		turns = append(turns, m)
+	}
+	return sess, turns, rows.Err()
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS turns (
+    session_id     TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    seq            INTEGER NOT NULL,
+    user_text      TEXT NOT NULL DEFAULT '',
+    assistant_text TEXT NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (session_id, seq)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE spans ADD COLUMN IF NOT EXISTS parent_id TEXT   NOT NULL DEFAULT '';
+ALTER TABLE spans ADD COLUMN IF NOT EXISTS seq       BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_sessions_tenant ON sessions(tenant, started_at);
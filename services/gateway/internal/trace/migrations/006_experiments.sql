@@ -0,0 +1,5 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS experiment TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS variant    TEXT NOT NULL DEFAULT '';
+ALTER TABLE runs     ADD COLUMN IF NOT EXISTS wer        DOUBLE PRECISION;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_experiment ON sessions(experiment, variant) WHERE experiment <> '';
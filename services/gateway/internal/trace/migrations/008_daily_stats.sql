@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS daily_stats (
+    day         DATE NOT NULL,
+    tenant      TEXT NOT NULL DEFAULT '',
+    stats       JSONB NOT NULL,
+    computed_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (day, tenant)
+);
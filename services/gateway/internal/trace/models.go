@@ -4,29 +4,57 @@ import "time"
 
 // Session represents one WebSocket connection.
 type Session struct {
-	ID        string    `json:"id"`
-	Metadata  string    `json:"metadata"`
-	StartedAt time.Time `json:"started_at"`
-	EndedAt   *time.Time `json:"ended_at,omitempty"`
-	RunCount  int       `json:"run_count,omitempty"`
+	ID         string       `json:"id"`
+	Tenant     string       `json:"tenant,omitempty"`
+	Experiment string       `json:"experiment,omitempty"`
+	Variant    string       `json:"variant,omitempty"` // the experiment arm the session was assigned
+	Metadata   string       `json:"metadata"`
+	StartedAt  time.Time    `json:"started_at"`
+	EndedAt    *time.Time   `json:"ended_at,omitempty"`
+	RunCount   int          `json:"run_count,omitempty"`
+	Usage      *Usage       `json:"usage,omitempty"`     // totals across runs (GetSession only)
+	Summary    *CallSummary `json:"summary,omitempty"`   // set once the call is summarized (GetSession only)
+	TalkTime   *TalkTime    `json:"talk_time,omitempty"` // set when a talk-mode call ends (GetSession only)
 }
 
 // Run represents one pipeline execution (one speech segment through ASR→LLM→TTS).
 type Run struct {
-	ID         string     `json:"id"`
-	SessionID  string     `json:"session_id"`
-	StartedAt  time.Time  `json:"started_at"`
-	DurationMs float64    `json:"duration_ms,omitempty"`
-	Transcript string     `json:"transcript,omitempty"`
-	Response   string     `json:"response,omitempty"`
-	Status     string     `json:"status"`
-	SpanCount  int        `json:"span_count,omitempty"`
+	ID         string    `json:"id"`
+	SessionID  string    `json:"session_id"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs float64   `json:"duration_ms,omitempty"`
+	Transcript string    `json:"transcript,omitempty"`
+	Response   string    `json:"response,omitempty"`
+	Status     string    `json:"status"`
+	SpanCount  int       `json:"span_count,omitempty"`
+	WER        *float64  `json:"wer,omitempty"` // against the session's reference transcript (nil = not scored)
+	Usage
+}
+
+// Usage is the token, character, and estimated cost accounting for a run.
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TTSChars         int     `json:"tts_chars"`
+	CostUSD          float64 `json:"cost_usd"`
 }
 
-// Span represents an individual pipeline stage execution.
+// Add accumulates o into u.
+func (u *Usage) Add(o Usage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.TTSChars += o.TTSChars
+	u.CostUSD += o.CostUSD
+}
+
+// Span represents an individual pipeline stage execution. ParentID nests a
+// span under another in the same run ("" = top level); Seq orders spans by
+// when they started, since concurrent stages finish out of order.
 type Span struct {
 	ID         string    `json:"id"`
 	RunID      string    `json:"run_id"`
+	ParentID   string    `json:"parent_id,omitempty"`
+	Seq        int64     `json:"seq"`
 	Name       string    `json:"name"`
 	StartedAt  time.Time `json:"started_at"`
 	DurationMs float64   `json:"duration_ms"`
@@ -35,3 +63,12 @@ type Span struct {
 	Status     string    `json:"status"`
 	Error      string    `json:"error,omitempty"`
 }
+
+// Turn is one persisted user→assistant exchange, stored untruncated so a
+// reconnecting client can resume the conversation with full context.
+type Turn struct {
+	Seq       int       `json:"seq"`
+	User      string    `json:"user"`
+	Assistant string    `json:"assistant"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,40 @@
+package trace
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// retentionInterval is how often the retention job purges expired sessions.
+const retentionInterval = time.Hour
+
+// RunRetention deletes sessions older than maxAge, once at startup and then
+// every retentionInterval, until ctx is cancelled. No-op when store is nil
+// or maxAge <= 0.
+func RunRetention(ctx context.Context, store *Store, maxAge time.Duration) {
+	if store == nil || maxAge <= 0 {
+		return
+	}
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		purgeExpired(store, maxAge)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeExpired(store *Store, maxAge time.Duration) {
+	n, err := store.PurgeSessionsBefore(time.Now().Add(-maxAge))
+	if err != nil {
+		slog.Warn("trace retention purge failed", "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("trace retention purged sessions", "count", n, "max_age", maxAge)
+	}
+}
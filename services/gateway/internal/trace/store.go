@@ -1,22 +1,33 @@
 package trace
 
 import (
+	"context"
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib" // registers "pgx" driver
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/redact"
 )
 
 //go:embed migrations/*.sql
 var migrationFS embed.FS
 
+// maxSessions is how many sessions are kept per tenant.
 const maxSessions = 100
 
+// ErrOtherTenant is returned by ResumeSession for a session ID that exists
+// under a different tenant.
+var ErrOtherTenant = errors.New("session belongs to another tenant")
+
 // Store persists trace data to PostgreSQL.
 type Store struct {
-	db *sql.DB
+	db       *sql.DB
+	redactor *redact.Redactor // masks PII in stored text (nil = store verbatim)
+	audio    *audioArchive    // post-VAD speech per run (nil = not archived)
 }
 
 // Open connects to a PostgreSQL trace database at connStr.
@@ -68,27 +79,88 @@ func migrate(db *sql.DB) error {
 	return nil
 }
 
+// SetRedactor masks PII in session metadata and in all transcripts,
+// responses, span text, and turns written from now on.
+func (s *Store) SetRedactor(r *redact.Redactor) {
+	s.redactor = r
+}
+
 // Close closes the database.
 func (s *Store) Close() error {
 	return s.db.Close()
 }
 
-// CreateSession inserts a new session and prunes old ones.
-func (s *Store) CreateSession(id, metadata string) error {
+// ServerVersion returns the Postgres server version, doubling as a
+// connectivity check.
+func (s *Store) ServerVersion(ctx context.Context) (string, error) {
+	var v string
+	err := s.db.QueryRowContext(ctx, "SHOW server_version").Scan(&v)
+	return v, err
+}
+
+// Probe connects to the database at connStr and returns its server
+// version, without migrating it, to check a database before opening it.
+func Probe(ctx context.Context, connStr string) (string, error) {
+	db, err := sql.Open("pgx", connStr)
+	if err != nil {
+		return "", fmt.Errorf("trace open: %w", err)
+	}
+	defer db.Close()
+	var v string
+	err = db.QueryRowContext(ctx, "SHOW server_version").Scan(&v)
+	return v, err
+}
+
+// CreateSession inserts a new session from sess's ID, tenant ("" =
+// untenanted), experiment variant, and metadata, and prunes that tenant's
+// old ones, so one busy tenant can't push out another's history.
+func (s *Store) CreateSession(sess Session) error {
 	_, err := s.db.Exec(
-		`INSERT INTO sessions (id, metadata, started_at) VALUES ($1, $2, $3)`,
-		id, metadata, time.Now().UTC(),
+		`INSERT INTO sessions (id, tenant, experiment, variant, metadata, started_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		sess.ID, sess.Tenant, sess.Experiment, sess.Variant, s.redactor.Redact(sess.Metadata), time.Now().UTC(),
 	)
 	if err != nil {
 		return err
 	}
-	_, err = s.db.Exec(
-		`DELETE FROM sessions WHERE id NOT IN (SELECT id FROM sessions ORDER BY started_at DESC LIMIT $1)`,
-		maxSessions,
+	_, err = s.deleteSessions(
+		`DELETE FROM sessions WHERE tenant = $2 AND id NOT IN (SELECT id FROM sessions WHERE tenant = $2 ORDER BY started_at DESC LIMIT $1) RETURNING id`,
+		maxSessions, sess.Tenant,
 	)
 	return err
 }
 
+// ResumeSession reopens an existing session of tenant by clearing ended_at.
+// Returns false if no session with the given ID exists, and ErrOtherTenant
+// if it exists under another tenant.
+func (s *Store) ResumeSession(id, tenant string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE sessions SET ended_at = NULL WHERE id = $1 AND tenant = $2`, id, tenant)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return true, nil
+	}
+	var exists bool
+	if err = s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM sessions WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return false, err
+	}
+	if exists {
+		return false, ErrOtherTenant
+	}
+	return false, nil
+}
+
+// SessionTenant returns the tenant a session belongs to, or sql.ErrNoRows.
+func (s *Store) SessionTenant(id string) (string, error) {
+	var tenant string
+	err := s.db.QueryRow(`SELECT tenant FROM sessions WHERE id = $1`, id).Scan(&tenant)
+	return tenant, err
+}
+
 // EndSession sets the ended_at timestamp.
 func (s *Store) EndSession(id string) error {
 	_, err := s.db.Exec(
@@ -98,6 +170,42 @@ func (s *Store) EndSession(id string) error {
 	return err
 }
 
+// DeleteSession removes a session and, via cascade, its runs, spans, and turns.
+// Returns false if no session with the given ID exists.
+func (s *Store) DeleteSession(id string) (bool, error) {
+	n, err := s.deleteSessions(`DELETE FROM sessions WHERE id = $1 RETURNING id`, id)
+	return n > 0, err
+}
+
+// PurgeSessionsBefore deletes sessions (and their runs, spans, and turns)
+// that started before cutoff. Returns the number of sessions removed.
+func (s *Store) PurgeSessionsBefore(cutoff time.Time) (int64, error) {
+	return s.deleteSessions(`DELETE FROM sessions WHERE started_at < $1 RETURNING id`, cutoff.UTC())
+}
+
+// deleteSessions runs a DELETE ... RETURNING id on sessions and removes the
+// archived audio of every session it deleted.
+func (s *Store) deleteSessions(query string, args ...any) (int64, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	s.audio.remove(ids)
+	return int64(len(ids)), nil
+}
+
 // CreateRun inserts a new run.
 func (s *Store) CreateRun(id, sessionID string) error {
 	_, err := s.db.Exec(
@@ -107,11 +215,15 @@ func (s *Store) CreateRun(id, sessionID string) error {
 	return err
 }
 
-// UpdateRun sets the run's final fields.
-func (s *Store) UpdateRun(id string, durationMs float64, transcript, response, status string) error {
+// UpdateRun sets the run's final fields. A negative wer means the run had
+// no reference transcript to score against.
+func (s *Store) UpdateRun(id string, durationMs float64, transcript, response, status string, u Usage, wer float64) error {
 	_, err := s.db.Exec(
-		`UPDATE runs SET duration_ms = $1, transcript = $2, response = $3, status = $4 WHERE id = $5`,
-		durationMs, transcript, response, status, id,
+		`UPDATE runs SET duration_ms = $1, transcript = $2, response = $3, status = $4,
+		        prompt_tokens = $5, completion_tokens = $6, tts_chars = $7, cost_usd = $8, wer = $9
+		 WHERE id = $10`,
+		durationMs, transcript, response, status, u.PromptTokens, u.CompletionTokens, u.TTSChars, u.CostUSD,
+		sql.NullFloat64{Float64: wer, Valid: wer >= 0}, id,
 	)
 	return err
 }
@@ -119,29 +231,63 @@ func (s *Store) UpdateRun(id string, durationMs float64, transcript, response, s
 // CreateSpan inserts a span.
 func (s *Store) CreateSpan(sp Span) error {
 	_, err := s.db.Exec(
-		`INSERT INTO spans (id, run_id, name, started_at, duration_ms, input, output, status, error_msg)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
-		sp.ID, sp.RunID, sp.Name, sp.StartedAt.UTC(),
+		`INSERT INTO spans (id, run_id, parent_id, seq, name, started_at, duration_ms, input, output, status, error_msg)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		sp.ID, sp.RunID, sp.ParentID, sp.Seq, sp.Name, sp.StartedAt.UTC(),
 		sp.DurationMs, sp.Input, sp.Output, sp.Status, sp.Error,
 	)
 	return err
 }
 
+// AppendTurn stores one conversation turn for a session.
+func (s *Store) AppendTurn(sessionID string, seq int, user, assistant string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO turns (session_id, seq, user_text, assistant_text, created_at) VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (session_id, seq) DO UPDATE SET user_text = EXCLUDED.user_text, assistant_text = EXCLUDED.assistant_text`,
+		sessionID, seq, user, assistant, time.Now().UTC(),
+	)
+	return err
+}
+
+// ListTurns returns a session's conversation turns in order.
+func (s *Store) ListTurns(sessionID string) ([]Turn, error) {
+	rows, err := s.db.Query(
+		`SELECT seq, user_text, assistant_text, created_at FROM turns WHERE session_id = $1 ORDER BY seq ASC`,
+		sessionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var turns []Turn
+	for rows.Next() {
+		var t Turn
+		if err = rows.Scan(&t.Seq, &t.User, &t.Assistant, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		turns = append(turns, t)
+	}
+	return turns, rows.Err()
+}
+
 // ListSessions returns sessions ordered newest first, with run counts.
-func (s *Store) ListSessions(limit, offset int) ([]Session, int, error) {
+// A non-empty tenant limits the list to that tenant's sessions.
+func (s *Store) ListSessions(tenant string, limit, offset int) ([]Session, int, error) {
 	var total int
-	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sessions`).Scan(&total); err != nil {
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sessions WHERE $1 = '' OR tenant = $1`, tenant).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 
 	rows, err := s.db.Query(`
-		SELECT s.id, s.metadata, s.started_at, s.ended_at, COUNT(r.id) as run_count
+		SELECT s.id, s.tenant, s.experiment, s.variant, s.metadata, s.started_at, s.ended_at, COUNT(r.id) as run_count
 		FROM sessions s
 		LEFT JOIN runs r ON r.session_id = s.id
+		WHERE $3 = '' OR s.tenant = $3
 		GROUP BY s.id
 		ORDER BY s.started_at DESC
 		LIMIT $1 OFFSET $2
-	`, limit, offset)
+	`, limit, offset, tenant)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -151,7 +297,7 @@ func (s *Store) ListSessions(limit, offset int) ([]Session, int, error) {
 	for rows.Next() {
 		var sess Session
 		var endedAt sql.NullTime
-		if err = rows.Scan(&sess.ID, &sess.Metadata, &sess.StartedAt, &endedAt, &sess.RunCount); err != nil {
+		if err = rows.Scan(&sess.ID, &sess.Tenant, &sess.Experiment, &sess.Variant, &sess.Metadata, &sess.StartedAt, &endedAt, &sess.RunCount); err != nil {
 			return nil, 0, err
 		}
 		if endedAt.Valid {
@@ -162,22 +308,30 @@ func (s *Store) ListSessions(limit, offset int) ([]Session, int, error) {
 	return sessions, total, rows.Err()
 }
 
-// GetSession returns a single session with its runs.
+// GetSession returns a single session with its runs and their usage totals.
 func (s *Store) GetSession(id string) (*Session, []Run, error) {
 	var sess Session
 	var endedAt sql.NullTime
+	var summary, talkTime []byte
 	err := s.db.QueryRow(
-		`SELECT id, metadata, started_at, ended_at FROM sessions WHERE id = $1`, id,
-	).Scan(&sess.ID, &sess.Metadata, &sess.StartedAt, &endedAt)
+		`SELECT id, tenant, experiment, variant, metadata, started_at, ended_at, summary, talk_time FROM sessions WHERE id = $1`, id,
+	).Scan(&sess.ID, &sess.Tenant, &sess.Experiment, &sess.Variant, &sess.Metadata, &sess.StartedAt, &endedAt, &summary, &talkTime)
 	if err != nil {
 		return nil, nil, err
 	}
 	if endedAt.Valid {
 		sess.EndedAt = &endedAt.Time
 	}
+	if sess.Summary, err = decodeSummary(summary); err != nil {
+		return nil, nil, err
+	}
+	if sess.TalkTime, err = decodeTalkTime(talkTime); err != nil {
+		return nil, nil, err
+	}
 
 	rows, err := s.db.Query(`
 		SELECT r.id, r.session_id, r.started_at, r.duration_ms, r.transcript, r.response, r.status,
+		       r.prompt_tokens, r.completion_tokens, r.tts_chars, r.cost_usd, r.wer,
 		       COUNT(sp.id) as span_count
 		FROM runs r
 		LEFT JOIN spans sp ON sp.run_id = r.id
@@ -191,13 +345,17 @@ func (s *Store) GetSession(id string) (*Session, []Run, error) {
 	defer rows.Close()
 
 	var runs []Run
+	var total Usage
 	for rows.Next() {
 		var r Run
-		if err = rows.Scan(&r.ID, &r.SessionID, &r.StartedAt, &r.DurationMs, &r.Transcript, &r.Response, &r.Status, &r.SpanCount); err != nil {
+		if err = rows.Scan(&r.ID, &r.SessionID, &r.StartedAt, &r.DurationMs, &r.Transcript, &r.Response, &r.Status,
+			&r.PromptTokens, &r.CompletionTokens, &r.TTSChars, &r.CostUSD, &r.WER, &r.SpanCount); err != nil {
 			return nil, nil, err
 		}
+		total.Add(r.Usage)
 		runs = append(runs, r)
 	}
+	sess.Usage = &total
 	return &sess, runs, rows.Err()
 }
 
@@ -205,15 +363,19 @@ func (s *Store) GetSession(id string) (*Session, []Run, error) {
 func (s *Store) GetRun(sessionID, runID string) (*Run, []Span, error) {
 	var r Run
 	err := s.db.QueryRow(
-		`SELECT id, session_id, started_at, duration_ms, transcript, response, status FROM runs WHERE id = $1 AND session_id = $2`,
+		`SELECT id, session_id, started_at, duration_ms, transcript, response, status,
+		        prompt_tokens, completion_tokens, tts_chars, cost_usd, wer
+		 FROM runs WHERE id = $1 AND session_id = $2`,
 		runID, sessionID,
-	).Scan(&r.ID, &r.SessionID, &r.StartedAt, &r.DurationMs, &r.Transcript, &r.Response, &r.Status)
+	).Scan(&r.ID, &r.SessionID, &r.StartedAt, &r.DurationMs, &r.Transcript, &r.Response, &r.Status,
+		&r.PromptTokens, &r.CompletionTokens, &r.TTSChars, &r.CostUSD, &r.WER)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	rows, err := s.db.Query(
-		`SELECT id, run_id, name, started_at, duration_ms, input, output, status, error_msg FROM spans WHERE run_id = $1 ORDER BY started_at ASC`,
+		`SELECT id, run_id, parent_id, seq, name, started_at, duration_ms, input, output, status, error_msg
+		 FROM spans WHERE run_id = $1 ORDER BY seq ASC, started_at ASC`,
 		runID,
 	)
 	if err != nil {
@@ -224,7 +386,7 @@ func (s *Store) GetRun(sessionID, runID string) (*Run, []Span, error) {
 	var spans []Span
 	for rows.Next() {
 		var sp Span
-		if err = rows.Scan(&sp.ID, &sp.RunID, &sp.Name, &sp.StartedAt, &sp.DurationMs, &sp.Input, &sp.Output, &sp.Status, &sp.Error); err != nil {
+		if err = rows.Scan(&sp.ID, &sp.RunID, &sp.ParentID, &sp.Seq, &sp.Name, &sp.StartedAt, &sp.DurationMs, &sp.Input, &sp.Output, &sp.Status, &sp.Error); err != nil {
 			return nil, nil, err
 		}
 		spans = append(spans, sp)
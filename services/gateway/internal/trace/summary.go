@@ -0,0 +1,60 @@
+package trace
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// CallSummary is the end-of-call summary of a session's conversation.
+type CallSummary struct {
+	Topics      []string  `json:"topics"`
+	Resolution  string    `json:"resolution"`
+	ActionItems []string  `json:"action_items"`
+	Sentiment   string    `json:"sentiment"` // the caller's: positive, neutral, or negative
+	Model       string    `json:"model,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SetSummary stores a session's summary, replacing any earlier one (a
+// resumed session is summarized again when it ends).
+func (s *Store) SetSummary(id string, sum *CallSummary) error {
+	redacted := *sum
+	redacted.Resolution = s.redactor.Redact(sum.Resolution)
+	redacted.Topics = s.redactAll(sum.Topics)
+	redacted.ActionItems = s.redactAll(sum.ActionItems)
+	data, err := json.Marshal(redacted)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`UPDATE sessions SET summary = $1 WHERE id = $2`, data, id)
+	return err
+}
+
+func (s *Store) redactAll(texts []string) []string {
+	out := make([]string, len(texts))
+	for i, t := range texts {
+		out[i] = s.redactor.Redact(t)
+	}
+	return out
+}
+
+// GetSummary returns a session's summary: nil if it has none yet, and
+// sql.ErrNoRows if the session doesn't exist.
+func (s *Store) GetSummary(id string) (*CallSummary, error) {
+	var data []byte
+	if err := s.db.QueryRow(`SELECT summary FROM sessions WHERE id = $1`, id).Scan(&data); err != nil {
+		return nil, err
+	}
+	return decodeSummary(data)
+}
+
+func decodeSummary(data []byte) (*CallSummary, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var sum CallSummary
+	if err := json.Unmarshal(data, &sum); err != nil {
+		return nil, err
+	}
+	return &sum, nil
+}
@@ -0,0 +1,42 @@
+package trace
+
+import "encoding/json"
+
+// TalkTime is how a call's time was spent: caller speech, agent audio,
+// both at once, and neither. Ratios are of the call's duration.
+type TalkTime struct {
+	DurationSec   float64 `json:"duration_sec"`
+	CallerSec     float64 `json:"caller_sec"`    // caller speech, as the VAD segmented it
+	AgentSec      float64 `json:"agent_sec"`     // agent audio sent, pauses and fillers excluded
+	OverTalkSec   float64 `json:"over_talk_sec"` // caller speech while agent audio was playing
+	SilenceSec    float64 `json:"silence_sec"`
+	CallerRatio   float64 `json:"caller_ratio"`
+	AgentRatio    float64 `json:"agent_ratio"`
+	OverTalkRatio float64 `json:"over_talk_ratio"`
+	SilenceRatio  float64 `json:"silence_ratio"`
+	Interruptions int     `json:"interruptions"` // caller utterances that began over the agent
+	CallerWords   int     `json:"caller_words"`
+	CallerWPM     float64 `json:"caller_wpm"` // words per minute of caller speech
+}
+
+// SetTalkTime stores a session's talk-time stats, replacing earlier ones
+// (a resumed session records its latest connection).
+func (s *Store) SetTalkTime(id string, tt *TalkTime) error {
+	data, err := json.Marshal(tt)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`UPDATE sessions SET talk_time = $1 WHERE id = $2`, data, id)
+	return err
+}
+
+func decodeTalkTime(data []byte) (*TalkTime, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var tt TalkTime
+	if err := json.Unmarshal(data, &tt); err != nil {
+		return nil, err
+	}
+	return &tt, nil
+}
@@ -2,6 +2,8 @@ package trace
 
 import (
 	"log/slog"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,7 +20,7 @@ const (
 )
 
 type traceMsg struct {
-	kind string // "run_create", "run_update", "span"
+	kind string // "run_create", "run_update", "span", "turn", "audio"
 	// run fields
 	runID      string
 	sessionID  string
@@ -26,8 +28,14 @@ type traceMsg struct {
 	transcript string
 	response   string
 	status     string
+	usage      Usage
+	wer        float64
 	// span fields
 	span Span
+	// turn fields
+	turn Turn
+	// audio fields
+	samples []float32
 }
 
 // Tracer writes trace data asynchronously via a buffered channel.
@@ -37,6 +45,16 @@ type Tracer struct {
 	sessionID string
 	ch        chan traceMsg
 	done      chan struct{}
+	seq       atomic.Int64 // last span sequence number handed out
+}
+
+// SpanRef names a span while it is still running, so stages nested in it
+// can record it as their parent. The zero value records nothing.
+type SpanRef struct {
+	ID       string
+	RunID    string
+	ParentID string
+	Seq      int64
 }
 
 // NewTracer creates a tracer bound to a session.
@@ -73,11 +91,17 @@ func (t *Tracer) dispatch(m traceMsg) error {
 		return t.store.CreateRun(m.runID, m.sessionID)
 	}
 	if m.kind == "run_update" {
-		return t.store.UpdateRun(m.runID, m.durationMs, m.transcript, m.response, m.status)
+		return t.store.UpdateRun(m.runID, m.durationMs, m.transcript, m.response, m.status, m.usage, m.wer)
 	}
 	if m.kind == "span" {
 		return t.store.CreateSpan(m.span)
 	}
+	if m.kind == "turn" {
+		return t.store.AppendTurn(t.sessionID, m.turn.Seq, m.turn.User, m.turn.Assistant)
+	}
+	if m.kind == "audio" {
+		return t.store.saveAudio(t.sessionID, m.runID, m.samples)
+	}
 	return nil
 }
 
@@ -91,8 +115,9 @@ func (t *Tracer) StartRun() string {
 	return id
 }
 
-// EndRun finalizes a run.
-func (t *Tracer) EndRun(runID string, durationMs float64, transcript, response, status string) {
+// EndRun finalizes a run with its token and cost accounting and its word
+// error rate (negative = not scored).
+func (t *Tracer) EndRun(runID string, durationMs float64, transcript, response, status string, usage Usage, wer float64) {
 	if t == nil {
 		return
 	}
@@ -100,33 +125,70 @@ func (t *Tracer) EndRun(runID string, durationMs float64, transcript, response,
 		kind:       "run_update",
 		runID:      runID,
 		durationMs: durationMs,
-		transcript: truncate(transcript, maxTraceFieldLen),
-		response:   truncate(response, maxTraceFieldLen),
+		transcript: truncate(t.redact(transcript), maxTraceFieldLen),
+		response:   truncate(t.redact(response), maxTraceFieldLen),
 		status:     status,
+		usage:      usage,
+		wer:        wer,
 	}
 }
 
-// RecordSpan records a completed span.
-func (t *Tracer) RecordSpan(runID, name string, startedAt time.Time, durationMs float64, input, output, status, errMsg string) {
-	if t == nil {
+// StartSpan reserves an ID and a sequence number for a span starting now
+// under parentID ("" = top level of the run).
+func (t *Tracer) StartSpan(runID, parentID string) SpanRef {
+	if t == nil || runID == "" {
+		return SpanRef{}
+	}
+	return SpanRef{ID: uuid.NewString(), RunID: runID, ParentID: parentID, Seq: t.seq.Add(1)}
+}
+
+// RecordSpan records a completed span reserved with StartSpan.
+func (t *Tracer) RecordSpan(ref SpanRef, name string, startedAt time.Time, durationMs float64, input, output, status, errMsg string) {
+	if t == nil || ref.ID == "" {
 		return
 	}
 	t.ch <- traceMsg{
 		kind: "span",
 		span: Span{
-			ID:         uuid.NewString(),
-			RunID:      runID,
+			ID:         ref.ID,
+			RunID:      ref.RunID,
+			ParentID:   ref.ParentID,
+			Seq:        ref.Seq,
 			Name:       name,
 			StartedAt:  startedAt,
 			DurationMs: durationMs,
-			Input:      truncate(input, maxTraceFieldLen),
-			Output:     truncate(output, maxTraceFieldLen),
+			Input:      truncate(t.redact(input), maxTraceFieldLen),
+			Output:     truncate(t.redact(output), maxTraceFieldLen),
 			Status:     status,
-			Error:      errMsg,
+			Error:      t.redact(errMsg),
 		},
 	}
 }
 
+// RecordAudio archives a run's post-VAD speech (16 kHz mono) when the
+// store has an audio directory.
+func (t *Tracer) RecordAudio(runID string, samples []float32) {
+	if t == nil || t.store.audio == nil {
+		return
+	}
+	t.ch <- traceMsg{kind: "audio", runID: runID, samples: slices.Clone(samples)}
+}
+
+// RecordTurn persists a completed conversation turn. Unlike span fields,
+// turn text is not truncated because it is replayed into the LLM on resume.
+func (t *Tracer) RecordTurn(seq int, user, assistant string) {
+	if t == nil {
+		return
+	}
+	t.ch <- traceMsg{kind: "turn", turn: Turn{Seq: seq, User: t.redact(user), Assistant: t.redact(assistant)}}
+}
+
+// redact masks PII before truncation, so a cut can't leave a partial
+// number unrecognized.
+func (t *Tracer) redact(s string) string {
+	return t.store.redactor.Redact(s)
+}
+
 // Close drains pending writes and shuts down the background goroutine.
 func (t *Tracer) Close() {
 	if t == nil {
@@ -0,0 +1,142 @@
+package trace
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TranscriptEntry is one utterance in a reconstructed conversation. Times
+// come from the run's spans: the ASR span for user speech, and the LLM span
+// through the last TTS span for the assistant's reply.
+type TranscriptEntry struct {
+	Speaker string    `json:"speaker"` // "user" or "assistant"
+	Text    string    `json:"text"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+}
+
+// GetTranscript rebuilds a session's conversation from its runs and spans.
+// Text is as stored on the run, so long utterances are truncated.
+func (s *Store) GetTranscript(sessionID string) (*Session, []TranscriptEntry, error) {
+	sess, _, err := s.GetSession(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT r.started_at, r.duration_ms, r.transcript, r.response,
+		       MIN(sp.started_at) FILTER (WHERE sp.name = 'asr'),
+		       MAX(sp.started_at + sp.duration_ms * interval '1 millisecond') FILTER (WHERE sp.name = 'asr'),
+		       MIN(sp.started_at) FILTER (WHERE sp.name = 'llm'),
+		       MAX(sp.started_at + sp.duration_ms * interval '1 millisecond') FILTER (WHERE sp.name IN ('llm', 'tts'))
+		FROM runs r
+		LEFT JOIN spans sp ON sp.run_id = r.id
+		WHERE r.session_id = $1
+		GROUP BY r.id
+		ORDER BY r.started_at ASC
+	`, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var entries []TranscriptEntry
+	for rows.Next() {
+		var (
+			runStart             time.Time
+			durationMs           float64
+			transcript, response string
+			asrStart, asrEnd     sql.NullTime
+			replyStart, replyEnd sql.NullTime
+		)
+		if err = rows.Scan(&runStart, &durationMs, &transcript, &response, &asrStart, &asrEnd, &replyStart, &replyEnd); err != nil {
+			return nil, nil, err
+		}
+		runEnd := runStart.Add(time.Duration(durationMs * float64(time.Millisecond)))
+		if transcript != "" {
+			entries = append(entries, TranscriptEntry{
+				Speaker: "user",
+				Text:    transcript,
+				Start:   timeOr(asrStart, runStart),
+				End:     timeOr(asrEnd, runStart),
+			})
+		}
+		if response != "" {
+			entries = append(entries, TranscriptEntry{
+				Speaker: "assistant",
+				Text:    response,
+				Start:   timeOr(replyStart, runStart),
+				End:     timeOr(replyEnd, runEnd),
+			})
+		}
+	}
+	return sess, entries, rows.Err()
+}
+
+func timeOr(t sql.NullTime, fallback time.Time) time.Time {
+	if t.Valid {
+		return t.Time
+	}
+	return fallback
+}
+
+// TranscriptFormats maps a ?format= value to its content type and writer.
+var TranscriptFormats = map[string]struct {
+	ContentType string
+	Write       func(w io.Writer, sess *Session, entries []TranscriptEntry) error
+}{
+	"json": {"application/json", writeTranscriptJSON},
+	"txt":  {"text/plain; charset=utf-8", writeTranscriptText},
+	"srt":  {"application/x-subrip", writeTranscriptSRT},
+}
+
+func writeTranscriptJSON(w io.Writer, sess *Session, entries []TranscriptEntry) error {
+	return json.NewEncoder(w).Encode(map[string]any{"session": sess, "entries": entries})
+}
+
+// writeTranscriptText writes one "[hh:mm:ss] Speaker: text" line per
+// utterance, timed from the start of the session.
+func writeTranscriptText(w io.Writer, sess *Session, entries []TranscriptEntry) error {
+	if _, err := fmt.Fprintf(w, "Session %s, started %s\n\n", sess.ID, sess.StartedAt.UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		offset := clockOffset(e.Start.Sub(sess.StartedAt))
+		if _, err := fmt.Fprintf(w, "[%s] %s: %s\n", offset[:8], speakerLabel(e.Speaker), e.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTranscriptSRT writes the conversation as SubRip subtitles, so it can
+// be played back alongside a call recording.
+func writeTranscriptSRT(w io.Writer, sess *Session, entries []TranscriptEntry) error {
+	for i, e := range entries {
+		start := e.Start.Sub(sess.StartedAt)
+		end := max(e.End.Sub(sess.StartedAt), start+time.Second)
+		_, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s: %s\n\n",
+			i+1, clockOffset(start), clockOffset(end), speakerLabel(e.Speaker), e.Text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clockOffset formats d as SRT's "hh:mm:ss,mmm".
+func clockOffset(d time.Duration) string {
+	d = max(d, 0)
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3_600_000, ms/60_000%60, ms/1000%60, ms%1000)
+}
+
+func speakerLabel(speaker string) string {
+	if speaker == "user" {
+		return "User"
+	}
+	return "Assistant"
+}
@@ -0,0 +1,367 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/auth"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/logctx"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+)
+
+// The /v1/chat/completions endpoint answers OpenAI Chat Completions requests
+// with the pipeline's text path, so text-only integrations get the same LLM
+// routing and fallbacks, prompt library, tenant limits, budgets, semantic
+// cache, and tracing as calls, without a WebSocket.
+//
+// model picks the LLM as "engine/model", an engine name, or a model on the
+// default engine ("" = the gateway's default). System messages become the
+// system prompt unless the gateway extension fields prompt/prompt_version
+// select one from the library. The other messages are the conversation so
+// far, and the last must be the user's. stream: true sends chat.completion.chunk
+// events over SSE, ending with [DONE].
+//
+// Every request is a session of its own: nothing is kept between requests
+// beyond what the client sends back in messages.
+
+// completionMaxBody caps a request body; the whole conversation is resent
+// with every request.
+const completionMaxBody = 1 << 20
+
+// CompletionsHandler serves the OpenAI-compatible chat completions
+// endpoint, sharing backends and limits with the call Handler.
+type CompletionsHandler struct {
+	h *Handler
+}
+
+// Completions returns the handler for /v1/chat/completions.
+func (h *Handler) Completions() *CompletionsHandler {
+	return &CompletionsHandler{h: h}
+}
+
+type completionMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type completionRequest struct {
+	Model    string              `json:"model"`
+	Messages []completionMessage `json:"messages"`
+	Stream   bool                `json:"stream"`
+	Tenant   string              `json:"tenant"`
+	// gateway extensions: a library prompt in place of system messages
+	Prompt        string `json:"prompt"`
+	PromptVersion int    `json:"prompt_version"`
+}
+
+type completionChoice struct {
+	Index        int              `json:"index"`
+	Message      *completionReply `json:"message,omitempty"`
+	Delta        *completionReply `json:"delta,omitempty"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+type completionReply struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type completionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []completionChoice `json:"choices"`
+}
+
+// completionError is an error response in OpenAI's shape.
+type completionError struct {
+	status  int
+	kind    string
+	message string
+}
+
+func (e *completionError) Error() string { return e.message }
+
+func invalidCompletion(format string, args ...any) *completionError {
+	return &completionError{http.StatusBadRequest, "invalid_request_error", fmt.Sprintf(format, args...)}
+}
+
+// ServeHTTP answers one chat completions request.
+func (ch *CompletionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeCompletionError(w, &completionError{http.StatusMethodNotAllowed, "invalid_request_error", "use POST"})
+		return
+	}
+	var req completionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, completionMaxBody)).Decode(&req); err != nil {
+		writeCompletionError(w, invalidCompletion("malformed request: %v", err))
+		return
+	}
+	history, message, system, err := splitConversation(req.Messages)
+	if err != nil {
+		writeCompletionError(w, err)
+		return
+	}
+	tenant, err := ch.h.tenants.resolve(auth.TenantOf(r), req.Tenant)
+	if err != nil {
+		writeCompletionError(w, &completionError{http.StatusForbidden, "permission_error", err.Error()})
+		return
+	}
+
+	h := ch.h
+	sessionID := uuid.NewString()
+	meta := &callMetadata{
+		SystemPrompt:  system,
+		Mode:          "text",
+		Tenant:        tenant,
+		Prompt:        req.Prompt,
+		PromptVersion: req.PromptVersion,
+	}
+	meta.LLMEngine, meta.LLMModel = h.resolveModel(req.Model)
+	h.applyPrompt(meta)
+	h.applyDefaults(meta)
+	h.applyExperiment(meta, sessionID)
+	params := resolveParams(meta, h.vadConfig())
+	params.asrEngine = "" // no audio, so the tenant's ASR engines don't apply
+	release, err := h.tenants.admit(tenant, params)
+	if err != nil {
+		writeCompletionError(w, &completionError{http.StatusTooManyRequests, "rate_limit_error", err.Error()})
+		return
+	}
+	defer release()
+
+	ctx := logctx.With(r.Context(), "session_id", sessionID)
+	tracer := h.startTracer(sessionID, meta, false)
+	if tracer != nil {
+		defer func() {
+			tracer.Close()
+			_ = h.cfg.TraceStore.EndSession(sessionID)
+		}()
+	}
+	pipe := pipeline.New(h.pipelineConfig(meta, params, sessionID, tracer, history))
+	defer pipe.Close()
+
+	model := h.cfg.LLMClient.ModelFor(params.llmEngine, meta.LLMModel)
+	out := newCompletionWriter(w, req.Stream, model)
+	slog.InfoContext(ctx, "chat completion", "tenant", tenant, "llm_engine", params.llmEngine, "llm_model", model, "history_turns", len(history), "stream", req.Stream)
+	if err := pipe.ProcessTextMessage(ctx, message, out.onEvent); err != nil {
+		slog.ErrorContext(ctx, "chat completion", "error", err)
+		out.fail(&completionError{http.StatusBadGateway, "api_error", err.Error()})
+	}
+	out.finish()
+}
+
+// resolveModel splits a request's model into the LLM engine and model to
+// run it on.
+func (h *Handler) resolveModel(model string) (engine, name string) {
+	if model == "" {
+		return "", ""
+	}
+	if engine, name, ok := strings.Cut(model, "/"); ok && h.cfg.LLMClient.Has(engine) {
+		return engine, name
+	}
+	if h.cfg.LLMClient.Has(model) {
+		return model, ""
+	}
+	return "", model
+}
+
+// splitConversation turns a request's messages into the system prompt, the
+// turns so far, and the user message to answer. Consecutive messages of one
+// role are joined.
+func splitConversation(msgs []completionMessage) (history []pipeline.Turn, message, system string, err error) {
+	var systemParts []string
+	var turn pipeline.Turn
+	for i, m := range msgs {
+		text, err := messageText(m.Content)
+		if err != nil {
+			return nil, "", "", invalidCompletion("messages[%d]: %v", i, err)
+		}
+		if m.Role == "system" || m.Role == "developer" {
+			systemParts = append(systemParts, text)
+			continue
+		}
+		if m.Role != "user" && m.Role != "assistant" {
+			return nil, "", "", invalidCompletion("messages[%d]: unsupported role %q", i, m.Role)
+		}
+		if m.Role == "user" && turn.Assistant != "" {
+			history = append(history, turn)
+			turn = pipeline.Turn{}
+		}
+		if m.Role == "user" {
+			turn.User = joinText(turn.User, text)
+		} else {
+			turn.Assistant = joinText(turn.Assistant, text)
+		}
+	}
+	if turn.Assistant != "" || strings.TrimSpace(turn.User) == "" {
+		return nil, "", "", invalidCompletion("messages must end with a user message")
+	}
+	return history, turn.User, strings.Join(systemParts, "\n\n"), nil
+}
+
+func joinText(prev, text string) string {
+	if prev == "" {
+		return text
+	}
+	return prev + "\n" + text
+}
+
+// messageText reads a message's content: a string, or an array of parts of
+// which only text parts are supported.
+func messageText(raw json.RawMessage) (string, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", errors.New("content must be a string or an array of parts")
+	}
+	var b strings.Builder
+	for _, p := range parts {
+		if p.Type != "text" {
+			return "", fmt.Errorf("unsupported content part %q", p.Type)
+		}
+		b.WriteString(p.Text)
+	}
+	return b.String(), nil
+}
+
+// completionWriter turns the turn's pipeline events into a chat completion
+// response, streamed as chunks or written whole at the end. Events that
+// arrive after finish (a handoff summary) are dropped.
+type completionWriter struct {
+	w      http.ResponseWriter
+	stream bool
+	resp   completionResponse
+
+	mu      sync.Mutex
+	started bool // stream headers and the role chunk are sent
+	done    bool
+	text    strings.Builder
+	err     *completionError
+}
+
+func newCompletionWriter(w http.ResponseWriter, stream bool, model string) *completionWriter {
+	return &completionWriter{
+		w:      w,
+		stream: stream,
+		resp: completionResponse{
+			ID:      "chatcmpl-" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+			Created: time.Now().Unix(),
+			Model:   model,
+		},
+	}
+}
+
+func (cw *completionWriter) onEvent(ev pipeline.Event) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.done {
+		return
+	}
+	if ev.Type == "llm_token" {
+		cw.text.WriteString(ev.Token)
+		cw.sendDelta(completionReply{Content: ev.Token}, nil)
+	}
+	if ev.Type == "llm_done" && cw.text.Len() == 0 {
+		cw.text.WriteString(ev.Text) // a reply that didn't stream, e.g. the hold message
+		cw.sendDelta(completionReply{Content: ev.Text}, nil)
+	}
+	if ev.Type == "budget_exceeded" {
+		cw.err = &completionError{http.StatusGatewayTimeout, "timeout_error", fmt.Sprintf("%s ran out of its %s of %d ms", ev.Stage, ev.Reason, ev.BudgetMs)}
+	}
+	if ev.Type == "turn_cancelled" {
+		cw.err = &completionError{499, "api_error", "request cancelled"}
+	}
+}
+
+func (cw *completionWriter) fail(err *completionError) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.err = err
+}
+
+// sendDelta streams one chunk, with the headers and role chunk first.
+// Caller holds mu.
+func (cw *completionWriter) sendDelta(delta completionReply, finish *string) {
+	if !cw.stream {
+		return
+	}
+	if !cw.started {
+		cw.started = true
+		cw.w.Header().Set("Content-Type", "text/event-stream")
+		cw.w.Header().Set("Cache-Control", "no-cache")
+		cw.w.WriteHeader(http.StatusOK)
+		cw.sendDelta(completionReply{Role: "assistant"}, nil)
+	}
+	chunk := cw.resp
+	chunk.Object = "chat.completion.chunk"
+	chunk.Choices = []completionChoice{{Delta: &delta, FinishReason: finish}}
+	cw.sendSSE(chunk)
+}
+
+func (cw *completionWriter) sendSSE(v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(cw.w, "data: %s\n\n", data)
+	if f, ok := cw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the response, or the rest of the stream. An error after
+// the stream started is sent as an error event before [DONE].
+func (cw *completionWriter) finish() {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.done = true
+	if cw.err != nil && !cw.started {
+		writeCompletionError(cw.w, cw.err)
+		return
+	}
+	stop := "stop"
+	if cw.stream {
+		if cw.err != nil {
+			cw.sendSSE(completionErrorBody(cw.err))
+		} else {
+			cw.sendDelta(completionReply{}, &stop)
+		}
+		fmt.Fprint(cw.w, "data: [DONE]\n\n")
+		return
+	}
+	resp := cw.resp
+	resp.Object = "chat.completion"
+	resp.Choices = []completionChoice{{
+		Message:      &completionReply{Role: "assistant", Content: cw.text.String()},
+		FinishReason: &stop,
+	}}
+	cw.w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(cw.w).Encode(resp)
+}
+
+func completionErrorBody(e *completionError) map[string]any {
+	return map[string]any{"error": map[string]any{"message": e.message, "type": e.kind}}
+}
+
+func writeCompletionError(w http.ResponseWriter, err error) {
+	var ce *completionError
+	if !errors.As(err, &ce) {
+		ce = &completionError{http.StatusInternalServerError, "api_error", err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ce.status)
+	_ = json.NewEncoder(w).Encode(completionErrorBody(ce))
+}
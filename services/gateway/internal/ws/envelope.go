@@ -0,0 +1,21 @@
+package ws
+
+import "encoding/binary"
+
+// envelopeHeaderMax is the largest event header a frame can carry.
+const envelopeHeaderMax = 0xFFFF
+
+// envelopeFrame packs an event and its audio into one binary frame: a
+// big-endian uint16 header length, the event's JSON (type, turn, sentence,
+// audio_format, ...), then the audio bytes. The client never has to pair a
+// frame with the text frame next to it. Returns false for a header too long
+// to encode, which the caller sends the old way.
+func envelopeFrame(header, data []byte) ([]byte, bool) {
+	if len(header) > envelopeHeaderMax {
+		return nil, false
+	}
+	frame := make([]byte, 2, 2+len(header)+len(data))
+	binary.BigEndian.PutUint16(frame, uint16(len(header)))
+	frame = append(frame, header...)
+	return append(frame, data...), true
+}
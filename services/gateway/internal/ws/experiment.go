@@ -0,0 +1,44 @@
+package ws
+
+import "log/slog"
+
+// applyExperiment assigns the call to an arm of its experiment, or of the
+// gateway's default one, and applies that arm's engines. key keeps the
+// assignment stable across reconnects ("" = random). Unknown experiments are
+// logged and ignored rather than failing the call, like unknown prompts.
+func (h *Handler) applyExperiment(meta *callMetadata, key string) {
+	t := h.Tunables()
+	id := meta.Experiment
+	if id == "" {
+		id = t.DefaultExperiment
+	}
+	meta.Experiment, meta.Variant = "", ""
+	if id == "" {
+		return
+	}
+	e, ok := t.Experiments[id]
+	if !ok {
+		slog.Warn("unknown experiment", "experiment", id)
+		return
+	}
+	v, ok := e.Assign(id, key)
+	if !ok {
+		return
+	}
+	meta.Experiment, meta.Variant = id, v.Name
+	overrides := []struct {
+		dst *string
+		val string
+	}{
+		{&meta.ASREngine, v.ASREngine},
+		{&meta.ASRModel, v.ASRModel},
+		{&meta.LLMEngine, v.LLMEngine},
+		{&meta.LLMModel, v.LLMModel},
+		{&meta.TTSEngine, v.TTSEngine},
+	}
+	for _, o := range overrides {
+		if o.val != "" {
+			*o.dst = o.val
+		}
+	}
+}
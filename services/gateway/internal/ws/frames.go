@@ -0,0 +1,68 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+)
+
+// maxAudioAhead is how far streamed audio may run ahead of real time, for
+// bursts a network or jitter buffer releases at once. The VAD times speech
+// and calibrates its noise floor on the wall clock, so audio arriving much
+// faster than it plays would throw both off.
+const maxAudioAhead = 2 * time.Second
+
+// frameValidator screens talk-mode audio frames before the VAD sees them.
+// A malformed frame is dropped with an error event carrying its code; a
+// run of them reports once, until a good frame arrives.
+type frameValidator struct {
+	codec      audio.Codec
+	sampleRate int
+
+	first    time.Time     // arrival of the first good frame
+	received time.Duration // end of the good audio so far, on the clock since first
+	reported map[string]bool
+}
+
+func newFrameValidator(codec audio.Codec, sampleRate int) *frameValidator {
+	return &frameValidator{codec: codec, sampleRate: sampleRate, reported: map[string]bool{}}
+}
+
+// admit reports whether data may go to the pipeline. A nil validator
+// admits everything.
+func (v *frameValidator) admit(data []byte, now time.Time, sendEvent pipeline.EventCallback) bool {
+	if v == nil {
+		return true
+	}
+	err := v.check(data, now)
+	if err == nil {
+		clear(v.reported)
+		return true
+	}
+	metrics.InvalidAudioFrames.WithLabelValues(err.Code).Inc()
+	if !v.reported[err.Code] {
+		v.reported[err.Code] = true
+		sendEvent(pipeline.Event{Type: "error", Code: err.Code, Text: err.Msg})
+	}
+	return false
+}
+
+func (v *frameValidator) check(data []byte, now time.Time) *audio.FrameError {
+	if err := audio.CheckFrame(data, v.codec, v.sampleRate); err != nil {
+		return err.(*audio.FrameError)
+	}
+	d := audio.FrameDuration(data, v.codec, v.sampleRate)
+	if v.first.IsZero() {
+		v.first = now
+	}
+	// a client that paused sending (a muted mic) resumes from now, with no
+	// credit for the gap
+	elapsed := now.Sub(v.first)
+	if v.received+d-elapsed > maxAudioAhead {
+		return &audio.FrameError{Code: "frame_cadence", Msg: "audio is arriving faster than real time; stream it as it is captured"}
+	}
+	v.received = max(v.received, elapsed) + d
+	return nil
+}
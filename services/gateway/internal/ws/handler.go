@@ -3,6 +3,8 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"sync"
@@ -12,8 +14,17 @@ import (
 	"github.com/gorilla/websocket"
 
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/auth"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/denoise"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/flow"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/logctx"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/models"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/orchestrator"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/prompts"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/ratelimit"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/redact"
 	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/trace"
 )
 
@@ -29,6 +40,9 @@ const (
 
 	// defaultTTSSpeed is the playback speed multiplier when the client omits it.
 	defaultTTSSpeed = 1.0
+
+	// backgroundStartTimeout bounds a non-blocking engine auto-start.
+	backgroundStartTimeout = 2 * time.Minute
 )
 
 var upgrader = websocket.Upgrader{
@@ -44,24 +58,91 @@ type HandlerConfig struct {
 	TTSClient     *pipeline.TTSRouter
 	VADConfig     audio.VADConfig
 	Denoiser       *denoise.Denoiser
+	// DenoisePerChunk denoises every chunk before the VAD rather than each
+	// speech segment after it.
+	DenoisePerChunk bool
+	// Resampler selects the resampler for caller audio ("" = linear).
+	Resampler      audio.ResampleMode
 	ClassifyClient *pipeline.ClassifyClient
 	TraceStore     *trace.Store
+	// MsgLimiter caps WebSocket frames per second per client (nil = unlimited).
+	MsgLimiter *ratelimit.Limiter
+	// MaxSessionAudioBytes ends a session once it has sent this much audio (0 = unlimited).
+	MaxSessionAudioBytes int64
+	// TTSVoices maps detected language codes to TTS voices.
+	TTSVoices map[string]string
+	// Pricing estimates per-run LLM and TTS cost (nil = no cost accounting).
+	Pricing *pipeline.Pricing
+	// Services auto-starts orchestrator-managed engines a call asks for (nil = never).
+	Services *orchestrator.HTTPControlManager
+	// ServiceStartWait is how long a new call waits for an auto-started engine
+	// to become healthy (0 = start it in the background and don't wait).
+	ServiceStartWait time.Duration
+	// OnServiceStarted receives the GPU JSON after an auto-start.
+	OnServiceStarted func(gpu json.RawMessage)
+	// WriteTimeout bounds each frame write to a client (0 = defaultWriteTimeout).
+	WriteTimeout time.Duration
+	// PingInterval is how often clients are pinged (0 = defaultPingInterval),
+	// and PongTimeout how long one may leave pings unanswered before it is
+	// disconnected (0 = defaultPongTimeout).
+	PingInterval, PongTimeout time.Duration
+	// SendQueueSize is the outbound frame buffer per client (0 = defaultSendQueue).
+	SendQueueSize int
+	// SlowClientPolicy is SlowClientDrop or SlowClientClose for a full send queue.
+	SlowClientPolicy string
+	// OllamaURL receives per-session keep_alive requests ("" = ignore them).
+	OllamaURL string
+	// Moderator screens every session's LLM sentences before they are sent
+	// or spoken (nil = off).
+	Moderator pipeline.Moderator
+	// Redactor masks PII in logged transcripts (nil = off). Stored traces are
+	// redacted by the trace store's own redactor.
+	Redactor *redact.Redactor
+	// Filler plays thinking audio when a response's first sentence is slow (nil = off).
+	Filler *pipeline.FillerCache
+	// SemanticCache answers repeated questions from earlier answers (nil = off).
+	SemanticCache *pipeline.SemanticCache
+	// Intents classifies caller turns and routes calls on intent (nil = off).
+	Intents *pipeline.IntentRouter
+	// Handoff lets the agent escalate calls to a human and hold them (nil = off).
+	Handoff *pipeline.Handoff
+	// CallSummary summarizes each traced call when it ends, onto its trace
+	// session.
+	CallSummary bool
+	// Flows are the call scripts a session can select with "flow", by name.
+	Flows map[string]*flow.Flow
+	// Prompts is the library a session can pick its system prompt from
+	// with "prompt" (nil = no library).
+	Prompts *prompts.Store
+	// Tunables are the settings calls start with; SetTunables changes them
+	// at runtime. Tenants are configured here, by name: calls are assigned
+	// one from their API key or their "tenant" metadata.
+	Tunables Tunables
 }
 
 // Handler manages WebSocket call sessions.
 type Handler struct {
-	cfg HandlerConfig
+	cfg  HandlerConfig
+	live    *liveRegistry
+	tenants *tenantRegistry
+
+	tunMu sync.RWMutex
+	tun   Tunables
 }
 
 // NewHandler creates a WebSocket handler with shared backend clients.
 func NewHandler(cfg HandlerConfig) *Handler {
-	return &Handler{cfg: cfg}
+	return &Handler{cfg: cfg, live: newLiveRegistry(), tenants: newTenantRegistry(cfg.Tunables.Tenants), tun: cfg.Tunables}
 }
 
 // callMetadata is the first text frame sent by the client.
 type callMetadata struct {
 	Codec               string  `json:"codec"`
 	SampleRate          int     `json:"sample_rate"`
+	// Channels is 2 for stereo PCM in snippet mode, caller on the left and
+	// agent on the right: each channel is transcribed on its own and
+	// transcripts are tagged with it. Anything else is mono.
+	Channels            int     `json:"channels"`
 	TTSEngine           string  `json:"tts_engine"`
 	ASREngine           string  `json:"asr_engine"`
 	SystemPrompt        string  `json:"system_prompt"`
@@ -70,21 +151,84 @@ type callMetadata struct {
 	Mode                string  `json:"mode"`
 	NoiseSuppression     bool    `json:"noise_suppression"`
 	ASRPrompt            string  `json:"asr_prompt"`
+	// ASRModel selects the ASR engine's model file for this session; a
+	// managed engine running another model is restarted on it.
+	ASRModel             string  `json:"asr_model"`
 	ConfidenceThreshold  float64 `json:"confidence_threshold"`
 	ReferenceTranscript  string  `json:"reference_transcript"`
 	TTSSpeed             float64 `json:"tts_speed"`
 	TTSPitch             float64 `json:"tts_pitch"`
+	// TTSOutputFormat transcodes agent audio to "wav", "pcm16", "mp3", or
+	// "opus" ("" = as the TTS engine produced it). Each tts_ready event then
+	// precedes its audio frame and names its format.
+	TTSOutputFormat      string  `json:"tts_output_format"`
 	TextNormalization    *bool   `json:"text_normalization"`
 	InterSentencePauseMs int     `json:"inter_sentence_pause_ms"`
 	VADSilenceTimeoutMs  int     `json:"vad_silence_timeout_ms"`
 	VADMinSpeechMs       int     `json:"vad_min_speech_ms"`
+	// Endpointing turns dynamic end-of-turn detection on or off for this
+	// session (nil = the gateway's setting).
+	Endpointing          *bool   `json:"endpointing"`
 	AudioClassification  bool    `json:"audio_classification"`
+	SessionID            string  `json:"session_id"`
+	Diarization          bool    `json:"diarization"`
+	Language             string  `json:"language"` // "auto" to detect, or a language code
+	EchoSuppression      bool    `json:"echo_suppression"`
+	DTMFDetection        bool    `json:"dtmf_detection"`
+	AudioBandwidth       string  `json:"audio_bandwidth"` // "wideband" (default) or "narrowband"
+	AutoGain             bool    `json:"auto_gain"`
+	AutoGainTargetDB     float64 `json:"auto_gain_target_db"` // 0 = default (-20 dBFS)
+	// ComfortNoise streams quiet noise frames (tts_ready with filler: true)
+	// while a turn is thinking, so the line never goes fully silent.
+	ComfortNoise         bool    `json:"comfort_noise"`
+	ComfortNoiseDelayMs  int     `json:"comfort_noise_delay_ms"` // dead air before it starts (0 = 500)
+	ComfortNoiseDB       float64 `json:"comfort_noise_db"`       // level in dBFS (0 = -50)
+	// SequencedFrames prefixes every binary audio frame with an 8-byte
+	// header (big-endian uint32 sequence number, uint32 sender timestamp in
+	// ms). Frames then pass through a jitter buffer that reorders them and
+	// conceals short gaps, for callers relayed over lossy networks.
+	SequencedFrames      bool    `json:"sequenced_frames"`
+	JitterBufferFrames   int     `json:"jitter_buffer_frames"` // frames held for a late one (0 = 3)
+	// AudioEnvelope sends each tts_ready event inside its audio frame
+	// instead of as a separate text frame; see envelopeFrame.
+	AudioEnvelope        bool    `json:"audio_envelope"`
+	// KeepAlive is passed to Ollama as-is: a duration ("30m") or -1 to keep
+	// the session's model loaded indefinitely.
+	KeepAlive json.RawMessage `json:"keep_alive"`
+	// Flow selects a scripted call flow by name ("" = open-ended chat).
+	Flow string `json:"flow"`
+	// Greeting is spoken as soon as the call connects, before the caller
+	// says anything (outbound calls, IVR). Resumed sessions skip it.
+	Greeting string `json:"greeting"`
+	// CacheBypass answers every turn fresh, without reading or filling the
+	// semantic cache.
+	CacheBypass bool `json:"cache_bypass"`
+	// StreamThinking sends a reasoning model's thinking as it is generated,
+	// as thinking_token events, for clients that render it live.
+	StreamThinking bool `json:"stream_thinking"`
+	// Tenant names the call's tenant when its API key isn't bound to one.
+	Tenant string `json:"tenant"`
+	// Prompt selects the system prompt from the prompt library by name, in
+	// place of system_prompt; PromptVersion pins a version (0 = latest). The
+	// version used is written back here, so the trace session records it.
+	Prompt        string `json:"prompt,omitempty"`
+	PromptVersion int    `json:"prompt_version,omitempty"`
+	// Experiment enrolls the call in an A/B experiment ("" = the gateway's
+	// default, if any). The assigned arm is written back to Variant and its
+	// engines replace the call's own.
+	Experiment string `json:"experiment,omitempty"`
+	Variant    string `json:"variant,omitempty"`
+	// Budgets caps each stage of a turn (asr_timeout_ms, llm_timeout_ms,
+	// tts_timeout_ms, total_budget_ms); a stage over budget ends the turn
+	// with budget_exceeded.
+	pipeline.Budgets
 }
 
 // wsAction is a text frame sent during a session (chat message, snippet process, etc).
 type wsAction struct {
 	Action  string `json:"action"`
 	Message string `json:"message,omitempty"`
+	Digit   string `json:"digit,omitempty"` // dtmf action: out-of-band keypad digit
 }
 
 // ServeHTTP upgrades the connection and runs the call session.
@@ -96,8 +240,9 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
+	h.heartbeat(conn)
 
-	h.runSession(conn)
+	h.runSession(conn, ratelimit.ClientKey(r), auth.TenantOf(r))
 }
 
 // sessionParams holds resolved metadata with defaults applied.
@@ -105,12 +250,14 @@ type sessionParams struct {
 	codec               audio.Codec
 	ttsEngine           string
 	asrEngine           string
+	asrModel            string
 	sampleRate          int
 	systemPrompt        string
 	llmEngine           string
 	mode                string
 	confidenceThreshold float64
 	ttsSpeed            float64
+	outputFormat        audio.OutputFormat
 	textNorm            bool
 	vadCfg              audio.VADConfig
 }
@@ -156,12 +303,14 @@ func resolveParams(meta *callMetadata, baseCfg audio.VADConfig) sessionParams {
 		codec:               audio.Codec(meta.Codec),
 		ttsEngine:           ttsEngine,
 		asrEngine:           asrEngine,
+		asrModel:            meta.ASRModel,
 		sampleRate:          sampleRate,
 		systemPrompt:        systemPrompt,
 		llmEngine:           llmEngine,
 		mode:                meta.Mode,
 		confidenceThreshold: confidenceThreshold,
 		ttsSpeed:            ttsSpeed,
+		outputFormat:        audio.OutputFormat(meta.TTSOutputFormat),
 		textNorm:            textNorm,
 		vadCfg:              vadCfg,
 	}
@@ -174,7 +323,7 @@ func orDefault(val, fallback string) string {
 	return fallback
 }
 
-func (h *Handler) runSession(conn *websocket.Conn) {
+func (h *Handler) runSession(conn *websocket.Conn, clientKey, keyTenant string) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -184,22 +333,33 @@ func (h *Handler) runSession(conn *websocket.Conn) {
 		return
 	}
 
-	params := resolveParams(meta, h.cfg.VADConfig)
-	sessionID := uuid.NewString()
-
-	var denoiser *denoise.Denoiser
-	if meta.NoiseSuppression {
-		denoiser = h.cfg.Denoiser
+	meta.Tenant, err = h.tenants.resolve(keyTenant, meta.Tenant)
+	if err != nil {
+		rejectCall(conn, err)
+		return
 	}
-
-	classifyClient := h.cfg.ClassifyClient
-	if !meta.AudioClassification {
-		classifyClient = nil
+	h.applyPrompt(meta)
+	h.applyDefaults(meta)
+	h.applyExperiment(meta, meta.SessionID)
+	params := resolveParams(meta, h.vadConfig())
+	if talkMode(params.mode) {
+		if err = audio.CheckFormat(params.codec, params.sampleRate); err != nil {
+			rejectCall(conn, err)
+			return
+		}
+	}
+	release, err := h.tenants.admit(meta.Tenant, params)
+	if err != nil {
+		rejectCall(conn, err)
+		return
 	}
+	defer release()
+	sessionID, resumed, history := h.resolveSession(meta.SessionID, meta.Tenant)
+	ctx = logctx.With(ctx, "session_id", sessionID)
 
-	slog.Info("call started", "session_id", sessionID, "codec", params.codec, "sample_rate", params.sampleRate, "tts_engine", params.ttsEngine, "asr_engine", params.asrEngine, "llm_engine", params.llmEngine, "mode", params.mode, "noise_suppression", meta.NoiseSuppression, "confidence_threshold", params.confidenceThreshold, "tts_speed", params.ttsSpeed)
+	slog.InfoContext(ctx, "call started", "tenant", meta.Tenant, "experiment", meta.Experiment, "variant", meta.Variant, "resumed", resumed, "history_turns", len(history), "codec", params.codec, "sample_rate", params.sampleRate, "tts_engine", params.ttsEngine, "asr_engine", params.asrEngine, "asr_model", params.asrModel, "llm_engine", params.llmEngine, "mode", params.mode, "noise_suppression", meta.NoiseSuppression, "confidence_threshold", params.confidenceThreshold, "tts_speed", params.ttsSpeed)
 
-	tracer := h.startTracer(sessionID, meta)
+	tracer := h.startTracer(sessionID, meta, resumed)
 	if tracer != nil {
 		defer func() {
 			tracer.Close()
@@ -207,7 +367,163 @@ func (h *Handler) runSession(conn *websocket.Conn) {
 		}()
 	}
 
-	pipe := pipeline.New(pipeline.Config{
+	pipe := pipeline.New(h.pipelineConfig(meta, params, sessionID, tracer, history))
+
+	out := h.newOutbox(conn)
+	defer out.close()
+	live, unregister := h.live.register(sessionID, meta.Tenant, pipe, params)
+	defer unregister()
+	format := params.outputFormat
+	if format != "" && !audio.ValidOutputFormat(format) {
+		format = ""
+	}
+	send := live.tee(newEventSender(ctx, out, format, meta.AudioEnvelope))
+	limits := newSessionLimiter(h.sessionLimits(meta.Tenant), send, func() {
+		out.close()
+		_ = conn.Close()
+	})
+	defer limits.stop()
+	sendEvent := limits.watch(send)
+	sendEvent(pipeline.Event{Type: "session_started", SessionID: sessionID, Resumed: resumed})
+	if format != params.outputFormat {
+		sendEvent(pipeline.Event{Type: "error", Text: fmt.Sprintf("unsupported tts_output_format %q; sending audio as synthesized", params.outputFormat)})
+	}
+	if meta.Channels == 2 && !stereo(meta, params) {
+		sendEvent(pipeline.Event{Type: "error", Text: "stereo audio needs the pcm or file codec in snippet mode; decoding as mono"})
+	}
+	if fs := pipe.FlowSession(); fs != nil {
+		sendEvent(pipeline.Event{Type: "flow_state", Text: fs.State(), Tools: fs.Tools()})
+	}
+	h.ensureEngines(ctx, params, sendEvent)
+	h.applyKeepAlive(meta, params)
+	if !resumed {
+		pipe.Speak(ctx, meta.Greeting, params.ttsEngine, sendEvent)
+	}
+	sess := &sessionCtx{
+		pipe:       pipe,
+		codec:      params.codec,
+		sampleRate: params.sampleRate,
+		ttsEngine:  params.ttsEngine,
+		asrEngine:  params.asrEngine,
+		mode:       params.mode,
+		sendEvent:  sendEvent,
+		clientKey:     clientKey,
+		msgLimiter:    h.cfg.MsgLimiter,
+		maxAudioBytes: h.cfg.MaxSessionAudioBytes,
+		live:          live,
+		limits:        limits,
+	}
+	if meta.SequencedFrames {
+		sess.jitter = newJitterBuffer(meta.JitterBufferFrames)
+	}
+	if talkMode(params.mode) {
+		sess.frames = newFrameValidator(params.codec, params.sampleRate)
+	}
+	h.processMessages(ctx, conn, sess)
+	if sess.jitter != nil {
+		slog.InfoContext(ctx, "jitter buffer", "interarrival_jitter_ms", sess.jitter.jitter)
+	}
+	// a reply still streaming has no one to hear it
+	pipe.Close()
+	h.finishCall(ctx, sessionID, pipe)
+
+	slog.InfoContext(ctx, "call ended")
+}
+
+// rejectCall refuses a call before its session starts: the caller gets a
+// single error event and the connection closes.
+func rejectCall(conn *websocket.Conn, err error) {
+	slog.Warn("call rejected", "error", err)
+	ev := pipeline.Event{Type: "error", Text: err.Error()}
+	var fe *audio.FrameError
+	if errors.As(err, &fe) {
+		ev.Code = fe.Code
+	}
+	_ = conn.WriteJSON(ev)
+}
+
+// talkMode reports whether a session's audio streams through the VAD
+// (talk, the default) rather than being buffered or not sent at all.
+func talkMode(mode string) bool {
+	return mode != "snippet" && mode != "text"
+}
+
+// ensureEngines starts any stopped orchestrator-managed service the session's
+// ASR or TTS engine runs on, so the first utterance doesn't fail against a
+// cold backend. A session asr_model is made the ASR service's active model.
+func (h *Handler) ensureEngines(ctx context.Context, params sessionParams, sendEvent pipeline.EventCallback) {
+	if h.cfg.Services == nil {
+		return
+	}
+	engines := []struct{ name, model string }{{params.asrEngine, params.asrModel}, {params.ttsEngine, ""}}
+	for _, e := range engines {
+		name, model := e.name, e.model
+		if name == "" || !h.cfg.Services.Manages(name) {
+			continue
+		}
+		if h.cfg.ServiceStartWait <= 0 {
+			go h.ensureRunning(context.Background(), name, model, backgroundStartTimeout, nil)
+			continue
+		}
+		h.ensureRunning(ctx, name, model, h.cfg.ServiceStartWait, sendEvent)
+	}
+}
+
+// applyKeepAlive forwards the session's keep_alive to Ollama in the
+// background, loading the model now instead of on the first utterance.
+func (h *Handler) applyKeepAlive(meta *callMetadata, params sessionParams) {
+	if len(meta.KeepAlive) == 0 || h.cfg.OllamaURL == "" || params.llmEngine != "ollama" {
+		return
+	}
+	model := h.cfg.LLMClient.ModelFor(params.llmEngine, meta.LLMModel)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundStartTimeout)
+		defer cancel()
+		if err := models.KeepAlive(ctx, h.cfg.OllamaURL, model, meta.KeepAlive); err != nil {
+			slog.Warn("ollama keep_alive", "model", model, "error", err)
+		}
+	}()
+}
+
+// ensureRunning starts one service on model ("" = its current one) and
+// reports the result to the client when sendEvent is non-nil.
+func (h *Handler) ensureRunning(ctx context.Context, name, model string, timeout time.Duration, sendEvent pipeline.EventCallback) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	gpu, err := h.cfg.Services.EnsureModel(ctx, name, model)
+	if err != nil {
+		slog.Warn("engine auto-start", "name", name, "error", err)
+		if sendEvent != nil {
+			sendEvent(pipeline.Event{Type: "error", Text: fmt.Sprintf("start %s: %v", name, err)})
+		}
+		return
+	}
+	if gpu == nil {
+		return
+	}
+	if h.cfg.OnServiceStarted != nil {
+		h.cfg.OnServiceStarted(gpu)
+	}
+	if sendEvent != nil {
+		sendEvent(pipeline.Event{Type: "service_started", Text: name})
+	}
+}
+
+// pipelineConfig builds the pipeline configuration for a session from its
+// metadata and resolved parameters.
+func (h *Handler) pipelineConfig(meta *callMetadata, params sessionParams, sessionID string, tracer *trace.Tracer, history []pipeline.Turn) pipeline.Config {
+	var denoiser *denoise.Denoiser
+	if meta.NoiseSuppression {
+		denoiser = h.cfg.Denoiser
+	}
+
+	classifyClient := h.cfg.ClassifyClient
+	if !meta.AudioClassification {
+		classifyClient = nil
+	}
+
+	return pipeline.Config{
 		// Backend clients
 		ASRClient:   h.cfg.ASRClient,
 		LLMClient:   h.cfg.LLMClient,
@@ -215,6 +531,8 @@ func (h *Handler) runSession(conn *websocket.Conn) {
 		// Audio & VAD
 		VADConfig:        params.vadCfg,
 		Denoiser:         denoiser,
+		DenoisePerChunk:  h.cfg.DenoisePerChunk,
+		Resampler:        h.cfg.Resampler,
 		NoiseSuppression: meta.NoiseSuppression,
 		// Session identity
 		SessionID:    sessionID,
@@ -224,6 +542,7 @@ func (h *Handler) runSession(conn *websocket.Conn) {
 		LLMEngine: params.llmEngine,
 		// ASR settings
 		ASRPrompt:           meta.ASRPrompt,
+		ASRModel:            params.asrModel,
 		ConfidenceThreshold: params.confidenceThreshold,
 		ReferenceTranscript: meta.ReferenceTranscript,
 		// TTS settings
@@ -235,33 +554,170 @@ func (h *Handler) runSession(conn *websocket.Conn) {
 		ClassifyClient:      classifyClient,
 		AudioClassification: meta.AudioClassification,
 		Tracer:              tracer,
-	})
+		Pricing:             h.cfg.Pricing,
+		History:             history,
+		// Speaker turns only make sense over a whole recording, not per VAD segment.
+		Diarization: meta.Diarization && params.mode == "snippet",
+		Stereo:      stereo(meta, params),
+		Language:    meta.Language,
+		TTSVoices:   h.cfg.TTSVoices,
+		// Echo only arises in talk mode, where the mic stays open during playback.
+		EchoSuppression: meta.EchoSuppression && params.mode != "snippet" && params.mode != "text",
+		DTMF:            meta.DTMFDetection,
+		Narrowband:      meta.AudioBandwidth == "narrowband",
+		AGC:             meta.AutoGain,
+		AGCTargetDB:     meta.AutoGainTargetDB,
+		Moderator:       h.cfg.Moderator,
+		Redactor:        h.cfg.Redactor,
+		Filler:          h.cfg.Filler,
+		Tenant:          meta.Tenant,
+		Experiment:      meta.Experiment,
+		Variant:         meta.Variant,
+		ComfortNoise:    pipeline.ComfortNoiseConfig{Enabled: meta.ComfortNoise, DelayMs: meta.ComfortNoiseDelayMs, LevelDB: meta.ComfortNoiseDB},
+		Flow:            h.flowSession(meta.Flow),
+		SemanticCache:   h.cfg.SemanticCache,
+		CacheBypass:     meta.CacheBypass,
+		Intents:         h.cfg.Intents,
+		Handoff:         h.cfg.Handoff,
+		Endpointing:     h.endpointing(meta),
+		Budgets:         meta.Budgets,
+		StreamThinking:  meta.StreamThinking,
+	}
+}
 
-	sendEvent := newEventSender(conn)
-	sess := &sessionCtx{
-		pipe:       pipe,
-		codec:      params.codec,
-		sampleRate: params.sampleRate,
-		ttsEngine:  params.ttsEngine,
-		asrEngine:  params.asrEngine,
-		mode:       params.mode,
-		sendEvent:  sendEvent,
+// stereo reports whether the session's audio is split into caller and
+// agent channels. Only PCM interleaves them (a file keeps its own), and
+// only a buffered recording is transcribed per channel; talk mode needs
+// one voice for the VAD.
+func stereo(meta *callMetadata, params sessionParams) bool {
+	pcm := params.codec == audio.CodecPCM || params.codec == audio.CodecFile
+	return meta.Channels == 2 && pcm && params.mode == "snippet"
+}
+
+// endpointing is the gateway's endpointing config with the session's
+// on/off override applied.
+func (h *Handler) endpointing(meta *callMetadata) pipeline.EndpointConfig {
+	cfg := h.Tunables().Endpointing
+	if meta.Endpointing != nil {
+		cfg.Enabled = *meta.Endpointing
+	}
+	return cfg
+}
+
+// applyPrompt replaces the session's system prompt with the library prompt
+// it names. Unknown names are logged and ignored rather than failing the
+// call, like unknown flows.
+func (h *Handler) applyPrompt(meta *callMetadata) {
+	if meta.Prompt == "" {
+		return
 	}
-	processMessages(ctx, conn, sess)
-	flushIfNeeded(ctx, sess)
+	if h.cfg.Prompts == nil {
+		slog.Warn("prompt library disabled, ignoring prompt", "prompt", meta.Prompt)
+		return
+	}
+	p, err := h.cfg.Prompts.Get(meta.Prompt, meta.PromptVersion)
+	if err != nil {
+		slog.Warn("prompt library", "prompt", meta.Prompt, "version", meta.PromptVersion, "error", err)
+		return
+	}
+	meta.SystemPrompt, meta.PromptVersion = p.Text, p.Version
+}
 
-	slog.Info("call ended")
+// flowSession starts the named call flow, or returns nil for open-ended
+// chat. Unknown names are logged and ignored rather than failing the call.
+func (h *Handler) flowSession(name string) *flow.Session {
+	if name == "" {
+		return nil
+	}
+	f, ok := h.cfg.Flows[name]
+	if !ok {
+		slog.Warn("unknown call flow", "flow", name)
+		return nil
+	}
+	return flow.NewSession(f)
 }
 
-func (h *Handler) startTracer(sessionID string, meta *callMetadata) *trace.Tracer {
+func (h *Handler) startTracer(sessionID string, meta *callMetadata, resumed bool) *trace.Tracer {
 	if h.cfg.TraceStore == nil {
 		return nil
 	}
-	metaJSON, _ := json.Marshal(meta)
-	_ = h.cfg.TraceStore.CreateSession(sessionID, string(metaJSON))
+	if !resumed {
+		metaJSON, _ := json.Marshal(meta)
+		_ = h.cfg.TraceStore.CreateSession(trace.Session{
+			ID:         sessionID,
+			Tenant:     meta.Tenant,
+			Experiment: meta.Experiment,
+			Variant:    meta.Variant,
+			Metadata:   string(metaJSON),
+		})
+	}
 	return trace.NewTracer(h.cfg.TraceStore, sessionID)
 }
 
+// finishCall records a call that has ended: its talk time, and its summary.
+func (h *Handler) finishCall(ctx context.Context, sessionID string, pipe *pipeline.Pipeline) {
+	if tt := pipe.Talk().Stats(); tt != nil {
+		slog.InfoContext(ctx, "talk time", "duration_sec", tt.DurationSec, "caller_ratio", tt.CallerRatio, "agent_ratio", tt.AgentRatio, "over_talk_ratio", tt.OverTalkRatio, "silence_ratio", tt.SilenceRatio, "interruptions", tt.Interruptions, "caller_wpm", tt.CallerWPM)
+		if h.cfg.TraceStore != nil {
+			if err := h.cfg.TraceStore.SetTalkTime(sessionID, tt); err != nil {
+				slog.WarnContext(ctx, "talk time", "error", err)
+			}
+		}
+	}
+	h.summarizeCall(ctx, sessionID, pipe)
+}
+
+// summarizeCall stores a summary of a finished call on its trace session,
+// in the background: the connection is gone and nothing waits on it.
+func (h *Handler) summarizeCall(ctx context.Context, sessionID string, pipe *pipeline.Pipeline) {
+	if !h.cfg.CallSummary || h.cfg.TraceStore == nil || len(pipe.History()) == 0 {
+		return
+	}
+	go func() {
+		sum, err := pipe.SummarizeCall(context.WithoutCancel(ctx))
+		if err == nil {
+			err = h.cfg.TraceStore.SetSummary(sessionID, sum)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "call summary", "error", err)
+		}
+	}()
+}
+
+// resolveSession picks the session ID for a connection. A client-supplied
+// session_id that matches a stored session is resumed with its conversation
+// history; an unknown but well-formed ID starts a new session under that ID
+// so the client can reconnect with it later. Another tenant's session is
+// never resumed. Resume requires the trace store.
+func (h *Handler) resolveSession(requested, tenant string) (string, bool, []pipeline.Turn) {
+	if requested == "" || h.cfg.TraceStore == nil {
+		return uuid.NewString(), false, nil
+	}
+	if _, err := uuid.Parse(requested); err != nil {
+		slog.Warn("ignoring invalid session_id", "session_id", requested)
+		return uuid.NewString(), false, nil
+	}
+
+	found, err := h.cfg.TraceStore.ResumeSession(requested, tenant)
+	if err != nil {
+		slog.Warn("resume session", "session_id", requested, "error", err)
+		return uuid.NewString(), false, nil
+	}
+	if !found {
+		return requested, false, nil
+	}
+
+	turns, err := h.cfg.TraceStore.ListTurns(requested)
+	if err != nil {
+		slog.Warn("load session turns", "session_id", requested, "error", err)
+	}
+	history := make([]pipeline.Turn, len(turns))
+	for i, t := range turns {
+		history[i] = pipeline.Turn{User: t.User, Assistant: t.Assistant}
+	}
+	return requested, true, history
+}
+
 // sessionCtx bundles the per-session state threaded through message handling.
 type sessionCtx struct {
 	pipe       *pipeline.Pipeline
@@ -271,19 +727,64 @@ type sessionCtx struct {
 	asrEngine  string
 	mode       string
 	sendEvent  pipeline.EventCallback
+
+	// rate limiting and quotas
+	clientKey     string
+	msgLimiter    *ratelimit.Limiter
+	maxAudioBytes int64
+	audioBytes    int64
+	throttled     bool // an error event was already sent for the current throttle burst
+
+	live   *liveSession    // supervisor monitors (nil for realtime sessions)
+	limits *sessionLimiter // duration, turn, and snippet caps (nil for realtime sessions)
+
+	jitter *jitterBuffer    // reorders sequenced frames (nil = frames carry no header)
+	frames *frameValidator // screens talk-mode frames (nil in snippet and text modes)
+}
+
+// admit applies the per-client message rate and the per-session audio quota.
+// Frames over the rate are dropped (process=false) but the session continues;
+// exhausting the audio quota ends the session (keep=false).
+func (sc *sessionCtx) admit(msgType int, data []byte) (process, keep bool) {
+	if ok, wait := sc.msgLimiter.Allow(sc.clientKey); !ok {
+		if !sc.throttled {
+			sc.throttled = true
+			sc.sendEvent(pipeline.Event{Type: "error", Text: "rate limit exceeded, retry after " + ratelimit.RetryAfter(wait) + "s"})
+		}
+		return false, true
+	}
+	sc.throttled = false
+
+	if msgType != websocket.BinaryMessage || sc.maxAudioBytes <= 0 {
+		return true, true
+	}
+	sc.audioBytes += int64(len(data))
+	if sc.audioBytes <= sc.maxAudioBytes {
+		return true, true
+	}
+	metrics.RateLimited.WithLabelValues("session_audio_bytes").Inc()
+	slog.Warn("session audio quota exceeded", "client", sc.clientKey, "bytes", sc.audioBytes)
+	sc.sendEvent(pipeline.Event{Type: "error", Text: "session audio quota exceeded"})
+	return false, false
 }
 
 // processMessages reads frames from the WebSocket in a loop.
 // Text frames carry actions (chat, process) and are handled in all modes.
 // Binary frames are mode-specific: talk=VAD, snippet=buffer, text=ignored.
-func processMessages(ctx context.Context, conn *websocket.Conn, sc *sessionCtx) {
+func (h *Handler) processMessages(ctx context.Context, conn *websocket.Conn, sc *sessionCtx) {
 	for {
-		msgType, data, err := conn.ReadMessage()
+		msgType, data, err := h.readMessage(conn)
 		if err != nil {
-			slog.Info("connection closed", "error", err)
+			slog.InfoContext(ctx, "connection closed", "error", err)
 			return
 		}
-		handleOneMessage(ctx, msgType, data, sc)
+		process, keep := sc.admit(msgType, data)
+		if !keep {
+			return
+		}
+		if process {
+			handleOneMessage(ctx, msgType, data, sc)
+		}
 	}
 }
 
@@ -298,26 +799,40 @@ func handleOneMessage(ctx context.Context, msgType int, data []byte, sc *session
 	if sc.mode == "text" {
 		return
 	}
+	if sc.jitter == nil {
+		handleAudio(ctx, data, sc)
+		return
+	}
+	seq, ts, payload, err := parseFrameHeader(data)
+	if err != nil {
+		sc.sendEvent(pipeline.Event{Type: "error", Text: err.Error()})
+		return
+	}
+	for _, frame := range sc.jitter.push(seq, ts, payload, time.Now()) {
+		handleAudio(ctx, frame, sc)
+	}
+}
+
+// handleAudio feeds one in-order audio frame to the pipeline.
+func handleAudio(ctx context.Context, data []byte, sc *sessionCtx) {
+	sc.live.callerAudio(data)
 	if sc.mode == "snippet" {
+		if !sc.limits.snippetAudio(len(data)) {
+			return
+		}
 		if err := sc.pipe.ProcessChunkNoVAD(data, sc.codec, sc.sampleRate); err != nil {
-			slog.Error("buffer chunk", "error", err)
+			slog.ErrorContext(ctx, "buffer chunk", "error", err)
 			sc.sendEvent(pipeline.Event{Type: "error", Text: err.Error()})
 		}
 		return
 	}
 	// talk mode (default): VAD processing
-	if err := sc.pipe.ProcessChunk(ctx, data, sc.codec, sc.sampleRate, sc.ttsEngine, sc.asrEngine, sc.sendEvent); err != nil {
-		slog.Error("process chunk", "error", err)
-		sc.sendEvent(pipeline.Event{Type: "error", Text: err.Error()})
-	}
-}
-
-func flushIfNeeded(ctx context.Context, sc *sessionCtx) {
-	if sc.mode == "snippet" || sc.mode == "text" {
+	if !sc.frames.admit(data, time.Now(), sc.sendEvent) {
 		return
 	}
-	if err := sc.pipe.Flush(ctx, sc.ttsEngine, sc.asrEngine, sc.sendEvent); err != nil {
-		slog.Error("flush", "error", err)
+	if err := sc.pipe.ProcessChunk(ctx, data, sc.codec, sc.sampleRate, sc.ttsEngine, sc.asrEngine, sc.sendEvent); err != nil {
+		slog.ErrorContext(ctx, "process chunk", "error", err)
+		sc.sendEvent(pipeline.Event{Type: "error", Text: err.Error()})
 	}
 }
 
@@ -328,42 +843,92 @@ func handleTextFrame(ctx context.Context, data []byte, sc *sessionCtx) {
 	}
 
 	if act.Action == "chat" {
-		if err := sc.pipe.ProcessTextMessage(ctx, act.Message, sc.sendEvent); err != nil {
-			slog.Error("chat", "error", err)
-			sc.sendEvent(pipeline.Event{Type: "error", Text: err.Error()})
-		}
+		sc.pipe.StartTextMessage(ctx, act.Message, sc.sendEvent)
+		return
+	}
+
+	// the client started playing over the agent (or hit stop): drop the reply
+	if act.Action == "cancel" {
+		sc.pipe.CancelTurn()
+		return
+	}
+
+	// speak a line as the agent, e.g. a prompt pushed by an IVR controller
+	if act.Action == "speak" {
+		sc.pipe.Speak(ctx, act.Message, sc.ttsEngine, sc.sendEvent)
+		return
+	}
+
+	if act.Action == "dtmf" {
+		sc.pipe.ProcessDTMF(act.Digit, sc.sendEvent)
 		return
 	}
 
 	if act.Action == "process" && sc.mode == "snippet" {
-		if err := sc.pipe.ProcessBuffered(ctx, sc.ttsEngine, sc.asrEngine, sc.sendEvent); err != nil {
-			slog.Error("process buffered", "error", err)
+		sc.limits.snippetProcessed()
+		if err := sc.pipe.StartBuffered(ctx, sc.ttsEngine, sc.asrEngine, sc.sendEvent); err != nil {
+			slog.ErrorContext(ctx, "process buffered", "error", err)
 			sc.sendEvent(pipeline.Event{Type: "error", Text: err.Error()})
 		}
 		return
 	}
 }
 
-func newEventSender(conn *websocket.Conn) pipeline.EventCallback {
+// newEventSender returns a callback that queues pipeline events on out.
+// Events come from several goroutines; the lock keeps an audio frame and
+// its JSON event adjacent in the queue.
+//
+// With an output format, audio is transcoded and the event is sent first as
+// the frame's descriptor (audio_format, sample_rate). Without one the
+// original order, frame then event, is kept for existing clients. With the
+// audio envelope, event and audio travel together in one binary frame.
+func newEventSender(ctx context.Context, out *outbox, format audio.OutputFormat, envelope bool) pipeline.EventCallback {
 	var mu sync.Mutex
 	return func(ev pipeline.Event) {
-		mu.Lock()
-		defer mu.Unlock()
-
-		if ev.Audio != nil {
-			if err := conn.WriteMessage(websocket.BinaryMessage, ev.Audio); err != nil {
-				slog.Error("write audio", "error", err)
-			}
+		if ev.Audio != nil && format != "" {
+			ev = transcodeEvent(ctx, ev, format)
+		}
+		if ev.Audio != nil && envelope && ev.AudioFormat == "" {
+			ev.AudioFormat = string(audio.Container(ev.Audio))
 		}
-
 		jsonBytes, err := json.Marshal(ev)
 		if err != nil {
 			return
 		}
-		if err = conn.WriteMessage(websocket.TextMessage, jsonBytes); err != nil {
-			slog.Error("write event", "error", err)
+		if ev.Audio != nil && envelope {
+			if frame, ok := envelopeFrame(jsonBytes, ev.Audio); ok {
+				out.send(websocket.BinaryMessage, frame)
+				return
+			}
 		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if ev.Audio == nil {
+			out.send(websocket.TextMessage, jsonBytes)
+			return
+		}
+		if format != "" {
+			out.send(websocket.TextMessage, jsonBytes)
+			out.send(websocket.BinaryMessage, ev.Audio)
+			return
+		}
+		out.send(websocket.BinaryMessage, ev.Audio)
+		out.send(websocket.TextMessage, jsonBytes)
+	}
+}
+
+// transcodeEvent converts the event's audio to format and describes it in
+// the event. If transcoding fails the original audio is kept, tagged with
+// its own container, so the sentence is still heard.
+func transcodeEvent(ctx context.Context, ev pipeline.Event, format audio.OutputFormat) pipeline.Event {
+	data, rate, err := audio.Transcode(ctx, ev.Audio, format)
+	if err != nil {
+		slog.Warn("transcode tts audio", "format", format, "error", err)
+		data, rate, format = ev.Audio, 0, audio.Container(ev.Audio)
 	}
+	ev.Audio, ev.AudioFormat, ev.SampleRate = data, string(format), rate
+	return ev
 }
 
 func readMetadata(conn *websocket.Conn) (*callMetadata, error) {
@@ -0,0 +1,124 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+)
+
+// heldLLM sends one token and then holds its stream open until release is
+// closed, ignoring ctx like a provider that doesn't notice cancellation.
+// Later calls answer at once.
+type heldLLM struct {
+	calls     atomic.Int32
+	cancelled chan struct{} // closed once the held call's ctx is done
+	release   chan struct{}
+}
+
+func newHeldLLM() *heldLLM {
+	return &heldLLM{cancelled: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (f *heldLLM) Chat(ctx context.Context, _ []pipeline.Message, _, _ string, onToken pipeline.TokenCallback) (*pipeline.LLMResult, error) {
+	if f.calls.Add(1) > 1 {
+		onToken("Second.")
+		return &pipeline.LLMResult{Text: "Second."}, nil
+	}
+	go func() {
+		<-ctx.Done()
+		close(f.cancelled)
+	}()
+	onToken("First ")
+	<-f.release
+	onToken("late.")
+	return &pipeline.LLMResult{Text: "First late."}, nil
+}
+
+func dialCall(t *testing.T, llm pipeline.LLMChatClient) *websocket.Conn {
+	t.Helper()
+	agent := pipeline.NewAgentLLM("fake", 0)
+	agent.RegisterRaw("fake", llm, "fake-model")
+	srv := httptest.NewServer(NewHandler(HandlerConfig{LLMClient: agent}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err = conn.WriteJSON(map[string]any{"mode": "text", "llm_engine": "fake"}); err != nil {
+		t.Fatalf("send metadata: %v", err)
+	}
+	readUntil(t, conn, func(e pipeline.Event) bool { return e.Type == "session_started" })
+	return conn
+}
+
+// readUntil reads events until done accepts one, returning all it read.
+func readUntil(t *testing.T, conn *websocket.Conn, done func(pipeline.Event) bool) []pipeline.Event {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var events []pipeline.Event
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read after %+v: %v", events, err)
+		}
+		var e pipeline.Event
+		if json.Unmarshal(data, &e) != nil {
+			continue
+		}
+		events = append(events, e)
+		if done(e) {
+			return events
+		}
+	}
+}
+
+// TestChatCancelledWhileStreaming sends a cancel, or a newer message, while
+// a chat reply is still streaming: the read loop must see it at once, not
+// once the reply has finished.
+func TestChatCancelledWhileStreaming(t *testing.T) {
+	interrupts := []struct {
+		name   string
+		action map[string]string
+	}{
+		{"cancel", map[string]string{"action": "cancel"}},
+		{"newer message", map[string]string{"action": "chat", "message": "again"}},
+	}
+	for _, tc := range interrupts {
+		t.Run(tc.name, func(t *testing.T) {
+			llm := newHeldLLM()
+			conn := dialCall(t, llm)
+			if err := conn.WriteJSON(map[string]string{"action": "chat", "message": "hello"}); err != nil {
+				t.Fatalf("send chat: %v", err)
+			}
+			readUntil(t, conn, func(e pipeline.Event) bool { return e.Type == "llm_token" })
+
+			if err := conn.WriteJSON(tc.action); err != nil {
+				t.Fatalf("send %s: %v", tc.name, err)
+			}
+			select {
+			case <-llm.cancelled:
+			case <-time.After(5 * time.Second):
+				close(llm.release)
+				t.Fatalf("%s didn't reach the turn while its reply was streaming", tc.name)
+			}
+			close(llm.release)
+
+			events := readUntil(t, conn, func(e pipeline.Event) bool { return e.Type == "turn_cancelled" })
+			for _, e := range events {
+				if e.Type == "llm_token" && e.Turn == 1 {
+					t.Errorf("llm_token %q after %s", e.Token, tc.name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// defaultPingInterval is how often clients are pinged.
+	defaultPingInterval = 15 * time.Second
+
+	// defaultPongTimeout is how long a client may go without answering a
+	// ping before its connection is taken for dead.
+	defaultPongTimeout = 90 * time.Second
+)
+
+// heartbeat arms conn's read deadline, which every pong pushes back. A
+// half-open connection (a phone that lost its network) stops answering the
+// outbox's pings, its read fails once the deadline passes, and the session
+// ends, releasing its pipeline. Call it right after the upgrade, so a client
+// that never sends its first frame is reaped too.
+func (h *Handler) heartbeat(conn *websocket.Conn) {
+	timeout := h.pongTimeout()
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+}
+
+// readMessage reads conn's next frame, first pushing the read deadline
+// back a full pong timeout. Pongs are only seen while a read is running,
+// so whatever the loop did since the last frame (an engine auto-start,
+// waiting out a turn) held them unread; it says nothing about the client.
+func (h *Handler) readMessage(conn *websocket.Conn) (int, []byte, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(h.pongTimeout()))
+	return conn.ReadMessage()
+}
+
+// pongTimeout is the handler's pong timeout, or the default.
+func (h *Handler) pongTimeout() time.Duration {
+	if h.cfg.PongTimeout <= 0 {
+		return defaultPongTimeout
+	}
+	return h.cfg.PongTimeout
+}
+
+// pingInterval is the handler's ping interval, or the default.
+func (h *Handler) pingInterval() time.Duration {
+	if h.cfg.PingInterval <= 0 {
+		return defaultPingInterval
+	}
+	return h.cfg.PingInterval
+}
@@ -0,0 +1,126 @@
+package ws
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+)
+
+const (
+	// frameHeaderLen is the size of the header on sequenced audio frames: a
+	// big-endian uint32 sequence number, then a big-endian uint32 sender
+	// timestamp in milliseconds.
+	frameHeaderLen = 8
+
+	// defaultJitterFrames is how many frames the jitter buffer holds back
+	// waiting for a late one (about 60 ms of 20 ms frames).
+	defaultJitterFrames = 3
+
+	// maxConcealFrames is the longest gap filled in; a longer outage is
+	// skipped rather than papered over.
+	maxConcealFrames = 5
+)
+
+var errShortFrame = errors.New("sequenced audio frame shorter than its header")
+
+// parseFrameHeader splits a sequenced frame into its header and payload.
+func parseFrameHeader(data []byte) (seq, ts uint32, payload []byte, err error) {
+	if len(data) < frameHeaderLen {
+		return 0, 0, nil, errShortFrame
+	}
+	return binary.BigEndian.Uint32(data[0:4]), binary.BigEndian.Uint32(data[4:8]), data[frameHeaderLen:], nil
+}
+
+// jitterBuffer reorders sequenced frames from a lossy network path (a
+// SIP/RTP bridge, a mobile relay) so the VAD sees audio in order. Frames are
+// released as soon as they're next in sequence; a missing frame is waited
+// for until depth later frames have arrived, then concealed by repeating
+// the previous frame. Frames arriving after their slot was released are
+// dropped. Not safe for concurrent use; the read loop owns it.
+type jitterBuffer struct {
+	depth   int
+	next    uint32
+	started bool
+	pending map[uint32][]byte
+	last    []byte
+
+	// RFC 3550 interarrival jitter, in milliseconds
+	jitter     float64
+	lastArrive time.Time
+	lastTS     uint32
+}
+
+func newJitterBuffer(depth int) *jitterBuffer {
+	if depth <= 0 {
+		depth = defaultJitterFrames
+	}
+	return &jitterBuffer{depth: depth, pending: map[uint32][]byte{}}
+}
+
+// push adds a frame and returns the frames now ready, in order.
+func (j *jitterBuffer) push(seq, ts uint32, payload []byte, now time.Time) [][]byte {
+	j.observe(ts, now)
+	if !j.started {
+		j.next, j.started = seq, true
+	}
+	if int32(seq-j.next) < 0 {
+		metrics.AudioFrames.WithLabelValues("late").Inc()
+		return nil
+	}
+	if _, dup := j.pending[seq]; dup {
+		metrics.AudioFrames.WithLabelValues("duplicate").Inc()
+		return nil
+	}
+	j.pending[seq] = payload
+
+	var ready [][]byte
+	for {
+		if frame, ok := j.pending[j.next]; ok {
+			delete(j.pending, j.next)
+			ready = append(ready, frame)
+			j.last = frame
+			j.next++
+			continue
+		}
+		if len(j.pending) <= j.depth {
+			return ready
+		}
+		// the missing frame is overdue: fill or skip the gap to the
+		// earliest frame we have
+		gap := j.earliest() - j.next
+		if gap <= maxConcealFrames && j.last != nil {
+			for range gap {
+				ready = append(ready, j.last)
+			}
+			metrics.AudioFrames.WithLabelValues("concealed").Add(float64(gap))
+		} else {
+			metrics.AudioFrames.WithLabelValues("skipped").Add(float64(gap))
+		}
+		j.next += gap
+	}
+}
+
+// earliest is the lowest pending sequence number at or after next.
+func (j *jitterBuffer) earliest() uint32 {
+	first, found := uint32(0), false
+	for seq := range j.pending {
+		if !found || int32(seq-first) < 0 {
+			first, found = seq, true
+		}
+	}
+	return first
+}
+
+// observe updates the interarrival jitter estimate (RFC 3550 §6.4.1).
+func (j *jitterBuffer) observe(ts uint32, now time.Time) {
+	if !j.lastArrive.IsZero() {
+		d := float64(now.Sub(j.lastArrive).Milliseconds()) - float64(int32(ts-j.lastTS))
+		if d < 0 {
+			d = -d
+		}
+		j.jitter += (d - j.jitter) / 16
+	}
+	j.lastArrive, j.lastTS = now, ts
+}
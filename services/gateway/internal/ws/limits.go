@@ -0,0 +1,124 @@
+package ws
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+)
+
+// SessionLimits cap what one /ws/call session may use, so a client can't
+// hold a pipeline indefinitely. A session that reaches a limit gets a
+// session_limit event and is disconnected. Zero fields are unlimited.
+type SessionLimits struct {
+	MaxDurationSec  int   `json:"max_duration_sec"`
+	MaxTurns        int   `json:"max_turns"`         // caller turns answered, counted by their metrics events
+	MaxSnippetBytes int64 `json:"max_snippet_bytes"` // audio buffered for one snippet before "process"
+}
+
+// Validate rejects negative limits.
+func (l SessionLimits) Validate() error {
+	if l.MaxDurationSec < 0 || l.MaxTurns < 0 || l.MaxSnippetBytes < 0 {
+		return fmt.Errorf("session_limits must not be negative")
+	}
+	return nil
+}
+
+// withTenant returns the limits with the tenant's set ones in their place.
+func (l SessionLimits) withTenant(t SessionLimits) SessionLimits {
+	if t.MaxDurationSec > 0 {
+		l.MaxDurationSec = t.MaxDurationSec
+	}
+	if t.MaxTurns > 0 {
+		l.MaxTurns = t.MaxTurns
+	}
+	if t.MaxSnippetBytes > 0 {
+		l.MaxSnippetBytes = t.MaxSnippetBytes
+	}
+	return l
+}
+
+// sessionLimits resolves a tenant's call limits from the gateway-wide ones.
+func (h *Handler) sessionLimits(tenant string) SessionLimits {
+	cfg, _ := h.tenants.config(tenant)
+	return h.Tunables().SessionLimits.withTenant(cfg.SessionLimits)
+}
+
+// sessionLimiter enforces one session's limits. The first limit reached
+// sends its session_limit event and ends the session; later ones do nothing.
+type sessionLimiter struct {
+	limits    SessionLimits
+	sendEvent pipeline.EventCallback
+	end       func() // flushes queued frames and disconnects
+
+	turns        atomic.Int64
+	snippetBytes int64 // read loop only
+	timer        *time.Timer
+	once         sync.Once
+}
+
+// newSessionLimiter starts enforcing limits; stop releases its timer.
+func newSessionLimiter(limits SessionLimits, sendEvent pipeline.EventCallback, end func()) *sessionLimiter {
+	l := &sessionLimiter{limits: limits, sendEvent: sendEvent, end: end}
+	if limits.MaxDurationSec > 0 {
+		d := time.Duration(limits.MaxDurationSec) * time.Second
+		l.timer = time.AfterFunc(d, func() {
+			l.hit("max_duration", fmt.Sprintf("call reached its %s duration limit", d))
+		})
+	}
+	return l
+}
+
+func (l *sessionLimiter) stop() {
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+}
+
+// watch returns sendEvent counting finished turns against max_turns: the
+// turn that reaches the limit is answered, then the session ends.
+func (l *sessionLimiter) watch(sendEvent pipeline.EventCallback) pipeline.EventCallback {
+	if l.limits.MaxTurns <= 0 {
+		return sendEvent
+	}
+	return func(ev pipeline.Event) {
+		sendEvent(ev)
+		if ev.Type == "metrics" && l.turns.Add(1) >= int64(l.limits.MaxTurns) {
+			l.hit("max_turns", fmt.Sprintf("call reached its limit of %d turns", l.limits.MaxTurns))
+		}
+	}
+}
+
+// snippetAudio counts n bytes of buffered snippet audio, ending the session
+// and returning false once they exceed max_snippet_bytes.
+func (l *sessionLimiter) snippetAudio(n int) bool {
+	if l == nil || l.limits.MaxSnippetBytes <= 0 {
+		return true
+	}
+	l.snippetBytes += int64(n)
+	if l.snippetBytes <= l.limits.MaxSnippetBytes {
+		return true
+	}
+	l.hit("max_snippet_bytes", fmt.Sprintf("snippet exceeded its limit of %d bytes", l.limits.MaxSnippetBytes))
+	return false
+}
+
+// snippetProcessed starts the count over for the next snippet.
+func (l *sessionLimiter) snippetProcessed() {
+	if l != nil {
+		l.snippetBytes = 0
+	}
+}
+
+// hit ends the session on the limit named by reason. It may be called from
+// a turn's event callback, so the disconnect runs on its own goroutine.
+func (l *sessionLimiter) hit(reason, text string) {
+	l.once.Do(func() {
+		metrics.RateLimited.WithLabelValues("session_" + reason).Inc()
+		l.sendEvent(pipeline.Event{Type: "session_limit", Reason: reason, Text: text})
+		go l.end()
+	})
+}
@@ -0,0 +1,233 @@
+package ws
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/auth"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+)
+
+// Binary frames sent to monitors start with one byte naming the channel, so
+// the caller's raw audio (in the session's codec) and the agent's TTS audio
+// can be told apart.
+const (
+	monitorCallerAudio byte = 1
+	monitorAgentAudio  byte = 2
+)
+
+// liveRegistry tracks active /ws/call sessions so supervisors can attach.
+type liveRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*liveSession
+}
+
+func newLiveRegistry() *liveRegistry {
+	return &liveRegistry{sessions: map[string]*liveSession{}}
+}
+
+// liveSession fans one call out to its monitors. Each monitor has its own
+// outbox, so a slow supervisor drops frames instead of stalling the call.
+type liveSession struct {
+	id         string
+	tenant     string
+	pipe       *pipeline.Pipeline
+	codec      string
+	sampleRate int
+
+	mu       sync.Mutex
+	monitors map[*outbox]struct{}
+	ended    bool
+}
+
+// register makes a call visible to monitors until the returned func is called.
+func (r *liveRegistry) register(id, tenant string, pipe *pipeline.Pipeline, params sessionParams) (*liveSession, func()) {
+	ls := &liveSession{id: id, tenant: tenant, pipe: pipe, codec: string(params.codec), sampleRate: params.sampleRate, monitors: map[*outbox]struct{}{}}
+	r.mu.Lock()
+	r.sessions[id] = ls
+	r.mu.Unlock()
+	return ls, func() {
+		r.mu.Lock()
+		if r.sessions[id] == ls {
+			delete(r.sessions, id)
+		}
+		r.mu.Unlock()
+		ls.end()
+	}
+}
+
+func (r *liveRegistry) lookup(id string) (*liveSession, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ls, ok := r.sessions[id]
+	return ls, ok
+}
+
+// attach adds a monitor. Returns false if the call ended in the meantime.
+func (ls *liveSession) attach(out *outbox) bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.ended {
+		return false
+	}
+	ls.monitors[out] = struct{}{}
+	return true
+}
+
+func (ls *liveSession) detach(out *outbox) {
+	ls.mu.Lock()
+	delete(ls.monitors, out)
+	ls.mu.Unlock()
+}
+
+func (ls *liveSession) broadcast(msgType int, data []byte) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for out := range ls.monitors {
+		out.send(msgType, data)
+	}
+}
+
+// callerAudio mirrors one inbound audio frame to monitors.
+func (ls *liveSession) callerAudio(data []byte) {
+	if ls == nil {
+		return
+	}
+	ls.broadcast(websocket.BinaryMessage, append([]byte{monitorCallerAudio}, data...))
+}
+
+// tee returns an event callback that also copies each event to monitors.
+func (ls *liveSession) tee(sendEvent pipeline.EventCallback) pipeline.EventCallback {
+	return func(ev pipeline.Event) {
+		sendEvent(ev)
+		jsonBytes, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		for out := range ls.monitors {
+			if ev.Audio != nil {
+				out.send(websocket.BinaryMessage, append([]byte{monitorAgentAudio}, ev.Audio...))
+			}
+			out.send(websocket.TextMessage, jsonBytes)
+		}
+	}
+}
+
+// end tells monitors the call is over and disconnects them.
+func (ls *liveSession) end() {
+	ls.mu.Lock()
+	monitors := ls.monitors
+	ls.monitors = map[*outbox]struct{}{}
+	ls.ended = true
+	ls.mu.Unlock()
+
+	ended, _ := json.Marshal(pipeline.Event{Type: "session_ended", SessionID: ls.id, TalkTime: ls.pipe.Talk().Stats()})
+	for out := range monitors {
+		out.send(websocket.TextMessage, ended)
+		out.close()
+		_ = out.conn.Close()
+	}
+}
+
+// MonitorHandler serves GET /ws/monitor/{session_id}: a read-only copy of a
+// live call (caller audio, transcripts, agent tokens and audio) plus a
+// "whisper" action that adds supervisor guidance to the agent's prompt and
+// a "release" action that hands a held call back to the agent.
+// Only /ws/call sessions can be monitored.
+type MonitorHandler struct {
+	h *Handler
+}
+
+// Monitor returns the handler for /ws/monitor/{session_id}.
+func (h *Handler) Monitor() *MonitorHandler {
+	return &MonitorHandler{h: h}
+}
+
+// monitorAction is a text frame sent by a supervisor.
+type monitorAction struct {
+	Action  string `json:"action"`  // "whisper" or "release"
+	Message string `json:"message"` // guidance for the agent
+}
+
+func (m *MonitorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("session_id")
+	ls, ok := m.h.live.lookup(id)
+	// a tenant's supervisors only see that tenant's calls
+	if tenant := auth.TenantOf(r); ok && tenant != "" && tenant != ls.tenant {
+		ok = false
+	}
+	if !ok {
+		http.Error(w, "session not active", http.StatusNotFound)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("monitor upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+	m.h.heartbeat(conn)
+
+	supervisor := "anonymous"
+	if ident, ok := auth.FromContext(r.Context()); ok {
+		supervisor = ident.Name
+	}
+	slog.Info("monitor attached", "session_id", id, "supervisor", supervisor)
+
+	out := m.h.newOutbox(conn)
+	started, _ := json.Marshal(map[string]any{"type": "monitor_started", "session_id": id, "codec": ls.codec, "sample_rate": ls.sampleRate})
+	out.send(websocket.TextMessage, started)
+	if !ls.attach(out) {
+		out.close()
+		return
+	}
+	defer func() {
+		ls.detach(out)
+		out.close()
+		slog.Info("monitor detached", "session_id", id, "supervisor", supervisor)
+	}()
+
+	for {
+		msgType, data, err := m.h.readMessage(conn)
+		if err != nil {
+			return
+		}
+		if msgType == websocket.TextMessage {
+			m.handleAction(ls, supervisor, data)
+		}
+	}
+}
+
+func (m *MonitorHandler) handleAction(ls *liveSession, supervisor string, data []byte) {
+	var act monitorAction
+	if json.Unmarshal(data, &act) != nil {
+		return
+	}
+	if act.Action == "release" && ls.pipe.OnHold() {
+		ls.pipe.ReleaseHold()
+		slog.Info("hold released", "session_id", ls.id, "supervisor", supervisor)
+		ack, _ := json.Marshal(map[string]string{"type": "hold_released", "supervisor": supervisor})
+		ls.broadcast(websocket.TextMessage, ack)
+		return
+	}
+	if act.Action != "whisper" {
+		return
+	}
+	msg := strings.TrimSpace(act.Message)
+	if msg == "" {
+		return
+	}
+	ls.pipe.AddGuidance(msg)
+	slog.Info("supervisor whisper", "session_id", ls.id, "supervisor", supervisor, "text", m.h.cfg.Redactor.Redact(msg))
+
+	// every monitor sees the whisper, so co-listening supervisors stay in sync
+	ack, _ := json.Marshal(map[string]string{"type": "whisper", "text": msg, "supervisor": supervisor})
+	ls.broadcast(websocket.TextMessage, ack)
+}
@@ -0,0 +1,168 @@
+package ws
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+)
+
+const (
+	// defaultWriteTimeout bounds a single frame write to the client.
+	defaultWriteTimeout = 5 * time.Second
+
+	// defaultSendQueue is how many outbound frames may wait for a slow client.
+	defaultSendQueue = 256
+
+	// SlowClientDrop discards frames while a client's send queue is full.
+	SlowClientDrop = "drop"
+	// SlowClientClose disconnects a client as soon as its send queue fills.
+	SlowClientClose = "close"
+)
+
+// outbox decouples the pipeline from the client's network: frames are queued
+// and written by one goroutine with a per-write deadline, so a stalled client
+// never blocks ASR/LLM/TTS. A write that misses its deadline closes the
+// connection; a full queue drops the frame or closes, per policy. The writer
+// also pings the client, for the heartbeat.
+type outbox struct {
+	conn    *websocket.Conn
+	frames  chan outFrame
+	timeout time.Duration
+	policy  string
+	ping    time.Duration // 0 = don't ping
+
+	stop     chan struct{} // closed to flush and exit the writer
+	finished chan struct{} // closed when the writer has exited
+	failed   chan struct{} // closed once the connection is given up on
+	failOnce sync.Once
+	stopOnce sync.Once
+}
+
+type outFrame struct {
+	msgType int
+	data    []byte
+}
+
+// newOutbox starts the writer for conn. Zero values select the defaults and
+// an empty policy selects SlowClientDrop; ping 0 sends no pings.
+func newOutbox(conn *websocket.Conn, queueSize int, timeout time.Duration, policy string, ping time.Duration) *outbox {
+	if queueSize <= 0 {
+		queueSize = defaultSendQueue
+	}
+	if timeout <= 0 {
+		timeout = defaultWriteTimeout
+	}
+	o := &outbox{
+		conn:     conn,
+		frames:   make(chan outFrame, queueSize),
+		timeout:  timeout,
+		policy:   policy,
+		ping:     ping,
+		stop:     make(chan struct{}),
+		finished: make(chan struct{}),
+		failed:   make(chan struct{}),
+	}
+	go o.run()
+	return o
+}
+
+// newOutbox creates an outbox with the handler's write and ping settings.
+func (h *Handler) newOutbox(conn *websocket.Conn) *outbox {
+	return newOutbox(conn, h.cfg.SendQueueSize, h.cfg.WriteTimeout, h.cfg.SlowClientPolicy, h.pingInterval())
+}
+
+// send queues a frame without blocking.
+func (o *outbox) send(msgType int, data []byte) {
+	select {
+	case <-o.failed:
+		return
+	default:
+	}
+	select {
+	case o.frames <- outFrame{msgType: msgType, data: data}:
+		return
+	default:
+	}
+
+	metrics.SlowClientDrops.WithLabelValues(frameKind(msgType)).Inc()
+	if o.policy == SlowClientClose {
+		o.fail("send queue full")
+	}
+}
+
+// close writes whatever is still queued, then stops the writer.
+func (o *outbox) close() {
+	o.stopOnce.Do(func() { close(o.stop) })
+	<-o.finished
+}
+
+func (o *outbox) run() {
+	defer close(o.finished)
+	var pings <-chan time.Time
+	if o.ping > 0 {
+		ticker := time.NewTicker(o.ping)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+	for {
+		select {
+		case f := <-o.frames:
+			if !o.write(f) {
+				return
+			}
+		case <-pings:
+			if err := o.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(o.timeout)); err != nil {
+				o.fail(err.Error())
+				return
+			}
+		case <-o.failed:
+			return
+		case <-o.stop:
+			o.drain()
+			return
+		}
+	}
+}
+
+func (o *outbox) drain() {
+	for {
+		select {
+		case f := <-o.frames:
+			if !o.write(f) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (o *outbox) write(f outFrame) bool {
+	_ = o.conn.SetWriteDeadline(time.Now().Add(o.timeout))
+	if err := o.conn.WriteMessage(f.msgType, f.data); err != nil {
+		o.fail(err.Error())
+		return false
+	}
+	return true
+}
+
+// fail gives up on the client. Closing the socket also ends the session's
+// read loop.
+func (o *outbox) fail(reason string) {
+	o.failOnce.Do(func() {
+		slog.Warn("dropping websocket client", "reason", reason)
+		close(o.failed)
+		_ = o.conn.Close()
+	})
+}
+
+func frameKind(msgType int) string {
+	if msgType == websocket.BinaryMessage {
+		return "audio"
+	}
+	return "event"
+}
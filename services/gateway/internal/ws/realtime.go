@@ -0,0 +1,636 @@
+package ws
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/auth"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/logctx"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/ratelimit"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/trace"
+)
+
+// The /v1/realtime endpoint speaks the OpenAI Realtime event protocol on top
+// of the local pipeline so Realtime clients can connect unchanged.
+//
+// Client events: session.update, input_audio_buffer.append/commit/clear,
+// conversation.item.create (input_text), response.create.
+// Server events: session.created/updated, input_audio_buffer.committed/cleared,
+// conversation.item.created, conversation.item.input_audio_transcription.completed,
+// response.created, response.audio.delta/done, response.audio_transcript.delta/done,
+// response.text.delta/done, response.done, error.
+//
+// Input audio may be pcm16 (24 kHz), g711_ulaw, or g711_alaw; output audio is
+// always pcm16 at 24 kHz. Typed messages are answered as text only, since the
+// pipeline's text path skips TTS.
+const (
+	// realtimeSampleRate is the Realtime protocol's fixed pcm16 rate.
+	realtimeSampleRate = 24000
+
+	// g711SampleRate is the telephony rate of g711_ulaw / g711_alaw input.
+	g711SampleRate = 8000
+
+	// defaultRealtimeVoice is reported until the client picks one. Voices are
+	// used as TTS engine names, so unknown ones fall back to the default engine.
+	defaultRealtimeVoice = "alloy"
+)
+
+var realtimeUpgrader = websocket.Upgrader{
+	ReadBufferSize:  wsBufferSize,
+	WriteBufferSize: wsBufferSize,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+	Subprotocols:    []string{"realtime"},
+}
+
+// RealtimeHandler serves the OpenAI Realtime-compatible WebSocket endpoint,
+// sharing backends and limits with the call Handler.
+type RealtimeHandler struct {
+	h *Handler
+}
+
+// Realtime returns the handler for /v1/realtime.
+func (h *Handler) Realtime() *RealtimeHandler {
+	return &RealtimeHandler{h: h}
+}
+
+// realtimeTurnDetection is the session's VAD setting. A nil value means the
+// client commits the audio buffer itself.
+type realtimeTurnDetection struct {
+	Type              string `json:"type"`
+	SilenceDurationMs int    `json:"silence_duration_ms,omitempty"`
+}
+
+// realtimeSession is the session object reported in session.created/updated.
+type realtimeSession struct {
+	ID                string                 `json:"id"`
+	Object            string                 `json:"object"`
+	Modalities        []string               `json:"modalities"`
+	Instructions      string                 `json:"instructions"`
+	Voice             string                 `json:"voice"`
+	InputAudioFormat  string                 `json:"input_audio_format"`
+	OutputAudioFormat string                 `json:"output_audio_format"`
+	TurnDetection     *realtimeTurnDetection `json:"turn_detection"`
+}
+
+// realtimeSessionUpdate is the session field of session.update. Omitted
+// fields are left unchanged; turn_detection stays raw so an explicit null
+// (manual commit) can be told apart from absence.
+type realtimeSessionUpdate struct {
+	Modalities       []string        `json:"modalities"`
+	Instructions     *string         `json:"instructions"`
+	Voice            string          `json:"voice"`
+	InputAudioFormat string          `json:"input_audio_format"`
+	TurnDetection    json.RawMessage `json:"turn_detection"`
+}
+
+type realtimeContent struct {
+	Type       string `json:"type"`
+	Text       string `json:"text,omitempty"`
+	Transcript string `json:"transcript,omitempty"`
+}
+
+type realtimeItem struct {
+	ID      string            `json:"id,omitempty"`
+	Object  string            `json:"object,omitempty"`
+	Type    string            `json:"type"`
+	Role    string            `json:"role,omitempty"`
+	Status  string            `json:"status,omitempty"`
+	Content []realtimeContent `json:"content,omitempty"`
+}
+
+type realtimeClientEvent struct {
+	Type    string                 `json:"type"`
+	EventID string                 `json:"event_id"`
+	Audio   string                 `json:"audio"`
+	Session *realtimeSessionUpdate `json:"session"`
+	Item    *realtimeItem          `json:"item"`
+
+	pcm []byte // decoded Audio for input_audio_buffer.append
+}
+
+// realtimeConn is the per-connection state of a Realtime session.
+type realtimeConn struct {
+	h         *Handler
+	sessionID string
+	meta      callMetadata
+	session   realtimeSession
+	tracer    *trace.Tracer
+	sc        *sessionCtx
+	out       *realtimeWriter
+
+	pendingText   string // input_text waiting for response.create
+	bufferedBytes int    // audio appended since the last commit/clear
+}
+
+var realtimeDispatch = map[string]func(*realtimeConn, context.Context, *realtimeClientEvent){
+	"session.update":            (*realtimeConn).updateSession,
+	"input_audio_buffer.append": (*realtimeConn).appendAudio,
+	"input_audio_buffer.commit": (*realtimeConn).commitAudio,
+	"input_audio_buffer.clear":  (*realtimeConn).clearAudio,
+	"conversation.item.create":  (*realtimeConn).createItem,
+	"response.create":           (*realtimeConn).createResponse,
+	"response.cancel":           (*realtimeConn).cancelResponse,
+}
+
+// ServeHTTP upgrades the connection and runs a Realtime session.
+func (rh *RealtimeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := realtimeUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("realtime upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+	rh.h.heartbeat(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// clients without a tenant-bound key may name one in ?tenant=
+	tenant, err := rh.h.tenants.resolve(auth.TenantOf(r), r.URL.Query().Get("tenant"))
+	if err != nil {
+		rejectCall(conn, err)
+		return
+	}
+	rc := rh.h.newRealtimeConn(conn, ratelimit.ClientKey(r), tenant)
+	defer rc.out.close()
+	if rc.tracer != nil {
+		defer func() {
+			rc.tracer.Close()
+			_ = rh.h.cfg.TraceStore.EndSession(rc.sessionID)
+		}()
+	}
+	ctx = logctx.With(ctx, "session_id", rc.sessionID)
+	release, err := rh.h.tenants.admit(tenant, resolveParams(&rc.meta, rh.h.vadConfig()))
+	if err != nil {
+		slog.WarnContext(ctx, "realtime session rejected", "error", err)
+		rc.out.sendError("invalid_request_error", err.Error(), "")
+		return
+	}
+	defer release()
+	slog.InfoContext(ctx, "realtime session started")
+	rc.out.send("session.created", map[string]any{"session": rc.session})
+
+	defer func() {
+		rc.sc.pipe.Close()
+		rh.h.finishCall(ctx, rc.sessionID, rc.sc.pipe)
+	}()
+
+	for {
+		msgType, data, err := rh.h.readMessage(conn)
+		if err != nil {
+			slog.InfoContext(ctx, "realtime connection closed", "error", err)
+			return
+		}
+		if msgType != websocket.TextMessage {
+			continue
+		}
+		if !rc.handleFrame(ctx, data) {
+			return
+		}
+	}
+}
+
+func (h *Handler) newRealtimeConn(conn *websocket.Conn, clientKey, tenant string) *realtimeConn {
+	rc := &realtimeConn{
+		h:         h,
+		sessionID: uuid.NewString(),
+		meta: callMetadata{
+			Codec:      string(audio.CodecPCM),
+			SampleRate: realtimeSampleRate,
+			TTSEngine:  defaultRealtimeVoice,
+			Mode:       "realtime",
+			Tenant:     tenant,
+		},
+		session: realtimeSession{
+			Object:            "realtime.session",
+			Modalities:        []string{"text", "audio"},
+			Voice:             defaultRealtimeVoice,
+			InputAudioFormat:  "pcm16",
+			OutputAudioFormat: "pcm16",
+			TurnDetection:     &realtimeTurnDetection{Type: "server_vad"},
+		},
+		out: &realtimeWriter{out: h.newOutbox(conn)},
+	}
+	rc.session.ID = rc.sessionID
+	h.applyDefaults(&rc.meta)
+	h.applyExperiment(&rc.meta, rc.sessionID)
+	rc.session.Instructions = orDefault(rc.meta.SystemPrompt, metaDefaults["system_prompt"])
+	rc.tracer = h.startTracer(rc.sessionID, &rc.meta, false)
+	rc.sc = &sessionCtx{
+		sendEvent:     rc.out.onEvent,
+		clientKey:     clientKey,
+		msgLimiter:    h.cfg.MsgLimiter,
+		maxAudioBytes: h.cfg.MaxSessionAudioBytes,
+	}
+	rc.rebuild()
+	return rc
+}
+
+// handleFrame applies one client event. Returns false to end the session.
+func (rc *realtimeConn) handleFrame(ctx context.Context, data []byte) bool {
+	var ev realtimeClientEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		rc.out.sendError("invalid_request_error", "malformed event: "+err.Error(), "")
+		return true
+	}
+
+	msgType := websocket.TextMessage
+	if ev.Type == "input_audio_buffer.append" {
+		decoded, err := base64.StdEncoding.DecodeString(ev.Audio)
+		if err != nil {
+			rc.out.sendError("invalid_request_error", "audio is not valid base64", ev.EventID)
+			return true
+		}
+		ev.pcm, msgType = decoded, websocket.BinaryMessage
+	}
+	process, keep := rc.sc.admit(msgType, ev.pcm)
+	if !keep || !process {
+		return keep
+	}
+
+	handle, ok := realtimeDispatch[ev.Type]
+	if !ok {
+		rc.out.sendError("invalid_request_error", "unsupported event type "+ev.Type, ev.EventID)
+		return true
+	}
+	handle(rc, ctx, &ev)
+	return true
+}
+
+// rebuild applies the current metadata to a fresh pipeline, carrying over
+// the conversation so far.
+func (rc *realtimeConn) rebuild() {
+	rc.h.applyDefaults(&rc.meta)
+	params := resolveParams(&rc.meta, rc.h.vadConfig())
+	var history []pipeline.Turn
+	if rc.sc.pipe != nil {
+		rc.sc.pipe.Wait() // let a response in flight finish into the history
+		history = rc.sc.pipe.History()
+	}
+	cfg := rc.h.pipelineConfig(&rc.meta, params, rc.sessionID, rc.tracer, history)
+	// keep the call's place in its flow unless the update switched flows
+	if rc.sc.pipe != nil {
+		if prev := rc.sc.pipe.FlowSession(); prev != nil && prev.Name() == cfg.Flow.Name() {
+			cfg.Flow = prev
+		}
+		cfg.OnHold = rc.sc.pipe.OnHold()
+		cfg.Talk = rc.sc.pipe.Talk()
+	}
+	rc.sc.pipe = pipeline.New(cfg)
+	rc.sc.codec = params.codec
+	rc.sc.sampleRate = params.sampleRate
+	rc.sc.ttsEngine = params.ttsEngine
+	rc.sc.asrEngine = params.asrEngine
+	rc.sc.mode = "snippet"
+	if rc.session.TurnDetection != nil {
+		rc.sc.mode = "talk"
+	}
+	rc.bufferedBytes = 0
+}
+
+func (rc *realtimeConn) updateSession(_ context.Context, ev *realtimeClientEvent) {
+	u := ev.Session
+	if u == nil {
+		rc.out.sendError("invalid_request_error", "session.update requires session", ev.EventID)
+		return
+	}
+	prevMeta, prevSession := rc.meta, rc.session
+	if u.Instructions != nil {
+		rc.session.Instructions = *u.Instructions
+		rc.meta.SystemPrompt = *u.Instructions
+	}
+	if u.Voice != "" {
+		rc.session.Voice = u.Voice
+	}
+	if len(u.Modalities) > 0 {
+		rc.session.Modalities = u.Modalities
+	}
+	rc.meta.TTSEngine = ""
+	if hasModality(rc.session.Modalities, "audio") {
+		rc.meta.TTSEngine = rc.session.Voice
+	}
+	if u.InputAudioFormat != "" && !rc.setInputFormat(u.InputAudioFormat) {
+		rc.out.sendError("invalid_request_error", "unsupported input_audio_format "+u.InputAudioFormat, ev.EventID)
+		return
+	}
+	if len(u.TurnDetection) > 0 {
+		var td *realtimeTurnDetection
+		if err := json.Unmarshal(u.TurnDetection, &td); err != nil {
+			rc.out.sendError("invalid_request_error", "invalid turn_detection", ev.EventID)
+			return
+		}
+		rc.session.TurnDetection = td
+		rc.meta.VADSilenceTimeoutMs = 0
+		if td != nil {
+			rc.meta.VADSilenceTimeoutMs = td.SilenceDurationMs
+		}
+	}
+	// voices are TTS engine names, so the tenant's engine list applies to them
+	if err := rc.h.tenants.allows(rc.meta.Tenant, resolveParams(&rc.meta, rc.h.vadConfig())); err != nil {
+		rc.meta, rc.session = prevMeta, prevSession
+		rc.out.sendError("invalid_request_error", err.Error(), ev.EventID)
+		return
+	}
+	rc.rebuild()
+	rc.out.send("session.updated", map[string]any{"session": rc.session})
+}
+
+// setInputFormat maps a Realtime audio format to the pipeline codec.
+func (rc *realtimeConn) setInputFormat(format string) bool {
+	codecs := map[string]struct {
+		codec audio.Codec
+		rate  int
+	}{
+		"pcm16":     {audio.CodecPCM, realtimeSampleRate},
+		"g711_ulaw": {audio.CodecG711Ulaw, g711SampleRate},
+		"g711_alaw": {audio.CodecG711Alaw, g711SampleRate},
+	}
+	c, ok := codecs[format]
+	if !ok {
+		return false
+	}
+	rc.session.InputAudioFormat = format
+	rc.meta.Codec = string(c.codec)
+	rc.meta.SampleRate = c.rate
+	return true
+}
+
+func hasModality(modalities []string, m string) bool {
+	for _, v := range modalities {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (rc *realtimeConn) appendAudio(ctx context.Context, ev *realtimeClientEvent) {
+	rc.bufferedBytes += len(ev.pcm)
+	rc.out.setAudio(rc.sc.ttsEngine != "")
+	handleOneMessage(ctx, websocket.BinaryMessage, ev.pcm, rc.sc)
+}
+
+func (rc *realtimeConn) commitAudio(ctx context.Context, ev *realtimeClientEvent) {
+	if rc.sc.mode != "snippet" {
+		rc.out.sendError("invalid_request_error", "input_audio_buffer.commit requires turn_detection null", ev.EventID)
+		return
+	}
+	if rc.bufferedBytes == 0 {
+		rc.out.sendError("invalid_request_error", "input audio buffer is empty", ev.EventID)
+		return
+	}
+	rc.bufferedBytes = 0
+	rc.out.send("input_audio_buffer.committed", map[string]any{"item_id": rc.out.newUserItem()})
+	rc.out.setAudio(rc.sc.ttsEngine != "")
+	if err := rc.sc.pipe.StartBuffered(ctx, rc.sc.ttsEngine, rc.sc.asrEngine, rc.sc.sendEvent); err != nil {
+		slog.ErrorContext(ctx, "realtime process buffered", "error", err)
+		rc.out.onEvent(pipeline.Event{Type: "error", Text: err.Error()})
+	}
+}
+
+func (rc *realtimeConn) clearAudio(_ context.Context, _ *realtimeClientEvent) {
+	rc.sc.pipe.ClearBuffer()
+	rc.bufferedBytes = 0
+	rc.out.send("input_audio_buffer.cleared", nil)
+}
+
+func (rc *realtimeConn) createItem(_ context.Context, ev *realtimeClientEvent) {
+	if ev.Item == nil || ev.Item.Type != "message" || ev.Item.Role != "user" {
+		rc.out.sendError("invalid_request_error", "only user message items are supported", ev.EventID)
+		return
+	}
+	var text strings.Builder
+	for _, c := range ev.Item.Content {
+		if c.Type == "input_text" {
+			text.WriteString(c.Text)
+		}
+	}
+	rc.pendingText += text.String()
+
+	item := *ev.Item
+	if item.ID == "" {
+		item.ID = realtimeID("item")
+	}
+	item.Object, item.Status = "realtime.item", "completed"
+	rc.out.send("conversation.item.created", map[string]any{"item": item})
+}
+
+func (rc *realtimeConn) createResponse(ctx context.Context, ev *realtimeClientEvent) {
+	if rc.pendingText == "" {
+		rc.out.sendError("invalid_request_error", "response.create requires a pending input_text item", ev.EventID)
+		return
+	}
+	text := rc.pendingText
+	rc.pendingText = ""
+	rc.out.setAudio(false)
+	rc.sc.pipe.StartTextMessage(ctx, text, rc.sc.sendEvent)
+}
+
+// cancelResponse stops the response in progress; it ends with status
+// "cancelled".
+func (rc *realtimeConn) cancelResponse(_ context.Context, _ *realtimeClientEvent) {
+	rc.sc.pipe.CancelTurn()
+}
+
+// realtimeWriter translates pipeline events into Realtime server events.
+// Pipeline events arrive from both the read loop and the TTS goroutine, so
+// writes and response state are guarded by mu.
+type realtimeWriter struct {
+	mu  sync.Mutex
+	out *outbox
+
+	audio      bool   // the next response carries TTS audio
+	userItemID string // item the current transcription belongs to
+	respID     string // "" while no response is in progress
+	itemID     string
+	text       strings.Builder
+}
+
+// setAudio selects the modality of the next response.
+func (w *realtimeWriter) setAudio(audio bool) {
+	w.mu.Lock()
+	w.audio = audio
+	w.mu.Unlock()
+}
+
+// newUserItem allocates the item ID the next transcription is reported under.
+func (w *realtimeWriter) newUserItem() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.userItemID = realtimeID("item")
+	return w.userItemID
+}
+
+// onEvent is the pipeline EventCallback for a Realtime session.
+func (w *realtimeWriter) onEvent(ev pipeline.Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if ev.Type == "transcript" {
+		w.transcriptLocked(ev.Text)
+		return
+	}
+	if ev.Type == "llm_token" {
+		w.startResponseLocked()
+		w.text.WriteString(ev.Token)
+		w.sendLocked(w.partType()+".delta", w.partFields(map[string]any{"delta": ev.Token}))
+		return
+	}
+	if ev.Type == "tts_ready" {
+		w.audioLocked(ev.Audio)
+		return
+	}
+	if ev.Type == "metrics" {
+		w.finishResponseLocked("completed")
+		return
+	}
+	if ev.Type == "turn_cancelled" {
+		w.finishResponseLocked("cancelled")
+		return
+	}
+	if ev.Type == "error" {
+		w.sendErrorLocked("server_error", ev.Text, "")
+		w.finishResponseLocked("failed")
+	}
+}
+
+func (w *realtimeWriter) transcriptLocked(text string) {
+	itemID := w.userItemID
+	w.userItemID = ""
+	if itemID == "" {
+		// server VAD committed the buffer on its own
+		itemID = realtimeID("item")
+		w.sendLocked("input_audio_buffer.committed", map[string]any{"item_id": itemID})
+	}
+	w.sendLocked("conversation.item.created", map[string]any{"item": realtimeItem{
+		ID: itemID, Object: "realtime.item", Type: "message", Role: "user", Status: "completed",
+		Content: []realtimeContent{{Type: "input_audio", Transcript: text}},
+	}})
+	w.sendLocked("conversation.item.input_audio_transcription.completed", map[string]any{
+		"item_id": itemID, "content_index": 0, "transcript": text,
+	})
+}
+
+// audioLocked forwards synthesized speech as pcm16 at the Realtime rate.
+func (w *realtimeWriter) audioLocked(wav []byte) {
+	samples, rate, err := audio.DecodeWAV(wav)
+	if err != nil {
+		slog.Warn("realtime audio not forwarded", "error", err)
+		return
+	}
+	w.startResponseLocked()
+	pcm := audio.EncodePCM16(audio.Resample(samples, rate, realtimeSampleRate))
+	w.sendLocked("response.audio.delta", w.partFields(map[string]any{"delta": base64.StdEncoding.EncodeToString(pcm)}))
+}
+
+func (w *realtimeWriter) startResponseLocked() {
+	if w.respID != "" {
+		return
+	}
+	w.respID, w.itemID = realtimeID("resp"), realtimeID("item")
+	w.text.Reset()
+	w.sendLocked("response.created", map[string]any{"response": map[string]any{
+		"id": w.respID, "object": "realtime.response", "status": "in_progress", "output": []any{},
+	}})
+}
+
+func (w *realtimeWriter) finishResponseLocked(status string) {
+	if w.respID == "" {
+		return
+	}
+	text := w.text.String()
+	content := realtimeContent{Type: "text", Text: text}
+	if w.audio {
+		w.sendLocked("response.audio.done", w.partFields(nil))
+		content = realtimeContent{Type: "audio", Transcript: text}
+	}
+	w.sendLocked(w.partType()+".done", w.partFields(map[string]any{w.textField(): text}))
+	w.sendLocked("response.done", map[string]any{"response": map[string]any{
+		"id": w.respID, "object": "realtime.response", "status": status,
+		"output": []realtimeItem{{
+			ID: w.itemID, Object: "realtime.item", Type: "message", Role: "assistant", Status: status,
+			Content: []realtimeContent{content},
+		}},
+	}})
+	w.respID, w.itemID = "", ""
+}
+
+// partType is the event prefix for streamed response text: the spoken
+// transcript when the response has audio, plain text otherwise.
+func (w *realtimeWriter) partType() string {
+	if w.audio {
+		return "response.audio_transcript"
+	}
+	return "response.text"
+}
+
+func (w *realtimeWriter) textField() string {
+	if w.audio {
+		return "transcript"
+	}
+	return "text"
+}
+
+// partFields adds the response/item addressing every content event carries.
+func (w *realtimeWriter) partFields(fields map[string]any) map[string]any {
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	fields["response_id"] = w.respID
+	fields["item_id"] = w.itemID
+	fields["output_index"] = 0
+	fields["content_index"] = 0
+	return fields
+}
+
+// close flushes queued events to the client.
+func (w *realtimeWriter) close() {
+	w.out.close()
+}
+
+func (w *realtimeWriter) send(eventType string, fields map[string]any) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sendLocked(eventType, fields)
+}
+
+func (w *realtimeWriter) sendError(errType, message, eventID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sendErrorLocked(errType, message, eventID)
+}
+
+func (w *realtimeWriter) sendErrorLocked(errType, message, eventID string) {
+	e := map[string]any{"type": errType, "message": message}
+	if eventID != "" {
+		e["event_id"] = eventID
+	}
+	w.sendLocked("error", map[string]any{"error": e})
+}
+
+func (w *realtimeWriter) sendLocked(eventType string, fields map[string]any) {
+	msg := map[string]any{"type": eventType, "event_id": realtimeID("event")}
+	for k, v := range fields {
+		msg[k] = v
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	w.out.send(websocket.TextMessage, data)
+}
+
+// realtimeID returns an OpenAI-style prefixed identifier.
+func realtimeID(prefix string) string {
+	return prefix + "_" + strings.ReplaceAll(uuid.NewString(), "-", "")[:20]
+}
@@ -0,0 +1,119 @@
+package ws
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/metrics"
+)
+
+// TenantConfig is one tenant's share of a gateway serving several
+// customers: its default prompt, the engines its calls may use, how many
+// of them may run at once, and how long each may run.
+type TenantConfig struct {
+	SystemPrompt  string        `json:"system_prompt"` // used when a call sends none ("" = gateway default)
+	ASREngines    []string      `json:"asr_engines"`   // engines its calls may select (empty = any)
+	LLMEngines    []string      `json:"llm_engines"`
+	TTSEngines    []string      `json:"tts_engines"`
+	MaxSessions   int           `json:"max_sessions"`   // concurrent calls (0 = unlimited)
+	SessionLimits SessionLimits `json:"session_limits"` // set fields replace the gateway's
+}
+
+// allows checks a call's engines against the tenant's lists.
+func (c TenantConfig) allows(params sessionParams) error {
+	checks := []struct {
+		kind, engine string
+		allowed      []string
+	}{
+		{"asr", params.asrEngine, c.ASREngines},
+		{"llm", params.llmEngine, c.LLMEngines},
+		{"tts", params.ttsEngine, c.TTSEngines},
+	}
+	for _, ch := range checks {
+		if ch.engine != "" && len(ch.allowed) > 0 && !slices.Contains(ch.allowed, ch.engine) {
+			return fmt.Errorf("%s engine %q is not enabled for this tenant", ch.kind, ch.engine)
+		}
+	}
+	return nil
+}
+
+// tenantRegistry holds the configured tenants and their live call counts.
+// The config can be replaced while calls run; counts carry over.
+type tenantRegistry struct {
+	mu     sync.Mutex
+	cfg    map[string]TenantConfig
+	active map[string]int
+}
+
+func newTenantRegistry(cfg map[string]TenantConfig) *tenantRegistry {
+	return &tenantRegistry{cfg: cfg, active: map[string]int{}}
+}
+
+func (t *tenantRegistry) setConfig(cfg map[string]TenantConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cfg = cfg
+}
+
+func (t *tenantRegistry) config(tenant string) (TenantConfig, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cfg, ok := t.cfg[tenant]
+	return cfg, ok
+}
+
+// resolve picks a call's tenant. A tenant bound to the API key wins; the
+// call's own "tenant" field is honored only for keys without one, and may
+// not contradict the key. "" is the untenanted gateway default.
+func (t *tenantRegistry) resolve(keyTenant, requested string) (string, error) {
+	if keyTenant != "" && requested != "" && requested != keyTenant {
+		return "", fmt.Errorf("tenant %q does not match the API key", requested)
+	}
+	tenant := keyTenant
+	if tenant == "" {
+		tenant = requested
+	}
+	if _, ok := t.config(tenant); tenant != "" && !ok {
+		return "", fmt.Errorf("unknown tenant %q", tenant)
+	}
+	return tenant, nil
+}
+
+// applyDefaults fills the call's metadata from its tenant's config.
+func (t *tenantRegistry) applyDefaults(meta *callMetadata) {
+	if meta.SystemPrompt == "" {
+		cfg, _ := t.config(meta.Tenant)
+		meta.SystemPrompt = cfg.SystemPrompt
+	}
+}
+
+// allows checks a call's engines against its tenant's lists.
+func (t *tenantRegistry) allows(tenant string, params sessionParams) error {
+	cfg, _ := t.config(tenant)
+	return cfg.allows(params)
+}
+
+// admit checks a call against its tenant's engines and session quota. The
+// returned release frees the session slot when the call ends.
+func (t *tenantRegistry) admit(tenant string, params sessionParams) (func(), error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cfg := t.cfg[tenant]
+	if err := cfg.allows(params); err != nil {
+		return nil, err
+	}
+	if cfg.MaxSessions > 0 && t.active[tenant] >= cfg.MaxSessions {
+		metrics.RateLimited.WithLabelValues("tenant_sessions").Inc()
+		return nil, fmt.Errorf("tenant %q is at its limit of %d concurrent calls", tenant, cfg.MaxSessions)
+	}
+	t.active[tenant]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.active[tenant]--
+		})
+	}, nil
+}
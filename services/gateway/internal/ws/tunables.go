@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/audio"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/experiment"
+	"github.com/hubenschmidt/asr-llm-tts-poc/gateway/internal/pipeline"
+)
+
+// Tunables are the call settings that can change while the gateway runs.
+// Each call reads them as it starts, so an update applies to new calls and
+// never disturbs one in progress. JSON names match gateway.json.
+type Tunables struct {
+	SystemPrompt         string                           `json:"llm_system_prompt"`       // default when neither the call nor its tenant sets one
+	VADSpeechThresholdDB float64                          `json:"vad_speech_threshold_db"` // 0 = the VAD default
+	VADSilenceTimeoutMs  int                              `json:"vad_silence_timeout_ms"`  // 0 = the VAD default
+	Endpointing          pipeline.EndpointConfig          `json:"endpointing"`
+	Tenants              map[string]TenantConfig          `json:"tenants"`
+	Experiments          map[string]experiment.Experiment `json:"experiments"`
+	DefaultExperiment    string                           `json:"default_experiment"` // joined by calls that name no experiment ("" = none)
+	SessionLimits        SessionLimits                    `json:"session_limits"`     // per-call caps, overridden per tenant
+}
+
+// Validate rejects settings no call could run with.
+func (t Tunables) Validate() error {
+	if t.VADSilenceTimeoutMs < 0 {
+		return errors.New("vad_silence_timeout_ms must not be negative")
+	}
+	for id, e := range t.Experiments {
+		if err := e.Validate(); err != nil {
+			return fmt.Errorf("experiment %q: %w", id, err)
+		}
+	}
+	if _, ok := t.Experiments[t.DefaultExperiment]; t.DefaultExperiment != "" && !ok {
+		return fmt.Errorf("default_experiment %q is not defined", t.DefaultExperiment)
+	}
+	if err := t.SessionLimits.Validate(); err != nil {
+		return err
+	}
+	for name, tc := range t.Tenants {
+		if err := tc.SessionLimits.Validate(); err != nil {
+			return fmt.Errorf("tenant %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Tunables returns the settings new calls start with.
+func (h *Handler) Tunables() Tunables {
+	h.tunMu.RLock()
+	defer h.tunMu.RUnlock()
+	return h.tun
+}
+
+// SetTunables replaces the settings for calls that start from now on.
+func (h *Handler) SetTunables(t Tunables) {
+	h.tunMu.Lock()
+	h.tun = t
+	h.tunMu.Unlock()
+	h.tenants.setConfig(t.Tenants)
+}
+
+// vadConfig is the gateway's VAD config with the tunable thresholds applied.
+func (h *Handler) vadConfig() audio.VADConfig {
+	t := h.Tunables()
+	cfg := h.cfg.VADConfig
+	if t.VADSpeechThresholdDB != 0 {
+		cfg.SpeechThresholdDB = t.VADSpeechThresholdDB
+	}
+	if t.VADSilenceTimeoutMs > 0 {
+		cfg.SilenceTimeout = time.Duration(t.VADSilenceTimeoutMs) * time.Millisecond
+	}
+	return cfg
+}
+
+// applyDefaults fills a call's unset prompt from its tenant, then from the
+// gateway's default.
+func (h *Handler) applyDefaults(meta *callMetadata) {
+	h.tenants.applyDefaults(meta)
+	if meta.SystemPrompt == "" {
+		meta.SystemPrompt = h.Tunables().SystemPrompt
+	}
+}